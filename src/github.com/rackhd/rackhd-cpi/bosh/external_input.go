@@ -17,14 +17,16 @@ const (
 	CREATE_STEMCELL = "create_stemcell"
 	DELETE_STEMCELL = "delete_stemcell"
 
-	CREATE_DISK     = "create_disk"
-	DELETE_DISK     = "delete_disk"
-	ATTACH_DISK     = "attach_disk"
-	DETACH_DISK     = "detach_disk"
-	HAS_DISK        = "has_disk"
-	GET_DISKS       = "get_disks"
-	SNAPSHOT_DISK   = "snapshot_disk"
-	DELETE_SNAPSHOT = "delete_snapshot"
+	CREATE_DISK       = "create_disk"
+	DELETE_DISK       = "delete_disk"
+	ATTACH_DISK       = "attach_disk"
+	DETACH_DISK       = "detach_disk"
+	HAS_DISK          = "has_disk"
+	GET_DISKS         = "get_disks"
+	RESIZE_DISK       = "resize_disk"
+	SET_DISK_METADATA = "set_disk_metadata"
+	SNAPSHOT_DISK     = "snapshot_disk"
+	DELETE_SNAPSHOT   = "delete_snapshot"
 
 	CURRENT_VM_ID = "current_vm_id"
 )
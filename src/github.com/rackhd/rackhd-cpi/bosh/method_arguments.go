@@ -0,0 +1,575 @@
+package bosh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+/*
+  The director sends the arguments of every method as a positional array. The
+  types below give each method a validated, named view of that array so the
+  CPI never has to index into MethodArguments directly. Every constructor
+  checks the argument count before touching an element and reports a missing
+  or mistyped argument by name.
+*/
+
+type CreateStemcellArguments struct {
+	ImagePath       string
+	CloudProperties map[string]interface{}
+}
+
+type DeleteStemcellArguments struct {
+	StemcellCID string
+}
+
+type CreateVMArguments struct {
+	AgentID         string
+	StemcellCID     string
+	CloudProperties map[string]interface{}
+	Networks        map[string]Network
+	DiskCIDs        []string
+	Env             map[string]interface{}
+}
+
+type DeleteVMArguments struct {
+	VMCID string
+}
+
+type HasVMArguments struct {
+	VMCID string
+}
+
+type SetVMMetadataArguments struct {
+	VMCID    string
+	Metadata map[string]interface{}
+}
+
+type CreateDiskArguments struct {
+	SizeInMB        int
+	CloudProperties map[string]interface{}
+	VMCID           string
+}
+
+type DeleteDiskArguments struct {
+	DiskCID string
+}
+
+type AttachDiskArguments struct {
+	VMCID   string
+	DiskCID string
+}
+
+type DetachDiskArguments struct {
+	VMCID   string
+	DiskCID string
+}
+
+type HasDiskArguments struct {
+	DiskCID string
+}
+
+type GetDisksArguments struct {
+	VMCID string
+}
+
+type SetDiskMetadataArguments struct {
+	DiskCID  string
+	Metadata map[string]interface{}
+}
+
+type ResizeDiskArguments struct {
+	DiskCID     string
+	NewSizeInMB int
+}
+
+func NewCreateStemcellArguments(args MethodArguments) (CreateStemcellArguments, error) {
+	if err := args.requireLength(CREATE_STEMCELL, 1); err != nil {
+		return CreateStemcellArguments{}, err
+	}
+
+	imagePath, err := args.requiredString(0, "stemcell image path")
+	if err != nil {
+		return CreateStemcellArguments{}, err
+	}
+
+	cloudProperties, err := args.optionalMap(1, "stemcell cloud properties")
+	if err != nil {
+		return CreateStemcellArguments{}, err
+	}
+
+	return CreateStemcellArguments{ImagePath: imagePath, CloudProperties: cloudProperties}, nil
+}
+
+func NewDeleteStemcellArguments(args MethodArguments) (DeleteStemcellArguments, error) {
+	if err := args.requireLength(DELETE_STEMCELL, 1); err != nil {
+		return DeleteStemcellArguments{}, err
+	}
+
+	cid, err := args.requiredString(0, "stemcell cid")
+	if err != nil {
+		return DeleteStemcellArguments{}, err
+	}
+
+	return DeleteStemcellArguments{StemcellCID: cid}, nil
+}
+
+func NewCreateVMArguments(args MethodArguments) (CreateVMArguments, error) {
+	if err := args.requireLength(CREATE_VM, 5); err != nil {
+		return CreateVMArguments{}, err
+	}
+
+	agentID, err := args.requiredString(0, "agent id")
+	if err != nil {
+		return CreateVMArguments{}, err
+	}
+
+	stemcellCID, err := args.requiredString(1, "stemcell id")
+	if err != nil {
+		return CreateVMArguments{}, err
+	}
+
+	cloudProperties, err := args.optionalMap(2, "cloud properties")
+	if err != nil {
+		return CreateVMArguments{}, err
+	}
+
+	networksInput, err := args.optionalMap(3, "network config")
+	if err != nil {
+		return CreateVMArguments{}, err
+	}
+
+	b, err := json.Marshal(networksInput)
+	if err != nil {
+		return CreateVMArguments{}, errors.New("error marshalling the network")
+	}
+
+	networks := map[string]Network{}
+	err = json.Unmarshal(b, &networks)
+	if err != nil {
+		return CreateVMArguments{}, fmt.Errorf("network config is malformed: %s", err)
+	}
+
+	diskCIDs, err := args.optionalStringSlice(4, "disk config")
+	if err != nil {
+		return CreateVMArguments{}, err
+	}
+
+	env, err := args.optionalMap(5, "env")
+	if err != nil {
+		return CreateVMArguments{}, err
+	}
+
+	return CreateVMArguments{
+		AgentID:         agentID,
+		StemcellCID:     stemcellCID,
+		CloudProperties: cloudProperties,
+		Networks:        networks,
+		DiskCIDs:        diskCIDs,
+		Env:             env,
+	}, nil
+}
+
+func NewDeleteVMArguments(args MethodArguments) (DeleteVMArguments, error) {
+	if err := args.requireLength(DELETE_VM, 1); err != nil {
+		return DeleteVMArguments{}, err
+	}
+
+	vmCID, err := args.requiredString(0, "vm cid")
+	if err != nil {
+		return DeleteVMArguments{}, err
+	}
+
+	return DeleteVMArguments{VMCID: vmCID}, nil
+}
+
+func NewHasVMArguments(args MethodArguments) (HasVMArguments, error) {
+	if err := args.requireLength(HAS_VM, 1); err != nil {
+		return HasVMArguments{}, err
+	}
+
+	vmCID, err := args.requiredString(0, "vm cid")
+	if err != nil {
+		return HasVMArguments{}, err
+	}
+
+	return HasVMArguments{VMCID: vmCID}, nil
+}
+
+func NewSetVMMetadataArguments(args MethodArguments) (SetVMMetadataArguments, error) {
+	if err := args.requireLength(SET_VM_METADATA, 2); err != nil {
+		return SetVMMetadataArguments{}, err
+	}
+
+	vmCID, err := args.requiredString(0, "vm cid")
+	if err != nil {
+		return SetVMMetadataArguments{}, err
+	}
+
+	metadata, err := args.optionalMap(1, "metadata")
+	if err != nil {
+		return SetVMMetadataArguments{}, err
+	}
+
+	return SetVMMetadataArguments{VMCID: vmCID, Metadata: metadata}, nil
+}
+
+func NewCreateDiskArguments(args MethodArguments) (CreateDiskArguments, error) {
+	if err := args.requireLength(CREATE_DISK, 3); err != nil {
+		return CreateDiskArguments{}, err
+	}
+
+	size, err := args.positiveInt(0, "disk size")
+	if err != nil {
+		return CreateDiskArguments{}, err
+	}
+
+	cloudProperties, err := args.optionalMap(1, "disk cloud properties")
+	if err != nil {
+		return CreateDiskArguments{}, err
+	}
+
+	vmCID, err := args.optionalString(2, "vm cid")
+	if err != nil {
+		return CreateDiskArguments{}, err
+	}
+
+	return CreateDiskArguments{SizeInMB: size, CloudProperties: cloudProperties, VMCID: vmCID}, nil
+}
+
+func NewDeleteDiskArguments(args MethodArguments) (DeleteDiskArguments, error) {
+	if err := args.requireLength(DELETE_DISK, 1); err != nil {
+		return DeleteDiskArguments{}, err
+	}
+
+	diskCID, err := args.requiredString(0, "disk cid")
+	if err != nil {
+		return DeleteDiskArguments{}, err
+	}
+
+	return DeleteDiskArguments{DiskCID: diskCID}, nil
+}
+
+func NewAttachDiskArguments(args MethodArguments) (AttachDiskArguments, error) {
+	if err := args.requireLength(ATTACH_DISK, 2); err != nil {
+		return AttachDiskArguments{}, err
+	}
+
+	vmCID, err := args.requiredString(0, "vm cid")
+	if err != nil {
+		return AttachDiskArguments{}, err
+	}
+
+	diskCID, err := args.requiredString(1, "disk cid")
+	if err != nil {
+		return AttachDiskArguments{}, err
+	}
+
+	return AttachDiskArguments{VMCID: vmCID, DiskCID: diskCID}, nil
+}
+
+func NewDetachDiskArguments(args MethodArguments) (DetachDiskArguments, error) {
+	if err := args.requireLength(DETACH_DISK, 2); err != nil {
+		return DetachDiskArguments{}, err
+	}
+
+	vmCID, err := args.requiredString(0, "vm cid")
+	if err != nil {
+		return DetachDiskArguments{}, err
+	}
+
+	diskCID, err := args.requiredString(1, "disk cid")
+	if err != nil {
+		return DetachDiskArguments{}, err
+	}
+
+	return DetachDiskArguments{VMCID: vmCID, DiskCID: diskCID}, nil
+}
+
+func NewHasDiskArguments(args MethodArguments) (HasDiskArguments, error) {
+	if err := args.requireLength(HAS_DISK, 1); err != nil {
+		return HasDiskArguments{}, err
+	}
+
+	// an empty disk cid is valid input, has_disk simply answers false
+	diskCID, err := args.optionalString(0, "disk cid")
+	if err != nil {
+		return HasDiskArguments{}, err
+	}
+
+	return HasDiskArguments{DiskCID: diskCID}, nil
+}
+
+func NewGetDisksArguments(args MethodArguments) (GetDisksArguments, error) {
+	if err := args.requireLength(GET_DISKS, 1); err != nil {
+		return GetDisksArguments{}, err
+	}
+
+	vmCID, err := args.requiredString(0, "vm cid")
+	if err != nil {
+		return GetDisksArguments{}, err
+	}
+
+	return GetDisksArguments{VMCID: vmCID}, nil
+}
+
+func NewSetDiskMetadataArguments(args MethodArguments) (SetDiskMetadataArguments, error) {
+	if err := args.requireLength(SET_DISK_METADATA, 2); err != nil {
+		return SetDiskMetadataArguments{}, err
+	}
+
+	diskCID, err := args.requiredString(0, "disk cid")
+	if err != nil {
+		return SetDiskMetadataArguments{}, err
+	}
+
+	metadata, err := args.optionalMap(1, "metadata")
+	if err != nil {
+		return SetDiskMetadataArguments{}, err
+	}
+
+	return SetDiskMetadataArguments{DiskCID: diskCID, Metadata: metadata}, nil
+}
+
+func NewResizeDiskArguments(args MethodArguments) (ResizeDiskArguments, error) {
+	if err := args.requireLength(RESIZE_DISK, 2); err != nil {
+		return ResizeDiskArguments{}, err
+	}
+
+	diskCID, err := args.requiredString(0, "disk cid")
+	if err != nil {
+		return ResizeDiskArguments{}, err
+	}
+
+	size, err := args.positiveInt(1, "new disk size")
+	if err != nil {
+		return ResizeDiskArguments{}, err
+	}
+
+	return ResizeDiskArguments{DiskCID: diskCID, NewSizeInMB: size}, nil
+}
+
+func (a *CreateStemcellArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewCreateStemcellArguments(args)
+	}
+	return err
+}
+
+func (a *DeleteStemcellArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewDeleteStemcellArguments(args)
+	}
+	return err
+}
+
+func (a *CreateVMArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewCreateVMArguments(args)
+	}
+	return err
+}
+
+func (a *DeleteVMArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewDeleteVMArguments(args)
+	}
+	return err
+}
+
+func (a *HasVMArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewHasVMArguments(args)
+	}
+	return err
+}
+
+func (a *SetVMMetadataArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewSetVMMetadataArguments(args)
+	}
+	return err
+}
+
+func (a *CreateDiskArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewCreateDiskArguments(args)
+	}
+	return err
+}
+
+func (a *DeleteDiskArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewDeleteDiskArguments(args)
+	}
+	return err
+}
+
+func (a *AttachDiskArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewAttachDiskArguments(args)
+	}
+	return err
+}
+
+func (a *DetachDiskArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewDetachDiskArguments(args)
+	}
+	return err
+}
+
+func (a *HasDiskArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewHasDiskArguments(args)
+	}
+	return err
+}
+
+func (a *GetDisksArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewGetDisksArguments(args)
+	}
+	return err
+}
+
+func (a *SetDiskMetadataArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewSetDiskMetadataArguments(args)
+	}
+	return err
+}
+
+func (a *ResizeDiskArguments) UnmarshalJSON(b []byte) (err error) {
+	args, err := unmarshalMethodArguments(b)
+	if err == nil {
+		*a, err = NewResizeDiskArguments(args)
+	}
+	return err
+}
+
+func unmarshalMethodArguments(b []byte) (MethodArguments, error) {
+	var args MethodArguments
+	err := json.Unmarshal(b, &args)
+	if err != nil {
+		return nil, fmt.Errorf("method arguments must be an array: %s", err)
+	}
+	return args, nil
+}
+
+func (args MethodArguments) requireLength(method string, length int) error {
+	if len(args) < length {
+		return fmt.Errorf("%s expects at least %d arguments, received %d", method, length, len(args))
+	}
+	return nil
+}
+
+func (args MethodArguments) at(i int) interface{} {
+	if i >= len(args) {
+		return nil
+	}
+	return args[i]
+}
+
+func (args MethodArguments) optionalString(i int, name string) (string, error) {
+	value := args.at(i)
+	if value == nil {
+		return "", nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return "", unexpectedTypeError(name, value, "a string")
+	}
+	return s, nil
+}
+
+func (args MethodArguments) requiredString(i int, name string) (string, error) {
+	if args.at(i) == nil {
+		return "", fmt.Errorf("%s is missing", name)
+	}
+
+	s, err := args.optionalString(i, name)
+	if err != nil {
+		return "", err
+	}
+
+	if s == "" {
+		return "", fmt.Errorf("%s cannot be empty", name)
+	}
+	return s, nil
+}
+
+func (args MethodArguments) optionalMap(i int, name string) (map[string]interface{}, error) {
+	value := args.at(i)
+	if value == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, unexpectedTypeError(name, value, "a map")
+	}
+	return m, nil
+}
+
+func (args MethodArguments) optionalStringSlice(i int, name string) ([]string, error) {
+	value := args.at(i)
+	if value == nil {
+		return []string{}, nil
+	}
+
+	elements, ok := value.([]interface{})
+	if !ok {
+		return nil, unexpectedTypeError(name, value, "an array")
+	}
+
+	result := make([]string, 0, len(elements))
+	for j, element := range elements {
+		s, ok := element.(string)
+		if !ok {
+			return nil, unexpectedTypeError(fmt.Sprintf("%s element %d", name, j), element, "a string")
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+func (args MethodArguments) positiveInt(i int, name string) (int, error) {
+	value := args.at(i)
+	if value == nil {
+		return 0, fmt.Errorf("%s is missing", name)
+	}
+
+	f, ok := value.(float64)
+	if !ok {
+		return 0, unexpectedTypeError(name, value, "a number")
+	}
+
+	if f <= 0 || f != math.Trunc(f) || f > maxExactFloatInt {
+		return 0, fmt.Errorf("%s must be a positive integer, received %v", name, f)
+	}
+	return int(f), nil
+}
+
+// JSON numbers arrive as float64, which only holds integers exactly up to 2^53
+const maxExactFloatInt = 1 << 53
+
+func unexpectedTypeError(name string, value interface{}, expected string) error {
+	return fmt.Errorf("%s has unexpected type: %s. Expecting %s", name, reflect.TypeOf(value), expected)
+}
@@ -0,0 +1,291 @@
+package bosh_test
+
+import (
+	"encoding/json"
+	"math/rand"
+
+	"github.com/onsi/ginkgo/config"
+	"github.com/rackhd/rackhd-cpi/bosh"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func parseArguments(jsonInput string) bosh.MethodArguments {
+	var args bosh.MethodArguments
+	err := json.Unmarshal([]byte(jsonInput), &args)
+	Expect(err).ToNot(HaveOccurred())
+	return args
+}
+
+var _ = Describe("method arguments", func() {
+	Describe("NewCreateVMArguments", func() {
+		It("decodes a valid request", func() {
+			args := parseArguments(`[
+				"4149ba0f-38d9-4485-476f-1581be36f290",
+				"vm-478585",
+				{"public_key": "MTIzNA=="},
+				{"private": {"type": "dynamic", "dns": ["8.8.8.8"]}},
+				["disk_cid-nodeid-uuid"],
+				{"bosh": {}}
+			]`)
+
+			input, err := bosh.NewCreateVMArguments(args)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(input.AgentID).To(Equal("4149ba0f-38d9-4485-476f-1581be36f290"))
+			Expect(input.StemcellCID).To(Equal("vm-478585"))
+			Expect(input.CloudProperties).To(HaveKeyWithValue("public_key", "MTIzNA=="))
+			Expect(input.Networks).To(Equal(map[string]bosh.Network{
+				"private": bosh.Network{NetworkType: bosh.DynamicNetworkType, DNS: []string{"8.8.8.8"}},
+			}))
+			Expect(input.DiskCIDs).To(Equal([]string{"disk_cid-nodeid-uuid"}))
+			Expect(input.Env).To(HaveKey("bosh"))
+		})
+
+		It("accepts a request without env", func() {
+			args := parseArguments(`["agent", "stemcell", {}, {}, []]`)
+
+			input, err := bosh.NewCreateVMArguments(args)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(input.Env).To(BeEmpty())
+		})
+
+		It("returns an error if too few arguments are provided", func() {
+			args := parseArguments(`["agent", "stemcell"]`)
+
+			_, err := bosh.NewCreateVMArguments(args)
+			Expect(err).To(MatchError("create_vm expects at least 5 arguments, received 2"))
+		})
+
+		It("returns an error if agent id is empty", func() {
+			args := parseArguments(`["", "stemcell", {}, {}, []]`)
+
+			_, err := bosh.NewCreateVMArguments(args)
+			Expect(err).To(MatchError("agent id cannot be empty"))
+		})
+
+		It("returns an error if agent id is of an unexpected type", func() {
+			args := parseArguments(`[{}, "stemcell", {}, {}, []]`)
+
+			_, err := bosh.NewCreateVMArguments(args)
+			Expect(err).To(MatchError("agent id has unexpected type: map[string]interface {}. Expecting a string"))
+		})
+
+		It("returns an error if the network config is of an unexpected type", func() {
+			args := parseArguments(`["agent", "stemcell", {}, "aint-gonna-work-network", []]`)
+
+			_, err := bosh.NewCreateVMArguments(args)
+			Expect(err).To(MatchError("network config has unexpected type: string. Expecting a map"))
+		})
+
+		It("returns an error if the disk config is of an unexpected type", func() {
+			args := parseArguments(`["agent", "stemcell", {}, {}, "aint gon work disks"]`)
+
+			_, err := bosh.NewCreateVMArguments(args)
+			Expect(err).To(MatchError("disk config has unexpected type: string. Expecting an array"))
+		})
+
+		It("returns an error if a disk cid is of an unexpected type", func() {
+			args := parseArguments(`["agent", "stemcell", {}, {}, ["disk_cid-1", 2]]`)
+
+			_, err := bosh.NewCreateVMArguments(args)
+			Expect(err).To(MatchError("disk config element 1 has unexpected type: float64. Expecting a string"))
+		})
+	})
+
+	Describe("NewCreateDiskArguments", func() {
+		It("decodes a valid request", func() {
+			args := parseArguments(`[25000, {"some": "options"}, "vm-1234"]`)
+
+			input, err := bosh.NewCreateDiskArguments(args)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(input).To(Equal(bosh.CreateDiskArguments{
+				SizeInMB:        25000,
+				CloudProperties: map[string]interface{}{"some": "options"},
+				VMCID:           "vm-1234",
+			}))
+		})
+
+		It("accepts a null vm cid", func() {
+			args := parseArguments(`[25000, {}, null]`)
+
+			input, err := bosh.NewCreateDiskArguments(args)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(input.VMCID).To(BeEmpty())
+		})
+
+		It("returns an error if the size is not a number", func() {
+			args := parseArguments(`["25000", {}, "vm-1234"]`)
+
+			_, err := bosh.NewCreateDiskArguments(args)
+			Expect(err).To(MatchError("disk size has unexpected type: string. Expecting a number"))
+		})
+
+		It("returns an error if the size is not a positive integer", func() {
+			for _, size := range []string{"0", "-1", "1.5"} {
+				args := parseArguments(`[` + size + `, {}, "vm-1234"]`)
+
+				_, err := bosh.NewCreateDiskArguments(args)
+				Expect(err).To(MatchError(ContainSubstring("disk size must be a positive integer")))
+			}
+		})
+
+		It("returns an error if the vm cid is of an unexpected type", func() {
+			args := parseArguments(`[25000, {}, 1234]`)
+
+			_, err := bosh.NewCreateDiskArguments(args)
+			Expect(err).To(MatchError("vm cid has unexpected type: float64. Expecting a string"))
+		})
+	})
+
+	Describe("NewAttachDiskArguments", func() {
+		It("decodes a valid request", func() {
+			args := parseArguments(`["vm_cid-fake_uuid", "disk_cid-fake_uuid"]`)
+
+			input, err := bosh.NewAttachDiskArguments(args)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(input).To(Equal(bosh.AttachDiskArguments{VMCID: "vm_cid-fake_uuid", DiskCID: "disk_cid-fake_uuid"}))
+		})
+
+		It("returns an error if the disk cid is missing", func() {
+			args := parseArguments(`["vm_cid-fake_uuid", null]`)
+
+			_, err := bosh.NewAttachDiskArguments(args)
+			Expect(err).To(MatchError("disk cid is missing"))
+		})
+	})
+
+	Describe("NewHasDiskArguments", func() {
+		It("accepts an empty disk cid", func() {
+			args := parseArguments(`[""]`)
+
+			input, err := bosh.NewHasDiskArguments(args)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(input.DiskCID).To(BeEmpty())
+		})
+	})
+
+	Describe("NewSetDiskMetadataArguments", func() {
+		It("decodes a valid request", func() {
+			args := parseArguments(`["disk_cid-fake_uuid", {"director": "bosh", "index": 0}]`)
+
+			input, err := bosh.NewSetDiskMetadataArguments(args)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(input.DiskCID).To(Equal("disk_cid-fake_uuid"))
+			Expect(input.Metadata).To(Equal(map[string]interface{}{"director": "bosh", "index": float64(0)}))
+		})
+
+		It("returns an error if the metadata is not a map", func() {
+			args := parseArguments(`["disk_cid-fake_uuid", "metadata"]`)
+
+			_, err := bosh.NewSetDiskMetadataArguments(args)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("NewResizeDiskArguments", func() {
+		It("decodes a valid request", func() {
+			args := parseArguments(`["disk_cid-fake_uuid", 4096]`)
+
+			input, err := bosh.NewResizeDiskArguments(args)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(input).To(Equal(bosh.ResizeDiskArguments{DiskCID: "disk_cid-fake_uuid", NewSizeInMB: 4096}))
+		})
+
+		It("returns an error if the new size is missing", func() {
+			args := parseArguments(`["disk_cid-fake_uuid"]`)
+
+			_, err := bosh.NewResizeDiskArguments(args)
+			Expect(err).To(MatchError("resize_disk expects at least 2 arguments, received 1"))
+		})
+	})
+
+	Describe("NewCreateStemcellArguments", func() {
+		It("returns an error if the image path is of an unexpected type", func() {
+			args := parseArguments(`[{"foo": "bar"}, {}]`)
+
+			_, err := bosh.NewCreateStemcellArguments(args)
+			Expect(err).To(MatchError("stemcell image path has unexpected type: map[string]interface {}. Expecting a string"))
+		})
+	})
+
+	Describe("unmarshalling typed arguments from JSON", func() {
+		It("decodes the positional array", func() {
+			var input bosh.DetachDiskArguments
+			err := json.Unmarshal([]byte(`["vm-1234", "disk-1234"]`), &input)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(input).To(Equal(bosh.DetachDiskArguments{VMCID: "vm-1234", DiskCID: "disk-1234"}))
+		})
+
+		It("returns an error if the arguments are not an array", func() {
+			var input bosh.DeleteVMArguments
+			err := json.Unmarshal([]byte(`{"vm_cid": "vm-1234"}`), &input)
+			Expect(err).To(MatchError(ContainSubstring("method arguments must be an array")))
+		})
+	})
+
+	Describe("malformed requests", func() {
+		parsers := map[string]func(bosh.MethodArguments) error{
+			bosh.CREATE_STEMCELL:   func(a bosh.MethodArguments) error { _, err := bosh.NewCreateStemcellArguments(a); return err },
+			bosh.DELETE_STEMCELL:   func(a bosh.MethodArguments) error { _, err := bosh.NewDeleteStemcellArguments(a); return err },
+			bosh.CREATE_VM:         func(a bosh.MethodArguments) error { _, err := bosh.NewCreateVMArguments(a); return err },
+			bosh.DELETE_VM:         func(a bosh.MethodArguments) error { _, err := bosh.NewDeleteVMArguments(a); return err },
+			bosh.HAS_VM:            func(a bosh.MethodArguments) error { _, err := bosh.NewHasVMArguments(a); return err },
+			bosh.SET_VM_METADATA:   func(a bosh.MethodArguments) error { _, err := bosh.NewSetVMMetadataArguments(a); return err },
+			bosh.CREATE_DISK:       func(a bosh.MethodArguments) error { _, err := bosh.NewCreateDiskArguments(a); return err },
+			bosh.DELETE_DISK:       func(a bosh.MethodArguments) error { _, err := bosh.NewDeleteDiskArguments(a); return err },
+			bosh.ATTACH_DISK:       func(a bosh.MethodArguments) error { _, err := bosh.NewAttachDiskArguments(a); return err },
+			bosh.DETACH_DISK:       func(a bosh.MethodArguments) error { _, err := bosh.NewDetachDiskArguments(a); return err },
+			bosh.HAS_DISK:          func(a bosh.MethodArguments) error { _, err := bosh.NewHasDiskArguments(a); return err },
+			bosh.GET_DISKS:         func(a bosh.MethodArguments) error { _, err := bosh.NewGetDisksArguments(a); return err },
+			bosh.RESIZE_DISK:       func(a bosh.MethodArguments) error { _, err := bosh.NewResizeDiskArguments(a); return err },
+			bosh.SET_DISK_METADATA: func(a bosh.MethodArguments) error { _, err := bosh.NewSetDiskMetadataArguments(a); return err },
+		}
+
+		values := []interface{}{
+			nil,
+			"",
+			"a-string",
+			float64(0),
+			float64(-3),
+			float64(2.5),
+			float64(1024),
+			true,
+			[]interface{}{},
+			[]interface{}{"a", float64(1), nil},
+			map[string]interface{}{},
+			map[string]interface{}{"private": "not-a-network"},
+			map[string]interface{}{"private": map[string]interface{}{"ip": float64(1)}},
+		}
+
+		It("never panics on truncated argument lists", func() {
+			for method, parse := range parsers {
+				for length := 0; length < 7; length++ {
+					args := make(bosh.MethodArguments, length)
+					Expect(func() { parse(args) }).ToNot(Panic(), "%s with %d nil arguments", method, length)
+				}
+			}
+		})
+
+		It("never panics on randomly typed arguments", func() {
+			r := rand.New(rand.NewSource(config.GinkgoConfig.RandomSeed))
+
+			for method, parse := range parsers {
+				for i := 0; i < 500; i++ {
+					args := make(bosh.MethodArguments, r.Intn(8))
+					for j := range args {
+						args[j] = values[r.Intn(len(values))]
+					}
+					Expect(func() { parse(args) }).ToNot(Panic(), "%s with arguments %#v", method, args)
+				}
+			}
+		})
+
+		It("returns an error when a required argument is of the wrong type", func() {
+			for method, parse := range parsers {
+				args := bosh.MethodArguments{true, true, true, true, true, true}
+				Expect(parse(args)).To(HaveOccurred(), method)
+			}
+		})
+	})
+})
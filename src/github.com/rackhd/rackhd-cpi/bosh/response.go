@@ -8,8 +8,23 @@ import (
 const (
 	DefaultErrorType        = "Bosh::Clouds::CloudError"
 	NotImplementedErrorType = "Bosh::Clouds::NotImplemented"
+	NotSupportedErrorType   = "Bosh::Clouds::NotSupported"
 )
 
+// NotSupportedError is returned when the CPI implements a method but cannot carry out this request,
+// so the director falls back to another strategy
+type NotSupportedError struct {
+	message string
+}
+
+func NewNotSupportedError(format string, args ...interface{}) NotSupportedError {
+	return NotSupportedError{message: fmt.Sprintf(format, args...)}
+}
+
+func (e NotSupportedError) Error() string {
+	return e.message
+}
+
 type ResponseError struct {
 	Type      string `json:"type"`
 	Message   string `json:"message"`
@@ -44,6 +59,12 @@ func BuildErrorResponse(err error, errType string, retryable bool, logOutput str
 	return fmt.Sprint(string(resBytes))
 }
 
+// BuildPanicResponse reports a recovered panic as a non-retryable CloudError, appending the stack trace to the log
+func BuildPanicResponse(recovered interface{}, stack []byte, logOutput string) string {
+	err := fmt.Errorf("CPI panicked: %v", recovered)
+	return BuildDefaultErrorResponse(err, false, fmt.Sprintf("%s%s\n%s", logOutput, err, stack))
+}
+
 func BuildResultResponse(result interface{}, logOutput string) string {
 	res := CpiResponse{Result: result, Log: logOutput}
 
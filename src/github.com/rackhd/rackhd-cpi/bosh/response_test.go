@@ -53,6 +53,27 @@ var _ = Describe("response handling", func() {
 		})
 	})
 
+	Describe("exiting after a panic", func() {
+		It("wraps the panic in a non-retryable CloudError with the stack trace in the log", func() {
+			stack := []byte("goroutine 1 [running]:\nmain.main()")
+			panicResp := bosh.BuildPanicResponse("runtime error: index out of range", stack, "earlier log line\n")
+			panicRespBytes := []byte(panicResp)
+
+			targetResponse := bosh.CpiResponse{}
+			err := json.Unmarshal(panicRespBytes, &targetResponse)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(targetResponse.Result).To(BeNil())
+			Expect(targetResponse.Log).To(HavePrefix("earlier log line\n"))
+			Expect(targetResponse.Log).To(ContainSubstring("goroutine 1 [running]:"))
+
+			targetResponseErr := targetResponse.Error
+			Expect(targetResponseErr.Type).To(Equal(bosh.DefaultErrorType))
+			Expect(targetResponseErr.Message).To(Equal("CPI panicked: runtime error: index out of range"))
+			Expect(targetResponseErr.Retryable).To(BeFalse())
+		})
+	})
+
 	Describe("exiting successfully", func() {
 		It("wraps the response in a CpiResponse", func() {
 			resultMsg := "successful result"
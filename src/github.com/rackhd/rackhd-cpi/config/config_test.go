@@ -1,7 +1,12 @@
 package config_test
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/rackhd/rackhd-cpi/bosh"
 	"github.com/rackhd/rackhd-cpi/config"
@@ -30,20 +35,20 @@ var _ = Describe("Creating a config", func() {
 	})
 
 	It("checks that the agent configuration includes a blobstore section", func() {
-		jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"mbus":"localhost"}}`)
+		jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"mbus":"nats://localhost:4222"}}`)
 		_, err := config.New(jsonReader, request)
-		Expect(err).To(MatchError(`Agent config invalid {map[] localhost []}`))
+		Expect(err).To(MatchError(`Agent config invalid {map[] nats://localhost:4222 []}`))
 	})
 
 	It("checks that the agent configuration includes a blobstore provider section", func() {
-		jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"mbus":"localhost","blobstore":{"some": "options"}}}`)
+		jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"mbus":"nats://localhost:4222","blobstore":{"some": "options"}}}`)
 		_, err := config.New(jsonReader, request)
-		Expect(err).To(MatchError(`Agent config invalid {map[some:options] localhost []}`))
+		Expect(err).To(MatchError(`Agent config invalid {map[some:options] nats://localhost:4222 []}`))
 	})
 
 	Context("with a request to create a VM", func() {
 		It("sets a default for max_create_vm_attmpts if not provided", func() {
-			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"localhost"}}`)
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}}`)
 			request.Method = bosh.CREATE_VM
 			c, err := config.New(jsonReader, request)
 			Expect(err).ToNot(HaveOccurred())
@@ -53,7 +58,7 @@ var _ = Describe("Creating a config", func() {
 
 	Context("with a request to create a VM", func() {
 		It("sets a default for max_create_vm_attmpts if not provided", func() {
-			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"localhost"}}`)
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}}`)
 			request.Method = bosh.CREATE_DISK
 			c, err := config.New(jsonReader, request)
 			Expect(err).ToNot(HaveOccurred())
@@ -63,7 +68,7 @@ var _ = Describe("Creating a config", func() {
 
 	Context("without a request to create a VM or disk", func() {
 		It("sets max_create_vm_attmpts to zero if not provided", func() {
-			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"localhost"}}`)
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}}`)
 			c, err := config.New(jsonReader, request)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(c.MaxReserveNodeAttempts).To(Equal(0))
@@ -72,7 +77,7 @@ var _ = Describe("Creating a config", func() {
 
 	Context("when uuid is not set", func() {
 		It("generates a new one", func() {
-			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"localhost"}}`)
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}}`)
 			c, err := config.New(jsonReader, request)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(c.RequestID).ToNot(Equal(""))
@@ -81,10 +86,252 @@ var _ = Describe("Creating a config", func() {
 
 	Context("when uuid is set", func() {
 		It("uses the specified uuid", func() {
-			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"localhost"}, "request_id": "9999"}`)
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}, "request_id": "9999"}`)
 			c, err := config.New(jsonReader, request)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(c.RequestID).To(Equal("9999"))
 		})
 	})
+
+	Context("when disk_wipe_policy is not set", func() {
+		It("does not wipe deleted disks", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}}`)
+			c, err := config.New(jsonReader, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.DiskWipePolicy).To(Equal(config.DiskWipeNone))
+		})
+	})
+
+	Context("when disk_wipe_policy is set", func() {
+		It("uses the specified policy", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}, "disk_wipe_policy": "secure_erase"}`)
+			c, err := config.New(jsonReader, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.DiskWipePolicy).To(Equal(config.DiskWipeSecureErase))
+		})
+
+		It("rejects an unknown policy", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}, "disk_wipe_policy": "shred"}`)
+			_, err := config.New(jsonReader, request)
+			Expect(err).To(MatchError(`Invalid config. DiskWipePolicy must be one of none, quick, full or secure_erase, got "shred"`))
+		})
+	})
+
+	Context("with OBM credentials", func() {
+		It("overrides the defaults with those of the manufacturer, the pool and then the node", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}, "obm": {"username": "admin", "password": "default-password", "manufacturers": {"Dell Inc.": {"username": "root", "password": "calvin"}}, "pools": [{"tag": "desktops", "service": "amt", "password": "amt-password"}, {"tag": "other-desktops", "service": "redfish"}], "nodes": {"node-id": {"password": "node-password"}}}}`)
+			c, err := config.New(jsonReader, request)
+			Expect(err).ToNot(HaveOccurred())
+
+			credentials := c.OBM.CredentialsFor("other-node-id", "", nil)
+			Expect(credentials).To(Equal(config.OBMCredentials{Username: "admin", Password: "default-password"}))
+
+			credentials = c.OBM.CredentialsFor("other-node-id", " dell inc.", nil)
+			Expect(credentials).To(Equal(config.OBMCredentials{Username: "root", Password: "calvin"}))
+
+			credentials = c.OBM.CredentialsFor("other-node-id", "Dell Inc.", []string{"other-desktops", "desktops"})
+			Expect(credentials).To(Equal(config.OBMCredentials{Service: config.OBMServiceAMT, Username: "root", Password: "amt-password"}))
+
+			credentials = c.OBM.CredentialsFor("node-id", "Dell Inc.", []string{"desktops"})
+			Expect(credentials).To(Equal(config.OBMCredentials{Service: config.OBMServiceAMT, Username: "root", Password: "node-password"}))
+		})
+
+		It("fills in the credentials left out from the credentials file", func() {
+			file, err := ioutil.TempFile("", "obm-credentials")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.Remove(file.Name())
+			_, err = file.WriteString(`{"service_name": "amt-obm-service", "username": "admin", "password": "file-password", "nodes": {"node-id": {"password": "file-node-password"}}}`)
+			Expect(err).ToNot(HaveOccurred())
+			file.Close()
+
+			jsonReader := strings.NewReader(fmt.Sprintf(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}, "obm": {"password": "inline-password", "credentials_file": "%s"}}`, file.Name()))
+			c, err := config.New(jsonReader, request)
+			Expect(err).ToNot(HaveOccurred())
+
+			credentials := c.OBM.CredentialsFor("other-node-id", "", nil)
+			Expect(credentials).To(Equal(config.OBMCredentials{ServiceName: "amt-obm-service", Username: "admin", Password: "inline-password"}))
+
+			credentials = c.OBM.CredentialsFor("node-id", "", nil)
+			Expect(credentials.Password).To(Equal("file-node-password"))
+		})
+
+		It("returns an error when the credentials file cannot be read", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}, "obm": {"credentials_file": "/does/not/exist"}}`)
+			_, err := config.New(jsonReader, request)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects an unknown OBM service", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}, "obm": {"pools": [{"tag": "pdus", "service": "wol"}]}}`)
+			_, err := config.New(jsonReader, request)
+			Expect(err).To(MatchError(`Invalid config. OBM service must be one of ipmi, amt, redfish or snmp-pdu, got "wol"`))
+		})
+	})
+
+	Context("with a YAML config", func() {
+		It("reads the same keys as the JSON config", func() {
+			yamlReader := strings.NewReader(`
+api_url: http://localhost:8080
+agent:
+  blobstore:
+    provider: local
+    options:
+      blobstore_path: /var/vcap/micro_bosh/data/cache
+  mbus: nats://localhost:4222
+run_workflow_timeout: 20m
+disk_wipe_policy: quick
+`)
+			c, err := config.New(yamlReader, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.ApiServer).To(Equal("http://localhost:8080"))
+			Expect(c.Agent.Mbus).To(Equal("nats://localhost:4222"))
+			Expect(c.Agent.Blobstore["options"]).To(Equal(map[string]interface{}{"blobstore_path": "/var/vcap/micro_bosh/data/cache"}))
+			Expect(c.RunWorkflowTimeout).To(Equal(config.Duration(20 * time.Minute)))
+			Expect(c.DiskWipePolicy).To(Equal(config.DiskWipeQuick))
+		})
+	})
+
+	Context("with run_workflow_timeout", func() {
+		It("defaults to 20 minutes", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}}`)
+			c, err := config.New(jsonReader, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.RunWorkflowTimeout).To(Equal(config.Duration(20 * time.Minute)))
+		})
+
+		It("reads a number as seconds", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}, "run_workflow_timeout": 90}`)
+			c, err := config.New(jsonReader, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.RunWorkflowTimeout).To(Equal(config.Duration(90 * time.Second)))
+		})
+
+		It("reads a duration string", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}, "run_workflow_timeout": "1h30m"}`)
+			c, err := config.New(jsonReader, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.RunWorkflowTimeout).To(Equal(config.Duration(90 * time.Minute)))
+		})
+
+		It("rejects an invalid duration", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}, "run_workflow_timeout": "soon"}`)
+			_, err := config.New(jsonReader, request)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`got "soon"`))
+		})
+	})
+
+	Context("with timeouts", func() {
+		It("falls back to run_workflow_timeout for the workflows left out", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}, "run_workflow_timeout": "30m", "timeouts": {"reserve": "2m", "provision": "45m", "http": "1m"}}`)
+			c, err := config.New(jsonReader, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.Timeouts).To(Equal(config.TimeoutsConfig{
+				Reserve:      config.Duration(2 * time.Minute),
+				Provision:    config.Duration(45 * time.Minute),
+				Deprovision:  config.Duration(30 * time.Minute),
+				WipeDisk:     config.Duration(30 * time.Minute),
+				RelocateDisk: config.Duration(30 * time.Minute),
+				OBM:          config.Duration(30 * time.Minute),
+				HTTP:         config.Duration(time.Minute),
+			}))
+		})
+
+		It("leaves the HTTP timeout unset by default", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}}`)
+			c, err := config.New(jsonReader, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.Timeouts.HTTP).To(BeZero())
+			Expect(c.Timeouts.Reserve).To(Equal(config.Duration(20 * time.Minute)))
+		})
+
+		It("rejects a negative timeout", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}, "timeouts": {"deprovision": "-5m"}}`)
+			_, err := config.New(jsonReader, request)
+			Expect(err).To(MatchError("Invalid config. timeouts.deprovision cannot be negative, got -5m0s"))
+		})
+
+		It("overrides the timeouts set by a vm_type", func() {
+			timeouts := config.TimeoutsConfig{Reserve: config.Duration(2 * time.Minute), Provision: config.Duration(20 * time.Minute)}
+			overridden := timeouts.OverriddenBy(config.TimeoutsConfig{Provision: config.Duration(45 * time.Minute)})
+			Expect(overridden).To(Equal(config.TimeoutsConfig{Reserve: config.Duration(2 * time.Minute), Provision: config.Duration(45 * time.Minute)}))
+		})
+	})
+
+	Context("with environment overrides", func() {
+		AfterEach(func() {
+			os.Unsetenv("RACKHD_CPI_API_URL")
+			os.Unsetenv("RACKHD_CPI_OBM_PASSWORD")
+			os.Unsetenv("RACKHD_CPI_MAX_RESERVE_NODE_ATTEMPTS")
+		})
+
+		It("replaces the keys of the config file", func() {
+			os.Setenv("RACKHD_CPI_API_URL", "https://rackhd.example.com:8443")
+			os.Setenv("RACKHD_CPI_OBM_PASSWORD", "from-env")
+			os.Setenv("RACKHD_CPI_MAX_RESERVE_NODE_ATTEMPTS", "3")
+
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}, "obm": {"username": "admin", "password": "from-file"}}`)
+			c, err := config.New(jsonReader, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.ApiServer).To(Equal("https://rackhd.example.com:8443"))
+			Expect(c.OBM.Username).To(Equal("admin"))
+			Expect(c.OBM.Password).To(Equal("from-env"))
+			Expect(c.MaxReserveNodeAttempts).To(Equal(3))
+		})
+
+		It("rejects a number that does not parse", func() {
+			os.Setenv("RACKHD_CPI_MAX_RESERVE_NODE_ATTEMPTS", "many")
+
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}}`)
+			_, err := config.New(jsonReader, request)
+			Expect(err).To(MatchError(`Invalid config. RACKHD_CPI_MAX_RESERVE_NODE_ATTEMPTS must be a number, got "many"`))
+		})
+	})
+
+	Context("when validating", func() {
+		It("rejects unknown keys", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222", "disks": {}}, "obm": {"pools": [{"tag": "a", "pasword": "x"}]}}`)
+			_, err := config.New(jsonReader, request)
+			Expect(err).To(MatchError("Invalid config. unknown key agent.disks"))
+		})
+
+		It("rejects an api_url that is not an http URL", func() {
+			jsonReader := strings.NewReader(`{"api_url":"localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}}`)
+			_, err := config.New(jsonReader, request)
+			Expect(err).To(MatchError(`Invalid config. api_url must be an http or https URL, got "localhost:8080"`))
+		})
+
+		It("rejects an mbus that is not a URL", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"localhost"}}`)
+			_, err := config.New(jsonReader, request)
+			Expect(err).To(MatchError(`Invalid config. agent.mbus must be a URL, got "localhost"`))
+		})
+
+		It("reports every problem at once", func() {
+			yamlReader := strings.NewReader(`
+api_url: localhost
+agent:
+  blobstore:
+    provider: local
+  mbus: localhost
+disk_wipe_policy: shred
+obm:
+  pools:
+  - tag: a
+    pasword: x
+`)
+			problems := config.Validate(yamlReader)
+			Expect(problems).To(Equal([]error{
+				errors.New("Invalid config. unknown key obm.pools[0].pasword"),
+				errors.New(`Invalid config. api_url must be an http or https URL, got "localhost"`),
+				errors.New(`Invalid config. DiskWipePolicy must be one of none, quick, full or secure_erase, got "shred"`),
+				errors.New(`Invalid config. agent.mbus must be a URL, got "localhost"`),
+			}))
+		})
+
+		It("returns no problems for a valid config", func() {
+			jsonReader := strings.NewReader(`{"api_url":"http://localhost:8080", "agent":{"blobstore": {"provider": "local", "some": "options"}, "mbus":"nats://localhost:4222"}}`)
+			Expect(config.Validate(jsonReader)).To(BeEmpty())
+		})
+	})
 })
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
+	"reflect"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -14,74 +16,175 @@ import (
 )
 
 const (
-	defaultMaxReserveNodeAttempts    = 5
-	defaultRunWorkflowTimeoutSeconds = 20 * 60
+	defaultMaxReserveNodeAttempts = 5
+	defaultRunWorkflowTimeout     = 20 * time.Minute
+)
+
+// Wipe policies applied to the drive of a deleted persistent disk
+const (
+	DiskWipeNone        = "none"
+	DiskWipeQuick       = "quick"
+	DiskWipeFull        = "full"
+	DiskWipeSecureErase = "secure_erase"
 )
 
 type Cpi struct {
-	ApiServer                 string        `json:"api_url"`
-	Agent                     AgentConfig   `json:"agent"`
-	MaxReserveNodeAttempts    int           `json:"max_reserve_node_attempts"`
-	RunWorkflowTimeoutSeconds time.Duration `json:"run_workflow_timeout"`
-	RequestID                 string        `json:"request_id"`
+	ApiServer              string         `json:"api_url"`
+	Agent                  AgentConfig    `json:"agent"`
+	MaxReserveNodeAttempts int            `json:"max_reserve_node_attempts"`
+	RunWorkflowTimeout     Duration       `json:"run_workflow_timeout"`
+	RequestID              string         `json:"request_id"`
+	DiskWipePolicy         string         `json:"disk_wipe_policy"`
+	OBM                    OBMConfig      `json:"obm"`
+	Timeouts               TimeoutsConfig `json:"timeouts"`
+	PreflightCheck         bool           `json:"preflight_check"`
 }
 
 type AgentConfig struct {
-	Blobstore map[string]interface{}
-	Mbus      string   `json:"mbus"`
-	Ntp       []string `json:"ntp"`
+	Blobstore map[string]interface{} `json:"blobstore"`
+	Mbus      string                 `json:"mbus"`
+	Ntp       []string               `json:"ntp"`
 }
 
 func DefaultMaxReserveNodeAttempts() int { return defaultMaxReserveNodeAttempts }
 
 func GetNewRandomSeed() int64 { return time.Now().UnixNano() }
 
+// New reads the JSON or YAML config, applies the RACKHD_CPI_ environment overrides and the defaults for request
 func New(config io.Reader, request bosh.CpiRequest) (Cpi, error) {
+	cpi, problems, err := load(config)
+	if err != nil {
+		return Cpi{}, err
+	}
+	if len(problems) > 0 {
+		return Cpi{}, problems[0]
+	}
+
+	if cpi.MaxReserveNodeAttempts == 0 && (request.Method == bosh.CREATE_VM || request.Method == bosh.CREATE_DISK) {
+		log.Info(fmt.Sprintf("No MaxReserveNodeAttempts was set, set to default value %d", defaultMaxReserveNodeAttempts))
+		cpi.MaxReserveNodeAttempts = defaultMaxReserveNodeAttempts
+	}
+
+	if cpi.RunWorkflowTimeout == 0 {
+		log.Info(fmt.Sprintf("No RunWorkflowTimeout was set, set to default value %s", defaultRunWorkflowTimeout))
+		cpi.RunWorkflowTimeout = Duration(defaultRunWorkflowTimeout)
+	}
+	cpi.Timeouts.setWorkflowDefaults(cpi.RunWorkflowTimeout)
+
+	if cpi.RequestID == "" {
+		uuid, err := uuid.NewV4()
+		if err != nil {
+			return Cpi{}, fmt.Errorf("Error generating uuid")
+		}
+		cpi.RequestID = uuid.String()
+		log.Info(fmt.Sprintf("Using uuid for request: %s", cpi.RequestID))
+	} else {
+		log.Info(fmt.Sprintf("Using specified id for request: %s", cpi.RequestID))
+	}
+
+	return cpi, nil
+}
+
+// Validate returns every problem of the config instead of stopping at the first one, without contacting RackHD
+func Validate(config io.Reader) []error {
+	_, problems, err := load(config)
+	if err != nil {
+		return []error{err}
+	}
+	return problems
+}
+
+// load parses the config and collects its problems; err is set only when the config cannot be read at all
+func load(config io.Reader) (Cpi, []error, error) {
 	b, err := ioutil.ReadAll(config)
 	if err != nil {
-		return Cpi{}, fmt.Errorf("Error reading config file %s", err)
+		return Cpi{}, nil, fmt.Errorf("Error reading config file %s", err)
+	}
+
+	document, err := parseDocument(b)
+	if err != nil {
+		return Cpi{}, nil, err
+	}
+
+	var problems []error
+	for _, problem := range applyEnvOverrides(document) {
+		problems = append(problems, errors.New(problem))
+	}
+	for _, key := range unknownKeys(reflect.TypeOf(Cpi{}), document, "") {
+		problems = append(problems, fmt.Errorf("Invalid config. unknown key %s", key))
+	}
+
+	b, err = json.Marshal(document)
+	if err != nil {
+		return Cpi{}, nil, fmt.Errorf("Error unmarshalling c config %s", err)
 	}
 
 	var cpi Cpi
 	err = json.Unmarshal(b, &cpi)
 	if err != nil {
-		return Cpi{}, fmt.Errorf("Error unmarshalling c config %s", err)
+		return Cpi{}, nil, fmt.Errorf("Error unmarshalling c config %s", err)
 	}
 
 	if cpi.ApiServer == "" {
-		return Cpi{}, errors.New("ApiServer IP is not set")
+		problems = append(problems, errors.New("ApiServer IP is not set"))
+	} else if !isHTTPURL(cpi.ApiServer) {
+		problems = append(problems, fmt.Errorf("Invalid config. api_url must be an http or https URL, got %q", cpi.ApiServer))
 	}
 
 	if cpi.MaxReserveNodeAttempts < 0 {
-		return Cpi{}, errors.New("Invalid config. MaxReserveNodeAttempts cannot be negative")
+		problems = append(problems, errors.New("Invalid config. MaxReserveNodeAttempts cannot be negative"))
 	}
 
-	if cpi.MaxReserveNodeAttempts == 0 && (request.Method == bosh.CREATE_VM || request.Method == bosh.CREATE_DISK) {
-		log.Info(fmt.Sprintf("No MaxReserveNodeAttempts was set, set to default value %d", defaultMaxReserveNodeAttempts))
-		cpi.MaxReserveNodeAttempts = defaultMaxReserveNodeAttempts
+	if cpi.RunWorkflowTimeout < 0 {
+		problems = append(problems, fmt.Errorf("Invalid config. run_workflow_timeout cannot be negative, got %s", cpi.RunWorkflowTimeout))
 	}
 
-	if cpi.RunWorkflowTimeoutSeconds == 0 {
-		log.Info(fmt.Sprintf("No RunWorkflowTimeoutSecounds was set, set to default value %d", defaultRunWorkflowTimeoutSeconds))
-		cpi.RunWorkflowTimeoutSeconds = defaultRunWorkflowTimeoutSeconds
+	err = cpi.Timeouts.Validate()
+	if err != nil {
+		problems = append(problems, fmt.Errorf("Invalid config. %s", err))
 	}
 
-	if cpi.RequestID == "" {
-		uuid, err := uuid.NewV4()
+	switch cpi.DiskWipePolicy {
+	case "":
+		cpi.DiskWipePolicy = DiskWipeNone
+	case DiskWipeNone, DiskWipeQuick, DiskWipeFull, DiskWipeSecureErase:
+	default:
+		problems = append(problems, fmt.Errorf("Invalid config. DiskWipePolicy must be one of %s, %s, %s or %s, got %q", DiskWipeNone, DiskWipeQuick, DiskWipeFull, DiskWipeSecureErase, cpi.DiskWipePolicy))
+	}
+
+	cpi.OBM, err = cpi.OBM.loadCredentialsFile()
+	if err != nil {
+		problems = append(problems, err)
+	} else {
+		err = cpi.OBM.validate()
 		if err != nil {
-			return Cpi{}, fmt.Errorf("Error generating uuid")
+			problems = append(problems, err)
 		}
-		cpi.RequestID = uuid.String()
-		log.Info(fmt.Sprintf("Using uuid for request: %s", cpi.RequestID))
-	} else {
-		log.Info(fmt.Sprintf("Using specified id for request: %s", cpi.RequestID))
 	}
 
 	if !isAgentConfigValid(cpi.Agent) {
-		return Cpi{}, fmt.Errorf("Agent config invalid %v", cpi.Agent)
+		problems = append(problems, fmt.Errorf("Agent config invalid %v", cpi.Agent))
+	} else if !isURL(cpi.Agent.Mbus) {
+		problems = append(problems, fmt.Errorf("Invalid config. agent.mbus must be a URL, got %q", cpi.Agent.Mbus))
 	}
 
-	return cpi, nil
+	return cpi, problems, nil
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func isURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return u.Scheme != "" && u.Host != ""
 }
 
 func isAgentConfigValid(config AgentConfig) bool {
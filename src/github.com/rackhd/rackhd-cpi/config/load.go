@@ -0,0 +1,301 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the environment variables overriding config keys, e.g. RACKHD_CPI_API_URL or RACKHD_CPI_OBM_PASSWORD
+const EnvPrefix = "RACKHD_CPI_"
+
+// Duration is a timeout given either as a duration string such as "20m" or as a number of seconds
+type Duration time.Duration
+
+// UnmarshalJSON accepts "20m" as well as 1200
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	err := json.Unmarshal(data, &seconds)
+	if err == nil {
+		*d = Duration(time.Duration(seconds * float64(time.Second)))
+		return nil
+	}
+
+	var s string
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string such as \"20m\" or a number of seconds, got %s", string(data))
+	}
+
+	parsed, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration as a duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("duration must be a string such as \"20m\" or a number of seconds, got %q", s)
+	}
+	return parsed, nil
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// parseDocument reads a JSON or YAML config into generic values with string keys
+func parseDocument(b []byte) (map[string]interface{}, error) {
+	document := map[string]interface{}{}
+
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) == 0 {
+		return document, nil
+	}
+
+	if trimmed[0] == '{' {
+		err := json.Unmarshal(trimmed, &document)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshalling c config %s", err)
+		}
+		return document, nil
+	}
+
+	var raw interface{}
+	err := yaml.Unmarshal(trimmed, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshalling c config %s", err)
+	}
+
+	converted, err := stringKeys(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshalling c config %s", err)
+	}
+
+	document, ok := converted.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Error unmarshalling c config: expected a mapping at the top level")
+	}
+	return document, nil
+}
+
+// stringKeys converts the maps yaml decodes with interface{} keys into maps JSON can encode
+func stringKeys(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			s, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", key)
+			}
+			converted, err := stringKeys(item)
+			if err != nil {
+				return nil, err
+			}
+			m[s] = converted
+		}
+		return m, nil
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			converted, err := stringKeys(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = converted
+		}
+		return items, nil
+	}
+	return value, nil
+}
+
+// applyEnvOverrides sets every scalar config key whose RACKHD_CPI_ variable is set in the environment
+func applyEnvOverrides(document map[string]interface{}) []string {
+	var problems []string
+	for _, leaf := range scalarKeys(reflect.TypeOf(Cpi{}), nil) {
+		name := EnvPrefix + strings.ToUpper(strings.Join(leaf.path, "_"))
+		value, found := os.LookupEnv(name)
+		if !found {
+			continue
+		}
+
+		var typed interface{} = value
+		switch leaf.kind {
+		case reflect.Int:
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("Invalid config. %s must be a number, got %q", name, value))
+				continue
+			}
+			typed = i
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("Invalid config. %s must be true or false, got %q", name, value))
+				continue
+			}
+			typed = b
+		}
+
+		setKey(document, leaf.path, typed)
+	}
+	return problems
+}
+
+type scalarKey struct {
+	path []string
+	kind reflect.Kind
+}
+
+// scalarKeys lists the keys of t holding strings, numbers, booleans and durations, skipping maps and lists
+func scalarKeys(t reflect.Type, prefix []string) []scalarKey {
+	var keys []scalarKey
+	for _, field := range jsonFields(t) {
+		path := append(append([]string{}, prefix...), field.name)
+		switch {
+		case field.typ == durationType:
+			keys = append(keys, scalarKey{path: path, kind: reflect.String})
+		case field.typ.Kind() == reflect.Struct:
+			keys = append(keys, scalarKeys(field.typ, path)...)
+		case field.typ.Kind() == reflect.String, field.typ.Kind() == reflect.Bool:
+			keys = append(keys, scalarKey{path: path, kind: field.typ.Kind()})
+		case field.typ.Kind() == reflect.Int:
+			keys = append(keys, scalarKey{path: path, kind: reflect.Int})
+		}
+	}
+	return keys
+}
+
+func setKey(document map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := document[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			document[key] = child
+		}
+		document = child
+	}
+	document[path[len(path)-1]] = value
+}
+
+type jsonField struct {
+	name string
+	typ  reflect.Type
+}
+
+// jsonFields lists the keys encoding/json reads into t, including those of embedded structs
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			fields = append(fields, jsonFields(field.Type)...)
+			continue
+		}
+
+		name := tag
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields = append(fields, jsonField{name: name, typ: field.Type})
+	}
+	return fields
+}
+
+// unknownKeys lists the keys of document that t has no field for, in a stable order
+func unknownKeys(t reflect.Type, document map[string]interface{}, prefix string) []string {
+	fields := map[string]reflect.Type{}
+	for _, field := range jsonFields(t) {
+		fields[strings.ToLower(field.name)] = field.typ
+	}
+
+	var names []string
+	for name := range document {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var unknown []string
+	for _, name := range names {
+		path := prefix + name
+		fieldType, found := fields[strings.ToLower(name)]
+		if !found {
+			unknown = append(unknown, path)
+			continue
+		}
+		unknown = append(unknown, unknownNestedKeys(fieldType, document[name], path)...)
+	}
+	return unknown
+}
+
+func unknownNestedKeys(t reflect.Type, value interface{}, path string) []string {
+	if t == durationType {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if m, ok := value.(map[string]interface{}); ok {
+			return unknownKeys(t, m, path+".")
+		}
+	case reflect.Map:
+		m, ok := value.(map[string]interface{})
+		if !ok || t.Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		var keys []string
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var unknown []string
+		for _, key := range keys {
+			unknown = append(unknown, unknownNestedKeys(t.Elem(), m[key], path+"."+key)...)
+		}
+		return unknown
+	case reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil
+		}
+		var unknown []string
+		for i, item := range items {
+			unknown = append(unknown, unknownNestedKeys(t.Elem(), item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return unknown
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// OBM services the CPI can register on a node
+const (
+	OBMServiceIPMI    = "ipmi"
+	OBMServiceAMT     = "amt"
+	OBMServiceRedfish = "redfish"
+	OBMServiceSNMPPDU = "snmp-pdu"
+)
+
+// OBMConfig holds the credentials the CPI registers an OBM service with on nodes RackHD discovered without one.
+// Credentials of a node override those of its pool, which override those of its manufacturer and then the defaults.
+type OBMConfig struct {
+	OBMCredentials
+	CredentialsFile string                    `json:"credentials_file"`
+	Nodes           map[string]OBMCredentials `json:"nodes"`
+	Manufacturers   map[string]OBMCredentials `json:"manufacturers"`
+	Pools           []OBMPool                 `json:"pools"`
+}
+
+// OBMPool overrides the OBM service and credentials of the nodes carrying a tag
+type OBMPool struct {
+	Tag string `json:"tag"`
+	OBMCredentials
+}
+
+// OBMCredentials are the service and login of the management controller of a node.
+// Service is one of ipmi, amt, redfish or snmp-pdu and is detected from the node catalogs when left empty.
+type OBMCredentials struct {
+	Service     string `json:"service"`
+	ServiceName string `json:"service_name"`
+	Host        string `json:"host"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	Community   string `json:"community"`
+	Outlet      int    `json:"outlet"`
+}
+
+// Configured reports whether the credentials hold a password or a PDU community, without which
+// no OBM service can be registered
+func (c OBMCredentials) Configured() bool {
+	return c.Password != "" || c.Community != ""
+}
+
+// HasManufacturerCredentials reports whether the credentials of a node may depend on its manufacturer
+func (o OBMConfig) HasManufacturerCredentials() bool {
+	return len(o.Manufacturers) > 0
+}
+
+// HasPoolCredentials reports whether the credentials of a node may depend on its tags
+func (o OBMConfig) HasPoolCredentials() bool {
+	return len(o.Pools) > 0
+}
+
+// CredentialsFor resolves the credentials of a node. Manufacturers match case-insensitively
+// and only the first pool whose tag the node carries applies.
+func (o OBMConfig) CredentialsFor(nodeID string, manufacturer string, tags []string) OBMCredentials {
+	credentials := o.OBMCredentials
+	for name, override := range o.Manufacturers {
+		if manufacturer != "" && strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(manufacturer)) {
+			credentials = credentials.overriddenBy(override)
+		}
+	}
+
+pools:
+	for _, pool := range o.Pools {
+		for _, tag := range tags {
+			if tag == pool.Tag {
+				credentials = credentials.overriddenBy(pool.OBMCredentials)
+				break pools
+			}
+		}
+	}
+
+	return credentials.overriddenBy(o.Nodes[nodeID])
+}
+
+func (c OBMCredentials) overriddenBy(override OBMCredentials) OBMCredentials {
+	if override.Service != "" {
+		c.Service = override.Service
+	}
+	if override.ServiceName != "" {
+		c.ServiceName = override.ServiceName
+	}
+	if override.Username != "" {
+		c.Username = override.Username
+	}
+	if override.Password != "" {
+		c.Password = override.Password
+	}
+	if override.Host != "" {
+		c.Host = override.Host
+	}
+	if override.Community != "" {
+		c.Community = override.Community
+	}
+	if override.Outlet != 0 {
+		c.Outlet = override.Outlet
+	}
+	return c
+}
+
+// loadCredentialsFile fills in the credentials the CPI config leaves out from the credentials file
+func (o OBMConfig) loadCredentialsFile() (OBMConfig, error) {
+	if o.CredentialsFile == "" {
+		return o, nil
+	}
+
+	b, err := ioutil.ReadFile(o.CredentialsFile)
+	if err != nil {
+		return OBMConfig{}, fmt.Errorf("Error reading OBM credentials file %s", err)
+	}
+
+	var file OBMConfig
+	err = json.Unmarshal(b, &file)
+	if err != nil {
+		return OBMConfig{}, fmt.Errorf("Error unmarshalling OBM credentials file %s", err)
+	}
+
+	merged := o
+	merged.OBMCredentials = file.OBMCredentials.overriddenBy(o.OBMCredentials)
+	merged.Nodes = mergeOBMCredentials(file.Nodes, o.Nodes)
+	merged.Manufacturers = mergeOBMCredentials(file.Manufacturers, o.Manufacturers)
+	if len(o.Pools) == 0 {
+		merged.Pools = file.Pools
+	}
+	return merged, nil
+}
+
+func mergeOBMCredentials(base map[string]OBMCredentials, overrides map[string]OBMCredentials) map[string]OBMCredentials {
+	if len(base) == 0 {
+		return overrides
+	}
+
+	merged := map[string]OBMCredentials{}
+	for key, credentials := range base {
+		merged[key] = credentials
+	}
+	for key, credentials := range overrides {
+		merged[key] = merged[key].overriddenBy(credentials)
+	}
+	return merged
+}
+
+// validate checks that every configured OBM service is one the CPI knows
+func (o OBMConfig) validate() error {
+	services := []string{o.Service}
+	for _, credentials := range o.Manufacturers {
+		services = append(services, credentials.Service)
+	}
+	for _, pool := range o.Pools {
+		if pool.Tag == "" {
+			return errors.New("Invalid config. OBM pools must have a tag")
+		}
+		services = append(services, pool.Service)
+	}
+	for _, credentials := range o.Nodes {
+		services = append(services, credentials.Service)
+	}
+
+	for _, service := range services {
+		switch service {
+		case "", OBMServiceIPMI, OBMServiceAMT, OBMServiceRedfish, OBMServiceSNMPPDU:
+		default:
+			return fmt.Errorf("Invalid config. OBM service must be one of %s, %s, %s or %s, got %q", OBMServiceIPMI, OBMServiceAMT, OBMServiceRedfish, OBMServiceSNMPPDU, service)
+		}
+	}
+	return nil
+}
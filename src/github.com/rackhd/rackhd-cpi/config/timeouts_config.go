@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeoutsConfig limits each kind of workflow and every HTTP call to RackHD.
+// A workflow timeout left out falls back to run_workflow_timeout; an HTTP timeout of zero never expires.
+type TimeoutsConfig struct {
+	Reserve      Duration `json:"reserve"`
+	Provision    Duration `json:"provision"`
+	Deprovision  Duration `json:"deprovision"`
+	WipeDisk     Duration `json:"wipe_disk"`
+	RelocateDisk Duration `json:"relocate_disk"`
+	OBM          Duration `json:"obm"`
+	HTTP         Duration `json:"http"`
+}
+
+type namedTimeout struct {
+	name    string
+	timeout *Duration
+}
+
+func (t *TimeoutsConfig) named() []namedTimeout {
+	return []namedTimeout{
+		{"reserve", &t.Reserve},
+		{"provision", &t.Provision},
+		{"deprovision", &t.Deprovision},
+		{"wipe_disk", &t.WipeDisk},
+		{"relocate_disk", &t.RelocateDisk},
+		{"obm", &t.OBM},
+		{"http", &t.HTTP},
+	}
+}
+
+// OverriddenBy returns the timeouts with those set in override replacing them
+func (t TimeoutsConfig) OverriddenBy(override TimeoutsConfig) TimeoutsConfig {
+	overrides := override.named()
+	for i, timeout := range t.named() {
+		if *overrides[i].timeout != 0 {
+			*timeout.timeout = *overrides[i].timeout
+		}
+	}
+	return t
+}
+
+// Validate rejects negative timeouts
+func (t TimeoutsConfig) Validate() error {
+	for _, timeout := range t.named() {
+		if *timeout.timeout < 0 {
+			return fmt.Errorf("timeouts.%s cannot be negative, got %s", timeout.name, time.Duration(*timeout.timeout))
+		}
+	}
+	return nil
+}
+
+func (t *TimeoutsConfig) setWorkflowDefaults(workflowTimeout Duration) {
+	for _, timeout := range t.named() {
+		if timeout.name != "http" && *timeout.timeout == 0 {
+			*timeout.timeout = workflowTimeout
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package cpi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	uuid "github.com/nu7hatch/gouuid"
+
+	"github.com/rackhd/rackhd-cpi/bosh"
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/models"
+	"github.com/rackhd/rackhd-cpi/rackhdapi"
+	"github.com/rackhd/rackhd-cpi/workflows"
+)
+
+// AdoptedMetadataKey is the node metadata recording the drives of an adopted server until create_vm takes it over
+const AdoptedMetadataKey = "adopted"
+
+// AdoptVMArguments describes a server already running a BOSH stemcell and the agent that should take it over
+type AdoptVMArguments struct {
+	NodeID    string
+	AgentID   string
+	PublicKey string
+	Networks  map[string]bosh.Network
+	// SystemDisk is the drive the stemcell is installed on
+	SystemDisk string
+	// EphemeralDisk is the drive the agent uses for /var/vcap/data, empty when the agent uses the system disk
+	EphemeralDisk string
+	// PersistentDisk is the drive holding the data of the server, empty when it has none
+	PersistentDisk string
+}
+
+// adoptedDrives are the drives of an adopted server, which create_vm writes the agent env of the director for
+type adoptedDrives struct {
+	SystemDisk    string `json:"system_disk"`
+	EphemeralDisk string `json:"ephemeral_disk,omitempty"`
+}
+
+// AdoptedVM is the result of an adoption: the cids BOSH knows the server by and the env its agent must read at boot
+type AdoptedVM struct {
+	VMCID    string        `json:"vm_cid"`
+	DiskCID  string        `json:"disk_cid,omitempty"`
+	AgentEnv bosh.AgentEnv `json:"agent_env"`
+}
+
+// AdoptVM records a server installed outside of BOSH as a VM of the CPI, without provisioning or wiping it.
+// The node is tagged with a new vm cid, and with a disk cid when the server holds a persistent disk. The agent env
+// returned must be copied to models.RackHDEnvPath on the server before its agent is restarted.
+// A create_vm pinned to the node through the disk cid takes the server over without reimaging it.
+func AdoptVM(c config.Cpi, input AdoptVMArguments) (AdoptedVM, error) {
+	if input.NodeID == "" || input.AgentID == "" || input.SystemDisk == "" {
+		return AdoptedVM{}, errors.New("adopting a server needs a node id, an agent id and a system disk")
+	}
+
+	if len(input.Networks) != 1 {
+		return AdoptedVM{}, fmt.Errorf("config error: Only one network supported, provided length: %d", len(input.Networks))
+	}
+
+	var netName string
+	var netSpec bosh.Network
+	for k, v := range input.Networks {
+		// cloud_properties are not handed to the agent
+		netName = k
+		netSpec = bosh.Network{
+			NetworkType: v.NetworkType,
+			Netmask:     v.Netmask,
+			Gateway:     v.Gateway,
+			IP:          v.IP,
+			Default:     v.Default,
+			DNS:         v.DNS,
+		}
+	}
+	defaultNetworkType(&netSpec)
+	err := validateNetworkingConfig(netSpec)
+	if err != nil {
+		return AdoptedVM{}, err
+	}
+
+	node, err := rackhdapi.GetTagNode(c, input.NodeID)
+	if err != nil {
+		return AdoptedVM{}, err
+	}
+
+	err = checkAdoptable(node)
+	if err != nil {
+		return AdoptedVM{}, err
+	}
+
+	active, err := rackhdapi.HasActiveWorkflow(c, node.ID)
+	if err != nil {
+		return AdoptedVM{}, err
+	}
+	if active {
+		return AdoptedVM{}, fmt.Errorf("node %s is running a workflow", node.ID)
+	}
+
+	catalogs, err := rackhdapi.GetNodeCatalogs(c, node.ID)
+	if err != nil {
+		return AdoptedVM{}, err
+	}
+
+	if netSpec.NetworkType == bosh.ManualNetworkType {
+		netSpec, err = attachMAC(catalogs.Ohai.NetworkData.Networks, netSpec)
+		if err != nil {
+			return AdoptedVM{}, err
+		}
+	}
+
+	for _, drive := range []string{input.SystemDisk, input.EphemeralDisk, input.PersistentDisk} {
+		if _, found := catalogs.Ohai.BlockDevices[deviceName(drive)]; drive != "" && !found {
+			return AdoptedVM{}, fmt.Errorf("node %s has no drive %s", node.ID, drive)
+		}
+	}
+
+	driveIDs, err := rackhdapi.GetNodeDriveIDs(c, node.ID)
+	if err != nil {
+		return AdoptedVM{}, err
+	}
+
+	adopted := AdoptedVM{}
+	settings := models.PersistentDiskSettings{}
+	used := map[string]bool{}
+	if input.PersistentDisk != "" {
+		size, err := catalogs.Ohai.BlockDevices[deviceName(input.PersistentDisk)].SizeInMB()
+		if err != nil {
+			return AdoptedVM{}, fmt.Errorf("error reading size of %s: %s", input.PersistentDisk, err)
+		}
+
+		adopted.DiskCID = fmt.Sprintf("%s%s-%s", DiskCIDTagPrefix, node.ID, c.RequestID)
+		settings.Disks = []models.PersistentDisk{{
+			DiskCID:    adopted.DiskCID,
+			Location:   devicePath(deviceName(input.PersistentDisk)),
+			DeviceID:   driveIDs[deviceName(input.PersistentDisk)],
+			SizeInMB:   size,
+			IsAttached: true,
+		}}
+		used = settings.UsedLocations()
+	}
+	settings.PregeneratedDisks = pregeneratePersistentDisks(c, node.ID, persistentDiskDevices(catalogs.Ohai.BlockDevices, input.SystemDisk, EphemeralDisk{Path: input.EphemeralDisk}), driveIDs, used)
+
+	u4, err := uuid.NewV4()
+	if err != nil {
+		return AdoptedVM{}, fmt.Errorf("error generating uuid")
+	}
+	adopted.VMCID = fmt.Sprintf("%s%s%s", VMCIDTagPrefix, node.ID, u4.String())
+
+	log.Info(fmt.Sprintf("adopting node %s as VM %s", node.ID, adopted.VMCID))
+
+	err = rackhdapi.CreateTag(c, node.ID, models.Unavailable)
+	if err != nil {
+		return AdoptedVM{}, fmt.Errorf("error reserving node %s: %s", node.ID, err)
+	}
+
+	err = rackhdapi.PatchPersistentDiskSettings(c, node.ID, settings)
+	if err != nil {
+		return AdoptedVM{}, fmt.Errorf("error patching persistent disk information for agent %s: %s", input.AgentID, err)
+	}
+
+	facts := hardwareFacts(catalogs.Hardware(), nodeRack(c, node.ID))
+	metadata, err := json.Marshal(mergeMetadata(node.Metadata, map[string]interface{}{
+		HardwareMetadataKey: facts,
+		AdoptedMetadataKey:  adoptedDrives{SystemDisk: input.SystemDisk, EphemeralDisk: input.EphemeralDisk},
+	}))
+	if err != nil {
+		return AdoptedVM{}, fmt.Errorf("error marshalling metadata of node %s: %s", node.ID, err)
+	}
+	err = rackhdapi.SetNodeMetadata(c, node.ID, string(metadata))
+	if err != nil {
+		return AdoptedVM{}, fmt.Errorf("error recording hardware facts of node %s: %s", node.ID, err)
+	}
+
+	if adopted.DiskCID != "" {
+		err = rackhdapi.CreateTag(c, node.ID, adopted.DiskCID)
+		if err != nil {
+			return AdoptedVM{}, err
+		}
+	}
+
+	// the vm cid is tagged last so an interrupted adoption never looks like a VM
+	err = rackhdapi.CreateTag(c, node.ID, adopted.VMCID)
+	if err != nil {
+		return AdoptedVM{}, err
+	}
+
+	adopted.AgentEnv = agentEnv(c, input.AgentID, input.PublicKey, map[string]bosh.Network{netName: netSpec}, input.SystemDisk, input.EphemeralDisk, settings, node.ID, facts)
+	return adopted, nil
+}
+
+// adoptedNodeDrives returns the drives AdoptVM recorded for the node, and false once create_vm took it over
+func adoptedNodeDrives(node models.TagNode) (adoptedDrives, bool, error) {
+	value, found := node.Metadata[AdoptedMetadataKey]
+	if !found || value == nil {
+		return adoptedDrives{}, false, nil
+	}
+
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return adoptedDrives{}, false, fmt.Errorf("error marshalling adoption of node %s: %s", node.ID, err)
+	}
+
+	var drives adoptedDrives
+	err = json.Unmarshal(valueBytes, &drives)
+	if err != nil || drives.SystemDisk == "" {
+		return adoptedDrives{}, false, fmt.Errorf("error reading adoption of node %s: %s", node.ID, valueBytes)
+	}
+	return drives, true, nil
+}
+
+// takeOverAdoptedVM hands an adopted server to the agent of a create_vm call and returns the vm cid AdoptVM tagged
+// the node with. The node boots the microkernel, which only replaces the agent env on the system disk, and reboots
+// into the stemcell installed on it; no drive is reimaged or wiped.
+func takeOverAdoptedVM(c config.Cpi, node models.TagNode, drives adoptedDrives, agentID string, publicKey string, networks map[string]bosh.Network, stemcellFile string) (string, error) {
+	var vmCID string
+	for _, tag := range node.Tags {
+		if strings.HasPrefix(tag, VMCIDTagPrefix) {
+			vmCID = tag
+		}
+	}
+	if vmCID == "" {
+		return "", fmt.Errorf("adopted node %s has no vm cid", node.ID)
+	}
+
+	var netName string
+	var netSpec bosh.Network
+	for k, v := range networks {
+		netName = k
+		netSpec = v
+	}
+
+	catalogs, err := rackhdapi.GetNodeCatalogs(c, node.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if netSpec.NetworkType == bosh.ManualNetworkType {
+		netSpec, err = attachMAC(catalogs.Ohai.NetworkData.Networks, netSpec)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	log.Info(fmt.Sprintf("taking over adopted VM %s on node %s", vmCID, node.ID))
+
+	// reserving boots the microkernel the provision workflow runs in
+	err = ReserveNodeFromRackHD(c, node.ID)
+	if err != nil {
+		return "", err
+	}
+
+	facts := hardwareFacts(catalogs.Hardware(), nodeRack(c, node.ID))
+	env := agentEnv(c, agentID, publicKey, map[string]bosh.Network{netName: netSpec}, drives.SystemDisk, drives.EphemeralDisk, node.PersistentDisk, node.ID, facts)
+
+	envBytes, err := json.Marshal(env)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling agent env %s", err)
+	}
+	uploadAgentEnv, err := rackhdapi.UploadFile(c, node.ID, bytes.NewReader(envBytes), int64(len(envBytes)))
+	if err != nil {
+		return "", err
+	}
+	defer rackhdapi.DeleteFile(c, uploadAgentEnv.UUID)
+
+	workflowName, err := workflows.PublishProvisionNodeWorkflow(c)
+	if err != nil {
+		return "", fmt.Errorf("error publishing provision workflow: %s", err)
+	}
+
+	err = workflows.RunProvisionNodeWorkflow(c, node.ID, workflowName, vmCID, stemcellFile, false, workflows.ProvisionDisks{
+		System:     drives.SystemDisk,
+		KeepSystem: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error running provision workflow: %s", err)
+	}
+
+	// clearing the adoption makes the node an ordinary VM
+	metadata, err := json.Marshal(mergeMetadata(node.Metadata, map[string]interface{}{HardwareMetadataKey: facts, AdoptedMetadataKey: nil}))
+	if err != nil {
+		return "", fmt.Errorf("error marshalling metadata of node %s: %s", node.ID, err)
+	}
+	err = rackhdapi.SetNodeMetadata(c, node.ID, string(metadata))
+	if err != nil {
+		return "", fmt.Errorf("error recording hardware facts of node %s: %s", node.ID, err)
+	}
+
+	return vmCID, nil
+}
+
+// checkAdoptable refuses nodes the CPI already uses or an operator blocked
+func checkAdoptable(node models.TagNode) error {
+	for _, tag := range node.Tags {
+		switch {
+		case tag == models.Blocked:
+			return fmt.Errorf("node %s is blocked", node.ID)
+		case strings.HasPrefix(tag, VMCIDTagPrefix):
+			return fmt.Errorf("node %s already runs VM %s", node.ID, tag)
+		case strings.HasPrefix(tag, DiskCIDTagPrefix):
+			return fmt.Errorf("node %s already holds disk %s", node.ID, tag)
+		}
+	}
+
+	if len(node.PersistentDisk.Disks) > 0 {
+		return fmt.Errorf("node %s already holds persistent disks", node.ID)
+	}
+
+	return nil
+}
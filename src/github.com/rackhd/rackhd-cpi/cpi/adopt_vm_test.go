@@ -0,0 +1,223 @@
+package cpi_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/rackhd/rackhd-cpi/bosh"
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/cpi"
+	"github.com/rackhd/rackhd-cpi/helpers"
+	"github.com/rackhd/rackhd-cpi/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("AdoptVM", func() {
+	var server *ghttp.Server
+	var cpiConfig config.Cpi
+	var input cpi.AdoptVMArguments
+	var tags []string
+	var patches []string
+	var nodeBody string
+	nodeID := "node-1"
+
+	BeforeEach(func() {
+		server, _, cpiConfig, _ = helpers.SetUp(bosh.CREATE_VM)
+		server.AllowUnhandledRequests = true
+		server.UnhandledRequestStatusCode = http.StatusNotFound
+
+		input = cpi.AdoptVMArguments{
+			NodeID:         nodeID,
+			AgentID:        "agent-1",
+			Networks:       map[string]bosh.Network{"private": {IP: "10.0.0.2", Gateway: "10.0.0.1", Netmask: "255.255.255.0", CloudProperties: map[string]interface{}{"vlan": 7}}},
+			SystemDisk:     "/dev/sda",
+			PersistentDisk: "/dev/sdb",
+		}
+		tags = []string{}
+		patches = []string{}
+		nodeBody = fmt.Sprintf(`{"id": "%s", "tags": "/api/2.0/nodes/%s/tags"}`, nodeID, nodeID)
+
+		server.RouteToHandler("GET", fmt.Sprintf("/api/2.0/nodes/%s", nodeID), func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(nodeBody))
+		})
+		server.RouteToHandler("GET", fmt.Sprintf("/api/2.0/nodes/%s/workflows", nodeID), ghttp.RespondWith(http.StatusOK, "[]"))
+		server.RouteToHandler("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/ohai", nodeID), ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_node_catalog_response.json")))
+		server.RouteToHandler("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/driveId", nodeID), ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_drive_id_catalog_response.json")))
+		server.RouteToHandler("PATCH", fmt.Sprintf("/api/2.0/nodes/%s/tags", nodeID), func(w http.ResponseWriter, r *http.Request) {
+			var body models.Tags
+			Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+			tags = append(tags, body.T...)
+		})
+		server.RouteToHandler("PATCH", fmt.Sprintf("/api/2.0/nodes/%s", nodeID), func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			patches = append(patches, string(body))
+		})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	routeTags := func(nodeTags string) {
+		server.RouteToHandler("GET", fmt.Sprintf("/api/2.0/nodes/%s/tags", nodeID), ghttp.RespondWith(http.StatusOK, nodeTags))
+	}
+
+	It("tags the node with a vm cid and a disk cid and returns the env of its agent", func() {
+		routeTags(`[]`)
+
+		adopted, err := cpi.AdoptVM(cpiConfig, input)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(adopted.VMCID).To(HavePrefix(cpi.VMCIDTagPrefix + nodeID))
+		Expect(adopted.DiskCID).To(Equal(fmt.Sprintf("%s%s-%s", cpi.DiskCIDTagPrefix, nodeID, cpiConfig.RequestID)))
+		Expect(tags).To(Equal([]string{models.Unavailable, adopted.DiskCID, adopted.VMCID}))
+
+		Expect(patches).ToNot(BeEmpty())
+		var settings models.PersistentDiskSettingsContainer
+		Expect(json.Unmarshal([]byte(patches[0]), &settings)).To(Succeed())
+		Expect(settings.PersistentDisk.Disks).To(HaveLen(1))
+		Expect(settings.PersistentDisk.Disks[0].DiskCID).To(Equal(adopted.DiskCID))
+		Expect(settings.PersistentDisk.Disks[0].Location).To(Equal("/dev/sdb"))
+		Expect(settings.PersistentDisk.Disks[0].IsAttached).To(BeTrue())
+		for _, disk := range settings.PersistentDisk.PregeneratedDisks {
+			Expect([]string{"/dev/sda", "/dev/sdb"}).ToNot(ContainElement(disk.Location))
+		}
+
+		env := adopted.AgentEnv
+		Expect(env.AgentID).To(Equal("agent-1"))
+		Expect(env.Mbus).To(Equal(cpiConfig.Agent.Mbus))
+		Expect(env.VM["id"]).To(Equal(nodeID))
+		Expect(env.Disks["system"]).To(Equal("/dev/sda"))
+		Expect(env.Disks["persistent"]).To(HaveKey(adopted.DiskCID))
+		Expect(env.Networks["private"].MAC).To(Equal("52:54:be:ef:fd:e0"))
+		Expect(env.Networks["private"].CloudProperties).To(BeNil())
+	})
+
+	It("records the drives of the server for the create_vm taking it over", func() {
+		routeTags(`[]`)
+
+		_, err := cpi.AdoptVM(cpiConfig, input)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(patches).To(ContainElement(MatchJSON(`{"metadata": {"hardware": {"serial_number": "754958"}, "adopted": {"system_disk": "/dev/sda"}}}`)))
+	})
+
+	It("keeps the metadata the node already has", func() {
+		routeTags(`[]`)
+		nodeBody = fmt.Sprintf(`{"id": "%s", "tags": "/api/2.0/nodes/%s/tags", "metadata": {"block_reason": "replaced fan"}}`, nodeID, nodeID)
+
+		_, err := cpi.AdoptVM(cpiConfig, input)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(patches).To(ContainElement(MatchJSON(`{"metadata": {"block_reason": "replaced fan", "hardware": {"serial_number": "754958"}, "adopted": {"system_disk": "/dev/sda"}}}`)))
+	})
+
+	It("adopts a server without a persistent disk", func() {
+		routeTags(`[]`)
+		input.PersistentDisk = ""
+
+		adopted, err := cpi.AdoptVM(cpiConfig, input)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(adopted.DiskCID).To(BeEmpty())
+		Expect(tags).To(Equal([]string{models.Unavailable, adopted.VMCID}))
+	})
+
+	It("refuses a node already running a VM", func() {
+		routeTags(`["unavailable", "vm_cid-1"]`)
+
+		_, err := cpi.AdoptVM(cpiConfig, input)
+		Expect(err).To(MatchError("node node-1 already runs VM vm_cid-1"))
+		Expect(tags).To(BeEmpty())
+	})
+
+	It("refuses a blocked node", func() {
+		routeTags(`["blocked"]`)
+
+		_, err := cpi.AdoptVM(cpiConfig, input)
+		Expect(err).To(MatchError("node node-1 is blocked"))
+		Expect(tags).To(BeEmpty())
+	})
+
+	It("refuses a drive the node does not have", func() {
+		routeTags(`[]`)
+		input.PersistentDisk = "/dev/sdz"
+
+		_, err := cpi.AdoptVM(cpiConfig, input)
+		Expect(err).To(MatchError("node node-1 has no drive /dev/sdz"))
+		Expect(tags).To(BeEmpty())
+	})
+
+	It("needs an agent id", func() {
+		input.AgentID = ""
+
+		_, err := cpi.AdoptVM(cpiConfig, input)
+		Expect(err).To(HaveOccurred())
+		Expect(strings.Contains(err.Error(), "agent id")).To(BeTrue())
+		Expect(server.ReceivedRequests()).To(BeEmpty())
+	})
+
+	Describe("taking over an adopted VM with create_vm", func() {
+		vmCID := "vm_cid-node-1-adopted"
+		diskCID := "disk_cid-node-1-adopt"
+		var provisionOptions map[string]interface{}
+
+		BeforeEach(func() {
+			cpiConfig.RequestID = "requestid"
+			nodeBody = fmt.Sprintf(`{"id": "%s", "obms": [{"service": "ipmi-obm-service"}], "tags": "/api/2.0/nodes/%s/tags", "metadata": {"adopted": {"system_disk": "/dev/sda"}}, "persistent_disk": {"disks": [{"disk_cid": "%s", "location": "/dev/sdb", "attached": true}]}}`, nodeID, nodeID, diskCID)
+			provisionOptions = nil
+			routeTags(fmt.Sprintf(`["unavailable", "%s", "%s"]`, diskCID, vmCID))
+
+			workflowResponse := fmt.Sprintf(`{"instanceId": "%s", "status": "succeeded"}`, cpiConfig.RequestID)
+			server.RouteToHandler("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", diskCID), ghttp.RespondWith(http.StatusOK, fmt.Sprintf(`[{"id": "%s", "tags": ["unavailable", "%s", "%s"]}]`, nodeID, diskCID, vmCID)))
+			server.RouteToHandler("PUT", "/api/2.0/files/"+nodeID, ghttp.RespondWith(http.StatusCreated, fmt.Sprintf(`{"name": "%s", "uuid": "env-uuid"}`, nodeID)))
+			server.RouteToHandler("PUT", regexp.MustCompile(`^/api/2.0/workflows/(tasks|graphs)$`), func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				Expect(err).ToNot(HaveOccurred())
+				w.WriteHeader(http.StatusCreated)
+				w.Write(body)
+			})
+			server.RouteToHandler("GET", regexp.MustCompile(`^/api/2.0/workflows/(tasks|graphs)/`), func(w http.ResponseWriter, r *http.Request) {
+				name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+				w.Write([]byte(fmt.Sprintf(`[{"injectableName": "%s"}]`, name)))
+			})
+			server.RouteToHandler("POST", fmt.Sprintf("/api/2.0/nodes/%s/workflows", nodeID), func(w http.ResponseWriter, r *http.Request) {
+				var body models.RunWorkflowRequestBody
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				if strings.Contains(body.Name, "Provision") {
+					provisionOptions = body.Options["defaults"].(map[string]interface{})
+				}
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(workflowResponse))
+			})
+			server.RouteToHandler("GET", "/api/2.0/workflows/"+cpiConfig.RequestID, ghttp.RespondWith(http.StatusOK, workflowResponse))
+		})
+
+		It("returns the adopted vm cid and replaces the agent env without reimaging the server", func() {
+			var createInput bosh.CreateVMArguments
+			err := json.Unmarshal([]byte(fmt.Sprintf(`["agent-2", "stemcell-1", {}, {"private": {"type": "dynamic", "default": ["dns", "gateway"]}}, ["%s"], {}]`, diskCID)), &createInput)
+			Expect(err).ToNot(HaveOccurred())
+
+			createdCID, err := cpi.CreateVM(cpiConfig, createInput)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(createdCID).To(Equal(vmCID))
+
+			Expect(provisionOptions).ToNot(BeNil())
+			Expect(provisionOptions["cid"]).To(Equal(vmCID))
+			Expect(provisionOptions["reimage"]).To(Equal("false"))
+			Expect(provisionOptions["wipeDisk"]).To(Equal("false"))
+			Expect(provisionOptions["device"]).To(Equal("/dev/sda"))
+
+			Expect(patches).ToNot(BeEmpty())
+			var metadata map[string]map[string]interface{}
+			Expect(json.Unmarshal([]byte(patches[len(patches)-1]), &metadata)).To(Succeed())
+			Expect(metadata["metadata"]).To(HaveKeyWithValue(cpi.AdoptedMetadataKey, BeNil()))
+			Expect(metadata["metadata"]).To(HaveKey(cpi.HardwareMetadataKey))
+			Expect(tags).To(BeEmpty())
+		})
+	})
+})
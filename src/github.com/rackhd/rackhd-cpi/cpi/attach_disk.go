@@ -1,10 +1,7 @@
 package cpi
 
 import (
-	"errors"
 	"fmt"
-	"reflect"
-	"strings"
 
 	"github.com/rackhd/rackhd-cpi/bosh"
 	"github.com/rackhd/rackhd-cpi/config"
@@ -12,47 +9,48 @@ import (
 )
 
 // AttachDisk attack a disk to a machine
-func AttachDisk(c config.Cpi, extInput bosh.MethodArguments) error {
-	var vmCID string
-	var diskCID string
+func AttachDisk(c config.Cpi, input bosh.AttachDiskArguments) error {
+	vmCID := input.VMCID
+	diskCID := input.DiskCID
 
-	if reflect.TypeOf(extInput[0]) != reflect.TypeOf(vmCID) {
-		return errors.New("Received unexpected type for vm cid")
+	node, err := rackhdapi.GetNodeByVMCID(c, vmCID)
+	if err != nil {
+		return fmt.Errorf("VM: %s not found", vmCID)
 	}
 
-	if reflect.TypeOf(extInput[1]) != reflect.TypeOf(diskCID) {
-		return errors.New("Received unexpected type for disk cid")
+	if !hasTag(node.Tags, diskCID) {
+		return fmt.Errorf("disk: %s not found on VM: %s", diskCID, vmCID)
 	}
 
-	vmCID = extInput[0].(string)
-	diskCID = extInput[1].(string)
+	disk, found := node.PersistentDisk.Disk(diskCID)
+	if !found {
+		return fmt.Errorf("disk: %s has no persistent disk settings on VM: %s", diskCID, vmCID)
+	}
 
-	node, err := rackhdapi.GetNodeByVMCID(c, vmCID)
-	if err != nil {
-		return fmt.Errorf("VM: %s not found", vmCID)
+	if disk.IsAttached {
+		return nil
 	}
 
-	var attachedDiskCID string
-	for _, tag := range node.Tags {
-		if strings.HasPrefix(tag, DiskCIDTagPrefix) {
-			if tag == diskCID && node.PersistentDisk.IsAttached {
-				return nil
-			}
-			attachedDiskCID = tag
+	if disk.DeviceID != "" {
+		driveIDs, err := rackhdapi.GetNodeDriveIDs(c, node.ID)
+		if err != nil {
+			return err
 		}
-	}
 
-	if attachedDiskCID == "" {
-		return fmt.Errorf("disk: %s not found on VM: %s", diskCID, vmCID)
+		err = verifyDiskIdentity(disk, driveIDs)
+		if err != nil {
+			return fmt.Errorf("refusing to attach disk: %s to VM: %s: %v", diskCID, vmCID, err)
+		}
 	}
 
-	if attachedDiskCID != diskCID {
-		if node.PersistentDisk.IsAttached {
-			return fmt.Errorf("node %s has persistent disk %s attached. Cannot attach additional disk %s", vmCID, attachedDiskCID, diskCID)
+	return rackhdapi.MakeDiskRequest(c, node, diskCID, true)
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
 		}
-		return fmt.Errorf("node %s has persistent disk %s, but detached. Cannot attach disk %s", vmCID, attachedDiskCID, diskCID)
 	}
-
-	err = rackhdapi.MakeDiskRequest(c, node, true)
-	return err
+	return false
 }
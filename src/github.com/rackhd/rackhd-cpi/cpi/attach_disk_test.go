@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/rackhd/rackhd-cpi/bosh"
 	"github.com/rackhd/rackhd-cpi/config"
@@ -19,12 +18,10 @@ import (
 
 var _ = Describe("AttachDisk", func() {
 	var server *ghttp.Server
-	var jsonReader *strings.Reader
 	var cpiConfig config.Cpi
-	var request bosh.CpiRequest
 
 	BeforeEach(func() {
-		server, jsonReader, cpiConfig, request = helpers.SetUp(bosh.ATTACH_DISK)
+		server, _, cpiConfig, _ = helpers.SetUp(bosh.ATTACH_DISK)
 	})
 
 	AfterEach(func() {
@@ -39,7 +36,7 @@ var _ = Describe("AttachDisk", func() {
               "vm_cid-fake_uuid",
               "disk_cid-fake_uuid"
             ]`)
-					var extInput bosh.MethodArguments
+					var extInput bosh.AttachDiskArguments
 					err := json.Unmarshal(jsonInput, &extInput)
 					Expect(err).ToNot(HaveOccurred())
 
@@ -60,17 +57,14 @@ var _ = Describe("AttachDisk", func() {
 			})
 
 			Context("given a disk CID that is not same as attached disk", func() {
-				Context("if existing disk is attached", func() {
+				Context("if the disk is not tagged on the VM", func() {
 					It("returns an error", func() {
-						vmCID := "vm_cid-fake_uuid"
-						attachedDiskCID := "disk_cid-fake_uuid"
-						unattachedDiskCID := "disk_cid-fake_uuid_unattached"
-
+						vmCID := "valid_vm_cid_5"
 						jsonInput := []byte(fmt.Sprintf(`[
                 "%s",
-                "%s"
-              ]`, vmCID, unattachedDiskCID))
-						var extInput bosh.MethodArguments
+                "new_disk_cid"
+              ]`, vmCID))
+						var extInput bosh.AttachDiskArguments
 						err := json.Unmarshal(jsonInput, &extInput)
 						Expect(err).ToNot(HaveOccurred())
 
@@ -84,37 +78,81 @@ var _ = Describe("AttachDisk", func() {
 						)
 
 						err = cpi.AttachDisk(cpiConfig, extInput)
-						errMsg := fmt.Sprintf(
-							"node %s has persistent disk %s attached. Cannot attach additional disk %s",
-							vmCID, attachedDiskCID, unattachedDiskCID)
-						Expect(err).To(MatchError(errMsg))
+						Expect(err).To(MatchError("disk: new_disk_cid not found on VM: valid_vm_cid_5"))
 						Expect(len(server.ReceivedRequests())).To(Equal(1))
 					})
 				})
 
-				Context("if existing disk is NOT attached", func() {
-					It("returns an error", func() {
-						vmCID := "valid_vm_cid_5"
+				Context("if the disk is another persistent disk of the VM", func() {
+					It("attaches the disk and leaves the attached disk untouched", func() {
+						vmCID := "vm_cid-fake_uuid"
+						diskCID := "disk_cid-fake_uuid_sdc"
 						jsonInput := []byte(fmt.Sprintf(`[
                 "%s",
-                "new_disk_cid"
-              ]`, vmCID))
-						var extInput bosh.MethodArguments
+                "%s"
+              ]`, vmCID, diskCID))
+						var extInput bosh.AttachDiskArguments
 						err := json.Unmarshal(jsonInput, &extInput)
 						Expect(err).ToNot(HaveOccurred())
 
-						expectedNodesData := helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_disk_detached.json")
-						Expect(err).ToNot(HaveOccurred())
+						expectedNodesData := helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_multiple_disks.json")
 						server.AppendHandlers(
 							ghttp.CombineHandlers(
 								ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", vmCID)),
 								ghttp.RespondWith(http.StatusOK, expectedNodesData),
 							),
+							ghttp.CombineHandlers(
+								ghttp.VerifyRequest("GET", "/api/2.0/nodes/57fb9fb03fcc55c807add41c/catalogs/driveId"),
+								ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_drive_id_catalog_response.json")),
+							),
+							ghttp.CombineHandlers(
+								ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/57fb9fb03fcc55c807add41c"),
+								ghttp.VerifyJSON(`{
+                  "persistent_disk": {
+                    "pregenerated_disks": [
+                      {"disk_cid": "disk_cid-57fb9fb03fcc55c807add41c-id-sdb", "location": "/dev/sdb", "size": 0, "attached": false},
+                      {"disk_cid": "disk_cid-57fb9fb03fcc55c807add41c-id-sdc", "location": "/dev/sdc", "device_id": "/dev/disk/by-id/wwn-0x5000c500a1b2c3c4", "size": 0, "attached": false}
+                    ],
+                    "disks": [
+                      {"disk_cid": "disk_cid-fake_uuid_sdb", "location": "/dev/sdb", "size": 2500, "attached": true},
+                      {"disk_cid": "disk_cid-fake_uuid_sdc", "location": "/dev/sdc", "device_id": "/dev/disk/by-id/wwn-0x5000c500a1b2c3c4", "size": 4000, "attached": true}
+                    ]
+                  }
+                }`),
+							),
 						)
 
 						err = cpi.AttachDisk(cpiConfig, extInput)
-						Expect(err).To(MatchError("node valid_vm_cid_5 has persistent disk disk_cid-fake_uuid, but detached. Cannot attach disk new_disk_cid"))
-						Expect(len(server.ReceivedRequests())).To(Equal(1))
+						Expect(err).ToNot(HaveOccurred())
+						Expect(len(server.ReceivedRequests())).To(Equal(3))
+					})
+
+					It("refuses to attach the disk when another drive now sits at its location", func() {
+						vmCID := "vm_cid-fake_uuid"
+						diskCID := "disk_cid-fake_uuid_sdc"
+						jsonInput := []byte(`["` + vmCID + `", "` + diskCID + `"]`)
+						var extInput bosh.AttachDiskArguments
+						err := json.Unmarshal(jsonInput, &extInput)
+						Expect(err).ToNot(HaveOccurred())
+
+						reorderedDriveIDs := `{"data": [
+              {"devName": "sdb", "linuxWwid": "/dev/disk/by-id/wwn-0x5000c500a1b2c3c4"},
+              {"devName": "sdc", "linuxWwid": "/dev/disk/by-id/wwn-0x5000c500a1b2c3b1"}
+            ]}`
+						server.AppendHandlers(
+							ghttp.CombineHandlers(
+								ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", vmCID)),
+								ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_multiple_disks.json")),
+							),
+							ghttp.CombineHandlers(
+								ghttp.VerifyRequest("GET", "/api/2.0/nodes/57fb9fb03fcc55c807add41c/catalogs/driveId"),
+								ghttp.RespondWith(http.StatusOK, reorderedDriveIDs),
+							),
+						)
+
+						err = cpi.AttachDisk(cpiConfig, extInput)
+						Expect(err).To(MatchError(`refusing to attach disk: disk_cid-fake_uuid_sdc to VM: vm_cid-fake_uuid: drive at /dev/sdc is "/dev/disk/by-id/wwn-0x5000c500a1b2c3b1", expected "/dev/disk/by-id/wwn-0x5000c500a1b2c3c4"`))
+						Expect(len(server.ReceivedRequests())).To(Equal(2))
 					})
 				})
 			})
@@ -130,7 +168,7 @@ var _ = Describe("AttachDisk", func() {
             "%s",
             "%s"
           ]`, vmCID, diskCID))
-					var extInput bosh.MethodArguments
+					var extInput bosh.AttachDiskArguments
 					err := json.Unmarshal(jsonInput, &extInput)
 					Expect(err).NotTo(HaveOccurred())
 
@@ -140,10 +178,11 @@ var _ = Describe("AttachDisk", func() {
 					Expect(err).NotTo(HaveOccurred())
 					Expect(len(expectedNodes)).To(Equal(1))
 					expectedNode := expectedNodes[0]
-					expectedNode.PersistentDisk.IsAttached = true
+					expectedSettings, err := expectedNode.PersistentDisk.WithDiskState(diskCID, true)
+					Expect(err).NotTo(HaveOccurred())
 
 					bodyBytes, err := json.Marshal(map[string]interface{}{
-						"persistent_disk": expectedNode.PersistentDisk,
+						"persistent_disk": expectedSettings,
 					})
 					Expect(err).NotTo(HaveOccurred())
 
@@ -173,7 +212,7 @@ var _ = Describe("AttachDisk", func() {
           "%s",
           "invalid_disk_cid"
         ]`, vmCID))
-			var extInput bosh.MethodArguments
+			var extInput bosh.AttachDiskArguments
 			err := json.Unmarshal(jsonInput, &extInput)
 			Expect(err).ToNot(HaveOccurred())
 
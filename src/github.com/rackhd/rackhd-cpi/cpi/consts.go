@@ -2,6 +2,7 @@ package cpi
 
 // VMCIDTagPrefix is a prefix for vm cid
 const (
-	VMCIDTagPrefix   string = "vm_cid-"
-	DiskCIDTagPrefix string = "disk_cid-"
+	VMCIDTagPrefix    string = "vm_cid-"
+	DiskCIDTagPrefix  string = "disk_cid-"
+	MetadataTagPrefix string = "bosh_"
 )
@@ -1,9 +1,7 @@
 package cpi
 
 import (
-	"encoding/json"
 	"fmt"
-	"reflect"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/rackhd/rackhd-cpi/bosh"
@@ -12,85 +10,97 @@ import (
 	"github.com/rackhd/rackhd-cpi/rackhdapi"
 )
 
-// CreateDisk patches disk info to node
-func CreateDisk(c config.Cpi, extInput bosh.MethodArguments) (string, error) {
-	diskSizeInMB, vmCID, err := parseCreateDiskInput(extInput)
-	if err != nil {
-		return "", err
-	}
+// CreateDisk claims a free local drive for a new persistent disk and patches disk info to node
+func CreateDisk(c config.Cpi, input bosh.CreateDiskArguments) (string, error) {
+	vmCID := input.VMCID
 
 	filter := Filter{
-		data:   diskSizeInMB,
+		data:   input.SizeInMB,
 		method: FilterBasedOnSizeMethod,
 	}
-	var diskCID string
+	var disk models.PersistentDisk
 	var node models.TagNode
+	var err error
 	if vmCID != "" {
 		node, err = rackhdapi.GetNodeByVMCID(c, vmCID)
 		if err != nil {
 			return "", err
 		}
 
-		if node.PersistentDisk.DiskCID != "" {
-			return "", fmt.Errorf("error creating disk: VM %s already has a persistent disk", vmCID)
+		if len(node.PersistentDisk.PregeneratedDisks) == 0 {
+			return "", fmt.Errorf("error creating disk: can not find pregenerated disk cid for VM %s", vmCID)
+		}
+
+		if len(freePregeneratedDisks(node.PersistentDisk)) == 0 {
+			return "", fmt.Errorf("error creating disk: VM %s has a persistent disk on every local drive", vmCID)
 		}
 
-		valid, err := filter.Run(c, node)
-		if !valid || err != nil {
+		catalog, err := rackhdapi.GetNodeCatalog(c, node.ID)
+		if err != nil {
 			return "", fmt.Errorf("error creating disk: %v", err)
 		}
 
-		if node.PersistentDisk.PregeneratedDiskCID == "" {
-			return "", fmt.Errorf("error creating disk: can not find pregenerated disk cid for VM %s", vmCID)
+		disk, err = selectPregeneratedDisk(node.PersistentDisk, spareLocalDrives(catalog.Data.BlockDevices), input.SizeInMB)
+		if err != nil {
+			return "", fmt.Errorf("error creating disk with size %vMB for VM %s: %v", input.SizeInMB, vmCID, err)
+		}
+
+		driveIDs, err := rackhdapi.GetNodeDriveIDs(c, node.ID)
+		if err != nil {
+			return "", fmt.Errorf("error creating disk: %v", err)
 		}
-		diskCID = node.PersistentDisk.PregeneratedDiskCID
 
+		err = verifyDiskIdentity(disk, driveIDs)
+		if err != nil {
+			return "", fmt.Errorf("error creating disk: drive changed since VM %s was created: %v", vmCID, err)
+		}
 	} else {
-		node.ID, err = TryReservationWithFilter(c, "", filter, SelectNodeFromRackHD, ReserveNodeFromRackHD)
+		nodeID, err := TryReservationWithFilter(c, "", filter, SelectNodeFromRackHD, ReserveNodeFromRackHD)
 		if err != nil {
 			return "", err
 		}
-		diskCID = fmt.Sprintf("%s%s-%s", DiskCIDTagPrefix, node.ID, c.RequestID)
-	}
 
-	container := models.PersistentDiskSettingsContainer{
-		PersistentDisk: models.PersistentDiskSettings{
-			DiskCID:    diskCID,
-			Location:   fmt.Sprintf("/dev/%s", models.PersistentDiskLocation),
-			IsAttached: false,
-		},
-	}
+		// the free node may still hold deleted disks waiting to be wiped
+		node, err = rackhdapi.GetTagNode(c, nodeID)
+		if err != nil {
+			return "", fmt.Errorf("error creating disk: %v", err)
+		}
 
-	bodyBytes, err := json.Marshal(container)
-	if err != nil {
-		return "", fmt.Errorf("error marshalling persistent disk information for VM %s", vmCID)
+		catalog, err := rackhdapi.GetNodeCatalog(c, node.ID)
+		if err != nil {
+			return "", fmt.Errorf("error creating disk: %v", err)
+		}
+
+		location, err := selectPersistentDiskDevice(persistentDiskDevices(catalog.Data.BlockDevices, defaultSystemDisk(catalog.Data.BlockDevices), EphemeralDisk{}), node.PersistentDisk.UsedLocations(), input.SizeInMB)
+		if err != nil {
+			return "", fmt.Errorf("error creating disk with size %vMB for node %s: %v", input.SizeInMB, node.ID, err)
+		}
+
+		driveIDs, err := rackhdapi.GetNodeDriveIDs(c, node.ID)
+		if err != nil {
+			return "", fmt.Errorf("error creating disk: %v", err)
+		}
+
+		disk = models.PersistentDisk{
+			DiskCID:  fmt.Sprintf("%s%s-%s", DiskCIDTagPrefix, node.ID, c.RequestID),
+			Location: location,
+			DeviceID: driveIDs[deviceName(location)],
+		}
 	}
 
-	err = rackhdapi.PatchNode(c, node.ID, bodyBytes)
+	disk.SizeInMB = input.SizeInMB
+	disk.IsAttached = false
+
+	err = rackhdapi.PatchPersistentDiskSettings(c, node.ID, node.PersistentDisk.WithDisk(disk))
 	if err != nil {
 		return "", err
 	}
 
-	log.Info(fmt.Sprintf("setting diskCID %s for node %s", diskCID, node.ID))
-	err = rackhdapi.CreateTag(c, node.ID, diskCID)
+	log.Info(fmt.Sprintf("setting diskCID %s on %s (%s) for node %s", disk.DiskCID, disk.Location, disk.DeviceID, node.ID))
+	err = rackhdapi.CreateTag(c, node.ID, disk.DiskCID)
 	if err != nil {
 		return "", err
 	}
 
-	return container.PersistentDisk.DiskCID, nil
-}
-
-func parseCreateDiskInput(extInput bosh.MethodArguments) (int, string, error) {
-	diskSizeInput := extInput[0]
-	diskSizeInMB := int(diskSizeInput.(float64))
-
-	vmCIDInput := extInput[2]
-	var vmCID string
-	if reflect.TypeOf(vmCID) != reflect.TypeOf(vmCIDInput) {
-		return 0, "", fmt.Errorf("vmCIDInput is unexpected type")
-	}
-
-	vmCID = vmCIDInput.(string)
-
-	return diskSizeInMB, vmCID, nil
+	return disk.DiskCID, nil
 }
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/onsi/gomega/ghttp"
 	"github.com/rackhd/rackhd-cpi/bosh"
@@ -18,12 +17,10 @@ import (
 
 var _ = Describe("CreateDisk", func() {
 	var server *ghttp.Server
-	var jsonReader *strings.Reader
 	var cpiConfig config.Cpi
-	var request bosh.CpiRequest
 
 	BeforeEach(func() {
-		server, jsonReader, cpiConfig, request = helpers.SetUp(bosh.CREATE_DISK)
+		server, _, cpiConfig, _ = helpers.SetUp(bosh.CREATE_DISK)
 		cpiConfig.RequestID = "my_id"
 	})
 
@@ -40,7 +37,7 @@ var _ = Describe("CreateDisk", func() {
 					},
 					"invalid-vm-cid"
 				]`)
-			var extInput bosh.MethodArguments
+			var extInput bosh.CreateDiskArguments
 			err := json.Unmarshal(jsonInput, &extInput)
 			Expect(err).NotTo(HaveOccurred())
 
@@ -66,7 +63,7 @@ var _ = Describe("CreateDisk", func() {
 					},
 					"vm-5678"
 				]`)
-			var extInput bosh.MethodArguments
+			var extInput bosh.CreateDiskArguments
 			err := json.Unmarshal(jsonInput, &extInput)
 			Expect(err).NotTo(HaveOccurred())
 
@@ -97,7 +94,7 @@ var _ = Describe("CreateDisk", func() {
 							},
 							"vm-1234"
 						]`)
-				var extInput bosh.MethodArguments
+				var extInput bosh.CreateDiskArguments
 				err := json.Unmarshal(jsonInput, &extInput)
 				Expect(err).NotTo(HaveOccurred())
 
@@ -135,17 +132,24 @@ var _ = Describe("CreateDisk", func() {
 								},
 								""
 							]`)
-					var extInput bosh.MethodArguments
+					var extInput bosh.CreateDiskArguments
 					err := json.Unmarshal(jsonInput, &extInput)
 					Expect(err).NotTo(HaveOccurred())
 
 					nodeID := "57fb9fb03fcc55c807add402"
 					expectedPersistentDiskSettings := `{
              "persistent_disk": {
-               "pregenerated_disk_cid": "",
-               "disk_cid": "` + cpi.DiskCIDTagPrefix + nodeID + `-my_id",
-               "location": "/dev/sdb",
-               "attached": false
+               "pregenerated_disks": [],
+               "disks": [
+                 {
+                   "disk_cid": "` + cpi.DiskCIDTagPrefix + nodeID + `-my_id",
+                   "location": "/dev/sdb",
+                   "device_id": "/dev/disk/by-id/wwn-0x5000c500a1b2c3b1",
+                   "size": 2500,
+                   "attached": false
+                 }
+               ],
+               "pending_wipes": [{"location": "/dev/sdc", "device_id": "/dev/disk/by-id/wwn-0x5000c500a1b2c3c1"}]
              }
            }`
 
@@ -157,6 +161,28 @@ var _ = Describe("CreateDisk", func() {
 					)
 
 					server.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", "/api/2.0/nodes/"+nodeID),
+							ghttp.RespondWith(http.StatusOK, `{
+								"id": "`+nodeID+`",
+								"persistent_disk": {
+									"pregenerated_disks": [],
+									"pending_wipes": [{"location": "/dev/sdc", "device_id": "/dev/disk/by-id/wwn-0x5000c500a1b2c3c1"}]
+								}
+							}`),
+						),
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/tags", nodeID)),
+							ghttp.RespondWith(http.StatusOK, `["unavailable"]`),
+						),
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/ohai", nodeID)),
+							ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_node_catalog_response.json")),
+						),
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/driveId", nodeID)),
+							ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_drive_id_catalog_response.json")),
+						),
 						ghttp.CombineHandlers(
 							ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/"+nodeID),
 							ghttp.VerifyJSON(expectedPersistentDiskSettings),
@@ -173,6 +199,39 @@ var _ = Describe("CreateDisk", func() {
 				})
 			})
 
+			Context("If VM cid is empty and the free drive of the node waits to be wiped", func() {
+				It("does not put the disk on that drive", func() {
+					var extInput bosh.CreateDiskArguments
+					err := json.Unmarshal([]byte(`[2500, {}, ""]`), &extInput)
+					Expect(err).NotTo(HaveOccurred())
+
+					nodeID := "57fb9fb03fcc55c807add402"
+					server.AppendHandlers(helpers.MakeTryReservationHandlers("my_id", nodeID)...)
+					server.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", "/api/2.0/nodes/"+nodeID),
+							ghttp.RespondWith(http.StatusOK, `{
+								"id": "`+nodeID+`",
+								"persistent_disk": {"pending_wipes": [{"location": "/dev/sdb"}]}
+							}`),
+						),
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/tags", nodeID)),
+							ghttp.RespondWith(http.StatusOK, `["unavailable"]`),
+						),
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/ohai", nodeID)),
+							ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_node_catalog_response.json")),
+						),
+					)
+
+					diskCID, err := cpi.CreateDisk(cpiConfig, extInput)
+					Expect(err).To(HaveOccurred())
+					Expect(diskCID).To(Equal(""))
+					Expect(server.ReceivedRequests()).To(HaveLen(len(helpers.MakeTryReservationHandlers("my_id", nodeID)) + 3))
+				})
+			})
+
 			Context("If VM CID is not empty", func() {
 				It("creates the disk and returns the disk cid", func() {
 					jsonInput := []byte(`[
@@ -182,11 +241,11 @@ var _ = Describe("CreateDisk", func() {
 								},
 								"vm-1234"
 							]`)
-					var extInput bosh.MethodArguments
+					var extInput bosh.CreateDiskArguments
 					err := json.Unmarshal(jsonInput, &extInput)
 					Expect(err).NotTo(HaveOccurred())
 
-					expectedNodes := helpers.LoadTagNodes("../spec_assets/tag_nodes_with_vm_disk_detached.json")
+					expectedNodes := helpers.LoadTagNodes("../spec_assets/tag_nodes_with_vm_pregenerated_disk.json")
 					expectedNodesBytes, err := json.Marshal(expectedNodes)
 					Expect(err).ToNot(HaveOccurred())
 					expectedNodeCatalogBytes := helpers.LoadJSON("../spec_assets/dummy_node_catalog_response.json")
@@ -200,6 +259,10 @@ var _ = Describe("CreateDisk", func() {
 							ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/ohai", expectedNodes[0].ID)),
 							ghttp.RespondWith(http.StatusOK, expectedNodeCatalogBytes),
 						),
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/driveId", expectedNodes[0].ID)),
+							ghttp.RespondWith(http.StatusNotFound, nil),
+						),
 						ghttp.CombineHandlers(
 							ghttp.VerifyRequest("PATCH", fmt.Sprintf("/api/2.0/nodes/%s", expectedNodes[0].ID)),
 							ghttp.RespondWith(http.StatusOK, nil),
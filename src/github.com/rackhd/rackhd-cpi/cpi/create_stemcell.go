@@ -1,10 +1,8 @@
 package cpi
 
 import (
-	"errors"
 	"fmt"
 	"os"
-	"reflect"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -14,16 +12,15 @@ import (
 	"github.com/rackhd/rackhd-cpi/rackhdapi"
 )
 
-func CreateStemcell(c config.Cpi, extInput bosh.MethodArguments) (string, error) {
-	var imagePath string
-
-	if reflect.TypeOf(extInput[0]) == reflect.TypeOf(imagePath) {
-		imagePath = extInput[0].(string)
-	} else {
-		return "", errors.New("received unexpected type for stemcell image path")
+// CreateStemcell stores the stemcell image unless the file store already holds the same image,
+// and returns a new stemcell cid referencing it with the cloud properties of the stemcell
+func CreateStemcell(c config.Cpi, input bosh.CreateStemcellArguments) (string, error) {
+	metadata, err := parseStemcellProperties(input.CloudProperties)
+	if err != nil {
+		return "", err
 	}
 
-	stemcellFile, err := os.Open(imagePath)
+	stemcellFile, err := os.Open(input.ImagePath)
 	if err != nil {
 		return "", fmt.Errorf("error obtaining stemcell file handle %s", err)
 	}
@@ -34,16 +31,51 @@ func CreateStemcell(c config.Cpi, extInput bosh.MethodArguments) (string, error)
 		return "", fmt.Errorf("error getting file's stats: %s", err)
 	}
 
-	fileName, err := helpers.GenerateUUID()
+	sha, err := fileSHA256(stemcellFile)
+	if err != nil {
+		return "", err
+	}
+
+	stemcellCID, err := helpers.GenerateUUID()
 	if err != nil {
 		return "", err
 	}
 
-	uploadFile, err := rackhdapi.UploadFile(c, fileName, stemcellFile, fileInfo.Size())
+	image, found, err := reuseStemcellImage(c, sha, stemcellCID)
+	if err != nil {
+		return "", err
+	}
+
+	if found {
+		log.Info(fmt.Sprintf("reusing stemcell image %s already in the file store", image))
+	} else {
+		// the image is uploaded under a name no other stemcell uses, so no image is ever replaced
+		image = StemcellImagePrefix + stemcellCID
+		uploadFile, err := rackhdapi.UploadFile(c, image, stemcellFile, fileInfo.Size())
+		if err != nil {
+			return "", err
+		}
+		if uploadFile.Sha256 != "" && uploadFile.Sha256 != sha {
+			rackhdapi.DeleteFile(c, image)
+			return "", fmt.Errorf("uploaded stemcell image has SHA-256 %s, expected %s", uploadFile.Sha256, sha)
+		}
+		log.Debug(fmt.Sprintf("uploaded stemcell: %s to server", image))
+
+		// the reference is recorded first: an image referenced by a stemcell that failed to be created
+		// is kept forever, while a stemcell missing from the references would lose its image
+		err = addStemcellReference(c, image, stemcellCID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	metadata.Image = image
+	metadata.Sha256 = sha
+	err = uploadStemcellMetadata(c, stemcellCID, metadata)
 	if err != nil {
 		return "", err
 	}
-	log.Debug(fmt.Sprintf("uploaded stemcell: %s to server", fileName))
+	log.Info(fmt.Sprintf("created stemcell %s from %s %s (%s, %s)", stemcellCID, metadata.Name, metadata.Version, metadata.OSDistro, metadata.Architecture))
 
-	return uploadFile.Name, nil
+	return stemcellCID, nil
 }
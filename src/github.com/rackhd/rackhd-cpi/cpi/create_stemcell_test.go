@@ -15,18 +15,18 @@ var _ = Describe("CreateStemcell", func() {
 	Context("With valid CPI v1 input", func() {
 		var fileName string
 		var c config.Cpi
-		var input bosh.MethodArguments
+		var input bosh.CreateStemcellArguments
 		var err error
 
 		BeforeEach(func() {
 			apiServer, err := helpers.GetRackHDHost()
 			Expect(err).ToNot(HaveOccurred())
 			c = config.Cpi{ApiServer: apiServer}
-			input = append(input, "../spec_assets/image")
+			input = bosh.CreateStemcellArguments{ImagePath: "../spec_assets/image"}
 		})
 
 		AfterEach(func() {
-			err := rackhdapi.DeleteFile(c, fileName)
+			err := cpi.DeleteStemcell(c, bosh.DeleteStemcellArguments{StemcellCID: fileName})
 			Expect(err).ToNot(HaveOccurred())
 		})
 
@@ -35,20 +35,11 @@ var _ = Describe("CreateStemcell", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(fileName).ToNot(BeEmpty())
 
-			_, err = rackhdapi.GetFile(c, fileName)
+			metadata, err := cpi.GetStemcellMetadata(c, fileName)
 			Expect(err).ToNot(HaveOccurred())
-		})
-	})
 
-	Context("With invalid CPI v1 input", func() {
-		It("Returns an error", func() {
-			config := config.Cpi{}
-			var input bosh.MethodArguments
-			input = append(input, map[string]string{"foo": "bar"})
-
-			fileUUID, err := cpi.CreateStemcell(config, input)
-			Expect(err).To(MatchError("received unexpected type for stemcell image path"))
-			Expect(fileUUID).To(BeEmpty())
+			_, err = rackhdapi.GetFile(c, metadata.Image)
+			Expect(err).ToNot(HaveOccurred())
 		})
 	})
 })
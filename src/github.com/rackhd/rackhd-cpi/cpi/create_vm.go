@@ -16,11 +16,61 @@ import (
 )
 
 // CreateVM provisions vm
-func CreateVM(c config.Cpi, extInput bosh.MethodArguments) (string, error) {
-	agentID, stemcellCID, publicKey, boshNetworks, nodeID, err := parseCreateVMInput(extInput)
+func CreateVM(c config.Cpi, input bosh.CreateVMArguments) (string, error) {
+	if c.PreflightCheck {
+		err := workflows.EnvironmentError(workflows.CheckEnvironment(c))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	agentID, stemcellCID, publicKey, boshNetworks, nodeID, err := parseCreateVMInput(input)
+	if err != nil {
+		return "", err
+	}
+
+	stemcellFile, err := stemcellImage(c, stemcellCID)
+	if err != nil {
+		return "", err
+	}
+
+	ephemeralDiskProperties, err := parseEphemeralDiskProperties(input.CloudProperties)
+	if err != nil {
+		return "", err
+	}
+
+	diskLayoutProperties, err := parseDiskLayoutProperties(input.CloudProperties)
+	if err != nil {
+		return "", err
+	}
+
+	timeoutProperties, err := parseTimeoutProperties(input.CloudProperties)
 	if err != nil {
 		return "", err
 	}
+	c.Timeouts = c.Timeouts.OverriddenBy(timeoutProperties)
+
+	if len(input.DiskCIDs) > 0 {
+		nodeID, err = nodeForDisks(c, input.DiskCIDs)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if nodeID != "" {
+		pinned, err := rackhdapi.GetTagNode(c, nodeID)
+		if err != nil {
+			return "", err
+		}
+
+		drives, adopted, err := adoptedNodeDrives(pinned)
+		if err != nil {
+			return "", err
+		}
+		if adopted {
+			return takeOverAdoptedVM(c, pinned, drives, agentID, publicKey, boshNetworks, stemcellFile)
+		}
+	}
 
 	nodeID, err = TryReservation(c, nodeID, SelectNodeFromRackHD, ReserveNodeFromRackHD)
 	if err != nil {
@@ -34,13 +84,13 @@ func CreateVM(c config.Cpi, extInput bosh.MethodArguments) (string, error) {
 		netSpec = v
 	}
 
-	nodeCatalog, err := rackhdapi.GetNodeCatalog(c, nodeID)
+	catalogs, err := rackhdapi.GetNodeCatalogs(c, nodeID)
 	if err != nil {
 		return "", err
 	}
 
 	if netSpec.NetworkType == bosh.ManualNetworkType {
-		netSpec, err = attachMAC(nodeCatalog.Data.NetworkData.Networks, netSpec)
+		netSpec, err = attachMAC(catalogs.Ohai.NetworkData.Networks, netSpec)
 		if err != nil {
 			return "", err
 		}
@@ -51,56 +101,46 @@ func CreateVM(c config.Cpi, extInput bosh.MethodArguments) (string, error) {
 		return "", err
 	}
 
-	var diskCID string
-	// We need PregeneratedDiskCID for persistentMetadata for bosh agent
-	if node.PersistentDisk.DiskCID == "" {
-		diskCID = fmt.Sprintf("%s%s-%s", DiskCIDTagPrefix, nodeID, c.RequestID)
+	diskLayout, err := selectDiskLayout(catalogs.Ohai.BlockDevices, diskLayoutProperties, node.PersistentDisk.UsedLocations())
+	if err != nil {
+		return "", err
+	}
 
-		container := models.PersistentDiskSettingsContainer{
-			PersistentDisk: models.PersistentDiskSettings{
-				PregeneratedDiskCID: diskCID,
-			},
-		}
+	reserved := []string{deviceName(diskLayout.System), deviceName(diskLayout.Persistent)}
+	for location := range node.PersistentDisk.UsedLocations() {
+		reserved = append(reserved, deviceName(location))
+	}
 
-		bodyBytes, err := json.Marshal(container)
-		if err != nil {
-			return "", fmt.Errorf("error marshalling persistent disk information for agent %s", agentID)
-		}
+	ephemeralDisk, err := selectEphemeralDisk(catalogs.Ohai.BlockDevices, ephemeralDiskProperties, reserved...)
+	if err != nil {
+		return "", err
+	}
 
-		err = rackhdapi.PatchNode(c, node.ID, bodyBytes)
-		if err != nil {
-			return "", err
-		}
-	} else {
-		diskCID = node.PersistentDisk.DiskCID
+	driveIDs, err := rackhdapi.GetNodeDriveIDs(c, nodeID)
+	if err != nil {
+		return "", err
 	}
 
-	persistentMetadata := map[string]interface{}{}
-	if _, sdbFound := nodeCatalog.Data.BlockDevices["sdb"]; sdbFound {
-		persistentMetadata = map[string]interface{}{
-			diskCID: map[string]string{
-				"path": "/dev/sdb",
-			},
-		}
+	// We need pregenerated disk cids for persistentMetadata for bosh agent
+	persistentDiskSettings := models.PersistentDiskSettings{
+		PregeneratedDisks: pregeneratePersistentDisks(c, nodeID, persistentDiskDevices(catalogs.Ohai.BlockDevices, diskLayout.System, ephemeralDisk), driveIDs, node.PersistentDisk.UsedLocations()),
+		Disks:             node.PersistentDisk.Disks,
+		PendingWipes:      node.PersistentDisk.PendingWipes,
 	}
 
-	env := bosh.AgentEnv{
-		AgentID:   agentID,
-		Blobstore: c.Agent.Blobstore,
-		Disks: map[string]interface{}{
-			"system":     "/dev/sda",
-			"persistent": persistentMetadata,
-		},
-		Mbus:     c.Agent.Mbus,
-		Networks: map[string]bosh.Network{netName: netSpec},
-		NTP:      c.Agent.Ntp,
-		VM: map[string]string{
-			"id":   nodeID,
-			"name": nodeID,
-		},
-		PublicKey: publicKey,
+	err = rackhdapi.PatchPersistentDiskSettings(c, node.ID, persistentDiskSettings)
+	if err != nil {
+		return "", fmt.Errorf("error patching persistent disk information for agent %s: %s", agentID, err)
+	}
+
+	facts := hardwareFacts(catalogs.Hardware(), nodeRack(c, nodeID))
+	err = rackhdapi.SetNodeMetadata(c, nodeID, hardwareMetadata(facts))
+	if err != nil {
+		return "", fmt.Errorf("error recording hardware facts of node %s: %s", nodeID, err)
 	}
 
+	env := agentEnv(c, agentID, publicKey, map[string]bosh.Network{netName: netSpec}, diskLayout.System, ephemeralDisk.Path, persistentDiskSettings, nodeID, facts)
+
 	envBytes, err := json.Marshal(env)
 	if err != nil {
 		return "", fmt.Errorf("error marshalling agent env %s", err)
@@ -117,7 +157,11 @@ func CreateVM(c config.Cpi, extInput bosh.MethodArguments) (string, error) {
 		return "", fmt.Errorf("error publishing provision workflow: %s", err)
 	}
 
-	wipeDisk := (nodeID == "")
+	wipeDisk := isFreeOfPersistentData(node)
+	persistentDrives := []string{}
+	for _, disk := range persistentDiskSettings.PregeneratedDisks {
+		persistentDrives = append(persistentDrives, disk.Location)
+	}
 
 	u4, err := uuid.NewV4()
 	if err != nil {
@@ -126,7 +170,11 @@ func CreateVM(c config.Cpi, extInput bosh.MethodArguments) (string, error) {
 	uid := u4.String()
 	vmCID := fmt.Sprintf("%s%s%s", VMCIDTagPrefix, uploadAgentEnv.Name, uid)
 
-	err = workflows.RunProvisionNodeWorkflow(c, nodeID, workflowName, vmCID, stemcellCID, wipeDisk)
+	err = workflows.RunProvisionNodeWorkflow(c, nodeID, workflowName, vmCID, stemcellFile, wipeDisk, workflows.ProvisionDisks{
+		System:                 diskLayout.System,
+		Persistent:             persistentDrives,
+		EphemeralStripeDevices: ephemeralDisk.StripeDevices,
+	})
 	if err != nil {
 		return "", fmt.Errorf("error running provision workflow: %s", err)
 	}
@@ -134,6 +182,58 @@ func CreateVM(c config.Cpi, extInput bosh.MethodArguments) (string, error) {
 	return vmCID, nil
 }
 
+// agentEnv is the settings the agent of the VM on nodeID reads at boot. The persistent disks include the
+// pregenerated disk cids so disks created later are known to the agent.
+func agentEnv(c config.Cpi, agentID string, publicKey string, networks map[string]bosh.Network, systemDisk string, ephemeralDisk string, settings models.PersistentDiskSettings, nodeID string, facts map[string]string) bosh.AgentEnv {
+	persistentMetadata := map[string]interface{}{}
+	for _, disks := range [][]models.PersistentDisk{settings.Disks, settings.PregeneratedDisks} {
+		for _, disk := range disks {
+			persistentMetadata[disk.DiskCID] = map[string]string{
+				"path": disk.AgentPath(),
+			}
+		}
+	}
+
+	disks := map[string]interface{}{
+		"system":     systemDisk,
+		"persistent": persistentMetadata,
+	}
+	if ephemeralDisk != "" {
+		disks["ephemeral"] = ephemeralDisk
+	}
+
+	vm := map[string]string{
+		"id":   nodeID,
+		"name": nodeID,
+	}
+	for key, value := range facts {
+		vm[key] = value
+	}
+
+	return bosh.AgentEnv{
+		AgentID:   agentID,
+		Blobstore: c.Agent.Blobstore,
+		Disks:     disks,
+		Mbus:      c.Agent.Mbus,
+		Networks:  networks,
+		NTP:       c.Agent.Ntp,
+		VM:        vm,
+		PublicKey: publicKey,
+	}
+}
+
+// isFreeOfPersistentData reports whether BOSH knows no persistent disk on the node, so stale data on its
+// persistent drive can be wiped. A node holding a disk_cid- tag, a disk or a pregenerated disk cid is never wiped.
+func isFreeOfPersistentData(node models.TagNode) bool {
+	for _, tag := range node.Tags {
+		if strings.HasPrefix(tag, DiskCIDTagPrefix) {
+			return false
+		}
+	}
+
+	return len(node.PersistentDisk.Disks) == 0 && len(node.PersistentDisk.PregeneratedDisks) == 0
+}
+
 func attachMAC(nodeNetworks map[string]models.Network, oldSpec bosh.Network) (bosh.Network, error) {
 	var upNetworks []models.Network
 
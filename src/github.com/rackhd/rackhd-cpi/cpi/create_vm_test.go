@@ -17,8 +17,10 @@ import (
   "io/ioutil"
   "net/http"
   "os"
+  "regexp"
   "strings"
   "sync"
+  "time"
 
   "github.com/rackhd/rackhd-cpi/bosh"
   "github.com/rackhd/rackhd-cpi/config"
@@ -33,13 +35,12 @@ import (
 
 var _ = Describe("The VM Creation Workflow", func() {
   var server *ghttp.Server
-  var jsonReader *strings.Reader
   var cpiConfig config.Cpi
   var request bosh.CpiRequest
   var allowFilter Filter
 
   BeforeEach(func() {
-    server, jsonReader, cpiConfig, request = helpers.SetUp(bosh.CREATE_VM)
+    server, _, cpiConfig, request = helpers.SetUp(bosh.CREATE_VM)
 
     allowFilter = Filter{
       data:   nil,
@@ -67,7 +68,7 @@ var _ = Describe("The VM Creation Workflow", func() {
           [],
           {}]`)
 
-        var extInput bosh.MethodArguments
+        var extInput bosh.CreateVMArguments
         err := json.Unmarshal(jsonInput, &extInput)
         Expect(err).ToNot(HaveOccurred())
 
@@ -92,7 +93,7 @@ var _ = Describe("The VM Creation Workflow", func() {
         },
         ["disk_cid-nodeid-uuid"],
         {}]`)
-      var extInput bosh.MethodArguments
+      var extInput bosh.CreateVMArguments
       err := json.Unmarshal(jsonInput, &extInput)
 
       Expect(err).ToNot(HaveOccurred())
@@ -107,44 +108,25 @@ var _ = Describe("The VM Creation Workflow", func() {
       }}))
     })
 
-    It("returns an error if passed an unexpected type for network configuration", func() {
-      jsonInput := []byte(`[
-        "4149ba0f-38d9-4485-476f-1581be36f290",
-        "vm-478585",
-        {},
-        {
-            "private": {
-                "type": "dynamic"
-            }
-        },
-        "aint gon work disks",
-        {}]`)
-
-      var extInput bosh.MethodArguments
+    It("accepts several disks on the same node", func() {
+      jsonInput := []byte(`["agent", "stemcell", {}, {"private": {"type": "dynamic"}}, ["disk_cid-nodeid-uuid-sdb", "disk_cid-nodeid-uuid-sdc"], {}]`)
+      var extInput bosh.CreateVMArguments
       err := json.Unmarshal(jsonInput, &extInput)
       Expect(err).ToNot(HaveOccurred())
 
-      _, _, _, _, _, err = parseCreateVMInput(extInput)
-      Expect(err).To(HaveOccurred())
-      Expect(err).To(MatchError("disk config has unexpected type in: string. Expecting an array"))
+      _, _, _, _, nodeID, err := parseCreateVMInput(extInput)
+      Expect(err).ToNot(HaveOccurred())
+      Expect(nodeID).To(Equal("nodeid"))
     })
 
-    It("returns an error if passed an unexpected type for network configuration", func() {
-      jsonInput := []byte(`[
-        "4149ba0f-38d9-4485-476f-1581be36f290",
-        "vm-478585",
-        {},
-        "aint-gonna-work-network",
-        [],
-        {}]`)
-
-      var extInput bosh.MethodArguments
+    It("returns an error if the disks are on different nodes", func() {
+      jsonInput := []byte(`["agent", "stemcell", {}, {"private": {"type": "dynamic"}}, ["disk_cid-nodeid-uuid", "disk_cid-othernode-uuid"], {}]`)
+      var extInput bosh.CreateVMArguments
       err := json.Unmarshal(jsonInput, &extInput)
       Expect(err).ToNot(HaveOccurred())
 
       _, _, _, _, _, err = parseCreateVMInput(extInput)
-      Expect(err).To(HaveOccurred())
-      Expect(err).To(MatchError("network config has unexpected type in: string. Expecting a map"))
+      Expect(err).To(MatchError("config error: disks [disk_cid-nodeid-uuid disk_cid-othernode-uuid] are on different nodes"))
     })
 
     It("returns an error if more than one network is provided", func() {
@@ -163,7 +145,7 @@ var _ = Describe("The VM Creation Workflow", func() {
         [],
         {}]`)
 
-      var extInput bosh.MethodArguments
+      var extInput bosh.CreateVMArguments
       err := json.Unmarshal(jsonInput, &extInput)
       Expect(err).ToNot(HaveOccurred())
 
@@ -186,7 +168,7 @@ var _ = Describe("The VM Creation Workflow", func() {
         [],
         {}]`)
 
-      var extInput bosh.MethodArguments
+      var extInput bosh.CreateVMArguments
       err := json.Unmarshal(jsonInput, &extInput)
       Expect(err).ToNot(HaveOccurred())
 
@@ -196,54 +178,6 @@ var _ = Describe("The VM Creation Workflow", func() {
       Expect(networks["private"].NetworkType).To(Equal(bosh.ManualNetworkType))
     })
 
-    It("returns an error if Agent ID is empty", func() {
-      jsonInput := []byte(`[
-        "",
-        "vm-478585",
-        {},
-        {
-            "private": {
-                "type": "dynamic"
-            },
-            "private2": {
-                "type": "dynamic"
-            }
-        },
-        [],
-        {}]`)
-
-      var extInput bosh.MethodArguments
-      err := json.Unmarshal(jsonInput, &extInput)
-      Expect(err).ToNot(HaveOccurred())
-
-      _, _, _, _, _, err = parseCreateVMInput(extInput)
-      Expect(err).To(MatchError("agent id cannot be empty"))
-    })
-
-    It("returns an error if Agent ID is of an unexpected type", func() {
-      jsonInput := []byte(`[
-        {},
-        "vm-478585",
-        {},
-        {
-            "private": {
-                "type": "dynamic"
-            },
-            "private2": {
-                "type": "dynamic"
-            }
-        },
-        [],
-        {}]`)
-
-			var extInput bosh.MethodArguments
-			err := json.Unmarshal(jsonInput, &extInput)
-			Expect(err).ToNot(HaveOccurred())
-
-			_, _, _, _, _, err = parseCreateVMInput(extInput)
-			Expect(err).To(MatchError("agent id has unexpected type: map[string]interface {}. Expecting a string"))
-		})
-
 		It("return an error if public key is of an unexpected type", func() {
 			jsonInput := []byte(`[
         "4149ba0f-38d9-4485-476f-1581be36f290",
@@ -256,7 +190,7 @@ var _ = Describe("The VM Creation Workflow", func() {
         },
         [],
         {}]`)
-			var extInput bosh.MethodArguments
+			var extInput bosh.CreateVMArguments
 			err := json.Unmarshal(jsonInput, &extInput)
 			Expect(err).ToNot(HaveOccurred())
 			_, _, _, _, _, err = parseCreateVMInput(extInput)
@@ -279,7 +213,7 @@ var _ = Describe("The VM Creation Workflow", func() {
         [],
         {}]`)
 
-				var extInput bosh.MethodArguments
+				var extInput bosh.CreateVMArguments
 				err := json.Unmarshal(jsonInput, &extInput)
 				Expect(err).ToNot(HaveOccurred())
 
@@ -302,7 +236,7 @@ var _ = Describe("The VM Creation Workflow", func() {
         [],
         {}]`)
 
-				var extInput bosh.MethodArguments
+				var extInput bosh.CreateVMArguments
 				err := json.Unmarshal(jsonInput, &extInput)
 				Expect(err).ToNot(HaveOccurred())
 
@@ -325,7 +259,7 @@ var _ = Describe("The VM Creation Workflow", func() {
         [],
         {}]`)
 
-				var extInput bosh.MethodArguments
+				var extInput bosh.CreateVMArguments
 				err := json.Unmarshal(jsonInput, &extInput)
 				Expect(err).ToNot(HaveOccurred())
 
@@ -421,6 +355,36 @@ var _ = Describe("The VM Creation Workflow", func() {
 		})
 	})
 
+	Describe("deciding whether to wipe the persistent drive", func() {
+		It("wipes a node that never held a persistent disk", func() {
+			node := helpers.LoadTagNodes("../spec_assets/tag_nodes_available.json")[0]
+			Expect(node.PersistentDisk).To(Equal(models.PersistentDiskSettings{}))
+			Expect(isFreeOfPersistentData(node)).To(BeTrue())
+		})
+
+		It("does not wipe a node carrying a disk cid tag", func() {
+			node := helpers.LoadTagNodes("../spec_assets/tag_nodes_with_disk_cid.json")[0]
+			node.PersistentDisk = models.PersistentDiskSettings{}
+			Expect(isFreeOfPersistentData(node)).To(BeFalse())
+		})
+
+		It("does not wipe a node with a pregenerated disk cid", func() {
+			node := helpers.LoadTagNodes("../spec_assets/tag_nodes_with_vm_pregenerated_disk.json")[0]
+			node.Tags = []string{models.Unavailable}
+			Expect(node.PersistentDisk.PregeneratedDisks).ToNot(BeEmpty())
+			Expect(isFreeOfPersistentData(node)).To(BeFalse())
+		})
+
+		It("does not wipe a node whose disk BOSH knows about", func() {
+			node := helpers.LoadTagNodes("../spec_assets/tag_nodes_with_vm_disk_detached.json")[0]
+			Expect(isFreeOfPersistentData(node)).To(BeFalse())
+
+			node.Tags = []string{models.Unavailable}
+			node.PersistentDisk.PregeneratedDisks = nil
+			Expect(isFreeOfPersistentData(node)).To(BeFalse())
+		})
+	})
+
 	Describe("SelectNodeFromRackHD", func() {
 		Context("with a nodeID", func() {
 			It("selects the node with the nodeID", func() {
@@ -551,7 +515,7 @@ var _ = Describe("The VM Creation Workflow", func() {
 			apiServer, err := helpers.GetRackHDHost()
 			Expect(err).ToNot(HaveOccurred())
 
-			jsonReader := strings.NewReader(fmt.Sprintf(`{"api_url":"%s", "agent":{"blobstore": {"provider":"local","some": "options"}, "mbus":"localhost", "disks":{"system": "/dev/sda"}}, "max_reserve_node_attempts":1}`, apiServer))
+			jsonReader := strings.NewReader(fmt.Sprintf(`{"api_url":"%s", "agent":{"blobstore": {"provider":"local","some": "options"}, "mbus":"nats://localhost:4222"}, "max_reserve_node_attempts":1}`, apiServer))
 			c, err := config.New(jsonReader, request)
 			Expect(err).ToNot(HaveOccurred())
 
@@ -587,6 +551,85 @@ var _ = Describe("The VM Creation Workflow", func() {
 		})
 	})
 
+	Describe("provisioning the selected node", func() {
+		nodeID := "node-1"
+		var persistentDisk string
+		var provisionOptions map[string]interface{}
+
+		echo := func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			w.WriteHeader(http.StatusCreated)
+			w.Write(body)
+		}
+
+		BeforeEach(func() {
+			server.AllowUnhandledRequests = true
+			server.UnhandledRequestStatusCode = http.StatusNotFound
+			cpiConfig.RequestID = "requestid"
+			nodeBytes := fmt.Sprintf(`{"id": "%s", "obms": [{"service": "ipmi-obm-service"}], "tags": "/api/2.0/nodes/%s/tags"}`, nodeID, nodeID)
+			persistentDisk = `{}`
+			provisionOptions = nil
+
+			workflowResponse := fmt.Sprintf(`{"instanceId": "%s", "status": "succeeded"}`, cpiConfig.RequestID)
+			server.RouteToHandler("GET", "/api/2.0/tags/unavailable/nodes", ghttp.RespondWith(http.StatusOK, "[]"))
+			server.RouteToHandler("GET", "/api/2.0/tags/blocked/nodes", ghttp.RespondWith(http.StatusOK, "[]"))
+			server.RouteToHandler("GET", "/api/2.0/nodes", ghttp.RespondWith(http.StatusOK, "["+nodeBytes+"]"))
+			server.RouteToHandler("GET", "/api/2.0/nodes/"+nodeID, ghttp.RespondWith(http.StatusOK, nodeBytes))
+			server.RouteToHandler("GET", "/api/2.0/tags/"+nodeID+"/nodes", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(fmt.Sprintf(`[{"id": "%s", "tags": ["unavailable", "%s"], "persistent_disk": %s}]`, nodeID, nodeID, persistentDisk)))
+			})
+			server.RouteToHandler("GET", "/api/2.0/nodes/"+nodeID+"/tags", ghttp.RespondWith(http.StatusOK, "[]"))
+			server.RouteToHandler("GET", "/api/2.0/nodes/"+nodeID+"/workflows", ghttp.RespondWith(http.StatusOK, "[]"))
+			server.RouteToHandler("GET", "/api/2.0/nodes/"+nodeID+"/catalogs/ohai", ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_node_catalog_response.json")))
+			server.RouteToHandler("GET", "/api/2.0/nodes/"+nodeID+"/catalogs/driveId", ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_drive_id_catalog_response.json")))
+			server.RouteToHandler("PATCH", "/api/2.0/nodes/"+nodeID, ghttp.RespondWith(http.StatusOK, nil))
+			server.RouteToHandler("PATCH", "/api/2.0/nodes/"+nodeID+"/tags", ghttp.RespondWith(http.StatusOK, nil))
+			server.RouteToHandler("PUT", "/api/2.0/files/"+nodeID, ghttp.RespondWith(http.StatusCreated, fmt.Sprintf(`{"name": "%s", "uuid": "env-uuid"}`, nodeID)))
+			server.RouteToHandler("PUT", regexp.MustCompile(`^/api/2.0/workflows/(tasks|graphs)$`), echo)
+			server.RouteToHandler("GET", regexp.MustCompile(`^/api/2.0/workflows/(tasks|graphs)/`), func(w http.ResponseWriter, r *http.Request) {
+				name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+				w.Write([]byte(fmt.Sprintf(`[{"injectableName": "%s"}]`, name)))
+			})
+			server.RouteToHandler("POST", "/api/2.0/nodes/"+nodeID+"/workflows", func(w http.ResponseWriter, r *http.Request) {
+				var body models.RunWorkflowRequestBody
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				if strings.Contains(body.Name, "Provision") {
+					provisionOptions = body.Options["defaults"].(map[string]interface{})
+				}
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(workflowResponse))
+			})
+			server.RouteToHandler("GET", "/api/2.0/workflows/"+cpiConfig.RequestID, ghttp.RespondWith(http.StatusOK, workflowResponse))
+		})
+
+		createVM := func() {
+			var input bosh.CreateVMArguments
+			err := json.Unmarshal([]byte(`["agent-1", "stemcell-1", {}, {"private": {"type": "dynamic", "default": ["dns", "gateway"]}}, [], {}]`), &input)
+			Expect(err).ToNot(HaveOccurred())
+
+			vmCID, err := CreateVM(cpiConfig, input)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vmCID).To(HavePrefix(VMCIDTagPrefix + nodeID))
+			Expect(provisionOptions).ToNot(BeNil())
+		}
+
+		It("wipes the persistent drives of a node that never held a persistent disk", func() {
+			createVM()
+
+			Expect(provisionOptions["wipeDisk"]).To(Equal("true"))
+			Expect(provisionOptions["persistent"]).ToNot(BeEmpty())
+		})
+
+		It("does not wipe a node still carrying pregenerated disk cids", func() {
+			persistentDisk = fmt.Sprintf(`{"pregenerated_disks": [{"disk_cid": "disk_cid-%s-old-sdb", "location": "/dev/sdb"}], "disks": []}`, nodeID)
+
+			createVM()
+
+			Expect(provisionOptions["wipeDisk"]).To(Equal("false"))
+		})
+	})
+
 	Context("reserving multiple nodes simultaneously", func() {
 		XIt("works", func() {
 			var wg sync.WaitGroup
@@ -606,7 +649,7 @@ var _ = Describe("The VM Creation Workflow", func() {
 			apiServer, err := helpers.GetRackHDHost()
 			Expect(err).ToNot(HaveOccurred())
 
-			c := config.Cpi{ApiServer: apiServer, MaxReserveNodeAttempts: 5, RunWorkflowTimeoutSeconds: 4 * 60}
+			c := config.Cpi{ApiServer: apiServer, MaxReserveNodeAttempts: 5, RunWorkflowTimeout: config.Duration(4 * time.Minute)}
 
 			nodes, err := rackhdapi.GetNodes(c)
 			Expect(err).ToNot(HaveOccurred())
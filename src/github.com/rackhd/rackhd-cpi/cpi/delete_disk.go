@@ -1,45 +1,62 @@
 package cpi
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
-	"reflect"
 	"strings"
 
+	log "github.com/Sirupsen/logrus"
+
 	"github.com/rackhd/rackhd-cpi/bosh"
 	"github.com/rackhd/rackhd-cpi/config"
-	"github.com/rackhd/rackhd-cpi/models"
 	"github.com/rackhd/rackhd-cpi/rackhdapi"
+	"github.com/rackhd/rackhd-cpi/workflows"
 )
 
 // DeleteDisk deprovisions disk
-func DeleteDisk(c config.Cpi, extInput bosh.MethodArguments) error {
-	var diskCID string
-
-	if reflect.TypeOf(extInput[0]) != reflect.TypeOf(diskCID) {
-		return errors.New("Received unexpected type for disk cid")
-	}
-	diskCID = extInput[0].(string)
+func DeleteDisk(c config.Cpi, input bosh.DeleteDiskArguments) error {
+	diskCID := input.DiskCID
 
 	node, err := rackhdapi.GetNodeByTag(c, diskCID)
 
 	if err != nil {
 		return err
 	}
-	if node.PersistentDisk.IsAttached {
+	disk, found := node.PersistentDisk.Disk(diskCID)
+	if found && disk.IsAttached {
 		return fmt.Errorf("disk: %s is attached", diskCID)
 	}
 
-	container := models.PersistentDiskSettingsContainer{
-		PersistentDisk: models.PersistentDiskSettings{},
+	hasVM := false
+	otherDisks := false
+	for _, tag := range node.Tags {
+		if strings.HasPrefix(tag, VMCIDTagPrefix) {
+			hasVM = true
+		}
+		if strings.HasPrefix(tag, DiskCIDTagPrefix) && tag != diskCID {
+			otherDisks = true
+		}
 	}
-	bodyBytes, err := json.Marshal(container)
-	if err != nil {
-		return err
+
+	settings := node.PersistentDisk.WithoutDisk(diskCID)
+	if found && c.DiskWipePolicy != config.DiskWipeNone {
+		if hasVM {
+			// wiping reboots the node, so the drive is wiped once the VM is deleted
+			log.Info(fmt.Sprintf("deferring wipe of %s on node %s until VM is deleted", disk.Location, node.ID))
+			settings = settings.WithPendingWipe(disk)
+		} else {
+			err = wipeDrives(c, node.ID, []string{disk.AgentPath()})
+			if err != nil {
+				return fmt.Errorf("error wiping disk %s: %s", diskCID, err)
+			}
+		}
 	}
 
-	err = rackhdapi.PatchNode(c, node.ID, bodyBytes)
+	if !hasVM && !otherDisks {
+		// the node is released, so the next VM on it gets fresh disk cids and wiped drives
+		settings = settings.WithoutPregeneratedDisks()
+	}
+
+	err = rackhdapi.PatchPersistentDiskSettings(c, node.ID, settings)
 	if err != nil {
 		return fmt.Errorf("error deleting disk metadata %s: %s", diskCID, err)
 	}
@@ -49,10 +66,8 @@ func DeleteDisk(c config.Cpi, extInput bosh.MethodArguments) error {
 		return fmt.Errorf("error deleting disk cid tag %s: %s", diskCID, err)
 	}
 
-	for _, tag := range node.Tags {
-		if strings.HasPrefix(tag, VMCIDTagPrefix) {
-			return nil
-		}
+	if hasVM || otherDisks {
+		return nil
 	}
 
 	err = rackhdapi.ReleaseNode(c, node.ID)
@@ -61,3 +76,15 @@ func DeleteDisk(c config.Cpi, extInput bosh.MethodArguments) error {
 	}
 	return nil
 }
+
+// wipeDrives runs the wipe disk workflow with the configured policy and waits for it to finish.
+// The node boots into the microkernel to wipe, so drives are named by their /dev/disk/by-id path when known.
+func wipeDrives(c config.Cpi, nodeID string, locations []string) error {
+	workflowName, err := workflows.PublishWipeDiskWorkflow(c)
+	if err != nil {
+		return fmt.Errorf("error publishing wipe disk workflow: %s", err)
+	}
+
+	log.Info(fmt.Sprintf("wiping %v on node %s with policy %s", locations, nodeID, c.DiskWipePolicy))
+	return workflows.RunWipeDiskWorkflow(c, nodeID, workflowName, locations, c.DiskWipePolicy)
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/rackhd/rackhd-cpi/bosh"
 	"github.com/rackhd/rackhd-cpi/config"
@@ -19,12 +18,10 @@ import (
 
 var _ = Describe("DeleteDisk", func() {
 	var server *ghttp.Server
-	var jsonReader *strings.Reader
 	var cpiConfig config.Cpi
-	var request bosh.CpiRequest
 
 	BeforeEach(func() {
-		server, jsonReader, cpiConfig, request = helpers.SetUp(bosh.DELETE_DISK)
+		server, _, cpiConfig, _ = helpers.SetUp(bosh.DELETE_DISK)
 	})
 
 	AfterEach(func() {
@@ -32,27 +29,27 @@ var _ = Describe("DeleteDisk", func() {
 	})
 
 	Context("when given a disk cid for an existing, unattached disk", func() {
-		var extInput bosh.MethodArguments
-		var expectedDeleteDiskBodyBytes []byte
-		var err error
+		var extInput bosh.DeleteDiskArguments
 
-		BeforeEach(func() {
+		expectedDeleteDiskBody := func(nodes []models.TagNode, diskCID string) string {
 			container := models.PersistentDiskSettingsContainer{
-				PersistentDisk: models.PersistentDiskSettings{},
+				PersistentDisk: nodes[0].PersistentDisk.WithoutDisk(diskCID),
 			}
-			expectedDeleteDiskBodyBytes, err = json.Marshal(container)
+			bodyBytes, err := json.Marshal(container)
 			Expect(err).ToNot(HaveOccurred())
-		})
+			return string(bodyBytes)
+		}
 
 		Context("when there is a VM left on the node", func() {
 			It("deletes the disk and disk cid tag", func() {
-				diskCID := "valid_disk_cid_3"
+				diskCID := "disk_cid-fake_uuid"
 				jsonInput := []byte(`["` + diskCID + `"]`)
 				err := json.Unmarshal(jsonInput, &extInput)
 				Expect(err).NotTo(HaveOccurred())
 
 				nodeID := "57fb9fb03fcc55c807add41c"
 				expectedNodesBytes := helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_disk_detached.json")
+				expectedBody := expectedDeleteDiskBody(helpers.LoadTagNodes("../spec_assets/tag_nodes_with_vm_disk_detached.json"), diskCID)
 				server.AppendHandlers(
 					ghttp.CombineHandlers(
 						ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", diskCID)),
@@ -60,7 +57,7 @@ var _ = Describe("DeleteDisk", func() {
 					),
 					ghttp.CombineHandlers(
 						ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/"+nodeID),
-						ghttp.VerifyJSON(string(expectedDeleteDiskBodyBytes)),
+						ghttp.VerifyJSON(expectedBody),
 					),
 					ghttp.CombineHandlers(
 						ghttp.VerifyRequest("DELETE", fmt.Sprintf("/api/2.0/nodes/%s/tags/%s", nodeID, diskCID)),
@@ -76,13 +73,18 @@ var _ = Describe("DeleteDisk", func() {
 
 		Context("when there is no VM left on the node", func() {
 			It("deletes the disk and sets the status to available", func() {
-				diskCID := "valid_disk_cid_1"
+				diskCID := "disk_cid-fake_uuid"
 				jsonInput := []byte(`["` + diskCID + `"]`)
 				err := json.Unmarshal(jsonInput, &extInput)
 				Expect(err).NotTo(HaveOccurred())
 
 				nodeID := "57fb9fb03fcc55c807add42b"
 				expectedNodesBytes := helpers.LoadJSON("../spec_assets/tag_nodes_with_disk_cid.json")
+				bodyBytes, err := json.Marshal(models.PersistentDiskSettingsContainer{
+					PersistentDisk: helpers.LoadTagNodes("../spec_assets/tag_nodes_with_disk_cid.json")[0].PersistentDisk.WithoutDisk(diskCID).WithoutPregeneratedDisks(),
+				})
+				Expect(err).ToNot(HaveOccurred())
+				expectedBody := string(bodyBytes)
 
 				server.AppendHandlers(
 					ghttp.CombineHandlers(
@@ -91,7 +93,7 @@ var _ = Describe("DeleteDisk", func() {
 					),
 					ghttp.CombineHandlers(
 						ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/"+nodeID),
-						ghttp.VerifyJSON(string(expectedDeleteDiskBodyBytes)),
+						ghttp.VerifyJSON(expectedBody),
 						ghttp.RespondWith(http.StatusOK, nil),
 					),
 					ghttp.CombineHandlers(
@@ -115,7 +117,7 @@ var _ = Describe("DeleteDisk", func() {
 		It("returns an error", func() {
 			diskCID := "invalid_disk_cid"
 			jsonInput := []byte(`["` + diskCID + `"]`)
-			var extInput bosh.MethodArguments
+			var extInput bosh.DeleteDiskArguments
 			err := json.Unmarshal(jsonInput, &extInput)
 			Expect(err).ToNot(HaveOccurred())
 
@@ -135,9 +137,9 @@ var _ = Describe("DeleteDisk", func() {
 
 	Context("when given a disk cid for a attached disk", func() {
 		It("returns an error", func() {
-			diskCID := "valid_disk_cid_2"
+			diskCID := "disk_cid-fake_uuid"
 			jsonInput := []byte(`["` + diskCID + `"]`)
-			var extInput bosh.MethodArguments
+			var extInput bosh.DeleteDiskArguments
 			err := json.Unmarshal(jsonInput, &extInput)
 			Expect(err).ToNot(HaveOccurred())
 
@@ -156,4 +158,91 @@ var _ = Describe("DeleteDisk", func() {
 			Expect(len(server.ReceivedRequests())).To(Equal(1))
 		})
 	})
+	Context("when a disk wipe policy is configured", func() {
+		var extInput bosh.DeleteDiskArguments
+		diskCID := "disk_cid-fake_uuid"
+
+		BeforeEach(func() {
+			cpiConfig.DiskWipePolicy = config.DiskWipeQuick
+			err := json.Unmarshal([]byte(`["`+diskCID+`"]`), &extInput)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("when there is no VM left on the node", func() {
+			It("wipes the drive before releasing the node", func() {
+				nodes := helpers.LoadTagNodes("../spec_assets/tag_nodes_with_vm_disk_detached.json")
+				nodes[0].Tags = []string{models.Unavailable, diskCID}
+				nodesBytes, err := json.Marshal(nodes)
+				Expect(err).ToNot(HaveOccurred())
+				nodeID := nodes[0].ID
+
+				expectedBody, err := json.Marshal(models.PersistentDiskSettingsContainer{
+					PersistentDisk: nodes[0].PersistentDisk.WithoutDisk(diskCID).WithoutPregeneratedDisks(),
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", diskCID)),
+						ghttp.RespondWith(http.StatusOK, nodesBytes),
+					),
+				)
+				server.AppendHandlers(helpers.MakeWorkflowHandlers("Wipe", cpiConfig.RequestID, nodeID)...)
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/"+nodeID),
+						ghttp.VerifyJSON(string(expectedBody)),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("DELETE", fmt.Sprintf("/api/2.0/nodes/%s/tags/%s", nodeID, diskCID)),
+						ghttp.RespondWith(http.StatusNoContent, nil),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("DELETE", fmt.Sprintf("/api/2.0/nodes/%s/tags/%s", nodeID, models.Unavailable)),
+						ghttp.RespondWith(http.StatusNoContent, nil),
+					),
+				)
+
+				err = cpi.DeleteDisk(cpiConfig, extInput)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(len(server.ReceivedRequests())).To(Equal(11))
+			})
+		})
+
+		Context("when there is a VM left on the node", func() {
+			It("defers the wipe until the VM is deleted", func() {
+				nodes := helpers.LoadTagNodes("../spec_assets/tag_nodes_with_vm_disk_detached.json")
+				nodeID := nodes[0].ID
+				nodes[0].PersistentDisk.Disks[0].DeviceID = "/dev/disk/by-id/wwn-0x5000c500a1b2c3d4"
+				nodesBytes, err := json.Marshal(nodes)
+				Expect(err).ToNot(HaveOccurred())
+				disk, _ := nodes[0].PersistentDisk.Disk(diskCID)
+
+				expectedBody, err := json.Marshal(models.PersistentDiskSettingsContainer{
+					PersistentDisk: nodes[0].PersistentDisk.WithoutDisk(diskCID).WithPendingWipe(disk),
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(expectedBody)).To(ContainSubstring(`"pending_wipes":[{"location":"/dev/sdb","device_id":"/dev/disk/by-id/wwn-0x5000c500a1b2c3d4"}]`))
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", diskCID)),
+						ghttp.RespondWith(http.StatusOK, nodesBytes),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/"+nodeID),
+						ghttp.VerifyJSON(string(expectedBody)),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("DELETE", fmt.Sprintf("/api/2.0/nodes/%s/tags/%s", nodeID, diskCID)),
+						ghttp.RespondWith(http.StatusNoContent, nil),
+					),
+				)
+
+				err = cpi.DeleteDisk(cpiConfig, extInput)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(len(server.ReceivedRequests())).To(Equal(3))
+			})
+		})
+	})
 })
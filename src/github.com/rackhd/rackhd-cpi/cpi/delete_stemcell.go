@@ -1,22 +1,51 @@
 package cpi
 
 import (
-	"errors"
-	"reflect"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
 
 	"github.com/rackhd/rackhd-cpi/bosh"
 	"github.com/rackhd/rackhd-cpi/config"
 	"github.com/rackhd/rackhd-cpi/rackhdapi"
 )
 
-func DeleteStemcell(c config.Cpi, extInput bosh.MethodArguments) error {
-	var cid string
+// DeleteStemcell deletes the metadata of the stemcell, and its image when no other stemcell uses it.
+// Stemcells created by earlier releases have no metadata and are stored under their cid.
+func DeleteStemcell(c config.Cpi, input bosh.DeleteStemcellArguments) error {
+	cid := input.StemcellCID
+	metadata, found, err := findStemcellMetadata(c, cid)
+	if err != nil {
+		return err
+	}
 
-	if reflect.TypeOf(extInput[0]) != reflect.TypeOf(cid) {
-		return errors.New("Received unexpected type for stemcell cid")
+	if !found {
+		log.Info(fmt.Sprintf("deleting stemcell %s without metadata", cid))
+		return rackhdapi.DeleteFile(c, cid)
 	}
 
-	cid = extInput[0].(string)
+	log.Info(fmt.Sprintf("deleting stemcell %s of %s %s", cid, metadata.Name, metadata.Version))
+
+	if metadata.Image == "" {
+		err = rackhdapi.DeleteFile(c, cid)
+		if err != nil {
+			return err
+		}
+	} else {
+		remaining, err := removeStemcellReference(c, metadata.Image, cid)
+		if err != nil {
+			return err
+		}
+
+		if remaining > 0 {
+			log.Info(fmt.Sprintf("keeping stemcell image %s used by %d other stemcells", metadata.Image, remaining))
+		} else {
+			err = rackhdapi.DeleteFile(c, metadata.Image)
+			if err != nil {
+				return err
+			}
+		}
+	}
 
-	return rackhdapi.DeleteFile(c, cid)
+	return rackhdapi.DeleteFile(c, StemcellMetadataFile(cid))
 }
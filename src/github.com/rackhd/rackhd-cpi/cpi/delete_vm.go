@@ -1,8 +1,7 @@
 package cpi
 
 import (
-	"errors"
-	"reflect"
+	"fmt"
 	"strings"
 
 	"github.com/rackhd/rackhd-cpi/bosh"
@@ -12,20 +11,15 @@ import (
 )
 
 // DeleteVM deprovision a vm
-func DeleteVM(c config.Cpi, extInput bosh.MethodArguments) error {
-	var cid string
-	if reflect.TypeOf(extInput[0]) != reflect.TypeOf(cid) {
-		return errors.New("Received unexpected type for vm cid")
-	}
-
-	cid = extInput[0].(string)
+func DeleteVM(c config.Cpi, input bosh.DeleteVMArguments) error {
+	cid := input.VMCID
 	node, err := rackhdapi.GetNodeByVMCID(c, cid)
 	if err != nil {
 		return err
 	}
 
-	if node.PersistentDisk.IsAttached {
-		err = rackhdapi.MakeDiskRequest(c, node, false)
+	if node.PersistentDisk.HasAttachedDisk() {
+		err = rackhdapi.PatchPersistentDiskSettings(c, node.ID, node.PersistentDisk.Detached())
 		if err != nil {
 			return err
 		}
@@ -41,12 +35,49 @@ func DeleteVM(c config.Cpi, extInput bosh.MethodArguments) error {
 		return err
 	}
 
+	err = clearVMMetadata(c, node)
+	if err != nil {
+		return err
+	}
+
+	settings := node.PersistentDisk.Detached()
+	changed := false
+	if len(settings.PendingWipes) > 0 {
+		if c.DiskWipePolicy != config.DiskWipeNone {
+			err = wipeDrives(c, node.ID, settings.PendingWipePaths())
+			if err != nil {
+				return fmt.Errorf("error wiping deleted disks of VM %s: %s", cid, err)
+			}
+		}
+
+		settings = settings.WithoutPendingWipes()
+		changed = true
+	}
+
+	keepsDisks := false
 	for _, tag := range node.Tags {
 		if strings.HasPrefix(tag, DiskCIDTagPrefix) {
-			return nil
+			keepsDisks = true
 		}
 	}
 
+	// without disks the pregenerated disk cids are stale, and dropping them lets the next VM wipe the drives
+	if !keepsDisks && len(settings.PregeneratedDisks) > 0 {
+		settings = settings.WithoutPregeneratedDisks()
+		changed = true
+	}
+
+	if changed {
+		err = rackhdapi.PatchPersistentDiskSettings(c, node.ID, settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	if keepsDisks {
+		return nil
+	}
+
 	err = rackhdapi.ReleaseNode(c, node.ID)
 	if err != nil {
 		return err
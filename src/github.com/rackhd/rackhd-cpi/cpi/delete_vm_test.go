@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/onsi/gomega/ghttp"
 	"github.com/rackhd/rackhd-cpi/bosh"
@@ -19,12 +18,10 @@ import (
 
 var _ = Describe("DeleteVM", func() {
 	var server *ghttp.Server
-	var jsonReader *strings.Reader
 	var cpiConfig config.Cpi
-	var request bosh.CpiRequest
 
 	BeforeEach(func() {
-		server, jsonReader, cpiConfig, request = helpers.SetUp(bosh.DELETE_VM)
+		server, _, cpiConfig, _ = helpers.SetUp(bosh.DELETE_VM)
 		cpiConfig.RequestID = "requestid"
 	})
 
@@ -33,7 +30,7 @@ var _ = Describe("DeleteVM", func() {
 	})
 
 	Context("with a valid VM CID and valid states", func() {
-		var extInput bosh.MethodArguments
+		var extInput bosh.DeleteVMArguments
 
 		Context("when there is a persistent disk left before deprovisioning", func() {
 			It("deprovisions the node", func() {
@@ -122,11 +119,10 @@ var _ = Describe("DeleteVM", func() {
 				Expect(len(expectedNodes)).To(Equal(1))
 
 				expectedNode := expectedNodes[0]
-				expectedNode.PersistentDisk.IsAttached = false
 				nodeID := expectedNode.ID
 
 				expectedPersistentDiskSettings, err := json.Marshal(map[string]interface{}{
-					"persistent_disk": expectedNode.PersistentDisk,
+					"persistent_disk": expectedNode.PersistentDisk.Detached(),
 				})
 				Expect(err).ToNot(HaveOccurred())
 
@@ -153,5 +149,141 @@ var _ = Describe("DeleteVM", func() {
 				Expect(len(server.ReceivedRequests())).To(Equal(9))
 			})
 		})
+
+		Context("when the node keeps pregenerated disk cids but no disk", func() {
+			It("clears the pregenerated disk cids before releasing the node", func() {
+				vmCID := "vm_cid-fake_uuid"
+
+				jsonInput := []byte(`["` + vmCID + `"]`)
+				err := json.Unmarshal(jsonInput, &extInput)
+				Expect(err).NotTo(HaveOccurred())
+
+				nodes := helpers.LoadTagNodes("../spec_assets/tag_nodes_with_vm_pregenerated_disk.json")
+				nodesBytes, err := json.Marshal(nodes)
+				Expect(err).ToNot(HaveOccurred())
+				nodeID := nodes[0].ID
+
+				expectedPersistentDiskSettings, err := json.Marshal(map[string]interface{}{
+					"persistent_disk": models.PersistentDiskSettings{PregeneratedDisks: []models.PersistentDisk{}, Disks: []models.PersistentDisk{}},
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", vmCID)),
+						ghttp.RespondWith(http.StatusOK, nodesBytes),
+					),
+				)
+				server.AppendHandlers(helpers.MakeWorkflowHandlers("Deprovision", cpiConfig.RequestID, nodeID)...)
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("PATCH", fmt.Sprintf("/api/2.0/nodes/%s", nodeID)),
+						ghttp.VerifyJSON(string(expectedPersistentDiskSettings)),
+						ghttp.RespondWith(http.StatusOK, nil),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("DELETE", fmt.Sprintf("/api/2.0/nodes/%s/tags/%s", nodeID, models.Unavailable)),
+						ghttp.RespondWith(http.StatusNoContent, nil),
+					),
+				)
+
+				err = cpi.DeleteVM(cpiConfig, extInput)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(len(server.ReceivedRequests())).To(Equal(10))
+			})
+		})
+
+		Context("when the node was named after the BOSH instance", func() {
+			It("deletes the metadata tags and gives the node back its name", func() {
+				vmCID := "vm_cid-fake_uuid"
+				nodeID := "57fb9fb03fcc55c807add41c"
+				err := json.Unmarshal([]byte(`["`+vmCID+`"]`), &extInput)
+				Expect(err).NotTo(HaveOccurred())
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", vmCID)),
+						ghttp.RespondWith(http.StatusOK, fmt.Sprintf(`[{"id": "%s", "name": "cf/router/0", "tags": ["unavailable", "%s", "bosh_deployment-cf"], "metadata": {"deployment": "cf", "node_name": "c0:3f:d5:63:fe:13"}}]`, nodeID, vmCID)),
+					),
+				)
+				server.AppendHandlers(helpers.MakeWorkflowHandlers("Deprovision", cpiConfig.RequestID, nodeID)...)
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("DELETE", fmt.Sprintf("/api/2.0/nodes/%s/tags/bosh_deployment-cf", nodeID)),
+						ghttp.RespondWith(http.StatusNoContent, nil),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("PATCH", fmt.Sprintf("/api/2.0/nodes/%s", nodeID)),
+						ghttp.VerifyJSON(`{"name": "c0:3f:d5:63:fe:13", "metadata": {"deployment": "cf"}}`),
+						ghttp.RespondWith(http.StatusOK, nil),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("DELETE", fmt.Sprintf("/api/2.0/nodes/%s/tags/%s", nodeID, models.Unavailable)),
+						ghttp.RespondWith(http.StatusNoContent, nil),
+					),
+				)
+
+				err = cpi.DeleteVM(cpiConfig, extInput)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(server.ReceivedRequests()).To(HaveLen(11))
+			})
+		})
+
+		Context("when deleted disks are waiting to be wiped", func() {
+			It("wipes their drives after deprovisioning the node", func() {
+				vmCID := "vm_cid-fake_uuid"
+				cpiConfig.DiskWipePolicy = config.DiskWipeFull
+
+				jsonInput := []byte(`["` + vmCID + `"]`)
+				err := json.Unmarshal(jsonInput, &extInput)
+				Expect(err).NotTo(HaveOccurred())
+
+				nodes := helpers.LoadTagNodes("../spec_assets/tag_nodes_with_vm_disk_detached.json")
+				nodes[0].PersistentDisk = nodes[0].PersistentDisk.WithoutDisk("disk_cid-fake_uuid").WithPendingWipe(models.PersistentDisk{Location: "/dev/sdb", DeviceID: "/dev/disk/by-id/wwn-0x5000c500a1b2c3d4"})
+				nodes[0].Tags = []string{models.Unavailable, vmCID}
+				nodesBytes, err := json.Marshal(nodes)
+				Expect(err).ToNot(HaveOccurred())
+				nodeID := nodes[0].ID
+
+				expectedPersistentDiskSettings, err := json.Marshal(map[string]interface{}{
+					"persistent_disk": nodes[0].PersistentDisk.WithoutPendingWipes().WithoutPregeneratedDisks(),
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", vmCID)),
+						ghttp.RespondWith(http.StatusOK, nodesBytes),
+					),
+				)
+				server.AppendHandlers(helpers.MakeWorkflowHandlers("Deprovision", cpiConfig.RequestID, nodeID)...)
+				wipeHandlers := helpers.MakeWorkflowHandlers("Wipe", cpiConfig.RequestID, nodeID)
+				wipeHandlers[5] = ghttp.CombineHandlers(
+					func(w http.ResponseWriter, r *http.Request) {
+						var body models.RunWorkflowRequestBody
+						Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+						defaults := body.Options["defaults"].(map[string]interface{})
+						Expect(defaults["devices"]).To(Equal("/dev/disk/by-id/wwn-0x5000c500a1b2c3d4"))
+					},
+					wipeHandlers[5],
+				)
+				server.AppendHandlers(wipeHandlers...)
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("PATCH", fmt.Sprintf("/api/2.0/nodes/%s", nodeID)),
+						ghttp.VerifyJSON(string(expectedPersistentDiskSettings)),
+						ghttp.RespondWith(http.StatusOK, nil),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("DELETE", fmt.Sprintf("/api/2.0/nodes/%s/tags/%s", nodeID, models.Unavailable)),
+						ghttp.RespondWith(http.StatusNoContent, nil),
+					),
+				)
+
+				err = cpi.DeleteVM(cpiConfig, extInput)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(len(server.ReceivedRequests())).To(Equal(17))
+			})
+		})
 	})
 })
@@ -1,10 +1,7 @@
 package cpi
 
 import (
-	"errors"
 	"fmt"
-	"reflect"
-	"strings"
 
 	"github.com/rackhd/rackhd-cpi/bosh"
 	"github.com/rackhd/rackhd-cpi/config"
@@ -12,39 +9,23 @@ import (
 )
 
 // DetachDisk detaches disk from vm
-func DetachDisk(c config.Cpi, extInput bosh.MethodArguments) error {
-	var vmCID string
-	var diskCID string
-
-	if reflect.TypeOf(extInput[0]) != reflect.TypeOf(vmCID) {
-		return errors.New("Received unexpected type for vm cid")
-	}
-
-	if reflect.TypeOf(extInput[1]) != reflect.TypeOf(diskCID) {
-		return errors.New("Received unexpected type for disk cid")
-	}
-
-	vmCID = extInput[0].(string)
-	diskCID = extInput[1].(string)
+func DetachDisk(c config.Cpi, input bosh.DetachDiskArguments) error {
+	vmCID := input.VMCID
+	diskCID := input.DiskCID
 
 	node, err := rackhdapi.GetNodeByVMCID(c, vmCID)
 	if err != nil {
 		return err
 	}
 
-	for _, tag := range node.Tags {
-		if strings.HasPrefix(tag, DiskCIDTagPrefix) {
-			if tag != diskCID {
-				return fmt.Errorf("another disk is attached to VM %s", vmCID)
-			}
-
-			if !node.PersistentDisk.IsAttached {
-				return fmt.Errorf("disk: %s is already detached to VM %s", diskCID, vmCID)
-			}
+	disk, found := node.PersistentDisk.Disk(diskCID)
+	if !found || !hasTag(node.Tags, diskCID) {
+		return fmt.Errorf("disk: %s was not found on VM %s", diskCID, vmCID)
+	}
 
-			return rackhdapi.MakeDiskRequest(c, node, false)
-		}
+	if !disk.IsAttached {
+		return fmt.Errorf("disk: %s is already detached to VM %s", diskCID, vmCID)
 	}
 
-	return fmt.Errorf("disk: %s was not found on VM %s", diskCID, vmCID)
+	return rackhdapi.MakeDiskRequest(c, node, diskCID, false)
 }
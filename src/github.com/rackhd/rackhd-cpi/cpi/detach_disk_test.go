@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/rackhd/rackhd-cpi/bosh"
 	"github.com/rackhd/rackhd-cpi/config"
@@ -19,12 +18,10 @@ import (
 
 var _ = Describe("DetachDisk", func() {
 	var server *ghttp.Server
-	var jsonReader *strings.Reader
 	var cpiConfig config.Cpi
-	var request bosh.CpiRequest
 
 	BeforeEach(func() {
-		server, jsonReader, cpiConfig, request = helpers.SetUp(bosh.CREATE_DISK)
+		server, _, cpiConfig, _ = helpers.SetUp(bosh.CREATE_DISK)
 	})
 
 	AfterEach(func() {
@@ -40,7 +37,7 @@ var _ = Describe("DetachDisk", func() {
             "` + vmCID + `",
             "` + diskCID + `"
           ]`)
-				var extInput bosh.MethodArguments
+				var extInput bosh.DetachDiskArguments
 				err := json.Unmarshal(jsonInput, &extInput)
 				Expect(err).ToNot(HaveOccurred())
 
@@ -68,7 +65,7 @@ var _ = Describe("DetachDisk", func() {
             	"` + vmCID + `",
             	"` + diskCID + `"
           	]`)
-					var extInput bosh.MethodArguments
+					var extInput bosh.DetachDiskArguments
 					err := json.Unmarshal(jsonInput, &extInput)
 					Expect(err).ToNot(HaveOccurred())
 
@@ -81,7 +78,7 @@ var _ = Describe("DetachDisk", func() {
 					)
 
 					err = cpi.DetachDisk(cpiConfig, extInput)
-					errMsg := fmt.Sprintf("another disk is attached to VM %s", vmCID)
+					errMsg := fmt.Sprintf("disk: %s was not found on VM %s", diskCID, vmCID)
 					Expect(err).To(MatchError(errMsg))
 					Expect(len(server.ReceivedRequests())).To(Equal(1))
 				})
@@ -95,7 +92,7 @@ var _ = Describe("DetachDisk", func() {
             	"` + vmCID + `",
             	"` + diskCID + `"
           	]`)
-					var extInput bosh.MethodArguments
+					var extInput bosh.DetachDiskArguments
 					err := json.Unmarshal(jsonInput, &extInput)
 					Expect(err).ToNot(HaveOccurred())
 
@@ -106,9 +103,10 @@ var _ = Describe("DetachDisk", func() {
 					Expect(len(expectedNodes)).To(Equal(1))
 
 					node := expectedNodes[0]
-					node.PersistentDisk.IsAttached = false
+					expectedPersistanceSettings, err := node.PersistentDisk.WithDiskState(diskCID, false)
+					Expect(err).ToNot(HaveOccurred())
 					expectedPersistanceSettingsBytes, err := json.Marshal(map[string]interface{}{
-						"persistent_disk": node.PersistentDisk,
+						"persistent_disk": expectedPersistanceSettings,
 					})
 
 					server.AppendHandlers(
@@ -128,6 +126,44 @@ var _ = Describe("DetachDisk", func() {
 					Expect(len(server.ReceivedRequests())).To(Equal(2))
 				})
 			})
+
+			Context("given a VM with several persistent disks", func() {
+				It("detaches only the given disk", func() {
+					vmCID := "vm_cid-fake_uuid"
+					diskCID := "disk_cid-fake_uuid_sdb"
+					jsonInput := []byte(`["` + vmCID + `", "` + diskCID + `"]`)
+					var extInput bosh.DetachDiskArguments
+					err := json.Unmarshal(jsonInput, &extInput)
+					Expect(err).ToNot(HaveOccurred())
+
+					expectedNodesBytes := helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_multiple_disks.json")
+					server.AppendHandlers(
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", vmCID)),
+							ghttp.RespondWith(http.StatusOK, expectedNodesBytes),
+						),
+						ghttp.CombineHandlers(
+							ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/57fb9fb03fcc55c807add41c"),
+							ghttp.VerifyJSON(`{
+                "persistent_disk": {
+                  "pregenerated_disks": [
+                    {"disk_cid": "disk_cid-57fb9fb03fcc55c807add41c-id-sdb", "location": "/dev/sdb", "size": 0, "attached": false},
+                    {"disk_cid": "disk_cid-57fb9fb03fcc55c807add41c-id-sdc", "location": "/dev/sdc", "device_id": "/dev/disk/by-id/wwn-0x5000c500a1b2c3c4", "size": 0, "attached": false}
+                  ],
+                  "disks": [
+                    {"disk_cid": "disk_cid-fake_uuid_sdb", "location": "/dev/sdb", "size": 2500, "attached": false},
+                    {"disk_cid": "disk_cid-fake_uuid_sdc", "location": "/dev/sdc", "device_id": "/dev/disk/by-id/wwn-0x5000c500a1b2c3c4", "size": 4000, "attached": false}
+                  ]
+                }
+              }`),
+						),
+					)
+
+					err = cpi.DetachDisk(cpiConfig, extInput)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(len(server.ReceivedRequests())).To(Equal(2))
+				})
+			})
 		})
 	})
 
@@ -139,7 +175,7 @@ var _ = Describe("DetachDisk", func() {
 					"` + vmCID + `",
           "` + diskCID + `"
 				]`)
-			var extInput bosh.MethodArguments
+			var extInput bosh.DetachDiskArguments
 			err := json.Unmarshal(jsonInput, &extInput)
 			Expect(err).ToNot(HaveOccurred())
 
@@ -0,0 +1,117 @@
+package cpi
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rackhd/rackhd-cpi/models"
+)
+
+const (
+	systemDiskCloudPropertyKey     = "system_disk"
+	persistentDiskCloudPropertyKey = "persistent_disk"
+)
+
+// DiskLayoutProperties are the vm_type cloud_properties naming the system and persistent drives
+type DiskLayoutProperties struct {
+	SystemDisk     string
+	PersistentDisk string
+}
+
+// DiskLayout is the pair of local drives chosen as system disk and default persistent disk of a node.
+// Persistent is empty when the node has no drive left for a persistent disk.
+type DiskLayout struct {
+	System     string
+	Persistent string
+}
+
+func parseDiskLayoutProperties(cloudProperties map[string]interface{}) (DiskLayoutProperties, error) {
+	properties := DiskLayoutProperties{}
+
+	for key, target := range map[string]*string{
+		systemDiskCloudPropertyKey:     &properties.SystemDisk,
+		persistentDiskCloudPropertyKey: &properties.PersistentDisk,
+	} {
+		input, exists := cloudProperties[key]
+		if !exists || input == nil {
+			continue
+		}
+
+		name, ok := input.(string)
+		if !ok {
+			return DiskLayoutProperties{}, fmt.Errorf("%s cloud property must be a device name, got %v", key, input)
+		}
+		*target = deviceName(name)
+	}
+
+	if properties.SystemDisk != "" && properties.SystemDisk == properties.PersistentDisk {
+		return DiskLayoutProperties{}, fmt.Errorf("%s and %s cloud properties both name %s", systemDiskCloudPropertyKey, persistentDiskCloudPropertyKey, properties.SystemDisk)
+	}
+
+	return properties, nil
+}
+
+// selectDiskLayout picks the smallest local drive as system disk and the largest remaining one as persistent disk,
+// unless the cloud properties name them. Drives in used back existing persistent disks and are never chosen.
+func selectDiskLayout(blockDevices map[string]models.Device, properties DiskLayoutProperties, used map[string]bool) (DiskLayout, error) {
+	drives := spareLocalDrives(blockDevices)
+	for location := range used {
+		delete(drives, deviceName(location))
+	}
+
+	system := properties.SystemDisk
+	if system != "" {
+		if _, found := drives[system]; !found {
+			return DiskLayout{}, fmt.Errorf("error selecting system disk: %s is not a free local drive on this node", system)
+		}
+	} else {
+		system = smallestDrive(drives)
+		if system == "" {
+			return DiskLayout{}, fmt.Errorf("error selecting system disk: no local drive found")
+		}
+	}
+	delete(drives, system)
+
+	persistent := properties.PersistentDisk
+	if persistent != "" {
+		if _, found := drives[persistent]; !found {
+			return DiskLayout{}, fmt.Errorf("error selecting persistent disk: %s is not a free local drive on this node", persistent)
+		}
+	} else {
+		persistent = largestDrive(drives)
+	}
+
+	log.Info(fmt.Sprintf("selected %s as system disk and %q as persistent disk", system, persistent))
+
+	layout := DiskLayout{System: devicePath(system)}
+	if persistent != "" {
+		layout.Persistent = devicePath(persistent)
+	}
+	return layout, nil
+}
+
+// defaultSystemDisk is the drive selectDiskLayout picks as system disk when no cloud property names one
+func defaultSystemDisk(blockDevices map[string]models.Device) string {
+	return smallestDrive(spareLocalDrives(blockDevices))
+}
+
+func smallestDrive(drives map[string]int) string {
+	var selected string
+	for name, size := range drives {
+		if selected == "" || size < drives[selected] || (size == drives[selected] && name < selected) {
+			selected = name
+		}
+	}
+	return selected
+}
+
+func largestDrive(drives map[string]int) string {
+	var selected string
+	for name, size := range drives {
+		if selected == "" || size > drives[selected] || (size == drives[selected] && name < selected) {
+			selected = name
+		}
+	}
+	return selected
+}
@@ -0,0 +1,90 @@
+package cpi
+
+import (
+	"github.com/rackhd/rackhd-cpi/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("disk layout", func() {
+	Describe("parseDiskLayoutProperties", func() {
+		It("returns empty properties when no drive is named", func() {
+			properties, err := parseDiskLayoutProperties(map[string]interface{}{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(properties).To(Equal(DiskLayoutProperties{}))
+		})
+
+		It("accepts device names and paths", func() {
+			properties, err := parseDiskLayoutProperties(map[string]interface{}{
+				"system_disk":     "/dev/nvme0n1",
+				"persistent_disk": "nvme1n1",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(properties).To(Equal(DiskLayoutProperties{SystemDisk: "nvme0n1", PersistentDisk: "nvme1n1"}))
+		})
+
+		It("returns an error when a drive is not a string", func() {
+			_, err := parseDiskLayoutProperties(map[string]interface{}{"system_disk": float64(1)})
+			Expect(err).To(MatchError("system_disk cloud property must be a device name, got 1"))
+		})
+
+		It("returns an error when both properties name the same drive", func() {
+			_, err := parseDiskLayoutProperties(map[string]interface{}{"system_disk": "sda", "persistent_disk": "/dev/sda"})
+			Expect(err).To(MatchError("system_disk and persistent_disk cloud properties both name sda"))
+		})
+	})
+
+	Describe("selectDiskLayout", func() {
+		It("keeps sda and sdb on nodes with equally sized SCSI drives", func() {
+			blockDevices := map[string]models.Device{
+				"sda": models.Device{Size: "16777216", Removable: "0"},
+				"sdb": models.Device{Size: "16777216", Removable: "0"},
+				"sr0": models.Device{Size: "2097151", Removable: "1"},
+			}
+
+			layout, err := selectDiskLayout(blockDevices, DiskLayoutProperties{}, map[string]bool{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(layout).To(Equal(DiskLayout{System: "/dev/sda", Persistent: "/dev/sdb"}))
+		})
+
+		Context("on a node with only NVMe drives", func() {
+			var blockDevices map[string]models.Device
+
+			BeforeEach(func() {
+				blockDevices = map[string]models.Device{
+					"nvme0n1": models.Device{Size: "1875385008", Removable: "0"},
+					"nvme1n1": models.Device{Size: "234441648", Removable: "0"},
+					"nvme2n1": models.Device{Size: "937703088", Removable: "0"},
+				}
+			})
+
+			It("uses the smallest drive for the system and the largest for persistent data", func() {
+				layout, err := selectDiskLayout(blockDevices, DiskLayoutProperties{}, map[string]bool{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(layout).To(Equal(DiskLayout{System: "/dev/nvme1n1", Persistent: "/dev/nvme0n1"}))
+			})
+
+			It("uses the drives named by the cloud properties", func() {
+				layout, err := selectDiskLayout(blockDevices, DiskLayoutProperties{SystemDisk: "nvme2n1", PersistentDisk: "nvme1n1"}, map[string]bool{})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(layout).To(Equal(DiskLayout{System: "/dev/nvme2n1", Persistent: "/dev/nvme1n1"}))
+			})
+
+			It("never chooses a drive backing a persistent disk", func() {
+				used := map[string]bool{"/dev/nvme1n1": true, "/dev/nvme0n1": true}
+				layout, err := selectDiskLayout(blockDevices, DiskLayoutProperties{}, used)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(layout).To(Equal(DiskLayout{System: "/dev/nvme2n1"}))
+
+				_, err = selectDiskLayout(blockDevices, DiskLayoutProperties{SystemDisk: "nvme1n1"}, used)
+				Expect(err).To(MatchError("error selecting system disk: nvme1n1 is not a free local drive on this node"))
+			})
+		})
+
+		It("returns an error when the node has no local drive", func() {
+			_, err := selectDiskLayout(map[string]models.Device{}, DiskLayoutProperties{}, map[string]bool{})
+			Expect(err).To(MatchError("error selecting system disk: no local drive found"))
+		})
+	})
+})
@@ -0,0 +1,163 @@
+package cpi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rackhd/rackhd-cpi/models"
+)
+
+const ephemeralDiskCloudPropertyKey = "ephemeral_disk"
+
+// EphemeralDiskProperties are the vm_type cloud_properties that control the ephemeral disk
+type EphemeralDiskProperties struct {
+	SizeInMB int      `json:"size"`
+	Devices  []string `json:"devices"`
+	Stripe   bool     `json:"stripe"`
+}
+
+// EphemeralDisk is the drive (or set of striped drives) chosen as the ephemeral disk of a node
+type EphemeralDisk struct {
+	Path          string
+	StripeDevices []string
+}
+
+func parseEphemeralDiskProperties(cloudProperties map[string]interface{}) (EphemeralDiskProperties, error) {
+	properties := EphemeralDiskProperties{}
+
+	input, exists := cloudProperties[ephemeralDiskCloudPropertyKey]
+	if !exists || input == nil {
+		return properties, nil
+	}
+
+	b, err := json.Marshal(input)
+	if err != nil {
+		return properties, fmt.Errorf("error marshalling %s cloud property: %s", ephemeralDiskCloudPropertyKey, err)
+	}
+
+	err = json.Unmarshal(b, &properties)
+	if err != nil {
+		return properties, fmt.Errorf("%s cloud property is invalid: %s", ephemeralDiskCloudPropertyKey, err)
+	}
+
+	if properties.SizeInMB < 0 {
+		return properties, fmt.Errorf("%s size cannot be negative: %d", ephemeralDiskCloudPropertyKey, properties.SizeInMB)
+	}
+
+	return properties, nil
+}
+
+// selectEphemeralDisk picks the ephemeral disk among the local drives not used as system or persistent disk.
+// An empty EphemeralDisk means the agent keeps its data on the system disk.
+func selectEphemeralDisk(blockDevices map[string]models.Device, properties EphemeralDiskProperties, reserved ...string) (EphemeralDisk, error) {
+	candidates := spareLocalDrives(blockDevices, reserved...)
+
+	if len(properties.Devices) > 0 {
+		return selectConfiguredEphemeralDisk(candidates, properties)
+	}
+
+	if len(candidates) == 0 {
+		if properties.SizeInMB > 0 {
+			return EphemeralDisk{}, fmt.Errorf("error selecting ephemeral disk: no spare local drive for %dMB ephemeral disk", properties.SizeInMB)
+		}
+		log.Info("no spare local drive found, ephemeral data stays on the system disk")
+		return EphemeralDisk{}, nil
+	}
+
+	if properties.Stripe && len(candidates) > 1 {
+		devices := make([]string, 0, len(candidates))
+		for name := range candidates {
+			devices = append(devices, name)
+		}
+		return stripeEphemeralDisk(candidates, devices, properties.SizeInMB)
+	}
+
+	var selected string
+	var selectedSize int
+	for name, size := range candidates {
+		if size < properties.SizeInMB {
+			continue
+		}
+		if selected == "" || size < selectedSize || (size == selectedSize && name < selected) {
+			selected = name
+			selectedSize = size
+		}
+	}
+
+	if selected == "" {
+		return EphemeralDisk{}, fmt.Errorf("error selecting ephemeral disk: no local drive has %dMB available", properties.SizeInMB)
+	}
+
+	log.Info(fmt.Sprintf("selected %s (%dMB) as ephemeral disk", selected, selectedSize))
+	return EphemeralDisk{Path: fmt.Sprintf("/dev/%s", selected)}, nil
+}
+
+func selectConfiguredEphemeralDisk(candidates map[string]int, properties EphemeralDiskProperties) (EphemeralDisk, error) {
+	for _, name := range properties.Devices {
+		if _, found := candidates[name]; !found {
+			return EphemeralDisk{}, fmt.Errorf("error selecting ephemeral disk: %s is not a spare local drive on this node", name)
+		}
+	}
+
+	if len(properties.Devices) > 1 {
+		if !properties.Stripe {
+			return EphemeralDisk{}, fmt.Errorf("error selecting ephemeral disk: %d devices configured but stripe is not enabled", len(properties.Devices))
+		}
+		return stripeEphemeralDisk(candidates, properties.Devices, properties.SizeInMB)
+	}
+
+	name := properties.Devices[0]
+	if candidates[name] < properties.SizeInMB {
+		return EphemeralDisk{}, fmt.Errorf("error selecting ephemeral disk: %s has %dMB, %dMB requested", name, candidates[name], properties.SizeInMB)
+	}
+
+	return EphemeralDisk{Path: fmt.Sprintf("/dev/%s", name)}, nil
+}
+
+func stripeEphemeralDisk(candidates map[string]int, names []string, sizeInMB int) (EphemeralDisk, error) {
+	sort.Strings(names)
+
+	// RAID0 capacity is bounded by its smallest member
+	smallest := -1
+	devices := make([]string, 0, len(names))
+	for _, name := range names {
+		if smallest < 0 || candidates[name] < smallest {
+			smallest = candidates[name]
+		}
+		devices = append(devices, fmt.Sprintf("/dev/%s", name))
+	}
+
+	total := smallest * len(names)
+	if total < sizeInMB {
+		return EphemeralDisk{}, fmt.Errorf("error selecting ephemeral disk: striping %v gives %dMB, %dMB requested", names, total, sizeInMB)
+	}
+
+	log.Info(fmt.Sprintf("striping %v (%dMB) as ephemeral disk", names, total))
+	return EphemeralDisk{Path: models.EphemeralStripeDevice, StripeDevices: devices}, nil
+}
+
+// spareLocalDrives maps every non-removable local drive outside reserved to its size in MB
+func spareLocalDrives(blockDevices map[string]models.Device, reserved ...string) map[string]int {
+	excluded := map[string]bool{}
+	for _, name := range reserved {
+		excluded[name] = true
+	}
+
+	candidates := map[string]int{}
+	for name, device := range blockDevices {
+		if excluded[name] || device.Removable == "1" || !models.LocalDriveNamePattern.MatchString(name) {
+			continue
+		}
+
+		size, err := device.SizeInMB()
+		if err != nil || size == 0 {
+			continue
+		}
+		candidates[name] = size
+	}
+
+	return candidates
+}
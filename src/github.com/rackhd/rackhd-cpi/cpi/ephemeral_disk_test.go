@@ -0,0 +1,119 @@
+package cpi
+
+import (
+	"github.com/rackhd/rackhd-cpi/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ephemeral disk", func() {
+	Describe("parseEphemeralDiskProperties", func() {
+		It("returns empty properties when ephemeral_disk is not set", func() {
+			properties, err := parseEphemeralDiskProperties(map[string]interface{}{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(properties).To(Equal(EphemeralDiskProperties{}))
+		})
+
+		It("decodes size, devices and stripe", func() {
+			cloudProperties := map[string]interface{}{
+				"ephemeral_disk": map[string]interface{}{
+					"size":    float64(2048),
+					"devices": []interface{}{"sdc", "sdd"},
+					"stripe":  true,
+				},
+			}
+
+			properties, err := parseEphemeralDiskProperties(cloudProperties)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(properties).To(Equal(EphemeralDiskProperties{SizeInMB: 2048, Devices: []string{"sdc", "sdd"}, Stripe: true}))
+		})
+
+		It("returns an error when ephemeral_disk is malformed", func() {
+			_, err := parseEphemeralDiskProperties(map[string]interface{}{"ephemeral_disk": "big"})
+			Expect(err).To(MatchError(ContainSubstring("ephemeral_disk cloud property is invalid")))
+		})
+
+		It("returns an error when the size is negative", func() {
+			_, err := parseEphemeralDiskProperties(map[string]interface{}{"ephemeral_disk": map[string]interface{}{"size": float64(-1)}})
+			Expect(err).To(MatchError("ephemeral_disk size cannot be negative: -1"))
+		})
+	})
+
+	Describe("selectEphemeralDisk", func() {
+		var blockDevices map[string]models.Device
+
+		BeforeEach(func() {
+			blockDevices = map[string]models.Device{
+				"sda":   models.Device{Size: "8388608", Removable: "0"},
+				"sdb":   models.Device{Size: "8388608", Removable: "0"},
+				"sdc":   models.Device{Size: "4194304", Removable: "0"},
+				"sdd":   models.Device{Size: "2097152", Removable: "0"},
+				"sde":   models.Device{Size: "16777216", Removable: "1"},
+				"loop0": models.Device{Size: "0", Removable: "0"},
+				"ram0":  models.Device{Size: "65536", Removable: "0"},
+			}
+		})
+
+		It("picks the smallest spare local drive by default", func() {
+			disk, err := selectEphemeralDisk(blockDevices, EphemeralDiskProperties{}, "sda", "sdb")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(disk).To(Equal(EphemeralDisk{Path: "/dev/sdd"}))
+		})
+
+		It("picks the smallest spare local drive satisfying the requested size", func() {
+			disk, err := selectEphemeralDisk(blockDevices, EphemeralDiskProperties{SizeInMB: 3072}, "sda", "sdb")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(disk).To(Equal(EphemeralDisk{Path: "/dev/sdc"}))
+		})
+
+		It("returns an error when no drive satisfies the requested size", func() {
+			_, err := selectEphemeralDisk(blockDevices, EphemeralDiskProperties{SizeInMB: 10240}, "sda", "sdb")
+			Expect(err).To(MatchError("error selecting ephemeral disk: no local drive has 10240MB available"))
+		})
+
+		It("uses the configured device", func() {
+			disk, err := selectEphemeralDisk(blockDevices, EphemeralDiskProperties{Devices: []string{"sdc"}}, "sda", "sdb")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(disk).To(Equal(EphemeralDisk{Path: "/dev/sdc"}))
+		})
+
+		It("refuses a configured device that is reserved or removable", func() {
+			_, err := selectEphemeralDisk(blockDevices, EphemeralDiskProperties{Devices: []string{"sdb"}}, "sda", "sdb")
+			Expect(err).To(MatchError("error selecting ephemeral disk: sdb is not a spare local drive on this node"))
+
+			_, err = selectEphemeralDisk(blockDevices, EphemeralDiskProperties{Devices: []string{"sde"}}, "sda", "sdb")
+			Expect(err).To(MatchError("error selecting ephemeral disk: sde is not a spare local drive on this node"))
+		})
+
+		It("refuses several configured devices without stripe", func() {
+			_, err := selectEphemeralDisk(blockDevices, EphemeralDiskProperties{Devices: []string{"sdc", "sdd"}}, "sda", "sdb")
+			Expect(err).To(MatchError("error selecting ephemeral disk: 2 devices configured but stripe is not enabled"))
+		})
+
+		It("stripes every spare local drive", func() {
+			disk, err := selectEphemeralDisk(blockDevices, EphemeralDiskProperties{Stripe: true, SizeInMB: 4096}, "sda", "sdb")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(disk).To(Equal(EphemeralDisk{
+				Path:          models.EphemeralStripeDevice,
+				StripeDevices: []string{"/dev/sdc", "/dev/sdd"},
+			}))
+		})
+
+		It("returns an error when the stripe is too small", func() {
+			_, err := selectEphemeralDisk(blockDevices, EphemeralDiskProperties{Stripe: true, SizeInMB: 5120}, "sda", "sdb")
+			Expect(err).To(MatchError("error selecting ephemeral disk: striping [sdc sdd] gives 4096MB, 5120MB requested"))
+		})
+
+		It("leaves the ephemeral disk unset when there is no spare local drive", func() {
+			disk, err := selectEphemeralDisk(map[string]models.Device{"sda": blockDevices["sda"]}, EphemeralDiskProperties{}, "sda", "sdb")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(disk).To(Equal(EphemeralDisk{}))
+		})
+
+		It("returns an error when a size is requested but there is no spare local drive", func() {
+			_, err := selectEphemeralDisk(map[string]models.Device{"sda": blockDevices["sda"]}, EphemeralDiskProperties{SizeInMB: 1024}, "sda", "sdb")
+			Expect(err).To(MatchError("error selecting ephemeral disk: no spare local drive for 1024MB ephemeral disk"))
+		})
+	})
+})
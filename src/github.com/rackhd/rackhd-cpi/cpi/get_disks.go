@@ -1,8 +1,6 @@
 package cpi
 
 import (
-	"errors"
-	"reflect"
 	"strings"
 
 	"github.com/rackhd/rackhd-cpi/bosh"
@@ -10,26 +8,21 @@ import (
 	"github.com/rackhd/rackhd-cpi/rackhdapi"
 )
 
-// GetDisks returns the persistent disk
-func GetDisks(c config.Cpi, extInput bosh.MethodArguments) ([]string, error) {
-	var vmCID string
-
-	if reflect.TypeOf(extInput[0]) != reflect.TypeOf(vmCID) {
-		return nil, errors.New("Received unexpected type for vm cid")
-	}
-
-	vmCID = extInput[0].(string)
+// GetDisks returns the persistent disks
+func GetDisks(c config.Cpi, input bosh.GetDisksArguments) ([]string, error) {
+	vmCID := input.VMCID
 
 	node, err := rackhdapi.GetNodeByVMCID(c, vmCID)
 	if err != nil {
 		return nil, err
 	}
 
+	disks := []string{}
 	for _, tag := range node.Tags {
 		if strings.HasPrefix(tag, DiskCIDTagPrefix) {
-			return []string{tag}, nil
+			disks = append(disks, tag)
 		}
 	}
 
-	return []string{}, nil
+	return disks, nil
 }
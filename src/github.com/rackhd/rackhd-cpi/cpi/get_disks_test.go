@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/rackhd/rackhd-cpi/bosh"
 	"github.com/rackhd/rackhd-cpi/config"
@@ -18,12 +17,10 @@ import (
 
 var _ = Describe("GetDisks", func() {
 	var server *ghttp.Server
-	var jsonReader *strings.Reader
 	var cpiConfig config.Cpi
-	var request bosh.CpiRequest
 
 	BeforeEach(func() {
-		server, jsonReader, cpiConfig, request = helpers.SetUp(bosh.GET_DISKS)
+		server, _, cpiConfig, _ = helpers.SetUp(bosh.GET_DISKS)
 	})
 
 	AfterEach(func() {
@@ -37,7 +34,7 @@ var _ = Describe("GetDisks", func() {
 				jsonInput := []byte(`[
             "` + vmCID + `"
           ]`)
-				var extInput bosh.MethodArguments
+				var extInput bosh.GetDisksArguments
 				err := json.Unmarshal(jsonInput, &extInput)
 				Expect(err).ToNot(HaveOccurred())
 
@@ -56,13 +53,35 @@ var _ = Describe("GetDisks", func() {
 			})
 		})
 
+		Context("the vm has several persistent disks", func() {
+			It("returns every disk CID", func() {
+				vmCID := "vm_cid-fake_uuid"
+				jsonInput := []byte(`["` + vmCID + `"]`)
+				var extInput bosh.GetDisksArguments
+				err := json.Unmarshal(jsonInput, &extInput)
+				Expect(err).ToNot(HaveOccurred())
+
+				expectedNodesBytes := helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_multiple_disks.json")
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", vmCID)),
+						ghttp.RespondWith(http.StatusOK, expectedNodesBytes),
+					),
+				)
+
+				result, err := cpi.GetDisks(cpiConfig, extInput)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result).To(Equal([]string{"disk_cid-fake_uuid_sdb", "disk_cid-fake_uuid_sdc"}))
+			})
+		})
+
 		Context("the vm does not have persistent disk", func() {
 			It("returns empty array", func() {
 				vmCID := "vm_cid-fake_uuid"
 				jsonInput := []byte(`[
             "` + vmCID + `"
           ]`)
-				var extInput bosh.MethodArguments
+				var extInput bosh.GetDisksArguments
 				err := json.Unmarshal(jsonInput, &extInput)
 				Expect(err).ToNot(HaveOccurred())
 
@@ -88,7 +107,7 @@ var _ = Describe("GetDisks", func() {
 			jsonInput := []byte(`[
           "` + vmCID + `"
         ]`)
-			var extInput bosh.MethodArguments
+			var extInput bosh.GetDisksArguments
 			err := json.Unmarshal(jsonInput, &extInput)
 			Expect(err).ToNot(HaveOccurred())
 
@@ -0,0 +1,70 @@
+package cpi
+
+import (
+	"encoding/json"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/models"
+	"github.com/rackhd/rackhd-cpi/rackhdapi"
+)
+
+// HardwareMetadataKey is the VM metadata key holding the hardware facts of the node
+const HardwareMetadataKey = "hardware"
+
+// hardwareFacts are the facts tying a VM to the server backing it. Facts the catalogs do not report are left out.
+func hardwareFacts(hardware models.Hardware, rack string) map[string]string {
+	facts := map[string]string{}
+	set := func(key string, value string) {
+		if value != "" {
+			facts[key] = value
+		}
+	}
+
+	set("serial_number", hardware.System.SerialNumber)
+	set("manufacturer", hardware.System.Manufacturer)
+	set("model", hardware.System.ProductName)
+	set("bmc_ip", hardware.BMC.IPAddress)
+	set("rack", rack)
+
+	for _, nic := range hardware.NICs {
+		if nic.SwitchPort != "" {
+			set("switch_port", fmt.Sprintf("%s/%s", nic.SwitchName, nic.SwitchPort))
+			break
+		}
+	}
+
+	return facts
+}
+
+// hardwareMetadata is the node metadata of a freshly created VM, replacing the metadata of the VM it held before
+func hardwareMetadata(facts map[string]string) string {
+	metadata, _ := json.Marshal(map[string]interface{}{HardwareMetadataKey: facts})
+	return string(metadata)
+}
+
+// nodeRack is the name of the enclosure RackHD found the node in. Nodes outside an enclosure have no rack.
+func nodeRack(c config.Cpi, nodeID string) string {
+	node, err := rackhdapi.GetNode(c, nodeID)
+	if err != nil {
+		log.Info(fmt.Sprintf("unable to find rack of node %s: %s", nodeID, err))
+		return ""
+	}
+
+	for _, relation := range node.Relations {
+		if relation.RelationType != models.EnclosedByRelation || len(relation.Targets) == 0 {
+			continue
+		}
+
+		enclosure, err := rackhdapi.GetNode(c, relation.Targets[0])
+		if err != nil {
+			log.Info(fmt.Sprintf("unable to find rack of node %s: %s", nodeID, err))
+			return ""
+		}
+		return enclosure.Name
+	}
+
+	return ""
+}
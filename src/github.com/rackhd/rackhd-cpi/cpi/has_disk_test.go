@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/rackhd/rackhd-cpi/bosh"
 	"github.com/rackhd/rackhd-cpi/config"
@@ -18,12 +17,10 @@ import (
 
 var _ = Describe("AttachDisk", func() {
 	var server *ghttp.Server
-	var jsonReader *strings.Reader
 	var cpiConfig config.Cpi
-	var request bosh.CpiRequest
 
 	BeforeEach(func() {
-		server, jsonReader, cpiConfig, request = helpers.SetUp(bosh.ATTACH_DISK)
+		server, _, cpiConfig, _ = helpers.SetUp(bosh.ATTACH_DISK)
 	})
 
 	AfterEach(func() {
@@ -36,7 +33,7 @@ var _ = Describe("AttachDisk", func() {
 			jsonInput := []byte(`[
           "` + diskCID + `"
         ]`)
-			var extInput bosh.MethodArguments
+			var extInput bosh.HasDiskArguments
 			err := json.Unmarshal(jsonInput, &extInput)
 			Expect(err).ToNot(HaveOccurred())
 
@@ -61,7 +58,7 @@ var _ = Describe("AttachDisk", func() {
 			jsonInput := []byte(`[
           "` + diskCID + `"
         ]`)
-			var extInput bosh.MethodArguments
+			var extInput bosh.HasDiskArguments
 			err := json.Unmarshal(jsonInput, &extInput)
 			Expect(err).ToNot(HaveOccurred())
 
@@ -83,7 +80,7 @@ var _ = Describe("AttachDisk", func() {
 	Context("given a disk CID that is an empty string", func() {
 		It("returns false", func() {
 			jsonInput := []byte(`[""]`)
-			var extInput bosh.MethodArguments
+			var extInput bosh.HasDiskArguments
 			err := json.Unmarshal(jsonInput, &extInput)
 			Expect(err).ToNot(HaveOccurred())
 
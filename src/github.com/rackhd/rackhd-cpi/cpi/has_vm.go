@@ -1,9 +1,7 @@
 package cpi
 
 import (
-	"errors"
 	"fmt"
-	"reflect"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/rackhd/rackhd-cpi/bosh"
@@ -12,13 +10,8 @@ import (
 )
 
 //HasVM will check all nodes available to RACKHD for the given CID, true/false if CID exists.
-func HasVM(c config.Cpi, extInput bosh.MethodArguments) (bool, error) {
-	var cid string
-	if reflect.TypeOf(extInput[0]) != reflect.TypeOf(cid) {
-		return false, errors.New("Received unexpected type for vm cid")
-	}
-
-	cid = extInput[0].(string)
+func HasVM(c config.Cpi, input bosh.HasVMArguments) (bool, error) {
+	cid := input.VMCID
 
 	nodes, err := rackhdapi.GetNodesByTag(c, cid)
 	if err != nil {
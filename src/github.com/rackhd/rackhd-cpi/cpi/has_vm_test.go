@@ -3,7 +3,6 @@ package cpi_test
 import (
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/rackhd/rackhd-cpi/bosh"
 	"github.com/rackhd/rackhd-cpi/config"
@@ -18,12 +17,10 @@ import (
 var _ = Describe("Cpi/HasVm", func() {
 	Context("Has VM", func() {
 		var server *ghttp.Server
-		var jsonReader *strings.Reader
 		var cpiConfig config.Cpi
-		var request bosh.CpiRequest
 
 		BeforeEach(func() {
-			server, jsonReader, cpiConfig, request = helpers.SetUp(bosh.HAS_VM)
+			server, _, cpiConfig, _ = helpers.SetUp(bosh.HAS_VM)
 		})
 
 		AfterEach(func() {
@@ -33,8 +30,7 @@ var _ = Describe("Cpi/HasVm", func() {
 		It("Find a vm that exist", func() {
 			cid := "vm-1234"
 
-			var metadataInput bosh.MethodArguments
-			metadataInput = append(metadataInput, cid)
+			input := bosh.HasVMArguments{VMCID: cid}
 			expectedNodesData := helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_cid.json")
 
 			server.AppendHandlers(
@@ -44,7 +40,7 @@ var _ = Describe("Cpi/HasVm", func() {
 				),
 			)
 
-			hasVM, err := cpi.HasVM(cpiConfig, metadataInput)
+			hasVM, err := cpi.HasVM(cpiConfig, input)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(hasVM).To(BeTrue())
 			Expect(server.ReceivedRequests()).To(HaveLen(1))
@@ -52,8 +48,7 @@ var _ = Describe("Cpi/HasVm", func() {
 
 		It("Cannot find a vm that does not exist", func() {
 			cid := "does-not-exist-cid"
-			var metadataInput bosh.MethodArguments
-			metadataInput = append(metadataInput, cid)
+			input := bosh.HasVMArguments{VMCID: cid}
 
 			server.AppendHandlers(
 				ghttp.CombineHandlers(
@@ -62,7 +57,7 @@ var _ = Describe("Cpi/HasVm", func() {
 				),
 			)
 
-			hasVM, err := cpi.HasVM(cpiConfig, metadataInput)
+			hasVM, err := cpi.HasVM(cpiConfig, input)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(hasVM).To(BeFalse())
 			Expect(server.ReceivedRequests()).To(HaveLen(1))
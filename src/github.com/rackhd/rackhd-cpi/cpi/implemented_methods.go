@@ -22,6 +22,8 @@ var cpiMethods = map[string]bool{
 	bosh.DETACH_DISK:        true,
 	bosh.HAS_DISK:           true,
 	bosh.GET_DISKS:          true,
+	bosh.RESIZE_DISK:        true,
+	bosh.SET_DISK_METADATA:  true,
 	bosh.SNAPSHOT_DISK:      false,
 	bosh.DELETE_SNAPSHOT:    false,
 	bosh.CURRENT_VM_ID:      false,
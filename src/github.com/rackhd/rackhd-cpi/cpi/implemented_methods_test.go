@@ -21,6 +21,8 @@ var _ = Describe("ImplementsMethod", func() {
 		Expect(cpi.ImplementsMethod("has_disk")).To(BeTrue())
 		Expect(cpi.ImplementsMethod("get_disks")).To(BeTrue())
 		Expect(cpi.ImplementsMethod("create_disk")).To(BeTrue())
+		Expect(cpi.ImplementsMethod("resize_disk")).To(BeTrue())
+		Expect(cpi.ImplementsMethod("set_disk_metadata")).To(BeTrue())
 	})
 
 	It("returns false if the CPI currently does not implement the method", func() {
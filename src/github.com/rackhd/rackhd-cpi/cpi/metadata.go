@@ -0,0 +1,34 @@
+package cpi
+
+import (
+	"fmt"
+	"math"
+)
+
+// validateMetadata checks that metadata set by the director is a hash with string or integer values
+func validateMetadata(metadata map[string]interface{}) error {
+	for key, value := range metadata {
+		switch v := value.(type) {
+		case string, int:
+		case float64:
+			if v != math.Trunc(v) {
+				return fmt.Errorf("metadata %s must be a string or an integer, got %v", key, v)
+			}
+		default:
+			return fmt.Errorf("metadata %s must be a string or an integer, got %v", key, v)
+		}
+	}
+	return nil
+}
+
+// mergeMetadata returns a copy of existing overlaid with update
+func mergeMetadata(existing map[string]interface{}, update map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for key, value := range existing {
+		merged[key] = value
+	}
+	for key, value := range update {
+		merged[key] = value
+	}
+	return merged
+}
@@ -2,7 +2,6 @@ package cpi
 
 import (
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
@@ -13,42 +12,11 @@ import (
 	"github.com/rackhd/rackhd-cpi/bosh"
 )
 
-func parseCreateVMInput(extInput bosh.MethodArguments) (string, string, string, map[string]bosh.Network, string, error) {
+func parseCreateVMInput(input bosh.CreateVMArguments) (string, string, string, map[string]bosh.Network, string, error) {
 	networkSpecs := map[string]bosh.Network{}
-	agentIDInput := extInput[0]
-	var agentID string
-
-	if reflect.TypeOf(agentIDInput) != reflect.TypeOf(agentID) {
-		return "", "", "", networkSpecs, "", fmt.Errorf("agent id has unexpected type: %s. Expecting a string", reflect.TypeOf(agentIDInput))
-	}
-
-	agentID = agentIDInput.(string)
-	if agentID == "" {
-		return "", "", "", networkSpecs, "", errors.New("agent id cannot be empty")
-	}
-
-	stemcellIDInput := extInput[1]
-	var stemcellID string
-
-	if reflect.TypeOf(stemcellIDInput) != reflect.TypeOf(stemcellID) {
-		return "", "", "", networkSpecs, "", fmt.Errorf("stemcell id has unexpected type: %s. Expecting a string", reflect.TypeOf(stemcellIDInput))
-	}
-
-	stemcellID = stemcellIDInput.(string)
-	if stemcellID == "" {
-		return "", "", "", networkSpecs, "", errors.New("stemcell id cannot be empty")
-	}
-
-	cloudPropertiesInput := extInput[2]
-	var cloudProperties map[string]interface{}
-	if reflect.TypeOf(cloudPropertiesInput) != reflect.TypeOf(cloudProperties) {
-		return "", "", "", networkSpecs, "", fmt.Errorf("cloud properties has unexpected type: %s. Expecting a map to interface", reflect.TypeOf(cloudPropertiesInput))
-	}
-
-	cloudProperties = cloudPropertiesInput.(map[string]interface{})
 
 	var encodedPublicKey string
-	if publicKeyInput, keyExist := cloudProperties["public_key"]; keyExist {
+	if publicKeyInput, keyExist := input.CloudProperties["public_key"]; keyExist {
 		if reflect.TypeOf(publicKeyInput) != reflect.TypeOf(encodedPublicKey) {
 			return "", "", "", networkSpecs, "", fmt.Errorf("public key has unexpected type: %s. Expecting a string", reflect.TypeOf(publicKeyInput))
 		}
@@ -65,33 +33,14 @@ func parseCreateVMInput(extInput bosh.MethodArguments) (string, string, string,
 		log.Info("warning: public key is empty. You may not be able to log in to the machine")
 	}
 
-	networkInput := extInput[3]
-	var networks map[string]interface{}
-
-	if reflect.TypeOf(networkInput) != reflect.TypeOf(networks) {
-		return "", "", "", networkSpecs, "", fmt.Errorf("network config has unexpected type in: %s. Expecting a map", reflect.TypeOf(networkInput))
-	}
-
-	networks = networkInput.(map[string]interface{})
-	if len(networks) > 1 {
-		return "", "", "", networkSpecs, "", fmt.Errorf("config error: Only one network supported, provided length: %d", len(networks))
-	}
-
-	b, err := json.Marshal(networks)
-	if err != nil {
-		return "", "", "", networkSpecs, "", errors.New("error marshalling the network")
-	}
-
-	var boshNetworks map[string]bosh.Network
-	err = json.Unmarshal(b, &boshNetworks)
-	if err != nil {
-		return "", "", "", networkSpecs, "", errors.New("error unmarshalling the network")
+	if len(input.Networks) > 1 {
+		return "", "", "", networkSpecs, "", fmt.Errorf("config error: Only one network supported, provided length: %d", len(input.Networks))
 	}
 
 	var boshNetName string
 	var boshNet bosh.Network
 
-	for k, v := range boshNetworks {
+	for k, v := range input.Networks {
 		boshNetName = k
 		boshNet = v
 	}
@@ -111,35 +60,16 @@ func parseCreateVMInput(extInput bosh.MethodArguments) (string, string, string,
 		DNS:         boshNet.DNS,
 	}
 
-	diskInput := extInput[4]
-
-	var disks []interface{}
-
-	if reflect.TypeOf(diskInput) != reflect.TypeOf(disks) {
-		return "", "", "", networkSpecs, "", fmt.Errorf("disk config has unexpected type in: %s. Expecting an array", reflect.TypeOf(diskInput))
-	}
-
-	disks = diskInput.([]interface{})
-	if len(disks) > 1 {
-		return "", "", "", networkSpecs, "", fmt.Errorf("config error: Only one disk supported, provided length: %d", len(disks))
-	}
-
-	d, err := json.Marshal(disks)
-	if err != nil {
-		return "", "", "", networkSpecs, "", errors.New("error marshalling the disks")
-	}
-
-	diskCIDs := []string{}
-	err = json.Unmarshal(d, &diskCIDs)
-	if err != nil {
-		return "", "", "", networkSpecs, "", errors.New("error unmarshalling the disks")
-	}
-
-	if len(diskCIDs) > 0 {
-		return agentID, stemcellID, publicKey, networkSpecs, parseDiskCID(diskCIDs[0]), nil
+	var nodeID string
+	for _, diskCID := range input.DiskCIDs {
+		diskNodeID := parseDiskCID(diskCID)
+		if nodeID != "" && diskNodeID != nodeID {
+			return "", "", "", networkSpecs, "", fmt.Errorf("config error: disks %v are on different nodes", input.DiskCIDs)
+		}
+		nodeID = diskNodeID
 	}
 
-	return agentID, stemcellID, publicKey, networkSpecs, "", nil
+	return input.AgentID, input.StemcellCID, publicKey, networkSpecs, nodeID, nil
 }
 
 func parseDiskCID(diskCID string) string {
@@ -0,0 +1,120 @@
+package cpi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/models"
+)
+
+// persistentDiskDevices maps the local drives that can back a persistent disk to their size in MB.
+// The system disk and the drives of the ephemeral disk are excluded.
+func persistentDiskDevices(blockDevices map[string]models.Device, systemDisk string, ephemeral EphemeralDisk) map[string]int {
+	devices := spareLocalDrives(blockDevices, deviceName(systemDisk))
+
+	delete(devices, deviceName(ephemeral.Path))
+	for _, path := range ephemeral.StripeDevices {
+		delete(devices, deviceName(path))
+	}
+
+	return devices
+}
+
+// selectPersistentDiskDevice picks the smallest device not in used that holds sizeInMB
+func selectPersistentDiskDevice(devices map[string]int, used map[string]bool, sizeInMB int) (string, error) {
+	var selected string
+	var selectedSize int
+	for name, size := range devices {
+		if used[devicePath(name)] || size < sizeInMB {
+			continue
+		}
+		if selected == "" || size < selectedSize || (size == selectedSize && name < selected) {
+			selected = name
+			selectedSize = size
+		}
+	}
+
+	if selected == "" {
+		return "", fmt.Errorf("no free local drive holds %dMB", sizeInMB)
+	}
+
+	return devicePath(selected), nil
+}
+
+// freePregeneratedDisks returns the pregenerated disks whose device does not back a persistent disk yet
+func freePregeneratedDisks(settings models.PersistentDiskSettings) []models.PersistentDisk {
+	used := settings.UsedLocations()
+
+	free := []models.PersistentDisk{}
+	for _, disk := range settings.PregeneratedDisks {
+		if _, claimed := settings.Disk(disk.DiskCID); claimed || used[disk.Location] {
+			continue
+		}
+		free = append(free, disk)
+	}
+
+	return free
+}
+
+// selectPregeneratedDisk picks the smallest free pregenerated disk that holds sizeInMB
+func selectPregeneratedDisk(settings models.PersistentDiskSettings, devices map[string]int, sizeInMB int) (models.PersistentDisk, error) {
+	var selected models.PersistentDisk
+	var selectedSize int
+	for _, disk := range freePregeneratedDisks(settings) {
+		size := devices[deviceName(disk.Location)]
+		if size < sizeInMB {
+			continue
+		}
+		if selected.DiskCID == "" || size < selectedSize {
+			selected = disk
+			selectedSize = size
+		}
+	}
+
+	if selected.DiskCID == "" {
+		return models.PersistentDisk{}, fmt.Errorf("no free local drive holds %dMB", sizeInMB)
+	}
+
+	return selected, nil
+}
+
+// pregeneratePersistentDisks assigns a disk cid to every free device so the agent env can reference disks created later
+func pregeneratePersistentDisks(c config.Cpi, nodeID string, devices map[string]int, driveIDs map[string]string, used map[string]bool) []models.PersistentDisk {
+	names := make([]string, 0, len(devices))
+	for name := range devices {
+		if !used[devicePath(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	disks := make([]models.PersistentDisk, 0, len(names))
+	for _, name := range names {
+		disks = append(disks, models.PersistentDisk{
+			DiskCID:  fmt.Sprintf("%s%s-%s-%s", DiskCIDTagPrefix, nodeID, c.RequestID, name),
+			Location: devicePath(name),
+			DeviceID: driveIDs[name],
+		})
+	}
+
+	return disks
+}
+
+// verifyDiskIdentity checks that the drive at the disk location is still the one recorded for the disk
+func verifyDiskIdentity(disk models.PersistentDisk, driveIDs map[string]string) error {
+	current := driveIDs[deviceName(disk.Location)]
+	if disk.DeviceID != current {
+		return fmt.Errorf("drive at %s is %q, expected %q", disk.Location, current, disk.DeviceID)
+	}
+	return nil
+}
+
+func devicePath(name string) string {
+	return fmt.Sprintf("/dev/%s", name)
+}
+
+func deviceName(path string) string {
+	return strings.TrimPrefix(path, "/dev/")
+}
@@ -0,0 +1,124 @@
+package cpi
+
+import (
+	"encoding/json"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("persistent disks", func() {
+	var blockDevices map[string]models.Device
+
+	BeforeEach(func() {
+		blockDevices = map[string]models.Device{
+			"sda": models.Device{Size: "8388608", Removable: "0"},
+			"sdb": models.Device{Size: "8388608", Removable: "0"},
+			"sdc": models.Device{Size: "4194304", Removable: "0"},
+			"sdd": models.Device{Size: "2097152", Removable: "0"},
+			"sr0": models.Device{Size: "2097151", Removable: "1"},
+		}
+	})
+
+	Describe("persistentDiskDevices", func() {
+		It("excludes the system disk and the ephemeral disk", func() {
+			devices := persistentDiskDevices(blockDevices, "/dev/sda", EphemeralDisk{Path: "/dev/sdd"})
+			Expect(devices).To(Equal(map[string]int{"sdb": 8192, "sdc": 4096}))
+		})
+
+		It("excludes striped ephemeral drives", func() {
+			ephemeral := EphemeralDisk{Path: models.EphemeralStripeDevice, StripeDevices: []string{"/dev/sdc", "/dev/sdd"}}
+			Expect(persistentDiskDevices(blockDevices, "/dev/sda", ephemeral)).To(Equal(map[string]int{"sdb": 8192}))
+		})
+	})
+
+	Describe("selectPersistentDiskDevice", func() {
+		It("picks the smallest free device holding the size", func() {
+			devices := persistentDiskDevices(blockDevices, "/dev/sda", EphemeralDisk{})
+
+			location, err := selectPersistentDiskDevice(devices, map[string]bool{}, 3000)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(location).To(Equal("/dev/sdc"))
+
+			location, err = selectPersistentDiskDevice(devices, map[string]bool{"/dev/sdc": true}, 3000)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(location).To(Equal("/dev/sdb"))
+		})
+
+		It("returns an error when no free device holds the size", func() {
+			devices := persistentDiskDevices(blockDevices, "/dev/sda", EphemeralDisk{})
+
+			_, err := selectPersistentDiskDevice(devices, map[string]bool{"/dev/sdb": true}, 5000)
+			Expect(err).To(MatchError("no free local drive holds 5000MB"))
+		})
+	})
+
+	Describe("pregeneratePersistentDisks", func() {
+		It("assigns a disk cid to every free device", func() {
+			c := config.Cpi{RequestID: "request"}
+			devices := persistentDiskDevices(blockDevices, "/dev/sda", EphemeralDisk{Path: "/dev/sdd"})
+
+			driveIDs := map[string]string{"sdc": "/dev/disk/by-id/wwn-0x5000c500a1b2c3c4"}
+
+			disks := pregeneratePersistentDisks(c, "nodeid", devices, driveIDs, map[string]bool{"/dev/sdb": true})
+			Expect(disks).To(Equal([]models.PersistentDisk{
+				{DiskCID: "disk_cid-nodeid-request-sdc", Location: "/dev/sdc", DeviceID: "/dev/disk/by-id/wwn-0x5000c500a1b2c3c4"},
+			}))
+		})
+	})
+
+	Describe("verifyDiskIdentity", func() {
+		It("accepts a drive that still has the recorded identity", func() {
+			disk := models.PersistentDisk{Location: "/dev/sdc", DeviceID: "/dev/disk/by-id/wwn-0x5000c500a1b2c3c4"}
+			Expect(verifyDiskIdentity(disk, map[string]string{"sdc": "/dev/disk/by-id/wwn-0x5000c500a1b2c3c4"})).To(Succeed())
+		})
+
+		It("rejects a drive whose identity changed", func() {
+			disk := models.PersistentDisk{Location: "/dev/sdc", DeviceID: "/dev/disk/by-id/wwn-0x5000c500a1b2c3c4"}
+			err := verifyDiskIdentity(disk, map[string]string{"sdc": "/dev/disk/by-id/wwn-0x5000c500a1b2c3b1"})
+			Expect(err).To(MatchError(`drive at /dev/sdc is "/dev/disk/by-id/wwn-0x5000c500a1b2c3b1", expected "/dev/disk/by-id/wwn-0x5000c500a1b2c3c4"`))
+		})
+	})
+
+	Describe("selectPregeneratedDisk", func() {
+		It("skips pregenerated disks whose device is already claimed", func() {
+			settings := models.PersistentDiskSettings{
+				PregeneratedDisks: []models.PersistentDisk{
+					{DiskCID: "disk_cid-nodeid-request-sdb", Location: "/dev/sdb"},
+					{DiskCID: "disk_cid-nodeid-request-sdc", Location: "/dev/sdc"},
+				},
+				Disks: []models.PersistentDisk{
+					{DiskCID: "disk_cid-nodeid-other", Location: "/dev/sdc", SizeInMB: 100},
+				},
+			}
+
+			disk, err := selectPregeneratedDisk(settings, spareLocalDrives(blockDevices), 1000)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(disk.DiskCID).To(Equal("disk_cid-nodeid-request-sdb"))
+
+			_, err = selectPregeneratedDisk(settings, spareLocalDrives(blockDevices), 10000)
+			Expect(err).To(MatchError("no free local drive holds 10000MB"))
+		})
+	})
+
+	Describe("reading persistent disk settings written by earlier releases", func() {
+		It("converts the single disk layout into a list of disks", func() {
+			var settings models.PersistentDiskSettings
+			err := json.Unmarshal([]byte(`{
+				"pregenerated_disk_cid": "disk_cid-nodeid-pregenerated",
+				"disk_cid": "disk_cid-nodeid-request",
+				"location": "/dev/sdb",
+				"attached": true
+			}`), &settings)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(settings).To(Equal(models.PersistentDiskSettings{
+				PregeneratedDisks: []models.PersistentDisk{{DiskCID: "disk_cid-nodeid-pregenerated", Location: "/dev/sdb"}},
+				Disks:             []models.PersistentDisk{{DiskCID: "disk_cid-nodeid-request", Location: "/dev/sdb", IsAttached: true}},
+			}))
+		})
+	})
+})
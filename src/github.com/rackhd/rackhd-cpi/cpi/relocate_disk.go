@@ -0,0 +1,316 @@
+package cpi
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/models"
+	"github.com/rackhd/rackhd-cpi/rackhdapi"
+	"github.com/rackhd/rackhd-cpi/workflows"
+)
+
+// RelocateDisk copies a detached persistent disk to a free node and moves its disk cid there.
+// The target is reserved from the free nodes with a drive large enough unless targetNodeID names one.
+func RelocateDisk(c config.Cpi, diskCID string, targetNodeID string) (string, error) {
+	return relocateDisks(c, []string{diskCID}, targetNodeID)
+}
+
+// nodeForDisks returns the node holding diskCIDs. When an operator blocked that node,
+// the disks are first relocated to a free node, which is returned instead.
+func nodeForDisks(c config.Cpi, diskCIDs []string) (string, error) {
+	node, err := rackhdapi.GetNodeByTag(c, diskCIDs[0])
+	if err != nil {
+		return "", err
+	}
+
+	for _, diskCID := range diskCIDs[1:] {
+		if !hasTag(node.Tags, diskCID) {
+			return "", fmt.Errorf("config error: disks %v are on different nodes", diskCIDs)
+		}
+	}
+
+	if !hasTag(node.Tags, models.Blocked) {
+		return node.ID, nil
+	}
+
+	log.Info(fmt.Sprintf("node %s holding disks %v is blocked, relocating them", node.ID, diskCIDs))
+	return relocateDisks(c, diskCIDs, "")
+}
+
+type relocation struct {
+	source   models.TagNode
+	disk     models.PersistentDisk
+	capacity int
+}
+
+// relocateDisks copies every disk of diskCIDs to one target node before releasing any of them on their
+// source, so a failed copy leaves all the disks where they were and the target back in the pool
+func relocateDisks(c config.Cpi, diskCIDs []string, targetNodeID string) (string, error) {
+	var relocations []relocation
+	capacity := 0
+	for _, diskCID := range diskCIDs {
+		source, disk, diskCapacity, err := relocatableDisk(c, diskCID)
+		if err != nil {
+			return "", err
+		}
+		relocations = append(relocations, relocation{source: source, disk: disk, capacity: diskCapacity})
+		if diskCapacity > capacity {
+			capacity = diskCapacity
+		}
+	}
+
+	if targetNodeID != "" {
+		tags, err := rackhdapi.GetTags(c, targetNodeID)
+		if err != nil {
+			return "", err
+		}
+		if hasTag(tags, models.Unavailable) || hasTag(tags, models.Blocked) {
+			return "", fmt.Errorf("error relocating disk %s: node %s is not free", strings.Join(diskCIDs, ", "), targetNodeID)
+		}
+	}
+
+	filter := Filter{
+		data:   capacity,
+		method: FilterBasedOnSizeMethod,
+	}
+	targetNodeID, err := TryReservationWithFilter(c, targetNodeID, filter, SelectNodeFromRackHD, ReserveNodeFromRackHD)
+	if err != nil {
+		return "", err
+	}
+
+	for i, r := range relocations {
+		err = moveDisk(c, r.source, r.disk, r.capacity, targetNodeID)
+		if err != nil {
+			abandonRelocation(c, targetNodeID, relocations[:i+1])
+			return "", err
+		}
+	}
+
+	for _, r := range relocations {
+		// releasing a disk changes the tags and settings of its source, so they are read again
+		source, err := rackhdapi.GetTagNode(c, r.source.ID)
+		if err != nil {
+			return "", fmt.Errorf("disk %s now lives on node %s: %s", r.disk.DiskCID, targetNodeID, err)
+		}
+
+		err = releaseRelocatedDisk(c, source, r.disk)
+		if err != nil {
+			return "", fmt.Errorf("disk %s now lives on node %s: %s", r.disk.DiskCID, targetNodeID, err)
+		}
+	}
+
+	return targetNodeID, nil
+}
+
+// abandonRelocation drops the disks of relocations from the target node and returns it to the pool.
+// The sources still hold the disks, so the copies on the target are simply forgotten.
+func abandonRelocation(c config.Cpi, targetNodeID string, relocations []relocation) {
+	target, err := rackhdapi.GetTagNode(c, targetNodeID)
+	if err != nil {
+		log.Error(fmt.Sprintf("error getting node %s after failed relocation: %s", targetNodeID, err))
+	} else {
+		settings := target.PersistentDisk
+		for _, r := range relocations {
+			settings = settings.WithoutDisk(r.disk.DiskCID)
+			if hasTag(target.Tags, r.disk.DiskCID) {
+				err = rackhdapi.DeleteTag(c, targetNodeID, r.disk.DiskCID)
+				if err != nil {
+					log.Error(fmt.Sprintf("error deleting disk cid %s from node %s after failed relocation: %s", r.disk.DiskCID, targetNodeID, err))
+				}
+			}
+		}
+
+		err = rackhdapi.PatchPersistentDiskSettings(c, targetNodeID, settings)
+		if err != nil {
+			log.Error(fmt.Sprintf("error deleting disk metadata from node %s after failed relocation: %s", targetNodeID, err))
+		}
+	}
+
+	err = rackhdapi.ReleaseNode(c, targetNodeID)
+	if err != nil {
+		log.Error(fmt.Sprintf("error releasing node %s after failed relocation: %s", targetNodeID, err))
+	}
+}
+
+// relocatableDisk returns the node holding diskCID, the disk and the capacity in MB of its drive.
+// Only a detached disk on a node without VM can be relocated, since copying reboots the node.
+func relocatableDisk(c config.Cpi, diskCID string) (models.TagNode, models.PersistentDisk, int, error) {
+	node, err := rackhdapi.GetNodeByTag(c, diskCID)
+	if err != nil {
+		return models.TagNode{}, models.PersistentDisk{}, 0, err
+	}
+
+	disk, found := node.PersistentDisk.Disk(diskCID)
+	if !found {
+		return models.TagNode{}, models.PersistentDisk{}, 0, fmt.Errorf("disk: %s has no persistent disk settings on node %s", diskCID, node.ID)
+	}
+	if disk.IsAttached {
+		return models.TagNode{}, models.PersistentDisk{}, 0, fmt.Errorf("disk: %s is attached", diskCID)
+	}
+
+	for _, tag := range node.Tags {
+		if strings.HasPrefix(tag, VMCIDTagPrefix) {
+			return models.TagNode{}, models.PersistentDisk{}, 0, fmt.Errorf("disk: %s is on node %s which still has VM %s, delete the VM before relocating the disk", diskCID, node.ID, tag)
+		}
+	}
+
+	catalog, err := rackhdapi.GetNodeCatalog(c, node.ID)
+	if err != nil {
+		return models.TagNode{}, models.PersistentDisk{}, 0, fmt.Errorf("error getting catalog of node: %s", node.ID)
+	}
+
+	capacity, found := spareLocalDrives(catalog.Data.BlockDevices)[deviceName(disk.Location)]
+	if !found {
+		return models.TagNode{}, models.PersistentDisk{}, 0, fmt.Errorf("disk: %s is on %s which is not a local drive of node %s", diskCID, disk.Location, node.ID)
+	}
+
+	return node, disk, capacity, nil
+}
+
+// moveDisk streams the drive of disk on source to a free drive of the reserved target node holding capacity,
+// then records the disk cid, its settings and metadata on the target
+func moveDisk(c config.Cpi, source models.TagNode, disk models.PersistentDisk, capacity int, targetNodeID string) error {
+	target, err := rackhdapi.GetNodeByTag(c, targetNodeID)
+	if err != nil {
+		return err
+	}
+
+	catalog, err := rackhdapi.GetNodeCatalog(c, target.ID)
+	if err != nil {
+		return fmt.Errorf("error getting catalog of node: %s", target.ID)
+	}
+
+	devices := persistentDiskDevices(catalog.Data.BlockDevices, defaultSystemDisk(catalog.Data.BlockDevices), EphemeralDisk{})
+	location, err := selectPersistentDiskDevice(devices, target.PersistentDisk.UsedLocations(), capacity)
+	if err != nil {
+		return fmt.Errorf("error relocating disk %s to node %s: %v", disk.DiskCID, target.ID, err)
+	}
+
+	driveIDs, err := rackhdapi.GetNodeDriveIDs(c, target.ID)
+	if err != nil {
+		return fmt.Errorf("error relocating disk %s: %v", disk.DiskCID, err)
+	}
+
+	// a stale address would let the sender stream to a microkernel that is gone, and a stale
+	// checksum would vouch for a copy that never completed
+	err = rackhdapi.PatchNode(c, target.ID, []byte(fmt.Sprintf(`{"%s": "", "%s": ""}`, workflows.RelocationAddressKey, workflows.RelocationSha256Key)))
+	if err != nil {
+		return fmt.Errorf("error clearing relocation address of node %s: %s", target.ID, err)
+	}
+
+	err = rackhdapi.PatchNode(c, source.ID, []byte(fmt.Sprintf(`{"%s": ""}`, workflows.RelocationSha256Key)))
+	if err != nil {
+		return fmt.Errorf("error clearing relocation checksum of node %s: %s", source.ID, err)
+	}
+
+	sendWorkflowName, receiveWorkflowName, err := workflows.PublishRelocateDiskWorkflows(c)
+	if err != nil {
+		return fmt.Errorf("error publishing relocate disk workflows: %s", err)
+	}
+
+	log.Info(fmt.Sprintf("copying disk %s from %s on node %s to %s on node %s", disk.DiskCID, disk.Location, source.ID, location, target.ID))
+	errs := make(chan error, 2)
+	go func() {
+		errs <- workflows.RunReceiveDiskWorkflow(c, target.ID, receiveWorkflowName, location)
+	}()
+	go func() {
+		errs <- workflows.RunSendDiskWorkflow(c, source.ID, sendWorkflowName, disk.Location, target.ID)
+	}()
+
+	var copyErrs []string
+	for i := 0; i < 2; i++ {
+		err = <-errs
+		if err != nil {
+			copyErrs = append(copyErrs, err.Error())
+		}
+	}
+	if len(copyErrs) > 0 {
+		return fmt.Errorf("error copying disk %s: %s", disk.DiskCID, strings.Join(copyErrs, "; "))
+	}
+
+	err = verifyCopy(c, source.ID, target.ID)
+	if err != nil {
+		return fmt.Errorf("error copying disk %s: %s", disk.DiskCID, err)
+	}
+
+	relocated := disk
+	relocated.Location = location
+	relocated.DeviceID = driveIDs[deviceName(location)]
+	relocated.IsAttached = false
+
+	err = rackhdapi.PatchPersistentDiskSettings(c, target.ID, target.PersistentDisk.WithDisk(relocated))
+	if err != nil {
+		return fmt.Errorf("error relocating disk metadata %s: %s", disk.DiskCID, err)
+	}
+
+	err = rackhdapi.CreateTag(c, target.ID, disk.DiskCID)
+	if err != nil {
+		return fmt.Errorf("error tagging node %s with disk cid %s: %s", target.ID, disk.DiskCID, err)
+	}
+
+	return nil
+}
+
+// verifyCopy compares the SHA-256 of the stream the source node sent with the one the target node received
+func verifyCopy(c config.Cpi, sourceNodeID string, targetNodeID string) error {
+	source, err := rackhdapi.GetTagNode(c, sourceNodeID)
+	if err != nil {
+		return err
+	}
+
+	target, err := rackhdapi.GetTagNode(c, targetNodeID)
+	if err != nil {
+		return err
+	}
+
+	if source.RelocationSha256 == "" || source.RelocationSha256 != target.RelocationSha256 {
+		return fmt.Errorf("node %s sent SHA-256 %q but node %s received %q", sourceNodeID, source.RelocationSha256, targetNodeID, target.RelocationSha256)
+	}
+	return nil
+}
+
+// releaseRelocatedDisk drops disk from the source node, wiping its drive with the configured policy,
+// and releases the node once it holds no other disk
+func releaseRelocatedDisk(c config.Cpi, source models.TagNode, disk models.PersistentDisk) error {
+	if c.DiskWipePolicy != config.DiskWipeNone {
+		err := wipeDrives(c, source.ID, []string{disk.AgentPath()})
+		if err != nil {
+			return fmt.Errorf("error wiping relocated disk %s on node %s: %s", disk.DiskCID, source.ID, err)
+		}
+	}
+
+	otherDisks := false
+	for _, tag := range source.Tags {
+		if strings.HasPrefix(tag, DiskCIDTagPrefix) && tag != disk.DiskCID {
+			otherDisks = true
+		}
+	}
+
+	settings := source.PersistentDisk.WithoutDisk(disk.DiskCID)
+	if !otherDisks {
+		settings = settings.WithoutPregeneratedDisks()
+	}
+
+	err := rackhdapi.PatchPersistentDiskSettings(c, source.ID, settings)
+	if err != nil {
+		return fmt.Errorf("error deleting disk metadata %s from node %s: %s", disk.DiskCID, source.ID, err)
+	}
+
+	err = rackhdapi.DeleteTag(c, source.ID, disk.DiskCID)
+	if err != nil {
+		return fmt.Errorf("error deleting disk cid tag %s from node %s: %s", disk.DiskCID, source.ID, err)
+	}
+
+	if otherDisks {
+		return nil
+	}
+
+	err = rackhdapi.ReleaseNode(c, source.ID)
+	if err != nil {
+		return fmt.Errorf("error releasing node %s after relocating disk %s: %v", source.ID, disk.DiskCID, err)
+	}
+	return nil
+}
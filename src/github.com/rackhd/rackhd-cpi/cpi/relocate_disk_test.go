@@ -0,0 +1,218 @@
+package cpi_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/rackhd/rackhd-cpi/bosh"
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/cpi"
+	"github.com/rackhd/rackhd-cpi/helpers"
+	"github.com/rackhd/rackhd-cpi/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("RelocateDisk", func() {
+	var server *ghttp.Server
+	var cpiConfig config.Cpi
+	diskCID := "disk_cid-fake_uuid"
+
+	BeforeEach(func() {
+		server, _, cpiConfig, _ = helpers.SetUp(bosh.CREATE_DISK)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("refuses to relocate an attached disk", func() {
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", diskCID)),
+				ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_disk_attached.json")),
+			),
+		)
+
+		_, err := cpi.RelocateDisk(cpiConfig, diskCID, "")
+		Expect(err).To(MatchError("disk: disk_cid-fake_uuid is attached"))
+		Expect(len(server.ReceivedRequests())).To(Equal(1))
+	})
+
+	It("refuses to relocate a disk from a node that still has a VM", func() {
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", diskCID)),
+				ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_disk_detached.json")),
+			),
+		)
+
+		_, err := cpi.RelocateDisk(cpiConfig, diskCID, "")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("delete the VM before relocating the disk"))
+		Expect(len(server.ReceivedRequests())).To(Equal(1))
+	})
+
+	It("refuses to relocate a disk to a node that is not free", func() {
+		nodeID := helpers.LoadTagNodes("../spec_assets/tag_nodes_with_disk_detached.json")[0].ID
+		targetNodeID := "5665a65a0561790005b77b85"
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", diskCID)),
+				ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/tag_nodes_with_disk_detached.json")),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/ohai", nodeID)),
+				ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_node_catalog_response.json")),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/tags", targetNodeID)),
+				ghttp.RespondWith(http.StatusOK, []byte(`["unavailable", "vm_cid-other"]`)),
+			),
+		)
+
+		_, err := cpi.RelocateDisk(cpiConfig, diskCID, targetNodeID)
+		Expect(err).To(MatchError(fmt.Sprintf("error relocating disk %s: node %s is not free", diskCID, targetNodeID)))
+		Expect(len(server.ReceivedRequests())).To(Equal(3))
+	})
+
+	Describe("copying the disk to the target node", func() {
+		sourceID := "node-1"
+		targetID := "node-2"
+		otherDiskCID := "disk_cid-other_uuid"
+		var sourceTagNode string
+		var sha256s map[string]string
+		var corruptSecondCopy bool
+		var ranWorkflows map[string][]string
+		var createdTags map[string][]string
+		var deletedTags []string
+
+		BeforeEach(func() {
+			server.AllowUnhandledRequests = true
+			server.UnhandledRequestStatusCode = http.StatusNotFound
+			cpiConfig.RequestID = "requestid"
+			cpiConfig.DiskWipePolicy = config.DiskWipeQuick
+			sourceTagNode = fmt.Sprintf(`[{"id": "%s", "tags": ["unavailable", "%s"], "persistent_disk": {"disks": [{"disk_cid": "%s", "location": "/dev/sdb", "attached": false}]}}]`, sourceID, diskCID, diskCID)
+			sha256s = map[string]string{}
+			corruptSecondCopy = false
+			ranWorkflows = map[string][]string{}
+			createdTags = map[string][]string{}
+			deletedTags = []string{}
+
+			nodePath := regexp.MustCompile(`^/api/2.0/nodes/(node-[12])`)
+			nodeOf := func(r *http.Request) string {
+				return nodePath.FindStringSubmatch(r.URL.Path)[1]
+			}
+			workflowResponse := fmt.Sprintf(`{"instanceId": "%s", "status": "succeeded"}`, cpiConfig.RequestID)
+
+			server.RouteToHandler("GET", regexp.MustCompile(fmt.Sprintf("^/api/2.0/tags/(%s|%s)/nodes$", diskCID, otherDiskCID)), func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(sourceTagNode))
+			})
+			server.RouteToHandler("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", targetID), ghttp.RespondWith(http.StatusOK, fmt.Sprintf(`[{"id": "%s", "tags": ["unavailable", "%s"], "persistent_disk": {}}]`, targetID, targetID)))
+			server.RouteToHandler("GET", "/api/2.0/tags/unavailable/nodes", ghttp.RespondWith(http.StatusOK, "[]"))
+			server.RouteToHandler("GET", "/api/2.0/tags/blocked/nodes", ghttp.RespondWith(http.StatusOK, "[]"))
+			server.RouteToHandler("GET", "/api/2.0/nodes", ghttp.RespondWith(http.StatusOK, fmt.Sprintf(`[{"id": "%s", "obms": [{"service": "ipmi-obm-service"}]}]`, targetID)))
+			server.RouteToHandler("GET", regexp.MustCompile(`^/api/2.0/nodes/node-[12]$`), func(w http.ResponseWriter, r *http.Request) {
+				id := nodeOf(r)
+				sha := sha256s[id]
+				if id == targetID && corruptSecondCopy && len(ranWorkflows[targetID]) > 2 {
+					sha = "truncated"
+				}
+				w.Write([]byte(fmt.Sprintf(`{"id": "%s", "obms": [{"service": "ipmi-obm-service"}], "tags": "/api/2.0/nodes/%s/tags", "relocation_sha256": "%s"}`, id, id, sha)))
+			})
+			server.RouteToHandler("GET", regexp.MustCompile(`^/api/2.0/nodes/node-[12]/tags$`), func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(append([]string{}, createdTags[nodeOf(r)]...))
+			})
+			server.RouteToHandler("GET", regexp.MustCompile(`^/api/2.0/nodes/node-[12]/workflows$`), ghttp.RespondWith(http.StatusOK, "[]"))
+			server.RouteToHandler("GET", regexp.MustCompile(`^/api/2.0/nodes/node-[12]/catalogs/ohai$`), ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_node_catalog_response.json")))
+			server.RouteToHandler("GET", regexp.MustCompile(`^/api/2.0/nodes/node-[12]/catalogs/driveId$`), ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_drive_id_catalog_response.json")))
+			server.RouteToHandler("PATCH", regexp.MustCompile(`^/api/2.0/nodes/node-[12]$`), ghttp.RespondWith(http.StatusOK, nil))
+			server.RouteToHandler("PATCH", regexp.MustCompile(`^/api/2.0/nodes/node-[12]/tags$`), func(w http.ResponseWriter, r *http.Request) {
+				var body models.Tags
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				createdTags[nodeOf(r)] = append(createdTags[nodeOf(r)], body.T...)
+			})
+			server.RouteToHandler("DELETE", regexp.MustCompile(`^/api/2.0/nodes/node-[12]/tags/`), func(w http.ResponseWriter, r *http.Request) {
+				deletedTags = append(deletedTags, nodeOf(r)+" "+r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+				w.WriteHeader(http.StatusNoContent)
+			})
+			server.RouteToHandler("PUT", regexp.MustCompile(`^/api/2.0/workflows/(tasks|graphs)$`), func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				Expect(err).ToNot(HaveOccurred())
+				w.WriteHeader(http.StatusCreated)
+				w.Write(body)
+			})
+			server.RouteToHandler("GET", regexp.MustCompile(`^/api/2.0/workflows/(tasks|graphs)/`), func(w http.ResponseWriter, r *http.Request) {
+				name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+				w.Write([]byte(fmt.Sprintf(`[{"injectableName": "%s"}]`, name)))
+			})
+			server.RouteToHandler("POST", regexp.MustCompile(`^/api/2.0/nodes/node-[12]/workflows$`), func(w http.ResponseWriter, r *http.Request) {
+				var body models.RunWorkflowRequestBody
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				id := nodeOf(r)
+				ranWorkflows[id] = append(ranWorkflows[id], body.Name)
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(workflowResponse))
+			})
+			server.RouteToHandler("GET", "/api/2.0/workflows/"+cpiConfig.RequestID, ghttp.RespondWith(http.StatusOK, workflowResponse))
+		})
+
+		ranWipe := func(nodeID string) bool {
+			for _, name := range ranWorkflows[nodeID] {
+				if strings.Contains(name, "Wipe") {
+					return true
+				}
+			}
+			return false
+		}
+
+		It("moves the disk cid and wipes the source once both nodes report the same SHA-256", func() {
+			sha256s[sourceID] = "abc"
+			sha256s[targetID] = "abc"
+
+			nodeID, err := cpi.RelocateDisk(cpiConfig, diskCID, targetID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nodeID).To(Equal(targetID))
+			Expect(ranWipe(sourceID)).To(BeTrue())
+			Expect(deletedTags).To(ContainElement(sourceID + " " + diskCID))
+		})
+
+		It("keeps the source drive and releases the target when the copy is incomplete", func() {
+			sha256s[sourceID] = "abc"
+			sha256s[targetID] = "abd"
+
+			_, err := cpi.RelocateDisk(cpiConfig, diskCID, targetID)
+			Expect(err).To(MatchError(fmt.Sprintf(`error copying disk %s: node %s sent SHA-256 "abc" but node %s received "abd"`, diskCID, sourceID, targetID)))
+			Expect(ranWipe(sourceID)).To(BeFalse())
+			Expect(deletedTags).To(Equal([]string{targetID + " " + models.Unavailable}))
+		})
+
+		It("moves no disk of a blocked node when one of them fails to copy", func() {
+			sourceTagNode = fmt.Sprintf(`[{"id": "%s", "tags": ["unavailable", "blocked", "%s", "%s"], "persistent_disk": {"disks": [{"disk_cid": "%s", "location": "/dev/sdb", "attached": false}, {"disk_cid": "%s", "location": "/dev/sdb", "attached": false}]}}]`, sourceID, diskCID, otherDiskCID, diskCID, otherDiskCID)
+			sha256s[sourceID] = "abc"
+			sha256s[targetID] = "abc"
+			corruptSecondCopy = true
+
+			var input bosh.CreateVMArguments
+			err := json.Unmarshal([]byte(fmt.Sprintf(`["agent-1", "stemcell-1", {}, {"private": {"type": "dynamic", "default": ["dns", "gateway"]}}, ["%s", "%s"], {}]`, diskCID, otherDiskCID)), &input)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = cpi.CreateVM(cpiConfig, input)
+			Expect(err).To(MatchError(fmt.Sprintf(`error copying disk %s: node %s sent SHA-256 "abc" but node %s received "truncated"`, otherDiskCID, sourceID, targetID)))
+			Expect(ranWipe(sourceID)).To(BeFalse())
+			Expect(deletedTags).To(Equal([]string{targetID + " " + diskCID, targetID + " " + models.Unavailable}))
+		})
+
+		It("refuses a copy the source node never vouched for", func() {
+			_, err := cpi.RelocateDisk(cpiConfig, diskCID, targetID)
+			Expect(err).To(HaveOccurred())
+			Expect(ranWipe(sourceID)).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,56 @@
+package cpi
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rackhd/rackhd-cpi/bosh"
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/rackhdapi"
+)
+
+// ResizeDisk grows a persistent disk in place up to the capacity of the local drive backing it
+func ResizeDisk(c config.Cpi, input bosh.ResizeDiskArguments) error {
+	diskCID := input.DiskCID
+
+	node, err := rackhdapi.GetNodeByTag(c, diskCID)
+	if err != nil {
+		return err
+	}
+
+	disk, found := node.PersistentDisk.Disk(diskCID)
+	if !found {
+		return fmt.Errorf("disk: %s has no persistent disk settings on node %s", diskCID, node.ID)
+	}
+
+	if input.NewSizeInMB == disk.SizeInMB {
+		return nil
+	}
+	if input.NewSizeInMB < disk.SizeInMB {
+		return bosh.NewNotSupportedError("disk: %s cannot shrink from %dMB to %dMB", diskCID, disk.SizeInMB, input.NewSizeInMB)
+	}
+
+	catalog, err := rackhdapi.GetNodeCatalog(c, node.ID)
+	if err != nil {
+		return fmt.Errorf("error getting catalog of node: %s", node.ID)
+	}
+
+	capacity, found := spareLocalDrives(catalog.Data.BlockDevices)[deviceName(disk.Location)]
+	if !found {
+		return fmt.Errorf("disk: %s is on %s which is not a local drive of node %s", diskCID, disk.Location, node.ID)
+	}
+	if capacity < input.NewSizeInMB {
+		return bosh.NewNotSupportedError("disk: %s cannot grow to %dMB, %s holds %dMB", diskCID, input.NewSizeInMB, disk.Location, capacity)
+	}
+
+	log.Info(fmt.Sprintf("resizing disk %s on %s from %dMB to %dMB", diskCID, disk.Location, disk.SizeInMB, input.NewSizeInMB))
+	disk.SizeInMB = input.NewSizeInMB
+
+	err = rackhdapi.PatchPersistentDiskSettings(c, node.ID, node.PersistentDisk.WithDisk(disk))
+	if err != nil {
+		return fmt.Errorf("error updating size of disk %s: %s", diskCID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package cpi_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/rackhd/rackhd-cpi/bosh"
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/cpi"
+	"github.com/rackhd/rackhd-cpi/helpers"
+	"github.com/rackhd/rackhd-cpi/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("ResizeDisk", func() {
+	var server *ghttp.Server
+	var cpiConfig config.Cpi
+	var nodes []models.TagNode
+	var nodeID string
+	diskCID := "disk_cid-fake_uuid"
+
+	BeforeEach(func() {
+		server, _, cpiConfig, _ = helpers.SetUp(bosh.RESIZE_DISK)
+		nodes = helpers.LoadTagNodes("../spec_assets/tag_nodes_with_vm_disk_detached.json")
+		nodeID = nodes[0].ID
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", diskCID)),
+				ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_disk_detached.json")),
+			),
+		)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when the drive holds the new size", func() {
+		It("records the new size of the disk", func() {
+			disk, found := nodes[0].PersistentDisk.Disk(diskCID)
+			Expect(found).To(BeTrue())
+			disk.SizeInMB = 8192
+			expectedBody, err := json.Marshal(models.PersistentDiskSettingsContainer{
+				PersistentDisk: nodes[0].PersistentDisk.WithDisk(disk),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/ohai", nodeID)),
+					ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_node_catalog_response.json")),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/"+nodeID),
+					ghttp.VerifyJSON(string(expectedBody)),
+				),
+			)
+
+			err = cpi.ResizeDisk(cpiConfig, bosh.ResizeDiskArguments{DiskCID: diskCID, NewSizeInMB: 8192})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(server.ReceivedRequests())).To(Equal(3))
+		})
+	})
+
+	Context("when the drive is too small", func() {
+		It("returns a NotSupported error", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/ohai", nodeID)),
+					ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_node_catalog_response.json")),
+				),
+			)
+
+			err := cpi.ResizeDisk(cpiConfig, bosh.ResizeDiskArguments{DiskCID: diskCID, NewSizeInMB: 20000})
+			Expect(err).To(BeAssignableToTypeOf(bosh.NotSupportedError{}))
+			Expect(err).To(MatchError("disk: disk_cid-fake_uuid cannot grow to 20000MB, /dev/sdb holds 16384MB"))
+			Expect(len(server.ReceivedRequests())).To(Equal(2))
+		})
+	})
+
+	Context("when asked to shrink the disk", func() {
+		It("returns a NotSupported error", func() {
+			err := cpi.ResizeDisk(cpiConfig, bosh.ResizeDiskArguments{DiskCID: diskCID, NewSizeInMB: 1024})
+			Expect(err).To(BeAssignableToTypeOf(bosh.NotSupportedError{}))
+			Expect(len(server.ReceivedRequests())).To(Equal(1))
+		})
+	})
+
+	Context("when the size does not change", func() {
+		It("does nothing", func() {
+			err := cpi.ResizeDisk(cpiConfig, bosh.ResizeDiskArguments{DiskCID: diskCID, NewSizeInMB: 2500})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(server.ReceivedRequests())).To(Equal(1))
+		})
+	})
+})
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
-	"strconv"
 	"strings"
 	"time"
 
@@ -97,16 +96,13 @@ func (f Filter) FilterBasedOnSize(c config.Cpi, node models.TagNode) (bool, erro
 		return false, fmt.Errorf("error getting catalog of VM: %s", node.ID)
 	}
 
-	persistentDiskSize := catalog.Data.BlockDevices[models.PersistentDiskLocation].Size
-	if persistentDiskSize == "" {
-		return false, fmt.Errorf("error creating disk for node %s: no disk found at %s", node.ID, models.PersistentDiskLocation)
-	}
-	availableSpaceInKB, err := strconv.Atoi(persistentDiskSize)
-	if err != nil {
-		return false, fmt.Errorf("error creating disk for node %s: %v", node.ID, err)
+	devices := persistentDiskDevices(catalog.Data.BlockDevices, defaultSystemDisk(catalog.Data.BlockDevices), EphemeralDisk{})
+	if len(devices) == 0 {
+		return false, fmt.Errorf("error creating disk for node %s: no local drive found for a persistent disk", node.ID)
 	}
 
-	if availableSpaceInKB < size*1024 {
+	_, err = selectPersistentDiskDevice(devices, node.PersistentDisk.UsedLocations(), size)
+	if err != nil {
 		return false, fmt.Errorf("error creating disk with size %vMB for node %s: insufficient available disk space", size, node.ID)
 	}
 
@@ -0,0 +1,37 @@
+package cpi
+
+import (
+	"fmt"
+
+	"github.com/rackhd/rackhd-cpi/bosh"
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/rackhdapi"
+)
+
+// SetDiskMetadata merges the director metadata into the persistent disk entry stored on the node
+func SetDiskMetadata(c config.Cpi, input bosh.SetDiskMetadataArguments) error {
+	diskCID := input.DiskCID
+
+	err := validateMetadata(input.Metadata)
+	if err != nil {
+		return fmt.Errorf("Cannot set disk metadata: %s", err)
+	}
+
+	node, err := rackhdapi.GetNodeByTag(c, diskCID)
+	if err != nil {
+		return err
+	}
+
+	disk, found := node.PersistentDisk.Disk(diskCID)
+	if !found {
+		return fmt.Errorf("disk: %s has no persistent disk settings on node %s", diskCID, node.ID)
+	}
+	disk.Metadata = mergeMetadata(disk.Metadata, input.Metadata)
+
+	err = rackhdapi.PatchPersistentDiskSettings(c, node.ID, node.PersistentDisk.WithDisk(disk))
+	if err != nil {
+		return fmt.Errorf("error setting metadata of disk %s: %s", diskCID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package cpi_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/rackhd/rackhd-cpi/bosh"
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/cpi"
+	"github.com/rackhd/rackhd-cpi/helpers"
+	"github.com/rackhd/rackhd-cpi/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("SetDiskMetadata", func() {
+	var server *ghttp.Server
+	var cpiConfig config.Cpi
+	diskCID := "disk_cid-fake_uuid_sdc"
+
+	BeforeEach(func() {
+		server, _, cpiConfig, _ = helpers.SetUp(bosh.SET_DISK_METADATA)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("merges the metadata into the disk entry on the node", func() {
+		nodes := helpers.LoadTagNodes("../spec_assets/tag_nodes_with_vm_multiple_disks.json")
+		disk, found := nodes[0].PersistentDisk.Disk(diskCID)
+		Expect(found).To(BeTrue())
+		disk.Metadata = map[string]interface{}{"director": "bosh", "index": float64(0)}
+		nodes[0].PersistentDisk = nodes[0].PersistentDisk.WithDisk(disk)
+		nodesBytes, err := json.Marshal(nodes)
+		Expect(err).ToNot(HaveOccurred())
+
+		var nodeByTag []models.TagNode
+		err = json.Unmarshal(nodesBytes, &nodeByTag)
+		Expect(err).ToNot(HaveOccurred())
+		storedDisk, _ := nodeByTag[0].PersistentDisk.Disk(diskCID)
+		Expect(storedDisk.Metadata).To(HaveKeyWithValue("director", "bosh"))
+
+		disk.Metadata = map[string]interface{}{"director": "bosh", "index": float64(1), "deployment": "cf"}
+		expectedBody, err := json.Marshal(models.PersistentDiskSettingsContainer{
+			PersistentDisk: nodes[0].PersistentDisk.WithDisk(disk),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/tags/%s/nodes", diskCID)),
+				ghttp.RespondWith(http.StatusOK, nodesBytes),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/"+nodes[0].ID),
+				ghttp.VerifyJSON(string(expectedBody)),
+			),
+		)
+
+		input := bosh.SetDiskMetadataArguments{
+			DiskCID:  diskCID,
+			Metadata: map[string]interface{}{"index": float64(1), "deployment": "cf"},
+		}
+		err = cpi.SetDiskMetadata(cpiConfig, input)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.ReceivedRequests()).To(HaveLen(2))
+	})
+
+	It("rejects metadata values that are neither strings nor integers", func() {
+		input := bosh.SetDiskMetadataArguments{
+			DiskCID:  diskCID,
+			Metadata: map[string]interface{}{"tags": []interface{}{"a"}},
+		}
+		err := cpi.SetDiskMetadata(cpiConfig, input)
+		Expect(err).To(MatchError("Cannot set disk metadata: metadata tags must be a string or an integer, got [a]"))
+		Expect(server.ReceivedRequests()).To(BeEmpty())
+	})
+
+	It("returns an error when the node has no entry for the disk", func() {
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/api/2.0/tags/disk_cid-unknown/nodes"),
+				ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_multiple_disks.json")),
+			),
+		)
+
+		err := cpi.SetDiskMetadata(cpiConfig, bosh.SetDiskMetadataArguments{DiskCID: "disk_cid-unknown"})
+		Expect(err).To(MatchError("disk: disk_cid-unknown has no persistent disk settings on node 57fb9fb03fcc55c807add41c"))
+	})
+})
@@ -3,38 +3,165 @@ package cpi
 import (
 	"encoding/json"
 	"fmt"
-	"reflect"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
 
 	"github.com/rackhd/rackhd-cpi/bosh"
 	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/models"
 	"github.com/rackhd/rackhd-cpi/rackhdapi"
 )
 
-func SetVMMetadata(c config.Cpi, extInput bosh.MethodArguments) error {
-	var cid string
-	if reflect.TypeOf(extInput[0]) != reflect.TypeOf(cid) {
-		return fmt.Errorf("Cannot set VM metadata: received unexpected value for vm cid: %s", cid)
+// NodeNameMetadataKey is the node metadata keeping the name of the node before it was named after a BOSH instance
+const NodeNameMetadataKey = "node_name"
+
+// mirroredMetadataKeys are the metadata keys copied into the RackHD node name and tags, in name order
+var mirroredMetadataKeys = []string{"deployment", "job", "index"}
+
+// SetVMMetadata merges metadata into the metadata of the node, next to its hardware facts,
+// and names and tags the node after the BOSH instance
+func SetVMMetadata(c config.Cpi, input bosh.SetVMMetadataArguments) error {
+	cid := input.VMCID
+
+	err := validateMetadata(input.Metadata)
+	if err != nil {
+		return fmt.Errorf("Cannot set VM metadata: %s", err)
 	}
-	cid = extInput[0].(string)
 
-	metadata, err := json.Marshal(extInput[1])
+	node, err := rackhdapi.GetNodeByVMCID(c, cid)
 	if err != nil {
-		return fmt.Errorf("Cannot set VM metadata: metadata is not valid JSON")
+		return err
+	}
+
+	metadata := mergeMetadata(node.Metadata, input.Metadata)
+	if hardware, found := node.Metadata[HardwareMetadataKey]; found {
+		metadata[HardwareMetadataKey] = hardware
 	} else {
-		for _, v := range extInput[1].(map[string]interface{}) {
-			switch v.(type) {
-			case string, int:
-			default:
-				fmt.Println("Cannot set VM metadata: metadata must be a hash with string or integer values")
-			}
+		// VMs created by earlier releases carry no hardware facts
+		catalogs, err := rackhdapi.GetNodeCatalogs(c, node.ID)
+		if err != nil {
+			log.Error(fmt.Sprintf("setting metadata of node %s without hardware facts: %s", node.ID, err))
+		} else {
+			metadata[HardwareMetadataKey] = hardwareFacts(catalogs.Hardware(), nodeRack(c, node.ID))
 		}
 	}
+	body := map[string]interface{}{"metadata": metadata}
 
-	node, err := rackhdapi.GetNodeByVMCID(c, cid)
-	nodeID := node.ID
+	var nameParts []string
+	for _, key := range mirroredMetadataKeys {
+		if value, found := metadata[key]; found {
+			nameParts = append(nameParts, metadataString(value))
+		}
+	}
+	if len(nameParts) > 0 {
+		body["name"] = strings.Join(nameParts, "/")
+
+		// delete_vm gives the node its name back. A node already carrying metadata tags was named by an earlier release.
+		if _, found := metadata[NodeNameMetadataKey]; !found && !hasMetadataTags(node.Tags) && node.Name != "" {
+			metadata[NodeNameMetadataKey] = node.Name
+		}
+	}
+
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("Cannot set VM metadata: %s", err)
+	}
+
+	err = rackhdapi.PatchNode(c, node.ID, bodyBytes)
 	if err != nil {
 		return err
 	}
 
-	return rackhdapi.SetNodeMetadata(c, nodeID, string(metadata))
+	return syncMetadataTags(c, node, metadata)
+}
+
+// syncMetadataTags replaces the metadata tags of the node with the ones for metadata
+func syncMetadataTags(c config.Cpi, node models.TagNode, metadata map[string]interface{}) error {
+	var tags []string
+	wanted := map[string]bool{}
+	for _, key := range mirroredMetadataKeys {
+		if value, found := metadata[key]; found {
+			tag := fmt.Sprintf("%s%s-%s", MetadataTagPrefix, key, metadataString(value))
+			tags = append(tags, tag)
+			wanted[tag] = true
+		}
+	}
+
+	for _, tag := range node.Tags {
+		if strings.HasPrefix(tag, MetadataTagPrefix) && !wanted[tag] {
+			err := rackhdapi.DeleteTag(c, node.ID, tag)
+			if err != nil {
+				return fmt.Errorf("error deleting metadata tag %s: %s", tag, err)
+			}
+		}
+	}
+
+	for _, tag := range tags {
+		if hasTag(node.Tags, tag) {
+			continue
+		}
+
+		log.Info(fmt.Sprintf("tagging node %s with %s", node.ID, tag))
+		err := rackhdapi.CreateTag(c, node.ID, tag)
+		if err != nil {
+			return fmt.Errorf("error creating metadata tag %s: %s", tag, err)
+		}
+	}
+
+	return nil
+}
+
+// clearVMMetadata deletes the metadata tags of the node and gives it back the name it had before
+// set_vm_metadata, or its id when that name is unknown
+func clearVMMetadata(c config.Cpi, node models.TagNode) error {
+	renamed := false
+	for _, tag := range node.Tags {
+		if strings.HasPrefix(tag, MetadataTagPrefix) {
+			renamed = true
+			err := rackhdapi.DeleteTag(c, node.ID, tag)
+			if err != nil {
+				return fmt.Errorf("error deleting metadata tag %s: %s", tag, err)
+			}
+		}
+	}
+
+	name, found := node.Metadata[NodeNameMetadataKey].(string)
+	if !found && !renamed {
+		return nil
+	}
+	if !found {
+		name = node.ID
+	}
+
+	metadata := map[string]interface{}{}
+	for key, value := range node.Metadata {
+		if key != NodeNameMetadataKey {
+			metadata[key] = value
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"name": name, "metadata": metadata})
+	if err != nil {
+		return fmt.Errorf("error marshalling name of node %s: %s", node.ID, err)
+	}
+	return rackhdapi.PatchNode(c, node.ID, body)
+}
+
+func hasMetadataTags(tags []string) bool {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, MetadataTagPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataString formats a validated metadata value, printing integers decoded from JSON without exponent
+func metadataString(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
 }
@@ -3,7 +3,6 @@ package cpi_test
 import (
 	"fmt"
 	"net/http"
-	"strings"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -17,19 +16,17 @@ import (
 var _ = Describe("Setting VM Metadata", func() {
 	Context("When called with metadata", func() {
 		var server *ghttp.Server
-		var jsonReader *strings.Reader
 		var cpiConfig config.Cpi
-		var request bosh.CpiRequest
 
 		BeforeEach(func() {
-			server, jsonReader, cpiConfig, request = helpers.SetUp(bosh.SET_VM_METADATA)
+			server, _, cpiConfig, _ = helpers.SetUp(bosh.SET_VM_METADATA)
 		})
 
 		AfterEach(func() {
 			server.Close()
 		})
 
-		It("Sends a request to set metadata to the RackHD API", func() {
+		It("Sends a request to set metadata and hardware facts to the RackHD API", func() {
 			id := "57fb9fb03fcc55c807add41c"
 			cid := "vm-5678"
 			metadata := map[string]interface{}{
@@ -38,22 +35,157 @@ var _ = Describe("Setting VM Metadata", func() {
 				"thing2": "bloop",
 			}
 
-			var metadataInput bosh.MethodArguments
-			metadataInput = append(metadataInput, cid)
-			metadataInput = append(metadataInput, metadata)
+			metadataInput := bosh.SetVMMetadataArguments{VMCID: cid, Metadata: metadata}
 			expectedNodesBytes := helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_cid.json")
 
+			catalogHandler := func(source string, status int, body []byte) http.HandlerFunc {
+				return ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/%s", id, source)),
+					ghttp.RespondWith(status, body),
+				)
+			}
+
 			server.AppendHandlers(
 				ghttp.CombineHandlers(
 					ghttp.VerifyRequest("GET", "/api/2.0/tags/"+cid+"/nodes"),
 					ghttp.RespondWith(http.StatusOK, expectedNodesBytes),
 				),
-				ghttp.VerifyRequest("PATCH", fmt.Sprintf("/api/2.0/nodes/%s", id)),
+				catalogHandler("ohai", http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_node_catalog_response.json")),
+				catalogHandler("dmi", http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_dmi_catalog_response.json")),
+				catalogHandler("bmc", http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_bmc_catalog_response.json")),
+				catalogHandler("lldp", http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_lldp_catalog_response.json")),
+				catalogHandler("smart", http.StatusNotFound, nil),
+				catalogHandler("lspci", http.StatusNotFound, nil),
+				catalogHandler("ethtool", http.StatusNotFound, nil),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s", id)),
+					ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_one_node_response.json")),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/2.0/nodes/583f2e33cb9019f9605e1716"),
+					ghttp.RespondWith(http.StatusOK, []byte(`{"id": "583f2e33cb9019f9605e1716", "name": "rack-12", "type": "enclosure"}`)),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PATCH", fmt.Sprintf("/api/2.0/nodes/%s", id)),
+					ghttp.VerifyJSON(`{
+						"metadata": {
+							"stuff": "definitely",
+							"thing1": 3563456,
+							"thing2": "bloop",
+							"hardware": {
+								"serial_number": "95SF082",
+								"manufacturer": "Dell Inc.",
+								"model": "PowerEdge R630",
+								"bmc_ip": "172.31.128.150",
+								"rack": "rack-12",
+								"switch_port": "tor-switch-1/Ethernet12"
+							}
+						}
+					}`),
+				),
 			)
 
 			err := cpi.SetVMMetadata(cpiConfig, metadataInput)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(server.ReceivedRequests()).To(HaveLen(2))
+			Expect(server.ReceivedRequests()).To(HaveLen(11))
+		})
+
+		It("sets the metadata without hardware facts when the catalogs of a node are unavailable", func() {
+			id := "57fb9fb03fcc55c807add41c"
+			cid := "vm-5678"
+
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/2.0/tags/"+cid+"/nodes"),
+					ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_cid.json")),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/ohai", id)),
+					ghttp.RespondWith(http.StatusInternalServerError, nil),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PATCH", fmt.Sprintf("/api/2.0/nodes/%s", id)),
+					ghttp.VerifyJSON(`{"metadata": {"stuff": "definitely"}}`),
+				),
+			)
+
+			err := cpi.SetVMMetadata(cpiConfig, bosh.SetVMMetadataArguments{VMCID: cid, Metadata: map[string]interface{}{"stuff": "definitely"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("keeps the name the node had before naming it after the BOSH instance", func() {
+			id := "57fb9fb03fcc55c807add41c"
+			cid := "vm-5678"
+
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/2.0/tags/"+cid+"/nodes"),
+					ghttp.RespondWith(http.StatusOK, fmt.Sprintf(`[{"id": "%s", "name": "c0:3f:d5:63:fe:13", "tags": ["unavailable", "%s"], "metadata": {"hardware": {"serial_number": "95SF082"}}}]`, id, cid)),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PATCH", fmt.Sprintf("/api/2.0/nodes/%s", id)),
+					ghttp.VerifyJSON(`{
+						"metadata": {"deployment": "cf", "node_name": "c0:3f:d5:63:fe:13", "hardware": {"serial_number": "95SF082"}},
+						"name": "cf"
+					}`),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PATCH", fmt.Sprintf("/api/2.0/nodes/%s/tags", id)),
+					ghttp.VerifyJSON(`{"tags": ["bosh_deployment-cf"]}`),
+				),
+			)
+
+			err := cpi.SetVMMetadata(cpiConfig, bosh.SetVMMetadataArguments{VMCID: cid, Metadata: map[string]interface{}{"deployment": "cf"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("returns an error when a value is neither a string nor an integer", func() {
+			metadataInput := bosh.SetVMMetadataArguments{VMCID: "vm-5678", Metadata: map[string]interface{}{"index": 1.5}}
+
+			err := cpi.SetVMMetadata(cpiConfig, metadataInput)
+			Expect(err).To(MatchError("Cannot set VM metadata: metadata index must be a string or an integer, got 1.5"))
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("merges metadata and names and tags the node after the BOSH instance", func() {
+			id := "57fb9fb03fcc55c807add41c"
+			cid := "vm_cid-fake_uuid"
+			metadata := map[string]interface{}{
+				"deployment": "cf",
+				"index":      float64(0),
+			}
+
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/2.0/tags/"+cid+"/nodes"),
+					ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/tag_nodes_with_vm_metadata.json")),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PATCH", fmt.Sprintf("/api/2.0/nodes/%s", id)),
+					ghttp.VerifyJSON(`{
+						"metadata": {"director": "bosh", "deployment": "cf", "job": "router", "index": 0, "hardware": {"serial_number": "95SF082"}},
+						"name": "cf/router/0"
+					}`),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("DELETE", fmt.Sprintf("/api/2.0/nodes/%s/tags/bosh_deployment-old", id)),
+					ghttp.RespondWith(http.StatusNoContent, nil),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PATCH", fmt.Sprintf("/api/2.0/nodes/%s/tags", id)),
+					ghttp.VerifyJSON(`{"tags": ["bosh_deployment-cf"]}`),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PATCH", fmt.Sprintf("/api/2.0/nodes/%s/tags", id)),
+					ghttp.VerifyJSON(`{"tags": ["bosh_index-0"]}`),
+				),
+			)
+
+			err := cpi.SetVMMetadata(cpiConfig, bosh.SetVMMetadataArguments{VMCID: cid, Metadata: metadata})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(5))
 		})
 	})
 })
@@ -0,0 +1,133 @@
+package cpi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/rackhdapi"
+)
+
+/*
+  Stemcell images are stored once per content: create_stemcell hashes the
+  image and only uploads it when the file store holds no image with that
+  SHA-256. Each cid using an image has a marker file named after the image
+  and the cid, so delete_stemcell only deletes the image once no marker is left.
+*/
+
+// StemcellImagePrefix names the files holding stemcell images, followed by the cid of the stemcell that uploaded it
+const StemcellImagePrefix = "stemcell-image-"
+
+const stemcellReferenceInfix = "-reference-"
+
+func stemcellReferenceFile(image string, stemcellCID string) string {
+	return image + stemcellReferenceInfix + stemcellCID
+}
+
+// stemcellImage is the file the provision workflow writes for stemcellCID. Stemcells created
+// before images were shared are stored under their cid.
+func stemcellImage(c config.Cpi, stemcellCID string) (string, error) {
+	metadata, found, err := findStemcellMetadata(c, stemcellCID)
+	if err != nil {
+		return "", err
+	}
+
+	if !found || metadata.Image == "" {
+		return stemcellCID, nil
+	}
+	return metadata.Image, nil
+}
+
+// fileSHA256 hashes the image and rewinds it for the upload
+func fileSHA256(f *os.File) (string, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, f)
+	if err != nil {
+		return "", fmt.Errorf("error hashing stemcell image: %s", err)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", fmt.Errorf("error rewinding stemcell image: %s", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// reuseStemcellImage references from stemcellCID an image already stored with the SHA-256 sha,
+// and returns false when the file store holds no such image
+func reuseStemcellImage(c config.Cpi, sha string, stemcellCID string) (string, bool, error) {
+	images, err := rackhdapi.ListFiles(c, StemcellImagePrefix)
+	if err != nil {
+		return "", false, fmt.Errorf("error listing stemcell images: %s", err)
+	}
+
+	for _, file := range images {
+		if file.Sha256 != sha || strings.Contains(file.Basename, stemcellReferenceInfix) {
+			continue
+		}
+		image := file.Basename
+
+		// the reference is recorded before checking the image, so delete_stemcell either keeps
+		// the image or has deleted it already
+		err = addStemcellReference(c, image, stemcellCID)
+		if err != nil {
+			return "", false, err
+		}
+
+		_, found, err := rackhdapi.GetFileMetadata(c, image)
+		if err != nil {
+			return "", false, err
+		}
+		if found {
+			return image, true, nil
+		}
+
+		log.Info(fmt.Sprintf("stemcell image %s was deleted meanwhile", image))
+		_, err = removeStemcellReference(c, image, stemcellCID)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	return "", false, nil
+}
+
+// addStemcellReference records that stemcellCID uses image in a marker file of its own, so
+// stemcells created concurrently never overwrite each other's references
+func addStemcellReference(c config.Cpi, image string, stemcellCID string) error {
+	b := []byte(stemcellCID)
+	_, err := rackhdapi.UploadFile(c, stemcellReferenceFile(image, stemcellCID), bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return fmt.Errorf("error uploading reference of stemcell %s to image %s: %s", stemcellCID, image, err)
+	}
+	return nil
+}
+
+// removeStemcellReference forgets that stemcellCID uses image and returns how many stemcells still use it
+func removeStemcellReference(c config.Cpi, image string, stemcellCID string) (int, error) {
+	name := stemcellReferenceFile(image, stemcellCID)
+	_, found, err := rackhdapi.GetFileMetadata(c, name)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		err = rackhdapi.DeleteFile(c, name)
+		if err != nil {
+			return 0, fmt.Errorf("error deleting reference of stemcell %s to image %s: %s", stemcellCID, image, err)
+		}
+	}
+
+	remaining, err := rackhdapi.ListFiles(c, image+stemcellReferenceInfix)
+	if err != nil {
+		return 0, fmt.Errorf("error listing references of stemcell image %s: %s", image, err)
+	}
+	return len(remaining), nil
+}
@@ -0,0 +1,125 @@
+package cpi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/models"
+	"github.com/rackhd/rackhd-cpi/rackhdapi"
+)
+
+// StemcellMetadataSuffix names the file holding the metadata of a stemcell after its cid.
+// The RackHD file store keeps no metadata of its own, so it lives in a file next to the image.
+const StemcellMetadataSuffix = "-metadata"
+
+// The provision workflow untars the image straight onto the system disk, so only raw x86_64 images boot
+var (
+	supportedStemcellDiskFormats   = []string{"raw"}
+	supportedStemcellArchitectures = []string{"x86_64"}
+)
+
+// parseStemcellProperties reads the stemcell cloud_properties and rejects images the provision workflow cannot write.
+// Stemcells built before the properties were published have none, and are accepted as they are.
+func parseStemcellProperties(cloudProperties map[string]interface{}) (models.StemcellMetadata, error) {
+	var properties struct {
+		models.StemcellMetadata
+		Version interface{} `json:"version"`
+	}
+
+	b, err := json.Marshal(cloudProperties)
+	if err != nil {
+		return models.StemcellMetadata{}, fmt.Errorf("error marshalling stemcell cloud properties: %s", err)
+	}
+
+	err = json.Unmarshal(b, &properties)
+	if err != nil {
+		return models.StemcellMetadata{}, fmt.Errorf("stemcell cloud properties are invalid: %s", err)
+	}
+
+	metadata := properties.StemcellMetadata
+	if properties.Version != nil {
+		metadata.Version = fmt.Sprint(properties.Version)
+	}
+
+	if metadata.DiskFormat == "" {
+		log.Info("stemcell has no disk_format, assuming a raw image")
+	} else if !contains(supportedStemcellDiskFormats, metadata.DiskFormat) {
+		return models.StemcellMetadata{}, fmt.Errorf("stemcell disk_format %s is not supported, expected one of %v", metadata.DiskFormat, supportedStemcellDiskFormats)
+	}
+
+	if metadata.Architecture == "" {
+		log.Info("stemcell has no architecture, assuming x86_64")
+	} else if !contains(supportedStemcellArchitectures, metadata.Architecture) {
+		return models.StemcellMetadata{}, fmt.Errorf("stemcell architecture %s is not supported, expected one of %v", metadata.Architecture, supportedStemcellArchitectures)
+	}
+
+	return metadata, nil
+}
+
+// StemcellMetadataFile is the name of the file holding the metadata of the stemcell stemcellCID
+func StemcellMetadataFile(stemcellCID string) string {
+	return stemcellCID + StemcellMetadataSuffix
+}
+
+// GetStemcellMetadata returns the metadata stored when the stemcell stemcellCID was created
+func GetStemcellMetadata(c config.Cpi, stemcellCID string) (models.StemcellMetadata, error) {
+	metadata, found, err := findStemcellMetadata(c, stemcellCID)
+	if err != nil {
+		return models.StemcellMetadata{}, err
+	}
+	if !found {
+		return models.StemcellMetadata{}, fmt.Errorf("stemcell %s has no metadata", stemcellCID)
+	}
+	return metadata, nil
+}
+
+// findStemcellMetadata returns the metadata of stemcellCID, and false for stemcells created by earlier releases
+func findStemcellMetadata(c config.Cpi, stemcellCID string) (models.StemcellMetadata, bool, error) {
+	_, found, err := rackhdapi.GetFileMetadata(c, StemcellMetadataFile(stemcellCID))
+	if err != nil {
+		return models.StemcellMetadata{}, false, err
+	}
+	if !found {
+		return models.StemcellMetadata{}, false, nil
+	}
+
+	b, err := rackhdapi.GetFile(c, StemcellMetadataFile(stemcellCID))
+	if err != nil {
+		return models.StemcellMetadata{}, false, fmt.Errorf("error getting metadata of stemcell %s: %s", stemcellCID, err)
+	}
+
+	var metadata models.StemcellMetadata
+	err = json.Unmarshal(b, &metadata)
+	if err != nil {
+		return models.StemcellMetadata{}, false, fmt.Errorf("error unmarshalling metadata of stemcell %s: %s", stemcellCID, err)
+	}
+
+	return metadata, true, nil
+}
+
+func uploadStemcellMetadata(c config.Cpi, stemcellCID string, metadata models.StemcellMetadata) error {
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("error marshalling metadata of stemcell %s: %s", stemcellCID, err)
+	}
+
+	_, err = rackhdapi.UploadFile(c, StemcellMetadataFile(stemcellCID), bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return fmt.Errorf("error uploading metadata of stemcell %s: %s", stemcellCID, err)
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,298 @@
+package cpi_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rackhd/rackhd-cpi/bosh"
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/cpi"
+	"github.com/rackhd/rackhd-cpi/helpers"
+	"github.com/rackhd/rackhd-cpi/models"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+// fakeFileStore serves the files API of RackHD from memory, keeping the latest version of each file
+type fakeFileStore struct {
+	files   map[string]models.FileUploadResponse
+	content map[string][]byte
+	uploads []string
+	// failUploads makes uploads of the files with this suffix fail
+	failUploads string
+	unreadable  map[string]bool
+}
+
+func newFakeFileStore(server *ghttp.Server) *fakeFileStore {
+	store := &fakeFileStore{
+		files:      map[string]models.FileUploadResponse{},
+		content:    map[string][]byte{},
+		unreadable: map[string]bool{},
+	}
+
+	metadataPath := regexp.MustCompile(`^/api/2.0/files/([^/]+)/metadata$`)
+	filePath := regexp.MustCompile(`^/api/2.0/files/([^/]+)$`)
+
+	server.RouteToHandler("GET", "/api/2.0/files", func(w http.ResponseWriter, r *http.Request) {
+		records := []models.FileRecord{}
+		for name, file := range store.files {
+			records = append(records, models.FileRecord{Basename: name, UUID: file.UUID, Sha256: file.Sha256})
+		}
+		json.NewEncoder(w).Encode(records)
+	})
+	server.RouteToHandler("GET", metadataPath, func(w http.ResponseWriter, r *http.Request) {
+		name := metadataPath.FindStringSubmatch(r.URL.Path)[1]
+		if store.unreadable[name] {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		file, found := store.files[name]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(file)
+	})
+	server.RouteToHandler("GET", filePath, func(w http.ResponseWriter, r *http.Request) {
+		name := filePath.FindStringSubmatch(r.URL.Path)[1]
+		if _, found := store.files[name]; !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(store.content[name])
+	})
+	server.RouteToHandler("PUT", filePath, func(w http.ResponseWriter, r *http.Request) {
+		name := filePath.FindStringSubmatch(r.URL.Path)[1]
+		if store.failUploads != "" && strings.HasSuffix(name, store.failUploads) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		sum := sha256.Sum256(b)
+		file := models.FileUploadResponse{Name: name, UUID: "uuid-" + name, Sha256: hex.EncodeToString(sum[:])}
+		store.files[name] = file
+		store.content[name] = b
+		store.uploads = append(store.uploads, name)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(file)
+	})
+	server.RouteToHandler("DELETE", filePath, func(w http.ResponseWriter, r *http.Request) {
+		uuid := filePath.FindStringSubmatch(r.URL.Path)[1]
+		delete(store.files, strings.TrimPrefix(uuid, "uuid-"))
+		delete(store.content, strings.TrimPrefix(uuid, "uuid-"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return store
+}
+
+func (s *fakeFileStore) names() []string {
+	var names []string
+	for name := range s.files {
+		names = append(names, name)
+	}
+	return names
+}
+
+var _ = Describe("Stemcells", func() {
+	var server *ghttp.Server
+	var store *fakeFileStore
+	var cpiConfig config.Cpi
+	var input bosh.CreateStemcellArguments
+	var sha string
+
+	BeforeEach(func() {
+		server, _, cpiConfig, _ = helpers.SetUp(bosh.CREATE_STEMCELL)
+		store = newFakeFileStore(server)
+		input = bosh.CreateStemcellArguments{
+			ImagePath: "../spec_assets/image",
+			CloudProperties: map[string]interface{}{
+				"name":         "bosh-openstack-kvm-ubuntu-trusty-go_agent-raw",
+				"version":      3263.8,
+				"os_type":      "linux",
+				"os_distro":    "ubuntu",
+				"architecture": "x86_64",
+				"disk_format":  "raw",
+				"hypervisor":   "kvm",
+			},
+		}
+
+		b, err := ioutil.ReadFile(input.ImagePath)
+		Expect(err).ToNot(HaveOccurred())
+		sum := sha256.Sum256(b)
+		sha = hex.EncodeToString(sum[:])
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	images := func() []string {
+		names := []string{}
+		for _, name := range store.names() {
+			if strings.HasPrefix(name, cpi.StemcellImagePrefix) && store.files[name].Sha256 == sha {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	references := func(image string) []string {
+		cids := []string{}
+		for _, name := range store.names() {
+			if strings.HasPrefix(name, image+"-reference-") {
+				cids = append(cids, string(store.content[name]))
+			}
+		}
+		sort.Strings(cids)
+		return cids
+	}
+
+	Describe("CreateStemcell", func() {
+		It("stores the image with the cloud properties of the stemcell", func() {
+			stemcellCID, err := cpi.CreateStemcell(cpiConfig, input)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(images()).To(Equal([]string{cpi.StemcellImagePrefix + stemcellCID}))
+			image := images()[0]
+			Expect(references(image)).To(Equal([]string{stemcellCID}))
+
+			metadata, err := cpi.GetStemcellMetadata(cpiConfig, stemcellCID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metadata).To(Equal(models.StemcellMetadata{
+				Name:         "bosh-openstack-kvm-ubuntu-trusty-go_agent-raw",
+				Version:      "3263.8",
+				OSType:       "linux",
+				OSDistro:     "ubuntu",
+				Architecture: "x86_64",
+				DiskFormat:   "raw",
+				Image:        image,
+				Sha256:       sha,
+			}))
+		})
+
+		It("reuses the image of an identical stemcell", func() {
+			first, err := cpi.CreateStemcell(cpiConfig, input)
+			Expect(err).ToNot(HaveOccurred())
+			second, err := cpi.CreateStemcell(cpiConfig, input)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(second).ToNot(Equal(first))
+			Expect(images()).To(Equal([]string{cpi.StemcellImagePrefix + first}))
+			Expect(references(images()[0])).To(ConsistOf(first, second))
+
+			metadata, err := cpi.GetStemcellMetadata(cpiConfig, second)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metadata.Image).To(Equal(images()[0]))
+		})
+
+		It("references an existing image with the same SHA-256 without uploading the stemcell again", func() {
+			existing := cpi.StemcellImagePrefix + "ffffffff-ffff-ffff-ffff-ffffffffffff"
+			store.files[existing] = models.FileUploadResponse{Name: existing, UUID: "uuid-" + existing, Sha256: sha}
+
+			stemcellCID, err := cpi.CreateStemcell(cpiConfig, input)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(images()).To(Equal([]string{existing}))
+			Expect(references(existing)).To(Equal([]string{stemcellCID}))
+			for _, name := range store.uploads {
+				Expect(name).ToNot(Equal(cpi.StemcellImagePrefix + stemcellCID))
+			}
+		})
+
+		It("never replaces an image whose content does not match, as other stemcells may use it", func() {
+			corrupt := cpi.StemcellImagePrefix + "0"
+			store.files[corrupt] = models.FileUploadResponse{Name: corrupt, UUID: "uuid-" + corrupt, Sha256: "corrupt"}
+			store.files[corrupt+"-reference-stemcell-1"] = models.FileUploadResponse{Name: corrupt + "-reference-stemcell-1", UUID: "uuid-" + corrupt + "-reference-stemcell-1"}
+
+			stemcellCID, err := cpi.CreateStemcell(cpiConfig, input)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store.files).To(HaveKey(corrupt))
+			Expect(images()).To(Equal([]string{cpi.StemcellImagePrefix + stemcellCID}))
+		})
+
+		It("accepts a stemcell without cloud properties", func() {
+			input.CloudProperties = nil
+
+			_, err := cpi.CreateStemcell(cpiConfig, input)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("rejects an image format the provision workflow cannot write before uploading it", func() {
+			input.CloudProperties["disk_format"] = "qcow2"
+
+			_, err := cpi.CreateStemcell(cpiConfig, input)
+			Expect(err).To(MatchError("stemcell disk_format qcow2 is not supported, expected one of [raw]"))
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("rejects an unsupported architecture before uploading it", func() {
+			input.CloudProperties["architecture"] = "ppc64le"
+
+			_, err := cpi.CreateStemcell(cpiConfig, input)
+			Expect(err).To(MatchError("stemcell architecture ppc64le is not supported, expected one of [x86_64]"))
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("keeps the image referenced when the metadata cannot be stored", func() {
+			store.failUploads = cpi.StemcellMetadataSuffix
+
+			_, err := cpi.CreateStemcell(cpiConfig, input)
+			Expect(err).To(HaveOccurred())
+			Expect(images()).To(HaveLen(1))
+			Expect(references(images()[0])).To(HaveLen(1))
+		})
+	})
+
+	Describe("DeleteStemcell", func() {
+		It("keeps the image while another stemcell uses it", func() {
+			first, err := cpi.CreateStemcell(cpiConfig, input)
+			Expect(err).ToNot(HaveOccurred())
+			second, err := cpi.CreateStemcell(cpiConfig, input)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = cpi.DeleteStemcell(cpiConfig, bosh.DeleteStemcellArguments{StemcellCID: first})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(images()).To(HaveLen(1))
+			Expect(store.files).ToNot(HaveKey(first + cpi.StemcellMetadataSuffix))
+			Expect(references(images()[0])).To(Equal([]string{second}))
+
+			err = cpi.DeleteStemcell(cpiConfig, bosh.DeleteStemcellArguments{StemcellCID: second})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store.names()).To(BeEmpty())
+		})
+
+		It("deletes a stemcell created before images were shared", func() {
+			store.files["stemcell-1"] = models.FileUploadResponse{Name: "stemcell-1", UUID: "uuid-stemcell-1"}
+			store.files["stemcell-1"+cpi.StemcellMetadataSuffix] = models.FileUploadResponse{Name: "stemcell-1-metadata", UUID: "uuid-stemcell-1-metadata"}
+			store.content["stemcell-1"+cpi.StemcellMetadataSuffix] = []byte(`{"name": "ubuntu", "version": "3263.8"}`)
+
+			err := cpi.DeleteStemcell(cpiConfig, bosh.DeleteStemcellArguments{StemcellCID: "stemcell-1"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store.names()).To(BeEmpty())
+		})
+
+		It("deletes a stemcell created without metadata", func() {
+			store.files["stemcell-1"] = models.FileUploadResponse{Name: "stemcell-1", UUID: "uuid-stemcell-1"}
+
+			err := cpi.DeleteStemcell(cpiConfig, bosh.DeleteStemcellArguments{StemcellCID: "stemcell-1"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(store.names()).To(BeEmpty())
+		})
+
+		It("fails without deleting anything when the file store cannot be read", func() {
+			store.unreadable["stemcell-1"+cpi.StemcellMetadataSuffix] = true
+			store.files["stemcell-1"] = models.FileUploadResponse{Name: "stemcell-1", UUID: "uuid-stemcell-1"}
+
+			err := cpi.DeleteStemcell(cpiConfig, bosh.DeleteStemcellArguments{StemcellCID: "stemcell-1"})
+			Expect(err).To(HaveOccurred())
+			Expect(store.files).To(HaveKey("stemcell-1"))
+		})
+	})
+})
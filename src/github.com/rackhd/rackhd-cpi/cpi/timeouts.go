@@ -0,0 +1,41 @@
+package cpi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/rackhd/rackhd-cpi/config"
+)
+
+const timeoutsCloudPropertyKey = "timeouts"
+
+// parseTimeoutProperties reads the vm_type cloud_properties overriding the timeouts of the CPI config,
+// e.g. a longer provision timeout for a big stemcell on a slow network
+func parseTimeoutProperties(cloudProperties map[string]interface{}) (config.TimeoutsConfig, error) {
+	properties := config.TimeoutsConfig{}
+
+	input, exists := cloudProperties[timeoutsCloudPropertyKey]
+	if !exists || input == nil {
+		return properties, nil
+	}
+
+	b, err := json.Marshal(input)
+	if err != nil {
+		return properties, fmt.Errorf("error marshalling %s cloud property: %s", timeoutsCloudPropertyKey, err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&properties)
+	if err != nil {
+		return properties, fmt.Errorf("%s cloud property is invalid: %s", timeoutsCloudPropertyKey, err)
+	}
+
+	err = properties.Validate()
+	if err != nil {
+		return properties, fmt.Errorf("%s cloud property is invalid: %s", timeoutsCloudPropertyKey, err)
+	}
+
+	return properties, nil
+}
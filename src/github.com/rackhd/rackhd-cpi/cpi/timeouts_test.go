@@ -0,0 +1,46 @@
+package cpi
+
+import (
+	"time"
+
+	"github.com/rackhd/rackhd-cpi/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("timeouts", func() {
+	Describe("parseTimeoutProperties", func() {
+		It("returns empty properties when timeouts is not set", func() {
+			properties, err := parseTimeoutProperties(map[string]interface{}{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(properties).To(Equal(config.TimeoutsConfig{}))
+		})
+
+		It("decodes durations and seconds", func() {
+			cloudProperties := map[string]interface{}{
+				"timeouts": map[string]interface{}{
+					"provision": "45m",
+					"reserve":   float64(120),
+				},
+			}
+
+			properties, err := parseTimeoutProperties(cloudProperties)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(properties).To(Equal(config.TimeoutsConfig{
+				Provision: config.Duration(45 * time.Minute),
+				Reserve:   config.Duration(2 * time.Minute),
+			}))
+		})
+
+		It("returns an error for an unknown timeout", func() {
+			_, err := parseTimeoutProperties(map[string]interface{}{"timeouts": map[string]interface{}{"provison": "45m"}})
+			Expect(err).To(MatchError(ContainSubstring("timeouts cloud property is invalid")))
+		})
+
+		It("returns an error for a negative timeout", func() {
+			_, err := parseTimeoutProperties(map[string]interface{}{"timeouts": map[string]interface{}{"reserve": "-1m"}})
+			Expect(err).To(MatchError("timeouts cloud property is invalid: timeouts.reserve cannot be negative, got -1m0s"))
+		})
+	})
+})
@@ -7,13 +7,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/nu7hatch/gouuid"
 	"github.com/rackhd/rackhd-cpi/models"
 )
 
 // MakeRequestWithMultiCode builds a request with given info and makes the request
-func MakeRequestWithMultiCode(url, method string, statusCode []int, body []byte) ([]byte, error) {
+func MakeRequestWithMultiCode(client *http.Client, url, method string, statusCode []int, body []byte) ([]byte, error) {
 	errMsg := fmt.Sprintf("%s request to %s with body %+v", method, url, string(body))
 
 	req, err := http.NewRequest(method, url, bytes.NewReader(body))
@@ -25,36 +26,50 @@ func MakeRequestWithMultiCode(url, method string, statusCode []int, body []byte)
 		req.ContentLength = int64(len(body))
 	}
 
-	return MakeConfigRequest(req, statusCode)
+	return MakeConfigRequest(client, req, statusCode)
 }
 
 // MakeConfigRequest makes the given request
-func MakeConfigRequest(req *http.Request, statusCode []int) ([]byte, error) {
+func MakeConfigRequest(client *http.Client, req *http.Request, statusCode []int) ([]byte, error) {
+	respBody, _, err := MakeConfigRequestWithStatus(client, req, statusCode)
+	return respBody, err
+}
+
+// MakeConfigRequestWithStatus makes the given request and also returns the status code it was answered with
+func MakeConfigRequestWithStatus(client *http.Client, req *http.Request, statusCode []int) ([]byte, int, error) {
 	errMsg := fmt.Sprintf("%s request to %s with body %+v", req.Method, req.URL, req.Body)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error making %s: %s", errMsg, err)
+		return nil, 0, fmt.Errorf("error making %s: %s", errMsg, err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing response body %s: %s", errMsg, err)
+		return nil, 0, fmt.Errorf("error parsing response body %s: %s", errMsg, err)
 	}
 
 	for _, code := range statusCode {
 		if resp.StatusCode == code {
-			return respBody, nil
+			return respBody, resp.StatusCode, nil
 		}
 	}
 
-	return nil, fmt.Errorf("error getting response from %s: %d, %s", errMsg, resp.StatusCode, string(respBody))
+	return nil, resp.StatusCode, fmt.Errorf("error getting response from %s: %d, %s", errMsg, resp.StatusCode, string(respBody))
 }
 
 // MakeRequest builds a request by given info and make the request
-func MakeRequest(url, method string, statusCode int, body []byte) ([]byte, error) {
-	return MakeRequestWithMultiCode(url, method, []int{statusCode}, body)
+func MakeRequest(client *http.Client, url, method string, statusCode int, body []byte) ([]byte, error) {
+	return MakeRequestWithMultiCode(client, url, method, []int{statusCode}, body)
+}
+
+// NewHTTPClient returns a client whose requests wait at most timeout for the response headers.
+// Sending a large request body such as a stemcell is not limited, and zero waits forever.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+	return &http.Client{Transport: transport}
 }
 
 // GenerateUUID generates an uuid
@@ -41,7 +41,7 @@ func AddHandlerWithParam(server *ghttp.Server, method, url, param string, status
 func SetUp(cpiRequestType string) (*ghttp.Server, *strings.Reader, config.Cpi, bosh.CpiRequest) {
 	var err error
 	server := ghttp.NewServer()
-	jsonReader := strings.NewReader(fmt.Sprintf(`{"api_url":"%s", "agent":{"blobstore": {"provider":"local","some": "options"}, "mbus":"localhost"}, "max_reserve_node_attempts":1}`, server.URL()))
+	jsonReader := strings.NewReader(fmt.Sprintf(`{"api_url":"%s", "agent":{"blobstore": {"provider":"local","some": "options"}, "mbus":"nats://localhost:4222"}, "max_reserve_node_attempts":1}`, server.URL()))
 	request := bosh.CpiRequest{Method: cpiRequestType}
 	cpiConfig, err := config.New(jsonReader, request)
 	Expect(err).ToNot(HaveOccurred())
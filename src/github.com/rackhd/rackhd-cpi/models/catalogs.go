@@ -0,0 +1,293 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sources of the catalogs RackHD collects while discovering a node
+const (
+	OhaiCatalogSource    = "ohai"
+	DMICatalogSource     = "dmi"
+	BMCCatalogSource     = "bmc"
+	LLDPCatalogSource    = "lldp"
+	SMARTCatalogSource   = "smart"
+	LSPCICatalogSource   = "lspci"
+	EthtoolCatalogSource = "ethtool"
+)
+
+// OhaiCPU is the cpu section of the ohai catalog, which keys processors by their index next to the totals
+type OhaiCPU struct {
+	Total      int
+	Real       int
+	Processors []OhaiProcessor
+}
+
+type OhaiProcessor struct {
+	VendorID   string `json:"vendor_id"`
+	ModelName  string `json:"model_name"`
+	MHz        string `json:"mhz"`
+	PhysicalID string `json:"physical_id"`
+	CoreID     string `json:"core_id"`
+	Cores      string `json:"cores"`
+}
+
+type OhaiMemory struct {
+	Total string `json:"total"`
+}
+
+type OhaiDMI struct {
+	System  OhaiDMISystem  `json:"system"`
+	Chassis OhaiDMIChassis `json:"chassis"`
+}
+
+type OhaiDMISystem struct {
+	Manufacturer string `json:"manufacturer"`
+	ProductName  string `json:"product_name"`
+	SerialNumber string `json:"serial_number"`
+	UUID         string `json:"uuid"`
+}
+
+type OhaiDMIChassis struct {
+	Type         string `json:"type"`
+	SerialNumber string `json:"serial_number"`
+}
+
+// DMICatalog is the dmidecode output RackHD stores by section title
+type DMICatalog struct {
+	Data DMICatalogData `json:"data"`
+}
+
+type DMICatalogData struct {
+	System        DMISystem        `json:"System Information"`
+	Processors    DMIProcessors    `json:"Processor Information"`
+	MemoryDevices DMIMemoryDevices `json:"Memory Device"`
+}
+
+type DMISystem struct {
+	Manufacturer string `json:"Manufacturer"`
+	ProductName  string `json:"Product Name"`
+	SerialNumber string `json:"Serial Number"`
+	UUID         string `json:"UUID"`
+}
+
+type DMIProcessor struct {
+	SocketDesignation string `json:"Socket Designation"`
+	Version           string `json:"Version"`
+	CoreCount         string `json:"Core Count"`
+	ThreadCount       string `json:"Thread Count"`
+	CurrentSpeed      string `json:"Current Speed"`
+}
+
+type DMIMemoryDevice struct {
+	Locator      string `json:"Locator"`
+	Size         string `json:"Size"`
+	Type         string `json:"Type"`
+	Speed        string `json:"Speed"`
+	Manufacturer string `json:"Manufacturer"`
+	SerialNumber string `json:"Serial Number"`
+	PartNumber   string `json:"Part Number"`
+}
+
+// DMIProcessors holds the processor sections, which dmidecode reports as an object on single socket nodes
+type DMIProcessors []DMIProcessor
+
+// DMIMemoryDevices holds the memory device sections, which dmidecode reports as an object on nodes with one slot
+type DMIMemoryDevices []DMIMemoryDevice
+
+// LLDPCatalog maps the interfaces of a node to the switch port they are cabled to
+type LLDPCatalog struct {
+	Data map[string]LLDPNeighbor `json:"data"`
+}
+
+type LLDPNeighbor struct {
+	Chassis LLDPChassis `json:"chassis"`
+	Port    LLDPPort    `json:"port"`
+}
+
+type LLDPChassis struct {
+	MAC         string `json:"mac"`
+	Name        string `json:"name"`
+	Description string `json:"descr"`
+}
+
+type LLDPPort struct {
+	IfName      string `json:"ifname"`
+	Description string `json:"descr"`
+}
+
+// SMARTCatalog lists the smartctl output of every drive
+type SMARTCatalog struct {
+	Data []SMARTDrive `json:"data"`
+}
+
+type SMARTDrive struct {
+	OSDeviceName string    `json:"OS Device Name"`
+	SMART        SMARTInfo `json:"SMART"`
+}
+
+type SMARTInfo struct {
+	Identity       SMARTIdentity `json:"Identity"`
+	SelfAssessment string        `json:"Self-Assessment"`
+}
+
+type SMARTIdentity struct {
+	DeviceModel     string `json:"Device Model"`
+	SerialNumber    string `json:"Serial Number"`
+	FirmwareVersion string `json:"Firmware Version"`
+	UserCapacity    string `json:"User Capacity"`
+}
+
+// LSPCICatalog lists the PCI devices of a node
+type LSPCICatalog struct {
+	Data []PCIDevice `json:"data"`
+}
+
+type PCIDevice struct {
+	BusNumber   string `json:"pciBusNumber"`
+	VendorName  string `json:"vendorName"`
+	DeviceName  string `json:"deviceName"`
+	DeviceClass string `json:"deviceClass"`
+	Driver      string `json:"driver"`
+}
+
+// EthtoolCatalog lists the driver and link settings of every network interface
+type EthtoolCatalog struct {
+	Data []EthtoolInterface `json:"data"`
+}
+
+type EthtoolInterface struct {
+	Identifier string          `json:"identifier"`
+	Driver     EthtoolDriver   `json:"driver"`
+	Settings   EthtoolSettings `json:"settings"`
+}
+
+type EthtoolDriver struct {
+	Driver  string `json:"driver"`
+	Version string `json:"version"`
+	BusInfo string `json:"bus-info"`
+}
+
+type EthtoolSettings struct {
+	Speed        string `json:"Speed"`
+	LinkDetected string `json:"Link detected"`
+}
+
+// UnmarshalJSON reads the totals and the processors keyed by index
+func (c *OhaiCPU) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	cpu := OhaiCPU{}
+	var indexes []int
+	processors := map[int]OhaiProcessor{}
+	for key, value := range raw {
+		switch key {
+		case "total":
+			err = json.Unmarshal(value, &cpu.Total)
+		case "real":
+			err = json.Unmarshal(value, &cpu.Real)
+		default:
+			index, convErr := strconv.Atoi(key)
+			if convErr != nil {
+				continue
+			}
+			var processor OhaiProcessor
+			err = json.Unmarshal(value, &processor)
+			processors[index] = processor
+			indexes = append(indexes, index)
+		}
+		if err != nil {
+			return fmt.Errorf("error parsing cpu %s: %s", key, err)
+		}
+	}
+
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		cpu.Processors = append(cpu.Processors, processors[index])
+	}
+
+	*c = cpu
+	return nil
+}
+
+// MarshalJSON writes the cpu section back in the ohai layout
+func (c OhaiCPU) MarshalJSON() ([]byte, error) {
+	raw := map[string]interface{}{
+		"total": c.Total,
+		"real":  c.Real,
+	}
+	for i, processor := range c.Processors {
+		raw[strconv.Itoa(i)] = processor
+	}
+	return json.Marshal(raw)
+}
+
+// TotalMB converts the total memory ohai reports in kB to MB
+func (m OhaiMemory) TotalMB() (int, error) {
+	sizeInKB, err := strconv.Atoi(strings.TrimSuffix(m.Total, "kB"))
+	if err != nil {
+		return 0, fmt.Errorf("error parsing memory size %s: %v", m.Total, err)
+	}
+	return sizeInKB / 1024, nil
+}
+
+// UnmarshalJSON accepts a single section as well as a list of them
+func (p *DMIProcessors) UnmarshalJSON(data []byte) error {
+	var processors []DMIProcessor
+	err := json.Unmarshal(dmiSections(data), &processors)
+	if err != nil {
+		return err
+	}
+	*p = processors
+	return nil
+}
+
+// UnmarshalJSON accepts a single section as well as a list of them
+func (d *DMIMemoryDevices) UnmarshalJSON(data []byte) error {
+	var devices []DMIMemoryDevice
+	err := json.Unmarshal(dmiSections(data), &devices)
+	if err != nil {
+		return err
+	}
+	*d = devices
+	return nil
+}
+
+func dmiSections(data []byte) []byte {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return append(append([]byte("["), trimmed...), ']')
+	}
+	return data
+}
+
+// Installed reports whether the memory slot holds a module
+func (d DMIMemoryDevice) Installed() bool {
+	return d.Size != "" && d.Size != "No Module Installed"
+}
+
+// SizeInMB converts the module size dmidecode reports in MB or GB
+func (d DMIMemoryDevice) SizeInMB() (int, error) {
+	var size int
+	var unit string
+	_, err := fmt.Sscanf(d.Size, "%d %s", &size, &unit)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing memory module size %s: %v", d.Size, err)
+	}
+
+	switch unit {
+	case "MB":
+		return size, nil
+	case "GB":
+		return size * 1024, nil
+	}
+	return 0, fmt.Errorf("error parsing memory module size %s: unknown unit %s", d.Size, unit)
+}
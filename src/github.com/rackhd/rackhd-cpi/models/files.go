@@ -6,3 +6,24 @@ type FileUploadResponse struct {
 	Md5    string `json:"md5"`
 	Sha256 string `json:"sha256"`
 }
+
+// FileRecord is a file of the RackHD file store as the files list returns it
+type FileRecord struct {
+	Basename string `json:"basename"`
+	UUID     string `json:"uuid"`
+	Md5      string `json:"md5"`
+	Sha256   string `json:"sha256"`
+}
+
+// StemcellMetadata is what the CPI keeps of a stemcell: its cloud_properties and the file holding its image
+type StemcellMetadata struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	OSType       string `json:"os_type"`
+	OSDistro     string `json:"os_distro"`
+	Architecture string `json:"architecture"`
+	DiskFormat   string `json:"disk_format"`
+	// Image is the file holding the stemcell image, shared by every stemcell uploaded with the same content
+	Image  string `json:"image,omitempty"`
+	Sha256 string `json:"sha256,omitempty"`
+}
@@ -0,0 +1,287 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NodeCatalogs are the catalogs of a node. Only the ohai catalog is collected on every node,
+// the others are left empty when RackHD did not collect them.
+type NodeCatalogs struct {
+	Ohai    CatalogData
+	DMI     DMICatalogData
+	BMC     BMCCatalogData
+	LLDP    map[string]LLDPNeighbor
+	SMART   []SMARTDrive
+	PCI     []PCIDevice
+	Ethtool []EthtoolInterface
+}
+
+// Hardware summarizes the hardware of a node from its catalogs
+type Hardware struct {
+	System     SystemHardware `json:"system"`
+	CPUs       []CPUHardware  `json:"cpus"`
+	MemoryMB   int            `json:"memory_mb"`
+	DIMMs      []DIMMHardware `json:"dimms,omitempty"`
+	PCIDevices []PCIHardware  `json:"pci_devices,omitempty"`
+	NICs       []NICHardware  `json:"nics"`
+	Disks      []DiskHardware `json:"disks"`
+	BMC        BMCHardware    `json:"bmc"`
+}
+
+type SystemHardware struct {
+	Manufacturer string `json:"manufacturer,omitempty"`
+	ProductName  string `json:"product_name,omitempty"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	UUID         string `json:"uuid,omitempty"`
+}
+
+type CPUHardware struct {
+	Socket   string `json:"socket"`
+	Model    string `json:"model"`
+	Cores    int    `json:"cores"`
+	Threads  int    `json:"threads"`
+	SpeedMHz int    `json:"speed_mhz"`
+}
+
+type DIMMHardware struct {
+	Locator      string `json:"locator"`
+	SizeMB       int    `json:"size_mb"`
+	Type         string `json:"type,omitempty"`
+	Speed        string `json:"speed,omitempty"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	PartNumber   string `json:"part_number,omitempty"`
+}
+
+type PCIHardware struct {
+	BusNumber string `json:"bus_number"`
+	Vendor    string `json:"vendor"`
+	Device    string `json:"device"`
+	Class     string `json:"class,omitempty"`
+	Driver    string `json:"driver,omitempty"`
+}
+
+type NICHardware struct {
+	Name       string `json:"name"`
+	MACAddress string `json:"mac_address"`
+	State      string `json:"state"`
+	Speed      string `json:"speed,omitempty"`
+	Driver     string `json:"driver,omitempty"`
+	SwitchName string `json:"switch_name,omitempty"`
+	SwitchPort string `json:"switch_port,omitempty"`
+}
+
+type DiskHardware struct {
+	Name         string `json:"name"`
+	SizeMB       int    `json:"size_mb"`
+	Model        string `json:"model,omitempty"`
+	Vendor       string `json:"vendor,omitempty"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	Health       string `json:"health,omitempty"`
+}
+
+type BMCHardware struct {
+	IPAddress      string `json:"ip_address,omitempty"`
+	MACAddress     string `json:"mac_address,omitempty"`
+	SubnetMask     string `json:"subnet_mask,omitempty"`
+	DefaultGateway string `json:"default_gateway,omitempty"`
+}
+
+// Hardware summarizes the catalogs, preferring dmidecode over ohai where both report a value
+func (c NodeCatalogs) Hardware() Hardware {
+	hardware := Hardware{
+		System: c.system(),
+		CPUs:   c.cpus(),
+		BMC: BMCHardware{
+			IPAddress:      c.BMC.IPAddress,
+			MACAddress:     c.BMC.MACAddress,
+			SubnetMask:     c.BMC.SubnetMask,
+			DefaultGateway: c.BMC.DefaultGateway,
+		},
+		NICs:  c.nics(),
+		Disks: c.disks(),
+	}
+
+	memoryMB, err := c.Ohai.Memory.TotalMB()
+	if err == nil {
+		hardware.MemoryMB = memoryMB
+	}
+
+	for _, device := range c.DMI.MemoryDevices {
+		if !device.Installed() {
+			continue
+		}
+		sizeMB, err := device.SizeInMB()
+		if err != nil {
+			continue
+		}
+		hardware.DIMMs = append(hardware.DIMMs, DIMMHardware{
+			Locator:      device.Locator,
+			SizeMB:       sizeMB,
+			Type:         device.Type,
+			Speed:        device.Speed,
+			Manufacturer: device.Manufacturer,
+			SerialNumber: device.SerialNumber,
+			PartNumber:   device.PartNumber,
+		})
+	}
+
+	for _, device := range c.PCI {
+		hardware.PCIDevices = append(hardware.PCIDevices, PCIHardware{
+			BusNumber: device.BusNumber,
+			Vendor:    device.VendorName,
+			Device:    device.DeviceName,
+			Class:     device.DeviceClass,
+			Driver:    device.Driver,
+		})
+	}
+
+	return hardware
+}
+
+func (c NodeCatalogs) system() SystemHardware {
+	if c.DMI.System.SerialNumber != "" {
+		return SystemHardware{
+			Manufacturer: strings.TrimSpace(c.DMI.System.Manufacturer),
+			ProductName:  strings.TrimSpace(c.DMI.System.ProductName),
+			SerialNumber: strings.TrimSpace(c.DMI.System.SerialNumber),
+			UUID:         c.DMI.System.UUID,
+		}
+	}
+
+	return SystemHardware{
+		Manufacturer: strings.TrimSpace(c.Ohai.DMI.System.Manufacturer),
+		ProductName:  strings.TrimSpace(c.Ohai.DMI.System.ProductName),
+		SerialNumber: strings.TrimSpace(c.Ohai.DMI.System.SerialNumber),
+		UUID:         c.Ohai.DMI.System.UUID,
+	}
+}
+
+// cpus lists one entry per socket. Ohai lists one processor per thread, grouped here by physical id.
+func (c NodeCatalogs) cpus() []CPUHardware {
+	var cpus []CPUHardware
+	if len(c.DMI.Processors) > 0 {
+		for _, processor := range c.DMI.Processors {
+			cores, _ := strconv.Atoi(processor.CoreCount)
+			threads, _ := strconv.Atoi(processor.ThreadCount)
+			cpus = append(cpus, CPUHardware{
+				Socket:   processor.SocketDesignation,
+				Model:    strings.TrimSpace(processor.Version),
+				Cores:    cores,
+				Threads:  threads,
+				SpeedMHz: leadingInt(processor.CurrentSpeed),
+			})
+		}
+		return cpus
+	}
+
+	sockets := map[string]int{}
+	for _, processor := range c.Ohai.CPU.Processors {
+		index, found := sockets[processor.PhysicalID]
+		if !found {
+			cores, _ := strconv.Atoi(processor.Cores)
+			mhz, _ := strconv.ParseFloat(processor.MHz, 64)
+			cpus = append(cpus, CPUHardware{
+				Socket:   processor.PhysicalID,
+				Model:    processor.ModelName,
+				Cores:    cores,
+				SpeedMHz: int(mhz),
+			})
+			index = len(cpus) - 1
+			sockets[processor.PhysicalID] = index
+		}
+		cpus[index].Threads++
+	}
+	return cpus
+}
+
+func (c NodeCatalogs) nics() []NICHardware {
+	ethtool := map[string]EthtoolInterface{}
+	for _, nic := range c.Ethtool {
+		ethtool[nic.Identifier] = nic
+	}
+
+	var nics []NICHardware
+	for name, network := range c.Ohai.NetworkData.Networks {
+		if network.Encapsulation != EthernetNetwork {
+			continue
+		}
+
+		nic := NICHardware{
+			Name:       name,
+			State:      network.State,
+			Speed:      ethtool[name].Settings.Speed,
+			Driver:     ethtool[name].Driver.Driver,
+			SwitchName: c.LLDP[name].Chassis.Name,
+			SwitchPort: c.LLDP[name].Port.IfName,
+		}
+		for address, properties := range network.Addresses {
+			if properties.Family == MacAddressFamily {
+				nic.MACAddress = strings.ToLower(address)
+			}
+		}
+		nics = append(nics, nic)
+	}
+
+	sort.Sort(nicsByName(nics))
+	return nics
+}
+
+func (c NodeCatalogs) disks() []DiskHardware {
+	smart := map[string]SMARTDrive{}
+	for _, drive := range c.SMART {
+		smart[strings.TrimPrefix(drive.OSDeviceName, "/dev/")] = drive
+	}
+
+	var disks []DiskHardware
+	for name, device := range c.Ohai.BlockDevices {
+		if device.Removable == "1" || !LocalDriveNamePattern.MatchString(name) {
+			continue
+		}
+		sizeMB, err := device.SizeInMB()
+		if err != nil || sizeMB == 0 {
+			continue
+		}
+
+		disk := DiskHardware{
+			Name:         name,
+			SizeMB:       sizeMB,
+			Model:        strings.TrimSpace(device.Model),
+			Vendor:       strings.TrimSpace(device.Vendor),
+			SerialNumber: smart[name].SMART.Identity.SerialNumber,
+			Health:       smart[name].SMART.SelfAssessment,
+		}
+		if model := smart[name].SMART.Identity.DeviceModel; model != "" {
+			disk.Model = model
+		}
+		disks = append(disks, disk)
+	}
+
+	sort.Sort(disksByName(disks))
+	return disks
+}
+
+// leadingInt parses values such as "2300 MHz"
+func leadingInt(value string) int {
+	var i int
+	_, err := fmt.Sscanf(value, "%d", &i)
+	if err != nil {
+		return 0
+	}
+	return i
+}
+
+type nicsByName []NICHardware
+
+func (n nicsByName) Len() int           { return len(n) }
+func (n nicsByName) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n nicsByName) Less(i, j int) bool { return n[i].Name < n[j].Name }
+
+type disksByName []DiskHardware
+
+func (d disksByName) Len() int           { return len(d) }
+func (d disksByName) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d disksByName) Less(i, j int) bool { return d[i].Name < d[j].Name }
@@ -1,5 +1,12 @@
 package models
 
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
 const (
 	NetworkActive    = "up"
 	NetworkInactive  = "down"
@@ -9,27 +16,53 @@ const (
 
 const (
 	PersistentDiskLocation = "sdb"
+	EphemeralStripeDevice  = "/dev/md/bosh-ephemeral"
+	DiskByIDDir            = "/dev/disk/by-id/"
 )
 
+// LocalDriveNamePattern matches the kernel names of SCSI, virtio, IDE, Xen and NVMe drives
+var LocalDriveNamePattern = regexp.MustCompile(`^((s|v|h|xv)d[a-z]+|nvme[0-9]+n[0-9]+)$`)
+
 type NodeCatalog struct {
 	Data CatalogData `json:"data"`
 }
 
+// DriveIDCatalog lists the stable identifiers RackHD discovered for the local drives
+type DriveIDCatalog struct {
+	Data []DriveID `json:"data"`
+}
+
+type DriveID struct {
+	DevName   string `json:"devName"`
+	LinuxWWID string `json:"linuxWwid"`
+	SCSIID    string `json:"scsiId"`
+}
+
 type BMCCatalog struct {
 	Data BMCCatalogData `json:"data"`
 }
 
 type BMCCatalogData struct {
-	MACAddress string `json:"MAC Address"`
+	MACAddress      string `json:"MAC Address"`
+	IPAddress       string `json:"IP Address"`
+	IPAddressSource string `json:"IP Address Source"`
+	SubnetMask      string `json:"Subnet Mask"`
+	DefaultGateway  string `json:"Default Gateway IP"`
 }
 
 type Device struct {
-	Size string `json:"size"`
+	Size      string `json:"size"`
+	Removable string `json:"removable"`
+	Model     string `json:"model"`
+	Vendor    string `json:"vendor"`
 }
 
 type CatalogData struct {
 	NetworkData  NetworkCatalog    `json:"network"`
 	BlockDevices map[string]Device `json:"block_device"`
+	CPU          OhaiCPU           `json:"cpu"`
+	Memory       OhaiMemory        `json:"memory"`
+	DMI          OhaiDMI           `json:"dmi"`
 }
 
 type NetworkCatalog struct {
@@ -41,6 +74,7 @@ type Network struct {
 	Number        string                    `json:"number"`
 	Addresses     map[string]NetworkAddress `json:"addresses"`
 	State         string                    `json:"state"`
+	MTU           string                    `json:"mtu"`
 }
 
 type NetworkAddress struct {
@@ -52,21 +86,72 @@ type OBM struct {
 	Ref         string `json:"ref"`
 }
 
+// OBMServiceRequest registers an OBM service on a node. Config holds the shape the service expects.
 type OBMServiceRequest struct {
-	Config      OBMConfig `json:"config"`
-	NodeID      string    `json:"nodeId"`
-	ServiceName string    `json:"service"`
+	Config      interface{} `json:"config"`
+	NodeID      string      `json:"nodeId"`
+	ServiceName string      `json:"service"`
 }
 
+// OBMConfig is the config of the ipmi OBM service, whose host may be the MAC address of the BMC
 type OBMConfig struct {
 	Host     string `json:"host"`
 	Password string `json:"password"`
 	User     string `json:"user"`
 }
 
+// AMTOBMConfig is the config of the amt OBM service, which always logs in as admin
+type AMTOBMConfig struct {
+	Host     string `json:"host"`
+	Password string `json:"password"`
+}
+
+// RedfishOBMConfig is the config of the redfish OBM service
+type RedfishOBMConfig struct {
+	URI      string `json:"uri"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// SNMPOBMConfig is the config of an OBM service switching the PDU outlet a node is plugged into
+type SNMPOBMConfig struct {
+	Host      string `json:"host"`
+	Community string `json:"community"`
+	Port      int    `json:"port"`
+}
+
 type Node struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	Workflows string `json:"workflows"`
-	OBMS      []OBM  `json:"obms"`
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Type        string         `json:"type"`
+	Identifiers []string       `json:"identifiers"`
+	Relations   []NodeRelation `json:"relations"`
+	Workflows   string         `json:"workflows"`
+	OBMS        []OBM          `json:"obms"`
+}
+
+// EnclosedByRelation relates a compute node to the enclosure holding it
+const EnclosedByRelation = "enclosedBy"
+
+// NodeRelation links a node to the enclosure or compute nodes it relates to
+type NodeRelation struct {
+	RelationType string   `json:"relationType"`
+	Targets      []string `json:"targets"`
+}
+
+// SizeInMB converts the catalog size of the device, which the CPI treats as KB, to MB
+func (d Device) SizeInMB() (int, error) {
+	sizeInKB, err := strconv.Atoi(d.Size)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing device size %s: %v", d.Size, err)
+	}
+	return sizeInKB / 1024, nil
+}
+
+// ByIDPath returns the /dev/disk/by-id path of the drive, which embeds its WWN or serial
+func (d DriveID) ByIDPath() string {
+	if d.LinuxWWID == "" || strings.HasPrefix(d.LinuxWWID, DiskByIDDir) {
+		return d.LinuxWWID
+	}
+	return DiskByIDDir + d.LinuxWWID
 }
@@ -1,5 +1,10 @@
 package models
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // status of nodes that will be taged
 const (
 	Blocked     = "blocked"
@@ -14,8 +19,12 @@ type Tags struct {
 // TagNode is a node with and ID and an array of tags
 type TagNode struct {
 	ID             string                 `json:"id"`
+	Name           string                 `json:"name,omitempty"`
 	Tags           []string               `json:"tags"`
 	PersistentDisk PersistentDiskSettings `json:"persistent_disk"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty"`
+	// RelocationSha256 is the SHA-256 of the last disk copied from or to the node
+	RelocationSha256 string `json:"relocation_sha256,omitempty"`
 }
 
 // PersistentDiskSettingsContainer is used to extract persistent_disk from tagnode
@@ -25,8 +34,204 @@ type PersistentDiskSettingsContainer struct {
 
 // PersistentDiskSettings is used to store value for persistent_disk
 type PersistentDiskSettings struct {
+	PregeneratedDisks []PersistentDisk `json:"pregenerated_disks"`
+	Disks             []PersistentDisk `json:"disks"`
+	PendingWipes      []PendingWipe    `json:"pending_wipes,omitempty"`
+}
+
+// PendingWipe is the drive of a deleted disk waiting for its VM to be deleted before it is wiped
+type PendingWipe struct {
+	Location string `json:"location"`
+	DeviceID string `json:"device_id,omitempty"`
+}
+
+// Path is the drive to wipe. The node boots into the microkernel first, which may name its drives
+// differently, so the stable /dev/disk/by-id path is preferred over the kernel device name.
+func (w PendingWipe) Path() string {
+	if w.DeviceID != "" {
+		return w.DeviceID
+	}
+	return w.Location
+}
+
+// PersistentDisk is a persistent disk claimed on one of the local drives of a node
+type PersistentDisk struct {
+	DiskCID    string                 `json:"disk_cid"`
+	Location   string                 `json:"location"`
+	DeviceID   string                 `json:"device_id,omitempty"`
+	SizeInMB   int                    `json:"size"`
+	IsAttached bool                   `json:"attached"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// AgentPath is the path handed to the agent, preferring the stable /dev/disk/by-id path over the kernel device name
+func (d PersistentDisk) AgentPath() string {
+	if d.DeviceID != "" {
+		return d.DeviceID
+	}
+	return d.Location
+}
+
+// legacyPersistentDiskSettings is the single disk persistent_disk written by earlier releases
+type legacyPersistentDiskSettings struct {
 	PregeneratedDiskCID string `json:"pregenerated_disk_cid"`
 	DiskCID             string `json:"disk_cid"`
 	Location            string `json:"location"`
 	IsAttached          bool   `json:"attached"`
 }
+
+// UnmarshalJSON reads persistent_disk, converting the single disk layout of earlier releases
+func (s *PersistentDiskSettings) UnmarshalJSON(data []byte) error {
+	type settings PersistentDiskSettings
+	var current settings
+	err := json.Unmarshal(data, &current)
+	if err != nil {
+		return err
+	}
+
+	var legacy legacyPersistentDiskSettings
+	err = json.Unmarshal(data, &legacy)
+	if err != nil {
+		return err
+	}
+
+	legacyLocation := legacy.Location
+	if legacyLocation == "" {
+		legacyLocation = fmt.Sprintf("/dev/%s", PersistentDiskLocation)
+	}
+	if legacy.PregeneratedDiskCID != "" && len(current.PregeneratedDisks) == 0 {
+		current.PregeneratedDisks = []PersistentDisk{{DiskCID: legacy.PregeneratedDiskCID, Location: legacyLocation}}
+	}
+	if legacy.DiskCID != "" && len(current.Disks) == 0 {
+		current.Disks = []PersistentDisk{{DiskCID: legacy.DiskCID, Location: legacyLocation, IsAttached: legacy.IsAttached}}
+	}
+
+	*s = PersistentDiskSettings(current)
+	return nil
+}
+
+// Disk returns the persistent disk with the given cid
+func (s PersistentDiskSettings) Disk(diskCID string) (PersistentDisk, bool) {
+	for _, disk := range s.Disks {
+		if disk.DiskCID == diskCID {
+			return disk, true
+		}
+	}
+	return PersistentDisk{}, false
+}
+
+// HasAttachedDisk reports whether any persistent disk is attached
+func (s PersistentDiskSettings) HasAttachedDisk() bool {
+	for _, disk := range s.Disks {
+		if disk.IsAttached {
+			return true
+		}
+	}
+	return false
+}
+
+// UsedLocations returns the set of devices backing a persistent disk or waiting to be wiped
+func (s PersistentDiskSettings) UsedLocations() map[string]bool {
+	locations := map[string]bool{}
+	for _, disk := range s.Disks {
+		locations[disk.Location] = true
+	}
+	for _, pending := range s.PendingWipes {
+		locations[pending.Location] = true
+	}
+	return locations
+}
+
+// WithDisk returns a copy of the settings with disk added, replacing any disk with the same cid
+func (s PersistentDiskSettings) WithDisk(disk PersistentDisk) PersistentDiskSettings {
+	result := s.WithoutDisk(disk.DiskCID)
+	result.Disks = append(result.Disks, disk)
+	return result
+}
+
+// WithoutDisk returns a copy of the settings without the disk with the given cid
+func (s PersistentDiskSettings) WithoutDisk(diskCID string) PersistentDiskSettings {
+	result := PersistentDiskSettings{
+		PregeneratedDisks: append([]PersistentDisk{}, s.PregeneratedDisks...),
+		Disks:             []PersistentDisk{},
+		PendingWipes:      s.PendingWipes,
+	}
+	for _, disk := range s.Disks {
+		if disk.DiskCID != diskCID {
+			result.Disks = append(result.Disks, disk)
+		}
+	}
+	return result
+}
+
+// WithDiskState returns a copy of the settings with the attachment state of the given disk changed
+func (s PersistentDiskSettings) WithDiskState(diskCID string, attached bool) (PersistentDiskSettings, error) {
+	disk, found := s.Disk(diskCID)
+	if !found {
+		return s, fmt.Errorf("disk %s not found in persistent disk settings", diskCID)
+	}
+	disk.IsAttached = attached
+
+	result := PersistentDiskSettings{
+		PregeneratedDisks: append([]PersistentDisk{}, s.PregeneratedDisks...),
+		Disks:             make([]PersistentDisk, len(s.Disks)),
+		PendingWipes:      s.PendingWipes,
+	}
+	for i, d := range s.Disks {
+		if d.DiskCID == diskCID {
+			d = disk
+		}
+		result.Disks[i] = d
+	}
+	return result, nil
+}
+
+// Detached returns a copy of the settings with every disk detached
+func (s PersistentDiskSettings) Detached() PersistentDiskSettings {
+	result := PersistentDiskSettings{
+		PregeneratedDisks: append([]PersistentDisk{}, s.PregeneratedDisks...),
+		Disks:             make([]PersistentDisk, len(s.Disks)),
+		PendingWipes:      s.PendingWipes,
+	}
+	for i, disk := range s.Disks {
+		disk.IsAttached = false
+		result.Disks[i] = disk
+	}
+	return result
+}
+
+// WithPendingWipe returns a copy of the settings recording that the drive of disk must be wiped
+func (s PersistentDiskSettings) WithPendingWipe(disk PersistentDisk) PersistentDiskSettings {
+	result := s
+	result.PendingWipes = append([]PendingWipe{}, s.PendingWipes...)
+	for _, pending := range s.PendingWipes {
+		if pending.Location == disk.Location {
+			return result
+		}
+	}
+	result.PendingWipes = append(result.PendingWipes, PendingWipe{Location: disk.Location, DeviceID: disk.DeviceID})
+	return result
+}
+
+// PendingWipePaths returns the paths of the drives waiting to be wiped
+func (s PersistentDiskSettings) PendingWipePaths() []string {
+	paths := []string{}
+	for _, pending := range s.PendingWipes {
+		paths = append(paths, pending.Path())
+	}
+	return paths
+}
+
+// WithoutPendingWipes returns a copy of the settings with no drive waiting to be wiped
+func (s PersistentDiskSettings) WithoutPendingWipes() PersistentDiskSettings {
+	result := s
+	result.PendingWipes = nil
+	return result
+}
+
+// WithoutPregeneratedDisks returns a copy of the settings with no pregenerated disk cid
+func (s PersistentDiskSettings) WithoutPregeneratedDisks() PersistentDiskSettings {
+	result := s
+	result.PregeneratedDisks = []PersistentDisk{}
+	return result
+}
@@ -0,0 +1,10 @@
+package models
+
+// OnHTTPPackage is the RackHD package serving the API
+const OnHTTPPackage = "on-http"
+
+// PackageVersion is the version of one RackHD package
+type PackageVersion struct {
+	Package string `json:"package"`
+	Version string `json:"version"`
+}
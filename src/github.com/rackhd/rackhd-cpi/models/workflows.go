@@ -1,8 +1,10 @@
 package models
 
 const (
-	OBMSettingIPMIServiceName = "ipmi-obm-service"
-	OBMSettingAMTServiceName  = "amt-obm-service"
+	OBMSettingIPMIServiceName    = "ipmi-obm-service"
+	OBMSettingAMTServiceName     = "amt-obm-service"
+	OBMSettingRedfishServiceName = "redfish-obm-service"
+	OBMSettingSNMPServiceName    = "snmp-obm-service"
 )
 
 const (
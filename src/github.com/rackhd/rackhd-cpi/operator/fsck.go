@@ -0,0 +1,214 @@
+package operator
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/cpi"
+	"github.com/rackhd/rackhd-cpi/models"
+	"github.com/rackhd/rackhd-cpi/rackhdapi"
+)
+
+// Classes of drift between the tags of a node and its persistent_disk field
+const (
+	// more than one vm_cid- tag; which VM is real needs an operator
+	InconsistencyMultipleVMCIDs = "multiple_vm_cids"
+	// a disk is marked attached although the node runs no VM; repaired by detaching it
+	InconsistencyAttachedWithoutVM = "attached_without_vm"
+	// a disk of persistent_disk has no disk_cid- tag, so the director cannot find it; repaired by tagging it
+	InconsistencyDiskWithoutTag = "disk_without_tag"
+	// a disk_cid- tag names no disk of persistent_disk; where its data lives needs an operator
+	InconsistencyTagWithoutDisk = "tag_without_disk"
+	// a node running a VM or holding disks lacks the unavailable tag and may be reserved and wiped;
+	// repaired by tagging it unavailable
+	InconsistencyNotReserved = "not_reserved"
+)
+
+// Inconsistency is one drift found on a node, with the safe fix when there is one
+type Inconsistency struct {
+	NodeID     string `json:"node_id"`
+	Class      string `json:"class"`
+	Detail     string `json:"detail"`
+	Repairable bool   `json:"repairable"`
+	Repaired   bool   `json:"repaired"`
+	Error      string `json:"error,omitempty"`
+
+	repair func() error
+}
+
+// Fsck scans every compute node for inconsistent CPI state. Unless repair is set it only reports,
+// otherwise it applies the safe fixes and records which succeeded.
+func Fsck(c config.Cpi, repair bool) ([]Inconsistency, error) {
+	nodes, err := rackhdapi.GetNodesWithType(c, "compute")
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(nodesByID(nodes))
+
+	found := []Inconsistency{}
+	for _, n := range nodes {
+		node, err := rackhdapi.GetTagNode(c, n.ID)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, nodeInconsistencies(c, node)...)
+	}
+
+	if !repair {
+		return found, nil
+	}
+
+	for i := range found {
+		if !found[i].Repairable {
+			continue
+		}
+		log.Info(fmt.Sprintf("repairing %s on node %s: %s", found[i].Class, found[i].NodeID, found[i].Detail))
+		err = found[i].repair()
+		if err != nil {
+			found[i].Error = err.Error()
+			continue
+		}
+		found[i].Repaired = true
+	}
+	return found, nil
+}
+
+func nodeInconsistencies(c config.Cpi, node models.TagNode) []Inconsistency {
+	var found []Inconsistency
+
+	var vmCIDs []string
+	diskTags := map[string]bool{}
+	for _, tag := range node.Tags {
+		switch {
+		case strings.HasPrefix(tag, cpi.VMCIDTagPrefix):
+			vmCIDs = append(vmCIDs, tag)
+		case strings.HasPrefix(tag, cpi.DiskCIDTagPrefix):
+			diskTags[tag] = true
+		}
+	}
+	sort.Strings(vmCIDs)
+
+	if len(vmCIDs) > 1 {
+		found = append(found, Inconsistency{
+			NodeID: node.ID,
+			Class:  InconsistencyMultipleVMCIDs,
+			Detail: strings.Join(vmCIDs, ", "),
+		})
+	}
+
+	if len(vmCIDs) == 0 && node.PersistentDisk.HasAttachedDisk() {
+		var attached []string
+		for _, disk := range node.PersistentDisk.Disks {
+			if disk.IsAttached {
+				attached = append(attached, disk.DiskCID)
+			}
+		}
+		settings := node.PersistentDisk.Detached()
+		found = append(found, Inconsistency{
+			NodeID:     node.ID,
+			Class:      InconsistencyAttachedWithoutVM,
+			Detail:     strings.Join(attached, ", "),
+			Repairable: true,
+			repair: func() error {
+				return rackhdapi.PatchPersistentDiskSettings(c, node.ID, settings)
+			},
+		})
+	}
+
+	disks := map[string]bool{}
+	for _, disk := range node.PersistentDisk.Disks {
+		disks[disk.DiskCID] = true
+		if diskTags[disk.DiskCID] {
+			continue
+		}
+		diskCID := disk.DiskCID
+		found = append(found, Inconsistency{
+			NodeID:     node.ID,
+			Class:      InconsistencyDiskWithoutTag,
+			Detail:     diskCID,
+			Repairable: true,
+			repair: func() error {
+				return rackhdapi.CreateTag(c, node.ID, diskCID)
+			},
+		})
+	}
+
+	var untracked []string
+	for tag := range diskTags {
+		if !disks[tag] {
+			untracked = append(untracked, tag)
+		}
+	}
+	sort.Strings(untracked)
+	for _, tag := range untracked {
+		found = append(found, Inconsistency{
+			NodeID: node.ID,
+			Class:  InconsistencyTagWithoutDisk,
+			Detail: tag,
+		})
+	}
+
+	held := map[string]bool{}
+	for cid := range disks {
+		held[cid] = true
+	}
+	for cid := range diskTags {
+		held[cid] = true
+	}
+	heldCIDs := append([]string{}, vmCIDs...)
+	for cid := range held {
+		heldCIDs = append(heldCIDs, cid)
+	}
+	sort.Strings(heldCIDs[len(vmCIDs):])
+
+	if len(heldCIDs) > 0 && !hasTag(node.Tags, models.Unavailable) {
+		found = append(found, Inconsistency{
+			NodeID:     node.ID,
+			Class:      InconsistencyNotReserved,
+			Detail:     strings.Join(heldCIDs, ", "),
+			Repairable: true,
+			repair: func() error {
+				return rackhdapi.CreateTag(c, node.ID, models.Unavailable)
+			},
+		})
+	}
+
+	return found
+}
+
+// WriteInconsistencies writes the fsck report as a table with one inconsistency per row, or as a JSON list
+func WriteInconsistencies(w io.Writer, format string, found []Inconsistency) error {
+	if format == OutputJSON {
+		return writeJSON(w, found)
+	}
+
+	if len(found) == 0 {
+		_, err := fmt.Fprintln(w, "no inconsistencies found")
+		return err
+	}
+
+	t := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(t, "NODE\tCLASS\tDETAIL\tFIX")
+	for _, inconsistency := range found {
+		fmt.Fprintf(t, "%s\t%s\t%s\t%s\n", inconsistency.NodeID, inconsistency.Class, inconsistency.Detail, inconsistency.fix())
+	}
+	return t.Flush()
+}
+
+func (i Inconsistency) fix() string {
+	switch {
+	case !i.Repairable:
+		return "manual"
+	case i.Error != "":
+		return fmt.Sprintf("failed: %s", i.Error)
+	case i.Repaired:
+		return "repaired"
+	}
+	return "repairable"
+}
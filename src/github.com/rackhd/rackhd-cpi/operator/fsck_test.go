@@ -0,0 +1,105 @@
+package operator_test
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/helpers"
+	"github.com/rackhd/rackhd-cpi/operator"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Fsck", func() {
+	var server *ghttp.Server
+	var cpiConfig config.Cpi
+
+	BeforeEach(func() {
+		server, _, cpiConfig, _ = helpers.SetUp("")
+
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/api/2.0/nodes", "type=compute"),
+				ghttp.RespondWith(http.StatusOK, []byte(`[{"id": "b"}, {"id": "a"}]`)),
+			),
+		)
+
+		// a runs two VMs and holds a disk whose tag is missing, next to a tag naming no disk
+		helpers.AddHandler(server, "GET", "/api/2.0/nodes/a", 200, []byte(`{"id": "a", "tags": "/api/2.0/nodes/a/tags", "persistent_disk": {"disks": [{"disk_cid": "disk_cid-1", "location": "/dev/sdb", "attached": true}]}}`))
+		helpers.AddHandler(server, "GET", "/api/2.0/nodes/a/tags", 200, []byte(`["unavailable", "vm_cid-2", "vm_cid-1", "disk_cid-2"]`))
+		// b lost its VM and its unavailable tag but still marks its disk attached
+		helpers.AddHandler(server, "GET", "/api/2.0/nodes/b", 200, []byte(`{"id": "b", "tags": "/api/2.0/nodes/b/tags", "persistent_disk": {"disks": [{"disk_cid": "disk_cid-3", "location": "/dev/sdb", "attached": true}]}}`))
+		helpers.AddHandler(server, "GET", "/api/2.0/nodes/b/tags", 200, []byte(`["disk_cid-3"]`))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("reports every inconsistency without changing anything by default", func() {
+		found, err := operator.Fsck(cpiConfig, false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.ReceivedRequests()).To(HaveLen(5))
+
+		var classes []string
+		for _, inconsistency := range found {
+			Expect(inconsistency.Repaired).To(BeFalse())
+			classes = append(classes, inconsistency.NodeID+" "+inconsistency.Class)
+		}
+		Expect(classes).To(Equal([]string{
+			"a " + operator.InconsistencyMultipleVMCIDs,
+			"a " + operator.InconsistencyDiskWithoutTag,
+			"a " + operator.InconsistencyTagWithoutDisk,
+			"b " + operator.InconsistencyAttachedWithoutVM,
+			"b " + operator.InconsistencyNotReserved,
+		}))
+
+		out := &bytes.Buffer{}
+		Expect(operator.WriteInconsistencies(out, operator.OutputTable, found)).To(Succeed())
+		Expect(out.String()).To(Equal(
+			"NODE  CLASS                DETAIL              FIX\n" +
+				"a     multiple_vm_cids     vm_cid-1, vm_cid-2  manual\n" +
+				"a     disk_without_tag     disk_cid-1          repairable\n" +
+				"a     tag_without_disk     disk_cid-2          manual\n" +
+				"b     attached_without_vm  disk_cid-3          repairable\n" +
+				"b     not_reserved         disk_cid-3          repairable\n"))
+	})
+
+	It("applies the safe fixes with repair", func() {
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/a/tags"),
+				ghttp.VerifyJSON(`{"tags": ["disk_cid-1"]}`),
+				ghttp.RespondWith(http.StatusOK, nil),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/b"),
+				ghttp.VerifyJSON(`{"persistent_disk": {"pregenerated_disks": [], "disks": [{"disk_cid": "disk_cid-3", "location": "/dev/sdb", "size": 0, "attached": false}]}}`),
+				ghttp.RespondWith(http.StatusOK, nil),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/b/tags"),
+				ghttp.RespondWith(http.StatusInternalServerError, nil),
+			),
+		)
+
+		found, err := operator.Fsck(cpiConfig, true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.ReceivedRequests()).To(HaveLen(8))
+
+		Expect(found[1].Repaired).To(BeTrue())
+		Expect(found[3].Repaired).To(BeTrue())
+		Expect(found[4].Repaired).To(BeFalse())
+		Expect(found[4].Error).To(ContainSubstring("500"))
+
+		out := &bytes.Buffer{}
+		Expect(operator.WriteInconsistencies(out, operator.OutputJSON, found[:2])).To(Succeed())
+		Expect(out.String()).To(MatchJSON(`[
+			{"node_id": "a", "class": "multiple_vm_cids", "detail": "vm_cid-1, vm_cid-2", "repairable": false, "repaired": false},
+			{"node_id": "a", "class": "disk_without_tag", "detail": "disk_cid-1", "repairable": true, "repaired": true}
+		]`))
+	})
+})
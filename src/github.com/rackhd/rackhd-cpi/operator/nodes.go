@@ -0,0 +1,248 @@
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/cpi"
+	"github.com/rackhd/rackhd-cpi/models"
+	"github.com/rackhd/rackhd-cpi/rackhdapi"
+)
+
+// States of a node in the pool, derived from its tags
+const (
+	StateFree     = "free"
+	StateReserved = "reserved"
+	StateInUse    = "in_use"
+	StateDisks    = "holds_disks"
+	StateBlocked  = "blocked"
+)
+
+// BlockReasonMetadataKey is the node metadata recording why an operator blocked the node
+const BlockReasonMetadataKey = "block_reason"
+
+// NodeSummary is one node of the pool as the operator commands report it
+type NodeSummary struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	State       string   `json:"state"`
+	VMCID       string   `json:"vm_cid,omitempty"`
+	DiskCIDs    []string `json:"disk_cids"`
+	BlockReason string   `json:"block_reason,omitempty"`
+	Tags        []string `json:"tags"`
+}
+
+// NodeDetails adds the persistent disks and metadata of a node to its summary
+type NodeDetails struct {
+	NodeSummary
+	PersistentDisk models.PersistentDiskSettings `json:"persistent_disk"`
+	Metadata       map[string]interface{}        `json:"metadata"`
+}
+
+// NodeState derives the state of a node from its tags. A blocked node is reported blocked whatever else it holds.
+func NodeState(tags []string) string {
+	state := StateFree
+	for _, tag := range tags {
+		switch {
+		case tag == models.Blocked:
+			return StateBlocked
+		case strings.HasPrefix(tag, cpi.VMCIDTagPrefix):
+			state = StateInUse
+		case strings.HasPrefix(tag, cpi.DiskCIDTagPrefix) && state != StateInUse:
+			state = StateDisks
+		case tag == models.Unavailable && state == StateFree:
+			state = StateReserved
+		}
+	}
+	return state
+}
+
+func summarize(id string, name string, tags []string, metadata map[string]interface{}) NodeSummary {
+	summary := NodeSummary{
+		ID:       id,
+		Name:     name,
+		State:    NodeState(tags),
+		DiskCIDs: []string{},
+		Tags:     tags,
+	}
+
+	for _, tag := range tags {
+		switch {
+		case strings.HasPrefix(tag, cpi.VMCIDTagPrefix):
+			summary.VMCID = tag
+		case strings.HasPrefix(tag, cpi.DiskCIDTagPrefix):
+			summary.DiskCIDs = append(summary.DiskCIDs, tag)
+		}
+	}
+	sort.Strings(summary.DiskCIDs)
+
+	if reason, ok := metadata[BlockReasonMetadataKey].(string); ok {
+		summary.BlockReason = reason
+	}
+	return summary
+}
+
+// ListNodes returns the compute nodes of the pool ordered by id
+func ListNodes(c config.Cpi) ([]NodeSummary, error) {
+	nodes, err := rackhdapi.GetNodesWithType(c, "compute")
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(nodesByID(nodes))
+
+	summaries := []NodeSummary{}
+	for _, node := range nodes {
+		tags, err := rackhdapi.GetTags(c, node.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting tags of node %s: %s", node.ID, err)
+		}
+		summaries = append(summaries, summarize(node.ID, node.Name, tags, nil))
+	}
+	return summaries, nil
+}
+
+// ShowNode returns the node nodeID with its persistent disks and metadata
+func ShowNode(c config.Cpi, nodeID string) (NodeDetails, error) {
+	node, err := rackhdapi.GetNode(c, nodeID)
+	if err != nil {
+		return NodeDetails{}, err
+	}
+
+	tagNode, err := rackhdapi.GetTagNode(c, nodeID)
+	if err != nil {
+		return NodeDetails{}, err
+	}
+
+	return details(node.Name, tagNode), nil
+}
+
+// FindVM returns the node running the VM vmCID
+func FindVM(c config.Cpi, vmCID string) (NodeDetails, error) {
+	tagNode, err := rackhdapi.GetNodeByVMCID(c, vmCID)
+	if err != nil {
+		return NodeDetails{}, err
+	}
+
+	node, err := rackhdapi.GetNode(c, tagNode.ID)
+	if err != nil {
+		return NodeDetails{}, err
+	}
+
+	return details(node.Name, tagNode), nil
+}
+
+func details(name string, tagNode models.TagNode) NodeDetails {
+	metadata := tagNode.Metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+
+	return NodeDetails{
+		NodeSummary:    summarize(tagNode.ID, name, tagNode.Tags, metadata),
+		PersistentDisk: tagNode.PersistentDisk,
+		Metadata:       metadata,
+	}
+}
+
+// BlockNode keeps the CPI from reserving nodeID and records reason in the node metadata
+func BlockNode(c config.Cpi, nodeID string, reason string) error {
+	node, err := rackhdapi.GetTagNode(c, nodeID)
+	if err != nil {
+		return err
+	}
+
+	metadata := map[string]interface{}{}
+	for key, value := range node.Metadata {
+		metadata[key] = value
+	}
+	if reason != "" {
+		metadata[BlockReasonMetadataKey] = reason
+	}
+
+	err = setMetadata(c, nodeID, metadata)
+	if err != nil {
+		return err
+	}
+
+	return rackhdapi.CreateTag(c, nodeID, models.Blocked)
+}
+
+// UnblockNode lets the CPI reserve nodeID again and forgets why it was blocked
+func UnblockNode(c config.Cpi, nodeID string) error {
+	node, err := rackhdapi.GetTagNode(c, nodeID)
+	if err != nil {
+		return err
+	}
+
+	if !hasTag(node.Tags, models.Blocked) {
+		return fmt.Errorf("node %s is not blocked", nodeID)
+	}
+
+	err = rackhdapi.DeleteTag(c, nodeID, models.Blocked)
+	if err != nil {
+		return err
+	}
+
+	if _, found := node.Metadata[BlockReasonMetadataKey]; !found {
+		return nil
+	}
+
+	metadata := map[string]interface{}{}
+	for key, value := range node.Metadata {
+		if key != BlockReasonMetadataKey {
+			metadata[key] = value
+		}
+	}
+	return setMetadata(c, nodeID, metadata)
+}
+
+// ReleaseNode returns a node left reserved by an interrupted create_vm to the pool.
+// Nodes running a VM or holding persistent disks are never released.
+func ReleaseNode(c config.Cpi, nodeID string) error {
+	tags, err := rackhdapi.GetTags(c, nodeID)
+	if err != nil {
+		return err
+	}
+
+	switch NodeState(tags) {
+	case StateReserved:
+		return rackhdapi.ReleaseNode(c, nodeID)
+	case StateFree:
+		return fmt.Errorf("node %s is not reserved", nodeID)
+	default:
+		summary := summarize(nodeID, "", tags, nil)
+		if summary.VMCID != "" {
+			return fmt.Errorf("node %s runs VM %s, delete the VM instead", nodeID, summary.VMCID)
+		}
+		if len(summary.DiskCIDs) > 0 {
+			return fmt.Errorf("node %s holds disks %s, delete or relocate them first", nodeID, strings.Join(summary.DiskCIDs, ", "))
+		}
+		return fmt.Errorf("node %s is %s", nodeID, summary.State)
+	}
+}
+
+func setMetadata(c config.Cpi, nodeID string, metadata map[string]interface{}) error {
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("error marshalling metadata of node %s: %s", nodeID, err)
+	}
+	return rackhdapi.SetNodeMetadata(c, nodeID, string(metadataBytes))
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+type nodesByID []models.Node
+
+func (n nodesByID) Len() int           { return len(n) }
+func (n nodesByID) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n nodesByID) Less(i, j int) bool { return n[i].ID < n[j].ID }
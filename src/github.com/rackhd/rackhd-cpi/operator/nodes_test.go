@@ -0,0 +1,159 @@
+package operator_test
+
+import (
+	"net/http"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/helpers"
+	"github.com/rackhd/rackhd-cpi/operator"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Nodes", func() {
+	var server *ghttp.Server
+	var cpiConfig config.Cpi
+
+	BeforeEach(func() {
+		server, _, cpiConfig, _ = helpers.SetUp("")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("NodeState", func() {
+		It("derives the state from the tags", func() {
+			Expect(operator.NodeState([]string{})).To(Equal(operator.StateFree))
+			Expect(operator.NodeState([]string{"unavailable", "node-id"})).To(Equal(operator.StateReserved))
+			Expect(operator.NodeState([]string{"unavailable", "disk_cid-1"})).To(Equal(operator.StateDisks))
+			Expect(operator.NodeState([]string{"disk_cid-1", "unavailable", "vm_cid-1"})).To(Equal(operator.StateInUse))
+			Expect(operator.NodeState([]string{"unavailable", "vm_cid-1", "blocked"})).To(Equal(operator.StateBlocked))
+		})
+	})
+
+	Describe("ListNodes", func() {
+		It("lists the compute nodes ordered by id with their state", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/2.0/nodes", "type=compute"),
+					ghttp.RespondWith(http.StatusOK, []byte(`[{"id": "b", "name": "node-b"}, {"id": "a", "name": "node-a"}]`)),
+				),
+			)
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a/tags", 200, []byte(`["unavailable", "a", "vm_cid-1", "disk_cid-2", "disk_cid-1"]`))
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/b/tags", 200, []byte(`[]`))
+
+			nodes, err := operator.ListNodes(cpiConfig)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nodes).To(HaveLen(2))
+			Expect(nodes[0].ID).To(Equal("a"))
+			Expect(nodes[0].State).To(Equal(operator.StateInUse))
+			Expect(nodes[0].VMCID).To(Equal("vm_cid-1"))
+			Expect(nodes[0].DiskCIDs).To(Equal([]string{"disk_cid-1", "disk_cid-2"}))
+			Expect(nodes[1].ID).To(Equal("b"))
+			Expect(nodes[1].State).To(Equal(operator.StateFree))
+		})
+	})
+
+	Describe("ShowNode", func() {
+		It("returns the node with its disks, metadata and block reason", func() {
+			nodeBody := []byte(`{"id": "a", "name": "node-a", "tags": "/api/2.0/nodes/a/tags", "metadata": {"block_reason": "bad DIMM"}, "persistent_disk": {"disks": [{"disk_cid": "disk_cid-1", "location": "/dev/sdb", "size": 1024}]}}`)
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a", 200, nodeBody)
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a", 200, nodeBody)
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a/tags", 200, []byte(`["unavailable", "disk_cid-1", "blocked"]`))
+
+			node, err := operator.ShowNode(cpiConfig, "a")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(node.Name).To(Equal("node-a"))
+			Expect(node.State).To(Equal(operator.StateBlocked))
+			Expect(node.BlockReason).To(Equal("bad DIMM"))
+			Expect(node.PersistentDisk.Disks).To(HaveLen(1))
+			Expect(node.Metadata).To(HaveKeyWithValue("block_reason", "bad DIMM"))
+		})
+	})
+
+	Describe("BlockNode", func() {
+		It("records the reason and tags the node blocked", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a", 200, []byte(`{"id": "a", "tags": "/api/2.0/nodes/a/tags", "metadata": {"rack": "r1"}}`))
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a/tags", 200, []byte(`[]`))
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/a"),
+					ghttp.VerifyJSON(`{"metadata": {"rack": "r1", "block_reason": "bad DIMM"}}`),
+					ghttp.RespondWith(http.StatusOK, nil),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/a/tags"),
+					ghttp.VerifyJSON(`{"tags": ["blocked"]}`),
+					ghttp.RespondWith(http.StatusOK, nil),
+				),
+			)
+
+			Expect(operator.BlockNode(cpiConfig, "a", "bad DIMM")).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(4))
+		})
+	})
+
+	Describe("UnblockNode", func() {
+		It("removes the blocked tag and the reason", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a", 200, []byte(`{"id": "a", "tags": "/api/2.0/nodes/a/tags", "metadata": {"rack": "r1", "block_reason": "bad DIMM"}}`))
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a/tags", 200, []byte(`["blocked"]`))
+			helpers.AddHandler(server, "DELETE", "/api/2.0/nodes/a/tags/blocked", 204, nil)
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PATCH", "/api/2.0/nodes/a"),
+					ghttp.VerifyJSON(`{"metadata": {"rack": "r1"}}`),
+					ghttp.RespondWith(http.StatusOK, nil),
+				),
+			)
+
+			Expect(operator.UnblockNode(cpiConfig, "a")).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(4))
+		})
+
+		It("returns an error when the node is not blocked", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a", 200, []byte(`{"id": "a", "tags": "/api/2.0/nodes/a/tags"}`))
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a/tags", 200, []byte(`[]`))
+
+			Expect(operator.UnblockNode(cpiConfig, "a")).To(MatchError("node a is not blocked"))
+		})
+	})
+
+	Describe("ReleaseNode", func() {
+		It("removes the unavailable tag of a reserved node", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a/tags", 200, []byte(`["unavailable", "a"]`))
+			helpers.AddHandler(server, "DELETE", "/api/2.0/nodes/a/tags/unavailable", 204, nil)
+
+			Expect(operator.ReleaseNode(cpiConfig, "a")).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("refuses to release a node running a VM", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a/tags", 200, []byte(`["unavailable", "vm_cid-1"]`))
+
+			Expect(operator.ReleaseNode(cpiConfig, "a")).To(MatchError("node a runs VM vm_cid-1, delete the VM instead"))
+		})
+
+		It("refuses to release a node holding disks", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a/tags", 200, []byte(`["unavailable", "disk_cid-1"]`))
+
+			Expect(operator.ReleaseNode(cpiConfig, "a")).To(MatchError("node a holds disks disk_cid-1, delete or relocate them first"))
+		})
+	})
+
+	Describe("FindVM", func() {
+		It("returns the node tagged with the vm cid", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/tags/vm_cid-1/nodes", 200, []byte(`[{"id": "a", "tags": ["unavailable", "vm_cid-1"], "metadata": {"job": "router"}}]`))
+			helpers.AddHandler(server, "GET", "/api/2.0/nodes/a", 200, []byte(`{"id": "a", "name": "node-a"}`))
+
+			node, err := operator.FindVM(cpiConfig, "vm_cid-1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(node.ID).To(Equal("a"))
+			Expect(node.Name).To(Equal("node-a"))
+			Expect(node.VMCID).To(Equal("vm_cid-1"))
+			Expect(node.Metadata).To(HaveKeyWithValue("job", "router"))
+		})
+	})
+})
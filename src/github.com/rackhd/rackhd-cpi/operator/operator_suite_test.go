@@ -0,0 +1,18 @@
+package operator_test
+
+import (
+	"io/ioutil"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestOperator(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Operator Suite")
+}
@@ -0,0 +1,116 @@
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats of the operator commands
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+// WriteNodes writes nodes as a table with one node per row, or as a JSON list
+func WriteNodes(w io.Writer, format string, nodes []NodeSummary) error {
+	if format == OutputJSON {
+		return writeJSON(w, nodes)
+	}
+
+	t := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(t, "ID\tNAME\tSTATE\tVM CID\tDISKS\tBLOCK REASON")
+	for _, node := range nodes {
+		fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%s\t%s\n", node.ID, dash(node.Name), node.State, dash(node.VMCID), dash(strings.Join(node.DiskCIDs, ",")), dash(node.BlockReason))
+	}
+	return t.Flush()
+}
+
+// WriteNode writes one node as a table of fields followed by its persistent disks and metadata, or as a JSON object
+func WriteNode(w io.Writer, format string, node NodeDetails) error {
+	if format == OutputJSON {
+		return writeJSON(w, node)
+	}
+
+	t := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(t, "ID\t%s\n", node.ID)
+	fmt.Fprintf(t, "NAME\t%s\n", dash(node.Name))
+	fmt.Fprintf(t, "STATE\t%s\n", node.State)
+	fmt.Fprintf(t, "VM CID\t%s\n", dash(node.VMCID))
+	fmt.Fprintf(t, "BLOCK REASON\t%s\n", dash(node.BlockReason))
+	fmt.Fprintf(t, "TAGS\t%s\n", dash(strings.Join(node.Tags, ",")))
+	err := t.Flush()
+	if err != nil {
+		return err
+	}
+
+	if len(node.PersistentDisk.Disks) > 0 {
+		fmt.Fprintln(w)
+		t = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(t, "DISK CID\tLOCATION\tSIZE (MB)\tATTACHED")
+		for _, disk := range node.PersistentDisk.Disks {
+			fmt.Fprintf(t, "%s\t%s\t%d\t%t\n", disk.DiskCID, disk.AgentPath(), disk.SizeInMB, disk.IsAttached)
+		}
+		err = t.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(node.Metadata) > 0 {
+		var keys []string
+		for key := range node.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintln(w)
+		t = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(t, "METADATA\tVALUE")
+		for _, key := range keys {
+			fmt.Fprintf(t, "%s\t%v\n", key, node.Metadata[key])
+		}
+		err = t.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteStemcell writes a stemcell as a table of fields, or as a JSON object
+func WriteStemcell(w io.Writer, format string, stemcell StemcellDetails) error {
+	if format == OutputJSON {
+		return writeJSON(w, stemcell)
+	}
+
+	t := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(t, "CID\t%s\n", stemcell.CID)
+	fmt.Fprintf(t, "NAME\t%s\n", dash(stemcell.Name))
+	fmt.Fprintf(t, "VERSION\t%s\n", dash(stemcell.Version))
+	fmt.Fprintf(t, "OS\t%s\n", dash(strings.TrimSpace(stemcell.OSType+" "+stemcell.OSDistro)))
+	fmt.Fprintf(t, "ARCHITECTURE\t%s\n", dash(stemcell.Architecture))
+	fmt.Fprintf(t, "DISK FORMAT\t%s\n", dash(stemcell.DiskFormat))
+	fmt.Fprintf(t, "IMAGE\t%s\n", dash(stemcell.Image))
+	return t.Flush()
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling output: %s", err)
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
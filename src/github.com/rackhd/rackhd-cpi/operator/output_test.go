@@ -0,0 +1,93 @@
+package operator_test
+
+import (
+	"bytes"
+
+	"github.com/rackhd/rackhd-cpi/models"
+	"github.com/rackhd/rackhd-cpi/operator"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Output", func() {
+	Describe("WriteNodes", func() {
+		nodes := []operator.NodeSummary{
+			{ID: "a", Name: "node-a", State: operator.StateInUse, VMCID: "vm_cid-1", DiskCIDs: []string{"disk_cid-1"}, Tags: []string{"vm_cid-1", "disk_cid-1"}},
+			{ID: "b", State: operator.StateBlocked, DiskCIDs: []string{}, BlockReason: "bad DIMM", Tags: []string{"blocked"}},
+		}
+
+		It("writes a table", func() {
+			out := &bytes.Buffer{}
+			Expect(operator.WriteNodes(out, operator.OutputTable, nodes)).To(Succeed())
+			Expect(out.String()).To(Equal(
+				"ID  NAME    STATE    VM CID    DISKS       BLOCK REASON\n" +
+					"a   node-a  in_use   vm_cid-1  disk_cid-1  -\n" +
+					"b   -       blocked  -         -           bad DIMM\n"))
+		})
+
+		It("writes JSON", func() {
+			out := &bytes.Buffer{}
+			Expect(operator.WriteNodes(out, operator.OutputJSON, nodes)).To(Succeed())
+			Expect(out.String()).To(MatchJSON(`[
+				{"id": "a", "name": "node-a", "state": "in_use", "vm_cid": "vm_cid-1", "disk_cids": ["disk_cid-1"], "tags": ["vm_cid-1", "disk_cid-1"]},
+				{"id": "b", "name": "", "state": "blocked", "disk_cids": [], "block_reason": "bad DIMM", "tags": ["blocked"]}
+			]`))
+		})
+	})
+
+	Describe("WriteNode", func() {
+		It("writes the fields, disks and metadata as tables", func() {
+			node := operator.NodeDetails{
+				NodeSummary: operator.NodeSummary{ID: "a", Name: "node-a", State: operator.StateInUse, VMCID: "vm_cid-1", Tags: []string{"vm_cid-1"}},
+				PersistentDisk: models.PersistentDiskSettings{
+					Disks: []models.PersistentDisk{{DiskCID: "disk_cid-1", Location: "/dev/sdb", SizeInMB: 1024, IsAttached: true}},
+				},
+				Metadata: map[string]interface{}{"job": "router", "index": float64(0)},
+			}
+
+			out := &bytes.Buffer{}
+			Expect(operator.WriteNode(out, operator.OutputTable, node)).To(Succeed())
+			Expect(out.String()).To(Equal(
+				"ID            a\n" +
+					"NAME          node-a\n" +
+					"STATE         in_use\n" +
+					"VM CID        vm_cid-1\n" +
+					"BLOCK REASON  -\n" +
+					"TAGS          vm_cid-1\n" +
+					"\n" +
+					"DISK CID    LOCATION  SIZE (MB)  ATTACHED\n" +
+					"disk_cid-1  /dev/sdb  1024       true\n" +
+					"\n" +
+					"METADATA  VALUE\n" +
+					"index     0\n" +
+					"job       router\n"))
+		})
+	})
+
+	Describe("WriteStemcell", func() {
+		stemcell := operator.StemcellDetails{
+			CID:              "stemcell-1",
+			StemcellMetadata: models.StemcellMetadata{Name: "ubuntu-raw", Version: "3263.8", OSType: "linux", OSDistro: "ubuntu", DiskFormat: "raw", Image: "stemcell-image-abc", Sha256: "abc"},
+		}
+
+		It("writes a table", func() {
+			out := &bytes.Buffer{}
+			Expect(operator.WriteStemcell(out, operator.OutputTable, stemcell)).To(Succeed())
+			Expect(out.String()).To(Equal(
+				"CID           stemcell-1\n" +
+					"NAME          ubuntu-raw\n" +
+					"VERSION       3263.8\n" +
+					"OS            linux ubuntu\n" +
+					"ARCHITECTURE  -\n" +
+					"DISK FORMAT   raw\n" +
+					"IMAGE         stemcell-image-abc\n"))
+		})
+
+		It("writes JSON", func() {
+			out := &bytes.Buffer{}
+			Expect(operator.WriteStemcell(out, operator.OutputJSON, stemcell)).To(Succeed())
+			Expect(out.String()).To(MatchJSON(`{"cid": "stemcell-1", "name": "ubuntu-raw", "version": "3263.8", "os_type": "linux", "os_distro": "ubuntu", "architecture": "", "disk_format": "raw", "image": "stemcell-image-abc", "sha256": "abc"}`))
+		})
+	})
+})
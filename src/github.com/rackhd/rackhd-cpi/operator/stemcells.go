@@ -0,0 +1,23 @@
+package operator
+
+import (
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/cpi"
+	"github.com/rackhd/rackhd-cpi/models"
+)
+
+// StemcellDetails is an uploaded stemcell with the cloud properties it was created from
+type StemcellDetails struct {
+	CID string `json:"cid"`
+	models.StemcellMetadata
+}
+
+// ShowStemcell returns the stemcell stemcellCID with its metadata
+func ShowStemcell(c config.Cpi, stemcellCID string) (StemcellDetails, error) {
+	metadata, err := cpi.GetStemcellMetadata(c, stemcellCID)
+	if err != nil {
+		return StemcellDetails{}, err
+	}
+
+	return StemcellDetails{CID: stemcellCID, StemcellMetadata: metadata}, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"runtime/debug"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/rackhd/rackhd-cpi/bosh"
 	"github.com/rackhd/rackhd-cpi/config"
 	"github.com/rackhd/rackhd-cpi/cpi"
+	"github.com/rackhd/rackhd-cpi/workflows"
 )
 
 var responseLogBuffer *bytes.Buffer
@@ -32,14 +34,92 @@ func exitWithNotImplementedError(err error) {
 	os.Exit(1)
 }
 
+func exitWithNotSupportedError(err error) {
+	fmt.Println(bosh.BuildErrorResponse(err, bosh.NotSupportedErrorType, false, responseLogBuffer.String()))
+	responseLogBuffer.Reset()
+	os.Exit(1)
+}
+
 func exitWithResult(result interface{}) {
 	fmt.Println(bosh.BuildResultResponse(result, responseLogBuffer.String()))
 	responseLogBuffer.Reset()
 	os.Exit(0)
 }
 
+func exitOnPanic() {
+	if r := recover(); r != nil {
+		log.Error(fmt.Sprintf("recovered from panic: %v", r))
+		fmt.Println(bosh.BuildPanicResponse(r, debug.Stack(), responseLogBuffer.String()))
+		responseLogBuffer.Reset()
+		os.Exit(1)
+	}
+}
+
+// relocateDisk is the operator command copying a detached persistent disk to another free node
+func relocateDisk(configFile io.Reader, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "usage: rackhd-cpi -configPath <path> relocate-disk <disk_cid> [target_node_id]")
+		os.Exit(2)
+	}
+
+	var targetNodeID string
+	if len(args) == 2 {
+		targetNodeID = args[1]
+	}
+
+	cpiConfig, err := config.New(configFile, bosh.CpiRequest{Method: bosh.CREATE_DISK})
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
+	nodeID, err := cpi.RelocateDisk(cpiConfig, args[0], targetNodeID)
+	if err != nil {
+		log.Error(fmt.Sprintf("Error relocating disk %s: %s", args[0], err))
+		os.Exit(1)
+	}
+
+	fmt.Printf("disk %s relocated to node %s\n", args[0], nodeID)
+	os.Exit(0)
+}
+
+// validateConfig prints every problem of the config file without contacting RackHD
+func validateConfig(configFile io.Reader) {
+	problems := config.Validate(configFile)
+	if len(problems) == 0 {
+		fmt.Println("config is valid")
+		os.Exit(0)
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	os.Exit(1)
+}
+
+// checkEnvironment is the operator command reporting whether RackHD is ready for the CPI
+func checkEnvironment(configFile io.Reader) {
+	cpiConfig, err := config.New(configFile, bosh.CpiRequest{})
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+
+	checks := workflows.CheckEnvironment(cpiConfig)
+	for _, check := range checks {
+		fmt.Println(check)
+	}
+
+	if workflows.EnvironmentError(checks) != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
 func main() {
 	responseLogBuffer = new(bytes.Buffer)
+	defer exitOnPanic()
+
 	multiWriter := io.MultiWriter(os.Stderr, responseLogBuffer)
 	logLevel := os.Getenv("RACKHD_CPI_LOG_LEVEL")
 	log.SetOutput(multiWriter)
@@ -58,6 +138,7 @@ func main() {
 	}
 
 	configPath := flag.String("configPath", "", "Path to configuration file")
+	validate := flag.Bool("validate-config", false, "Report every problem of the configuration file and exit")
 	flag.Parse()
 
 	file, err := os.Open(*configPath)
@@ -68,6 +149,30 @@ func main() {
 		exitWithDefaultError(err)
 	}
 
+	if *validate {
+		validateConfig(file)
+	}
+
+	if flag.Arg(0) == "relocate-disk" {
+		relocateDisk(file, flag.Args()[1:])
+	}
+
+	if flag.Arg(0) == "nodes" || flag.Arg(0) == "vms" || flag.Arg(0) == "stemcells" {
+		runOperatorCommand(file, flag.Args())
+	}
+
+	if flag.Arg(0) == "fsck" {
+		runFsck(file, flag.Args()[1:])
+	}
+
+	if flag.Arg(0) == "adopt" {
+		runAdopt(file, flag.Args()[1:])
+	}
+
+	if flag.Arg(0) == "check" {
+		checkEnvironment(file)
+	}
+
 	reqBytes, err := ioutil.ReadAll(os.Stdin)
 	if err != nil {
 		exitWithDefaultError(err)
@@ -95,77 +200,148 @@ func main() {
 
 	switch req.Method {
 	case bosh.CREATE_STEMCELL:
-		cid, err := cpi.CreateStemcell(cpiConfig, req.Arguments)
+		input, err := bosh.NewCreateStemcellArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing CreateStemcell arguments: %s", err))
+		}
+		cid, err := cpi.CreateStemcell(cpiConfig, input)
 		if err != nil {
 			exitWithDefaultError(fmt.Errorf("Error running CreateStemcell: %s", err))
 		}
 		exitWithResult(cid)
 	case bosh.CREATE_VM:
-		vmcid, err := cpi.CreateVM(cpiConfig, req.Arguments)
+		input, err := bosh.NewCreateVMArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing CreateVM arguments: %s", err))
+		}
+		vmcid, err := cpi.CreateVM(cpiConfig, input)
 		if err != nil {
 			exitWithDefaultError(fmt.Errorf("Error running CreateVM: %s", err))
 		}
 		exitWithResult(vmcid)
 	case bosh.DELETE_STEMCELL:
-		err = cpi.DeleteStemcell(cpiConfig, req.Arguments)
+		input, err := bosh.NewDeleteStemcellArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing DeleteStemcell arguments: %s", err))
+		}
+		err = cpi.DeleteStemcell(cpiConfig, input)
 		if err != nil {
 			exitWithDefaultError(fmt.Errorf("Error running DeleteStemcell: %s", err))
 		}
 		exitWithResult("")
 	case bosh.DELETE_VM:
-		err = cpi.DeleteVM(cpiConfig, req.Arguments)
+		input, err := bosh.NewDeleteVMArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing DeleteVM arguments: %s", err))
+		}
+		err = cpi.DeleteVM(cpiConfig, input)
 		if err != nil {
 			exitWithDefaultError(fmt.Errorf("Error running DeleteVM: %s", err))
 		}
 		exitWithResult("")
 	case bosh.SET_VM_METADATA:
-		err := cpi.SetVMMetadata(cpiConfig, req.Arguments)
+		input, err := bosh.NewSetVMMetadataArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing SetVMMetadata arguments: %s", err))
+		}
+		err = cpi.SetVMMetadata(cpiConfig, input)
 		if err != nil {
 			exitWithDefaultError(fmt.Errorf("Error running SetVMMetadata: %s", err))
 		}
 		exitWithResult("")
 	case bosh.HAS_VM:
-		hasVM, err := cpi.HasVM(cpiConfig, req.Arguments)
+		input, err := bosh.NewHasVMArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing HasVM arguments: %s", err))
+		}
+		hasVM, err := cpi.HasVM(cpiConfig, input)
 		if err != nil {
 			exitWithDefaultError(fmt.Errorf("Error running HasVM: %s", err))
 		}
 		exitWithResult(hasVM)
 	case bosh.CREATE_DISK:
-		diskCID, err := cpi.CreateDisk(cpiConfig, req.Arguments)
+		input, err := bosh.NewCreateDiskArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing CreateDisk arguments: %s", err))
+		}
+		diskCID, err := cpi.CreateDisk(cpiConfig, input)
 		if err != nil {
 			exitWithDefaultError(fmt.Errorf("Error running CreateDisk: %s", err))
 		}
 		exitWithResult(diskCID)
 	case bosh.DELETE_DISK:
-		err := cpi.DeleteDisk(cpiConfig, req.Arguments)
+		input, err := bosh.NewDeleteDiskArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing DeleteDisk arguments: %s", err))
+		}
+		err = cpi.DeleteDisk(cpiConfig, input)
 		if err != nil {
 			exitWithDefaultError(fmt.Errorf("Error running DeleteDisk: %s", err))
 		}
 		exitWithResult("")
 	case bosh.ATTACH_DISK:
-		err := cpi.AttachDisk(cpiConfig, req.Arguments)
+		input, err := bosh.NewAttachDiskArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing AttachDisk arguments: %s", err))
+		}
+		err = cpi.AttachDisk(cpiConfig, input)
 		if err != nil {
 			exitWithDefaultError(fmt.Errorf("Error running AttachDisk: %s", err))
 		}
 		exitWithResult("")
 	case bosh.DETACH_DISK:
-		err := cpi.DetachDisk(cpiConfig, req.Arguments)
+		input, err := bosh.NewDetachDiskArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing DetachDisk arguments: %s", err))
+		}
+		err = cpi.DetachDisk(cpiConfig, input)
 		if err != nil {
 			exitWithDefaultError(fmt.Errorf("Error running DetachDisk: %s", err))
 		}
 		exitWithResult("")
 	case bosh.HAS_DISK:
-		diskExists, err := cpi.HasDisk(cpiConfig, req.Arguments)
+		input, err := bosh.NewHasDiskArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing HasDisk arguments: %s", err))
+		}
+		diskExists, err := cpi.HasDisk(cpiConfig, input)
 		if err != nil {
 			exitWithDefaultError(fmt.Errorf("Error running HasDisk: %s", err))
 		}
 		exitWithResult(diskExists)
 	case bosh.GET_DISKS:
-		diskCIDs, err := cpi.GetDisks(cpiConfig, req.Arguments)
+		input, err := bosh.NewGetDisksArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing GetDisks arguments: %s", err))
+		}
+		diskCIDs, err := cpi.GetDisks(cpiConfig, input)
 		if err != nil {
 			exitWithDefaultError(fmt.Errorf("Error running GetDisks: %s", err))
 		}
 		exitWithResult(diskCIDs)
+	case bosh.SET_DISK_METADATA:
+		input, err := bosh.NewSetDiskMetadataArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing SetDiskMetadata arguments: %s", err))
+		}
+		err = cpi.SetDiskMetadata(cpiConfig, input)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error running SetDiskMetadata: %s", err))
+		}
+		exitWithResult("")
+	case bosh.RESIZE_DISK:
+		input, err := bosh.NewResizeDiskArguments(req.Arguments)
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error parsing ResizeDisk arguments: %s", err))
+		}
+		err = cpi.ResizeDisk(cpiConfig, input)
+		if _, notSupported := err.(bosh.NotSupportedError); notSupported {
+			exitWithNotSupportedError(err)
+		}
+		if err != nil {
+			exitWithDefaultError(fmt.Errorf("Error running ResizeDisk: %s", err))
+		}
+		exitWithResult("")
 	default:
 		exitWithDefaultError(fmt.Errorf("Unexpected command: %s dispatched  .aborting", req.Method))
 	}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rackhd/rackhd-cpi/bosh"
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/cpi"
+	"github.com/rackhd/rackhd-cpi/models"
+	"github.com/rackhd/rackhd-cpi/operator"
+)
+
+const operatorUsage = `usage: rackhd-cpi -configPath <path> <command> [-output table|json]
+
+commands:
+  nodes list                      list the compute nodes and their state
+  nodes show <node_id>            show a node with its persistent disks and metadata
+  nodes block <node_id> -reason   keep the CPI from using a node
+  nodes unblock <node_id>         let the CPI use a blocked node again
+  nodes release <node_id>         return a node left reserved by a failed create_vm to the pool
+  vms find <vm_cid>               show the node running a VM
+  stemcells show <stemcell_cid>   show the cloud properties a stemcell was created from
+  fsck [-repair]                  report inconsistent node state, and fix what is safe to fix with -repair
+  adopt <node_id> -agent-id -network -env-file [-system-disk] [-ephemeral-disk] [-persistent-disk] [-public-key]
+                                  record a server installed outside of BOSH as a VM and write the env of its agent`
+
+// runOperatorCommand runs the nodes, vms and stemcells commands operators use to inspect and manage the pool
+func runOperatorCommand(configFile io.Reader, args []string) {
+	if len(args) < 2 {
+		exitWithUsage()
+	}
+
+	flags := flag.NewFlagSet(args[0]+" "+args[1], flag.ExitOnError)
+	output := flags.String("output", operator.OutputTable, "output format, table or json")
+	reason := flags.String("reason", "", "why the node is blocked")
+	positional := parseInterspersed(flags, args[2:])
+
+	cpiConfig := operatorConfig(configFile, *output)
+
+	switch args[0] + " " + args[1] {
+	case "nodes list":
+		requireArgs(positional, 0)
+		nodes, err := operator.ListNodes(cpiConfig)
+		exitOnOperatorError(err)
+		exitOnOperatorError(operator.WriteNodes(os.Stdout, *output, nodes))
+	case "nodes show":
+		requireArgs(positional, 1)
+		node, err := operator.ShowNode(cpiConfig, positional[0])
+		exitOnOperatorError(err)
+		exitOnOperatorError(operator.WriteNode(os.Stdout, *output, node))
+	case "nodes block":
+		requireArgs(positional, 1)
+		if *reason == "" {
+			fmt.Fprintln(os.Stderr, "nodes block needs a -reason")
+			os.Exit(2)
+		}
+		exitOnOperatorError(operator.BlockNode(cpiConfig, positional[0], *reason))
+		printNode(cpiConfig, positional[0], *output)
+	case "nodes unblock":
+		requireArgs(positional, 1)
+		exitOnOperatorError(operator.UnblockNode(cpiConfig, positional[0]))
+		printNode(cpiConfig, positional[0], *output)
+	case "nodes release":
+		requireArgs(positional, 1)
+		exitOnOperatorError(operator.ReleaseNode(cpiConfig, positional[0]))
+		printNode(cpiConfig, positional[0], *output)
+	case "vms find":
+		requireArgs(positional, 1)
+		node, err := operator.FindVM(cpiConfig, positional[0])
+		exitOnOperatorError(err)
+		exitOnOperatorError(operator.WriteNode(os.Stdout, *output, node))
+	case "stemcells show":
+		requireArgs(positional, 1)
+		stemcell, err := operator.ShowStemcell(cpiConfig, positional[0])
+		exitOnOperatorError(err)
+		exitOnOperatorError(operator.WriteStemcell(os.Stdout, *output, stemcell))
+	default:
+		exitWithUsage()
+	}
+
+	os.Exit(0)
+}
+
+// runFsck reports the inconsistent state of the compute nodes and exits non-zero while any is left
+func runFsck(configFile io.Reader, args []string) {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	output := flags.String("output", operator.OutputTable, "output format, table or json")
+	repair := flags.Bool("repair", false, "apply the safe fixes instead of only reporting")
+	requireArgs(parseInterspersed(flags, args), 0)
+
+	cpiConfig := operatorConfig(configFile, *output)
+
+	found, err := operator.Fsck(cpiConfig, *repair)
+	exitOnOperatorError(err)
+	exitOnOperatorError(operator.WriteInconsistencies(os.Stdout, *output, found))
+
+	for _, inconsistency := range found {
+		if !inconsistency.Repaired {
+			os.Exit(1)
+		}
+	}
+	os.Exit(0)
+}
+
+// runAdopt tags a running server as a VM of the CPI and writes the agent env to copy onto it
+func runAdopt(configFile io.Reader, args []string) {
+	flags := flag.NewFlagSet("adopt", flag.ExitOnError)
+	output := flags.String("output", operator.OutputTable, "output format, table or json")
+	agentID := flags.String("agent-id", "", "id of the agent taking over the server")
+	networkFile := flags.String("network", "", "path to the JSON network spec of the VM, as BOSH passes it to create_vm")
+	envFile := flags.String("env-file", "", "path to write the agent env to")
+	systemDisk := flags.String("system-disk", "/dev/sda", "drive the stemcell is installed on")
+	ephemeralDisk := flags.String("ephemeral-disk", "", "drive the agent uses for its ephemeral data")
+	persistentDisk := flags.String("persistent-disk", "", "drive holding the persistent data of the server")
+	publicKeyFile := flags.String("public-key", "", "path to the public key allowed to log in to the VM")
+	positional := parseInterspersed(flags, args)
+	requireArgs(positional, 1)
+
+	if *agentID == "" || *networkFile == "" || *envFile == "" {
+		fmt.Fprintln(os.Stderr, "adopt needs an -agent-id, a -network and an -env-file")
+		os.Exit(2)
+	}
+
+	cpiConfig := operatorConfig(configFile, *output)
+
+	networkBytes, err := ioutil.ReadFile(*networkFile)
+	exitOnOperatorError(err)
+	var networks map[string]bosh.Network
+	err = json.Unmarshal(networkBytes, &networks)
+	if err != nil {
+		exitOnOperatorError(fmt.Errorf("error parsing network spec %s: %s", *networkFile, err))
+	}
+
+	var publicKey string
+	if *publicKeyFile != "" {
+		publicKeyBytes, err := ioutil.ReadFile(*publicKeyFile)
+		exitOnOperatorError(err)
+		publicKey = strings.TrimSpace(string(publicKeyBytes))
+	}
+
+	adopted, err := cpi.AdoptVM(cpiConfig, cpi.AdoptVMArguments{
+		NodeID:         positional[0],
+		AgentID:        *agentID,
+		PublicKey:      publicKey,
+		Networks:       networks,
+		SystemDisk:     *systemDisk,
+		EphemeralDisk:  *ephemeralDisk,
+		PersistentDisk: *persistentDisk,
+	})
+	exitOnOperatorError(err)
+
+	envBytes, err := json.Marshal(adopted.AgentEnv)
+	exitOnOperatorError(err)
+	exitOnOperatorError(ioutil.WriteFile(*envFile, envBytes, 0600))
+	log.Info(fmt.Sprintf("copy %s to %s on the server and restart its agent", *envFile, models.RackHDEnvPath))
+
+	printNode(cpiConfig, positional[0], *output)
+	os.Exit(0)
+}
+
+// operatorConfig loads the config of an operator command
+func operatorConfig(configFile io.Reader, output string) config.Cpi {
+	if output != operator.OutputTable && output != operator.OutputJSON {
+		fmt.Fprintf(os.Stderr, "output must be %s or %s, got %q\n", operator.OutputTable, operator.OutputJSON, output)
+		os.Exit(2)
+	}
+
+	cpiConfig, err := config.New(configFile, bosh.CpiRequest{})
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+	return cpiConfig
+}
+
+func printNode(c config.Cpi, nodeID string, output string) {
+	node, err := operator.ShowNode(c, nodeID)
+	exitOnOperatorError(err)
+	exitOnOperatorError(operator.WriteNode(os.Stdout, output, node))
+}
+
+// parseInterspersed parses flags given before, between and after the positional arguments
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func requireArgs(args []string, count int) {
+	if len(args) != count {
+		exitWithUsage()
+	}
+}
+
+func exitWithUsage() {
+	fmt.Fprintln(os.Stderr, operatorUsage)
+	os.Exit(2)
+}
+
+func exitOnOperatorError(err error) {
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+}
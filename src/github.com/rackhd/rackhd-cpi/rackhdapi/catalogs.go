@@ -0,0 +1,100 @@
+package rackhdapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/models"
+)
+
+// GetNodeCatalogs returns every catalog RackHD collected for a node. The ohai catalog is required,
+// catalogs of the other sources are left empty when RackHD did not collect them.
+func GetNodeCatalogs(c config.Cpi, nodeID string) (models.NodeCatalogs, error) {
+	ohai, err := GetNodeCatalog(c, nodeID)
+	if err != nil {
+		return models.NodeCatalogs{}, err
+	}
+
+	var dmi models.DMICatalog
+	var bmc models.BMCCatalog
+	var lldp models.LLDPCatalog
+	var smart models.SMARTCatalog
+	var lspci models.LSPCICatalog
+	var ethtool models.EthtoolCatalog
+
+	catalogs := []struct {
+		source  string
+		catalog interface{}
+	}{
+		{models.DMICatalogSource, &dmi},
+		{models.BMCCatalogSource, &bmc},
+		{models.LLDPCatalogSource, &lldp},
+		{models.SMARTCatalogSource, &smart},
+		{models.LSPCICatalogSource, &lspci},
+		{models.EthtoolCatalogSource, &ethtool},
+	}
+	for _, catalog := range catalogs {
+		found, err := getCatalog(c, nodeID, catalog.source, catalog.catalog)
+		if err != nil {
+			return models.NodeCatalogs{}, err
+		}
+		if !found {
+			log.Debug(fmt.Sprintf("node %s has no %s catalog", nodeID, catalog.source))
+		}
+	}
+
+	return models.NodeCatalogs{
+		Ohai:    ohai.Data,
+		DMI:     dmi.Data,
+		BMC:     bmc.Data,
+		LLDP:    lldp.Data,
+		SMART:   smart.Data,
+		PCI:     lspci.Data,
+		Ethtool: ethtool.Data,
+	}, nil
+}
+
+// GetNodeHardware summarizes the hardware of a node from its catalogs
+func GetNodeHardware(c config.Cpi, nodeID string) (models.Hardware, error) {
+	catalogs, err := GetNodeCatalogs(c, nodeID)
+	if err != nil {
+		return models.Hardware{}, err
+	}
+
+	return catalogs.Hardware(), nil
+}
+
+// getCatalog unmarshals the catalog of the given source into catalog and reports whether the node has one
+func getCatalog(c config.Cpi, nodeID string, source string, catalog interface{}) (bool, error) {
+	catalogURL := fmt.Sprintf("%s/api/2.0/nodes/%s/catalogs/%s", c.ApiServer, nodeID, source)
+	resp, err := httpClient(c).Get(catalogURL)
+	if err != nil {
+		return false, fmt.Errorf("error getting %s catalog %s", source, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if resp.StatusCode != 200 {
+		return false, fmt.Errorf("Failed getting %s catalog with status: %s", source, resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("error reading %s catalog body %s", source, err)
+	}
+
+	err = json.Unmarshal(b, catalog)
+	if err != nil {
+		return false, fmt.Errorf("error unmarshal %s catalog body %s", source, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,188 @@
+package rackhdapi_test
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/helpers"
+	"github.com/rackhd/rackhd-cpi/models"
+	"github.com/rackhd/rackhd-cpi/rackhdapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Catalogs", func() {
+	var server *ghttp.Server
+	var c config.Cpi
+	nodeID := "583f2dec08a459ab6085a867"
+
+	catalogHandler := func(source string, fixture string) http.HandlerFunc {
+		return ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/%s", nodeID, source)),
+			ghttp.RespondWith(http.StatusOK, helpers.LoadJSON(fixture)),
+		)
+	}
+
+	missingCatalogHandler := func(source string) http.HandlerFunc {
+		return ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/%s", nodeID, source)),
+			ghttp.RespondWith(http.StatusNotFound, nil),
+		)
+	}
+
+	BeforeEach(func() {
+		server, _, c, _ = helpers.SetUp("")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("the ohai catalog", func() {
+		It("lists the processors and the memory", func() {
+			catalog := helpers.LoadNodeCatalog("../spec_assets/dummy_node_catalog_response.json")
+
+			Expect(catalog.Data.CPU.Total).To(Equal(2))
+			Expect(catalog.Data.CPU.Processors).To(HaveLen(2))
+			Expect(catalog.Data.CPU.Processors[1]).To(Equal(models.OhaiProcessor{
+				VendorID:   "GenuineIntel",
+				ModelName:  "Intel Core Processor (Haswell)",
+				MHz:        "2593.750",
+				PhysicalID: "1",
+				CoreID:     "0",
+				Cores:      "1",
+			}))
+
+			memoryMB, err := catalog.Data.Memory.TotalMB()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(memoryMB).To(Equal(7276))
+			Expect(catalog.Data.DMI.System.SerialNumber).To(Equal("754958"))
+		})
+	})
+
+	Describe("GetNodeCatalogs", func() {
+		It("fetches the catalog of every source", func() {
+			server.AppendHandlers(
+				catalogHandler("ohai", "../spec_assets/dummy_node_catalog_response.json"),
+				catalogHandler("dmi", "../spec_assets/dummy_dmi_catalog_response.json"),
+				catalogHandler("bmc", "../spec_assets/dummy_bmc_catalog_response.json"),
+				catalogHandler("lldp", "../spec_assets/dummy_lldp_catalog_response.json"),
+				catalogHandler("smart", "../spec_assets/dummy_smart_catalog_response.json"),
+				catalogHandler("lspci", "../spec_assets/dummy_lspci_catalog_response.json"),
+				catalogHandler("ethtool", "../spec_assets/dummy_ethtool_catalog_response.json"),
+			)
+
+			catalogs, err := rackhdapi.GetNodeCatalogs(c, nodeID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(7))
+
+			Expect(catalogs.DMI.System.ProductName).To(Equal("PowerEdge R630"))
+			Expect(catalogs.DMI.Processors).To(HaveLen(2))
+			Expect(catalogs.DMI.MemoryDevices).To(HaveLen(3))
+			Expect(catalogs.BMC.IPAddress).To(Equal("172.31.128.150"))
+			Expect(catalogs.LLDP["eth0"].Port.IfName).To(Equal("Ethernet12"))
+			Expect(catalogs.SMART[1].SMART.Identity.SerialNumber).To(Equal("Z4023V9K"))
+			Expect(catalogs.PCI[0].Driver).To(Equal("e1000"))
+			Expect(catalogs.Ethtool[0].Settings.Speed).To(Equal("1000Mb/s"))
+		})
+
+		It("leaves out the catalogs RackHD did not collect", func() {
+			server.AppendHandlers(
+				catalogHandler("ohai", "../spec_assets/dummy_node_catalog_response.json"),
+				missingCatalogHandler("dmi"),
+				missingCatalogHandler("bmc"),
+				missingCatalogHandler("lldp"),
+				missingCatalogHandler("smart"),
+				missingCatalogHandler("lspci"),
+				missingCatalogHandler("ethtool"),
+			)
+
+			catalogs, err := rackhdapi.GetNodeCatalogs(c, nodeID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(catalogs.DMI).To(Equal(models.DMICatalogData{}))
+			Expect(catalogs.SMART).To(BeEmpty())
+			Expect(catalogs.Ohai.BlockDevices).To(HaveKey("sda"))
+		})
+
+		It("gives up on RackHD after the HTTP timeout", func() {
+			c.Timeouts.HTTP = config.Duration(50 * time.Millisecond)
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/ohai", nodeID)),
+					func(w http.ResponseWriter, r *http.Request) { time.Sleep(500 * time.Millisecond) },
+				),
+			)
+
+			_, err := rackhdapi.GetNodeCatalogs(c, nodeID)
+			Expect(err).To(MatchError(ContainSubstring("timeout awaiting response headers")))
+		})
+
+		It("returns an error when the ohai catalog is missing", func() {
+			server.AppendHandlers(missingCatalogHandler("ohai"))
+
+			_, err := rackhdapi.GetNodeCatalogs(c, nodeID)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("GetNodeHardware", func() {
+		It("summarizes the hardware from every catalog", func() {
+			server.AppendHandlers(
+				catalogHandler("ohai", "../spec_assets/dummy_node_catalog_response.json"),
+				catalogHandler("dmi", "../spec_assets/dummy_dmi_catalog_response.json"),
+				catalogHandler("bmc", "../spec_assets/dummy_bmc_catalog_response.json"),
+				catalogHandler("lldp", "../spec_assets/dummy_lldp_catalog_response.json"),
+				catalogHandler("smart", "../spec_assets/dummy_smart_catalog_response.json"),
+				catalogHandler("lspci", "../spec_assets/dummy_lspci_catalog_response.json"),
+				catalogHandler("ethtool", "../spec_assets/dummy_ethtool_catalog_response.json"),
+			)
+
+			hardware, err := rackhdapi.GetNodeHardware(c, nodeID)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(hardware.System).To(Equal(models.SystemHardware{
+				Manufacturer: "Dell Inc.",
+				ProductName:  "PowerEdge R630",
+				SerialNumber: "95SF082",
+				UUID:         "4C4C4544-0035-5310-8046-B9C04F303832",
+			}))
+			Expect(hardware.CPUs).To(Equal([]models.CPUHardware{
+				{Socket: "CPU1", Model: "Intel(R) Xeon(R) CPU E5-2699 v3 @ 2.30GHz", Cores: 18, Threads: 36, SpeedMHz: 2300},
+				{Socket: "CPU2", Model: "Intel(R) Xeon(R) CPU E5-2699 v3 @ 2.30GHz", Cores: 18, Threads: 36, SpeedMHz: 2300},
+			}))
+			Expect(hardware.MemoryMB).To(Equal(7276))
+			Expect(hardware.DIMMs).To(HaveLen(2))
+			Expect(hardware.DIMMs[1].SizeMB).To(Equal(16384))
+			Expect(hardware.PCIDevices).To(HaveLen(2))
+			Expect(hardware.NICs).To(Equal([]models.NICHardware{
+				{Name: "eth0", MACAddress: "52:54:be:ef:fd:e0", State: "up", Speed: "1000Mb/s", Driver: "e1000", SwitchName: "tor-switch-1", SwitchPort: "Ethernet12"},
+			}))
+			Expect(hardware.Disks).To(Equal([]models.DiskHardware{
+				{Name: "sda", SizeMB: 16384, Model: "ST1200MM0088", Vendor: "ATA", SerialNumber: "Z4023V1F", Health: "PASSED"},
+				{Name: "sdb", SizeMB: 16384, Model: "ST1200MM0088", Vendor: "ATA", SerialNumber: "Z4023V9K", Health: "FAILED"},
+			}))
+			Expect(hardware.BMC).To(Equal(models.BMCHardware{
+				IPAddress:      "172.31.128.150",
+				MACAddress:     "52:54:be:ef:fd:e1",
+				SubnetMask:     "255.255.240.0",
+				DefaultGateway: "172.31.128.1",
+			}))
+		})
+
+		It("falls back to ohai when dmidecode was not collected", func() {
+			catalogs := models.NodeCatalogs{Ohai: helpers.LoadNodeCatalog("../spec_assets/dummy_node_catalog_response.json").Data}
+
+			hardware := catalogs.Hardware()
+			Expect(hardware.System.SerialNumber).To(Equal("754958"))
+			Expect(hardware.CPUs).To(Equal([]models.CPUHardware{
+				{Socket: "0", Model: "Intel Core Processor (Haswell)", Cores: 1, Threads: 1, SpeedMHz: 2593},
+				{Socket: "1", Model: "Intel Core Processor (Haswell)", Cores: 1, Threads: 1, SpeedMHz: 2593},
+			}))
+			Expect(hardware.Disks[0]).To(Equal(models.DiskHardware{Name: "sda", SizeMB: 16384, Model: "QEMU HARDDISK", Vendor: "ATA"}))
+		})
+	})
+})
@@ -0,0 +1,29 @@
+package rackhdapi
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/helpers"
+)
+
+var (
+	clientsMutex sync.Mutex
+	clients      = map[config.Duration]*http.Client{}
+)
+
+// httpClient returns the client for every request to RackHD, built once per HTTP timeout so that
+// create_vm can override the timeout of the configuration with its cloud properties
+func httpClient(c config.Cpi) *http.Client {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	client, ok := clients[c.Timeouts.HTTP]
+	if !ok {
+		client = helpers.NewHTTPClient(time.Duration(c.Timeouts.HTTP))
+		clients[c.Timeouts.HTTP] = client
+	}
+	return client
+}
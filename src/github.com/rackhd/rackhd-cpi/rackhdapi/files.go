@@ -21,7 +21,7 @@ func UploadFile(c config.Cpi, baseName string, r io.Reader, contentLength int64)
 	}
 	request.ContentLength = contentLength
 
-	respBody, err := helpers.MakeConfigRequest(request, []int{201})
+	respBody, err := helpers.MakeConfigRequest(httpClient(c), request, []int{201})
 	if err != nil {
 		return models.FileUploadResponse{}, fmt.Errorf("Error making request %s", err)
 	}
@@ -36,10 +36,13 @@ func UploadFile(c config.Cpi, baseName string, r io.Reader, contentLength int64)
 }
 
 func DeleteFile(c config.Cpi, fileName string) error {
-	fileMetadata, err := getFileMetadata(c, fileName)
+	fileMetadata, found, err := GetFileMetadata(c, fileName)
 	if err != nil {
 		return err
 	}
+	if !found {
+		return fmt.Errorf("Error deleting file %s: no such file", fileName)
+	}
 
 	err = deleteFile(c, fileMetadata.UUID)
 	if err != nil {
@@ -56,7 +59,7 @@ func GetFile(c config.Cpi, baseName string) ([]byte, error) {
 		return []byte{}, fmt.Errorf("Error building request to api server: %s", err)
 	}
 
-	respBody, err := helpers.MakeConfigRequest(request, []int{200})
+	respBody, err := helpers.MakeConfigRequest(httpClient(c), request, []int{200})
 	if err != nil {
 		return []byte{}, fmt.Errorf("Error making request %s", err)
 	}
@@ -64,29 +67,61 @@ func GetFile(c config.Cpi, baseName string) ([]byte, error) {
 	return respBody, nil
 }
 
+// ListFiles returns the latest version of every file of the file store whose name starts with prefix
+func ListFiles(c config.Cpi, prefix string) ([]models.FileRecord, error) {
+	url := fmt.Sprintf("%s/api/2.0/files", c.ApiServer)
+	request, err := http.NewRequest("GET", url, strings.NewReader(""))
+	if err != nil {
+		return nil, fmt.Errorf("Error building request to api server: %s", err)
+	}
+
+	respBody, err := helpers.MakeConfigRequest(httpClient(c), request, []int{200})
+	if err != nil {
+		return nil, fmt.Errorf("Error making request %s", err)
+	}
+
+	var files []models.FileRecord
+	err = json.Unmarshal(respBody, &files)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshalling files: %s", err)
+	}
+
+	matching := []models.FileRecord{}
+	for _, file := range files {
+		if strings.HasPrefix(file.Basename, prefix) {
+			matching = append(matching, file)
+		}
+	}
+	return matching, nil
+}
+
 func deleteFile(c config.Cpi, fileUUID string) error {
 	url := fmt.Sprintf("%s/api/2.0/files/%s", c.ApiServer, fileUUID)
-	_, err := helpers.MakeRequestWithMultiCode(url, "DELETE", []int{204, 404}, nil)
+	_, err := helpers.MakeRequestWithMultiCode(httpClient(c), url, "DELETE", []int{204, 404}, nil)
 	return err
 }
 
-func getFileMetadata(c config.Cpi, fileName string) (models.FileUploadResponse, error) {
+// GetFileMetadata returns the metadata of the file fileName, and false when the file store has no such file
+func GetFileMetadata(c config.Cpi, fileName string) (models.FileUploadResponse, bool, error) {
 	url := fmt.Sprintf("%s/api/2.0/files/%s/metadata", c.ApiServer, fileName)
 	request, err := http.NewRequest("GET", url, strings.NewReader(""))
 	if err != nil {
-		return models.FileUploadResponse{}, fmt.Errorf("Error building request to api server: %s", err)
+		return models.FileUploadResponse{}, false, fmt.Errorf("Error building request to api server: %s", err)
 	}
 
-	respBody, err := helpers.MakeConfigRequest(request, []int{200})
+	respBody, status, err := helpers.MakeConfigRequestWithStatus(httpClient(c), request, []int{200, 404})
 	if err != nil {
-		return models.FileUploadResponse{}, fmt.Errorf("Error making request %s", err)
+		return models.FileUploadResponse{}, false, fmt.Errorf("Error making request %s", err)
+	}
+	if status == http.StatusNotFound {
+		return models.FileUploadResponse{}, false, nil
 	}
 
 	var fileMetadata models.FileUploadResponse
 	err = json.Unmarshal(respBody, &fileMetadata)
 	if err != nil {
-		return models.FileUploadResponse{}, fmt.Errorf("Error getting metadata from uploaded file name: %s. error: %s", fileName, err)
+		return models.FileUploadResponse{}, false, fmt.Errorf("Error getting metadata from uploaded file name: %s. error: %s", fileName, err)
 	}
 
-	return fileMetadata, nil
+	return fileMetadata, true, nil
 }
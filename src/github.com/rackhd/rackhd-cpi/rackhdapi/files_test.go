@@ -1,6 +1,7 @@
 package rackhdapi_test
 
 import (
+	"net/http"
 	"strings"
 
 	"github.com/nu7hatch/gouuid"
@@ -11,6 +12,7 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
 )
 
 var _ = Describe("Files", func() {
@@ -53,4 +55,101 @@ var _ = Describe("Files", func() {
 			Expect(string(getFile)).To(Equal("Some ice cold file"))
 		})
 	})
+
+	Describe("GetFileMetadata", func() {
+		var server *ghttp.Server
+		var c config.Cpi
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+			c = config.Cpi{ApiServer: server.URL()}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("returns the metadata of an existing file", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/files/image/metadata", http.StatusOK, []byte(`{"name": "image", "uuid": "uuid-1", "sha256": "abc"}`))
+
+			metadata, found, err := rackhdapi.GetFileMetadata(c, "image")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(metadata).To(Equal(models.FileUploadResponse{Name: "image", UUID: "uuid-1", Sha256: "abc"}))
+		})
+
+		It("reports a missing file without an error", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/files/image/metadata", http.StatusNotFound, []byte(`{}`))
+
+			_, found, err := rackhdapi.GetFileMetadata(c, "image")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(BeFalse())
+		})
+
+		It("returns an error when the file store fails", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/files/image/metadata", http.StatusInternalServerError, []byte(`{}`))
+
+			_, _, err := rackhdapi.GetFileMetadata(c, "image")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Describe("DeleteFile", func() {
+		var server *ghttp.Server
+		var c config.Cpi
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+			c = config.Cpi{ApiServer: server.URL()}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("deletes the file by the uuid of its metadata", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/files/image/metadata", http.StatusOK, []byte(`{"name": "image", "uuid": "uuid-1"}`))
+			helpers.AddHandler(server, "DELETE", "/api/2.0/files/uuid-1", http.StatusNoContent, nil)
+
+			err := rackhdapi.DeleteFile(c, "image")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("returns an error for a missing file", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/files/image/metadata", http.StatusNotFound, []byte(`{}`))
+
+			err := rackhdapi.DeleteFile(c, "image")
+			Expect(err).To(MatchError("Error deleting file image: no such file"))
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Describe("ListFiles", func() {
+		var server *ghttp.Server
+		var c config.Cpi
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+			c = config.Cpi{ApiServer: server.URL()}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("returns the files whose name starts with the prefix", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/files", http.StatusOK, []byte(`[{"basename": "image-1", "uuid": "uuid-1"}, {"basename": "other", "uuid": "uuid-2"}, {"basename": "image-2", "uuid": "uuid-3"}]`))
+
+			files, err := rackhdapi.ListFiles(c, "image-")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]models.FileRecord{{Basename: "image-1", UUID: "uuid-1"}, {Basename: "image-2", UUID: "uuid-3"}}))
+		})
+
+		It("returns an error when the file store fails", func() {
+			helpers.AddHandler(server, "GET", "/api/2.0/files", http.StatusInternalServerError, []byte(`{}`))
+
+			_, err := rackhdapi.ListFiles(c, "image-")
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
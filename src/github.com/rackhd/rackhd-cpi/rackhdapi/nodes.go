@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -17,7 +16,7 @@ import (
 // GetNodes returns all nodes
 func GetNodes(c config.Cpi) ([]models.Node, error) {
 	url := fmt.Sprintf("%s/api/2.0/nodes", c.ApiServer)
-	respBody, err := helpers.MakeRequest(url, "GET", 200, nil)
+	respBody, err := helpers.MakeRequest(httpClient(c), url, "GET", 200, nil)
 	if err != nil {
 		return []models.Node{}, fmt.Errorf("error getting nodes: %s", err)
 	}
@@ -34,7 +33,7 @@ func GetNodes(c config.Cpi) ([]models.Node, error) {
 // GetNodesWithType returns all nodes with the type specified in the query string
 func GetNodesWithType(c config.Cpi, nodeType string) ([]models.Node, error) {
 	url := fmt.Sprintf("%s/api/2.0/nodes?type=%s", c.ApiServer, nodeType)
-	respBody, err := helpers.MakeRequest(url, "GET", 200, nil)
+	respBody, err := helpers.MakeRequest(httpClient(c), url, "GET", 200, nil)
 	if err != nil {
 		return []models.Node{}, fmt.Errorf("error getting nodes: %s", err)
 	}
@@ -50,7 +49,7 @@ func GetNodesWithType(c config.Cpi, nodeType string) ([]models.Node, error) {
 
 func GetNode(c config.Cpi, nodeID string) (models.Node, error) {
 	nodeURL := fmt.Sprintf("%s/api/2.0/nodes/%s", c.ApiServer, nodeID)
-	resp, err := http.Get(nodeURL)
+	resp, err := httpClient(c).Get(nodeURL)
 	if err != nil {
 		return models.Node{}, fmt.Errorf("error fetching node %s: %s", nodeID, err)
 	}
@@ -76,7 +75,7 @@ func GetNode(c config.Cpi, nodeID string) (models.Node, error) {
 
 func GetOBMSettings(c config.Cpi, nodeID string) ([]models.OBM, error) {
 	nodeURL := fmt.Sprintf("%s/api/2.0/nodes/%s", c.ApiServer, nodeID)
-	resp, err := http.Get(nodeURL)
+	resp, err := httpClient(c).Get(nodeURL)
 	if err != nil {
 		return nil, fmt.Errorf("error getting node %s", err)
 	}
@@ -104,28 +103,24 @@ func GetOBMSettings(c config.Cpi, nodeID string) ([]models.OBM, error) {
 	return node.OBMS, nil
 }
 
-// GetOBMServiceName creates an OBMService if one is not assigned to the node
+// GetOBMServiceName returns the OBM service of a node, registering one when the node has none
 func GetOBMServiceName(c config.Cpi, nodeID string) (string, error) {
-	obmSettings, err := GetOBMSettings(c, nodeID)
-	if err != nil {
-		if err.Error() == "error: got empty obm settings" {
-			// create OBM Service, associate with a node
-			name, er := setOBMService(c, nodeID)
-			if er != nil {
-				return "", err
-			}
-			return name, nil
-		}
+	node, err := GetNode(c, nodeID)
+	if err != nil {
 		return "", fmt.Errorf("error retrieving obm settings of node: %s, error: %v", nodeID, err)
 	}
 
-	return obmSettings[0].ServiceName, nil
+	if len(node.OBMS) == 0 {
+		return setOBMService(c, node)
+	}
+
+	return node.OBMS[0].ServiceName, nil
 }
 
 // GetNodeCatalog returns a NodeCatalog object containing the full catalog for a given nodes' data
 func GetNodeCatalog(c config.Cpi, nodeID string) (models.NodeCatalog, error) {
 	catalogURL := fmt.Sprintf("%s/api/2.0/nodes/%s/catalogs/ohai", c.ApiServer, nodeID)
-	resp, err := http.Get(catalogURL)
+	resp, err := httpClient(c).Get(catalogURL)
 	if err != nil {
 		return models.NodeCatalog{}, fmt.Errorf("error getting catalog %s", err)
 	}
@@ -149,6 +144,46 @@ func GetNodeCatalog(c config.Cpi, nodeID string) (models.NodeCatalog, error) {
 	return nodeCatalog, nil
 }
 
+// GetNodeDriveIDs maps the local drive names of a node to their /dev/disk/by-id path.
+// Nodes discovered without the driveId catalog return an empty map.
+func GetNodeDriveIDs(c config.Cpi, nodeID string) (map[string]string, error) {
+	catalogURL := fmt.Sprintf("%s/api/2.0/nodes/%s/catalogs/driveId", c.ApiServer, nodeID)
+	resp, err := httpClient(c).Get(catalogURL)
+	if err != nil {
+		return nil, fmt.Errorf("error getting driveId catalog %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		log.Info(fmt.Sprintf("node %s has no driveId catalog, disks are identified by device name", nodeID))
+		return map[string]string{}, nil
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Failed getting driveId catalog with status: %s", resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading driveId catalog body %s", err)
+	}
+
+	var catalog models.DriveIDCatalog
+	err = json.Unmarshal(b, &catalog)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshal driveId catalog body %s", err)
+	}
+
+	driveIDs := map[string]string{}
+	for _, drive := range catalog.Data {
+		if drive.DevName != "" && drive.ByIDPath() != "" {
+			driveIDs[drive.DevName] = drive.ByIDPath()
+		}
+	}
+
+	return driveIDs, nil
+}
+
 func SetNodeMetadata(c config.Cpi, nodeID string, metadata string) error {
 	metadataBytes := []byte(fmt.Sprintf("{\"metadata\": %s}", metadata))
 	return PatchNode(c, nodeID, metadataBytes)
@@ -157,25 +192,20 @@ func SetNodeMetadata(c config.Cpi, nodeID string, metadata string) error {
 func PatchNode(c config.Cpi, nodeID string, body []byte) error {
 	url := fmt.Sprintf("%s/api/2.0/nodes/%s", c.ApiServer, nodeID)
 
-	_, err := helpers.MakeRequest(url, "PATCH", 200, body)
+	_, err := helpers.MakeRequest(httpClient(c), url, "PATCH", 200, body)
 	if err != nil {
 		return fmt.Errorf("Error making request to patch metadata to node: %s", err)
 	}
 	return nil
 }
 
-func MakeDiskRequest(c config.Cpi, node models.TagNode, newDiskState bool) error {
-	container := models.PersistentDiskSettingsContainer{
-		PersistentDisk: node.PersistentDisk,
-	}
-	container.PersistentDisk.IsAttached = newDiskState
-
-	bodyBytes, err := json.Marshal(container)
+func MakeDiskRequest(c config.Cpi, node models.TagNode, diskCID string, newDiskState bool) error {
+	settings, err := node.PersistentDisk.WithDiskState(diskCID, newDiskState)
 	if err != nil {
-		return err
+		return fmt.Errorf("Error requesting new disk state: %v", err)
 	}
 
-	err = PatchNode(c, node.ID, bodyBytes)
+	err = PatchPersistentDiskSettings(c, node.ID, settings)
 	if err != nil {
 		return fmt.Errorf("Error requesting new disk state: %v", err)
 	}
@@ -183,72 +213,15 @@ func MakeDiskRequest(c config.Cpi, node models.TagNode, newDiskState bool) error
 	return nil
 }
 
-func setOBMService(c config.Cpi, nodeID string) (string, error) {
-	log.Debug("Setting OBM Service from Environment")
-	username := os.Getenv("OBM_USERNAME")
-	if username == "" {
-		log.Debug("OBM_USERNAME not found in environment, setting to default admin")
-		username = "admin"
-	}
-	password := os.Getenv("OBM_PASSWORD")
-	if password == "" {
-		log.Debug("OBM_PASSWORD not found in environment, setting to default admin")
-		password = "admin"
-	}
-	servicename := os.Getenv("OBM_SERVICE_NAME")
-	if servicename == "" {
-		log.Debug("OBM_SERVICE_NAME not found in enviornment, setting to default ipmi-obm-service")
-		servicename = "ipmi-obm-service"
-	}
-
-	enclosureMAC, err := getEnclosureMACAddress(c, nodeID)
-	if err != nil {
-		return "", err
-	}
-
-	obmReq := &models.OBMServiceRequest{
-		Config: models.OBMConfig{
-			Host:     enclosureMAC,
-			Password: password,
-			User:     username,
-		},
-		NodeID:      nodeID,
-		ServiceName: servicename,
-	}
-
-	url := fmt.Sprintf("%s/api/2.0/obms", c.ApiServer)
-	log.Debug("Posting To %s with %+v", url, obmReq)
-	obmBytes, err := json.Marshal(obmReq)
-	if err != nil {
-		return "", err
-	}
-	_, err = helpers.MakeRequest(url, "PUT", 201, obmBytes)
-	if err != nil {
-		return "", err
-	}
-	return servicename, nil
-}
-
-func getEnclosureMACAddress(c config.Cpi, nodeID string) (string, error) {
-	url := fmt.Sprintf("%s/api/2.0/nodes/%s/catalogs/bmc", c.ApiServer, nodeID)
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	var catalogResp models.BMCCatalog
-	respBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+func PatchPersistentDiskSettings(c config.Cpi, nodeID string, settings models.PersistentDiskSettings) error {
+	container := models.PersistentDiskSettingsContainer{
+		PersistentDisk: settings,
 	}
-	defer resp.Body.Close()
 
-	err = json.Unmarshal(respBytes, &catalogResp)
+	bodyBytes, err := json.Marshal(container)
 	if err != nil {
-		return "", err
-	}
-	if catalogResp.Data.MACAddress == "" {
-		return "", fmt.Errorf("MAC Address not found")
+		return err
 	}
 
-	return catalogResp.Data.MACAddress, nil
+	return PatchNode(c, nodeID, bodyBytes)
 }
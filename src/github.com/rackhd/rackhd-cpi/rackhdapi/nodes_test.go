@@ -107,6 +107,211 @@ var _ = Describe("Nodes", func() {
 		})
 	})
 
+	Describe("GetOBMServiceName", func() {
+		var nodeID string
+
+		BeforeEach(func() {
+			nodeID = "583f2dec08a459ab6085a867"
+			c.OBM = config.OBMConfig{
+				OBMCredentials: config.OBMCredentials{Username: "admin", Password: "default-password"},
+				Nodes:          map[string]config.OBMCredentials{"other-node-id": {Password: "other-password"}},
+			}
+		})
+
+		It("registers an OBM service with the configured credentials on a node without one", func() {
+			expectedRequest := models.OBMServiceRequest{
+				Config:      models.OBMConfig{Host: "52:54:be:ef:fd:e1", User: "admin", Password: "default-password"},
+				NodeID:      nodeID,
+				ServiceName: "ipmi-obm-service",
+			}
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s", nodeID)),
+					ghttp.RespondWith(http.StatusOK, []byte(fmt.Sprintf(`{"id": "%s", "obms": []}`, nodeID))),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/bmc", nodeID)),
+					ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_bmc_catalog_response.json")),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PUT", "/api/2.0/obms"),
+					ghttp.VerifyJSONRepresenting(expectedRequest),
+					ghttp.RespondWith(http.StatusCreated, []byte(`{}`)),
+				),
+			)
+
+			name, err := rackhdapi.GetOBMServiceName(c, nodeID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(3))
+			Expect(name).To(Equal("ipmi-obm-service"))
+		})
+
+		It("uses the credentials of the node manufacturer", func() {
+			c.OBM.Manufacturers = map[string]config.OBMCredentials{"Dell Inc.": {ServiceName: "dell-obm-service", Username: "root", Password: "calvin"}}
+			catalog := helpers.LoadNodeCatalog("../spec_assets/dummy_node_catalog_response.json")
+			catalog.Data.DMI.System.Manufacturer = "Dell Inc."
+			catalogBytes, err := json.Marshal(catalog)
+			Expect(err).ToNot(HaveOccurred())
+
+			expectedRequest := models.OBMServiceRequest{
+				Config:      models.OBMConfig{Host: "52:54:be:ef:fd:e1", User: "root", Password: "calvin"},
+				NodeID:      nodeID,
+				ServiceName: "dell-obm-service",
+			}
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s", nodeID)),
+					ghttp.RespondWith(http.StatusOK, []byte(fmt.Sprintf(`{"id": "%s", "obms": []}`, nodeID))),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/ohai", nodeID)),
+					ghttp.RespondWith(http.StatusOK, catalogBytes),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/bmc", nodeID)),
+					ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_bmc_catalog_response.json")),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PUT", "/api/2.0/obms"),
+					ghttp.VerifyJSONRepresenting(expectedRequest),
+					ghttp.RespondWith(http.StatusCreated, []byte(`{}`)),
+				),
+			)
+
+			name, err := rackhdapi.GetOBMServiceName(c, nodeID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(4))
+			Expect(name).To(Equal("dell-obm-service"))
+		})
+
+		It("returns an error instead of registering default credentials when none are configured", func() {
+			c.OBM = config.OBMConfig{}
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s", nodeID)),
+					ghttp.RespondWith(http.StatusOK, []byte(fmt.Sprintf(`{"id": "%s", "obms": []}`, nodeID))),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/bmc", nodeID)),
+					ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_bmc_catalog_response.json")),
+				),
+			)
+
+			_, err := rackhdapi.GetOBMServiceName(c, nodeID)
+			Expect(err).To(MatchError(fmt.Sprintf("error setting OBM service of node %s: no OBM credentials configured for node %s", nodeID, nodeID)))
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("registers a redfish OBM service on a node discovered over redfish", func() {
+			expectedRequest := models.OBMServiceRequest{
+				Config:      models.RedfishOBMConfig{URI: "https://172.31.128.150/redfish/v1", Username: "admin", Password: "default-password"},
+				NodeID:      nodeID,
+				ServiceName: models.OBMSettingRedfishServiceName,
+			}
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s", nodeID)),
+					ghttp.RespondWith(http.StatusOK, []byte(fmt.Sprintf(`{"id": "%s", "obms": [], "identifiers": ["https://172.31.128.150/redfish/v1/Systems/System.Embedded.1"]}`, nodeID))),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PUT", "/api/2.0/obms"),
+					ghttp.VerifyJSONRepresenting(expectedRequest),
+					ghttp.RespondWith(http.StatusCreated, []byte(`{}`)),
+				),
+			)
+
+			name, err := rackhdapi.GetOBMServiceName(c, nodeID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+			Expect(name).To(Equal(models.OBMSettingRedfishServiceName))
+		})
+
+		It("registers an amt OBM service on a node without BMC exposing AMT", func() {
+			expectedRequest := models.OBMServiceRequest{
+				Config:      models.AMTOBMConfig{Host: "52:54:be:ef:fd:e2", Password: "default-password"},
+				NodeID:      nodeID,
+				ServiceName: models.OBMSettingAMTServiceName,
+			}
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s", nodeID)),
+					ghttp.RespondWith(http.StatusOK, []byte(fmt.Sprintf(`{"id": "%s", "obms": [], "identifiers": ["52:54:be:ef:fd:e2"]}`, nodeID))),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/bmc", nodeID)),
+					ghttp.RespondWith(http.StatusNotFound, nil),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/lspci", nodeID)),
+					ghttp.RespondWith(http.StatusOK, []byte(`{"data": [{"pciBusNumber": "00:16.3", "vendorName": "Intel Corporation", "deviceName": "7 Series/C210 Series Chipset Family KT Controller", "deviceClass": "Active Management Technology - SOL"}, {"pciBusNumber": "00:16.0", "vendorName": "Intel Corporation", "deviceName": "Active Management Technology - SOL"}]}`)),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PUT", "/api/2.0/obms"),
+					ghttp.VerifyJSONRepresenting(expectedRequest),
+					ghttp.RespondWith(http.StatusCreated, []byte(`{}`)),
+				),
+			)
+
+			name, err := rackhdapi.GetOBMServiceName(c, nodeID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(4))
+			Expect(name).To(Equal(models.OBMSettingAMTServiceName))
+		})
+
+		It("uses the OBM service of the pool the node is tagged with", func() {
+			c.OBM.Pools = []config.OBMPool{
+				{Tag: "pdu-powered", OBMCredentials: config.OBMCredentials{Service: config.OBMServiceSNMPPDU, Host: "10.0.0.5", Community: "private"}},
+			}
+			c.OBM.Nodes[nodeID] = config.OBMCredentials{Outlet: 7}
+			expectedRequest := models.OBMServiceRequest{
+				Config:      models.SNMPOBMConfig{Host: "10.0.0.5", Community: "private", Port: 7},
+				NodeID:      nodeID,
+				ServiceName: models.OBMSettingSNMPServiceName,
+			}
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s", nodeID)),
+					ghttp.RespondWith(http.StatusOK, []byte(fmt.Sprintf(`{"id": "%s", "obms": []}`, nodeID))),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/tags", nodeID)),
+					ghttp.RespondWith(http.StatusOK, []byte(`["pdu-powered"]`)),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PUT", "/api/2.0/obms"),
+					ghttp.VerifyJSONRepresenting(expectedRequest),
+					ghttp.RespondWith(http.StatusCreated, []byte(`{}`)),
+				),
+			)
+
+			name, err := rackhdapi.GetOBMServiceName(c, nodeID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(3))
+			Expect(name).To(Equal(models.OBMSettingSNMPServiceName))
+		})
+
+		It("returns an error when no OBM service can be detected", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s", nodeID)),
+					ghttp.RespondWith(http.StatusOK, []byte(fmt.Sprintf(`{"id": "%s", "obms": []}`, nodeID))),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/bmc", nodeID)),
+					ghttp.RespondWith(http.StatusNotFound, nil),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/lspci", nodeID)),
+					ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_lspci_catalog_response.json")),
+				),
+			)
+
+			_, err := rackhdapi.GetOBMServiceName(c, nodeID)
+			Expect(err).To(MatchError(ContainSubstring("no BMC, redfish or AMT found")))
+			Expect(server.ReceivedRequests()).To(HaveLen(3))
+		})
+	})
+
 	Describe("Getting catalog", func() {
 		It("returns a catalog", func() {
 			expectedNodeCatalog := helpers.LoadNodeCatalog("../spec_assets/dummy_node_catalog_response.json")
@@ -127,6 +332,40 @@ var _ = Describe("Nodes", func() {
 		})
 	})
 
+	Describe("Getting drive ids", func() {
+		It("maps the drive names to their by-id path", func() {
+			testNodeID := "57fb9fb03fcc55c807add41c"
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/driveId", testNodeID)),
+					ghttp.RespondWith(http.StatusOK, helpers.LoadJSON("../spec_assets/dummy_drive_id_catalog_response.json")),
+				),
+			)
+
+			driveIDs, err := rackhdapi.GetNodeDriveIDs(c, testNodeID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(driveIDs).To(Equal(map[string]string{
+				"sda": "/dev/disk/by-id/wwn-0x5000c500a1b2c3a0",
+				"sdb": "/dev/disk/by-id/wwn-0x5000c500a1b2c3b1",
+				"sdc": "/dev/disk/by-id/wwn-0x5000c500a1b2c3c4",
+			}))
+		})
+
+		It("returns no drive ids when the node has no driveId catalog", func() {
+			testNodeID := "57fb9fb03fcc55c807add41c"
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s/catalogs/driveId", testNodeID)),
+					ghttp.RespondWith(http.StatusNotFound, nil),
+				),
+			)
+
+			driveIDs, err := rackhdapi.GetNodeDriveIDs(c, testNodeID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(driveIDs).To(BeEmpty())
+		})
+	})
+
 	Describe("Setting node metadata", func() {
 		XIt("Adds metadata to the node", func() {
 			nodeID := "node_id"
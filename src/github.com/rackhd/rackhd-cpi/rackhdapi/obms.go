@@ -0,0 +1,240 @@
+package rackhdapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/helpers"
+	"github.com/rackhd/rackhd-cpi/models"
+)
+
+// AMTDeviceName is part of the name lspci reports for the serial port of Intel AMT
+const AMTDeviceName = "Active Management Technology"
+
+// obmServiceNames are the RackHD services registered for each OBM service unless the config names another
+var obmServiceNames = map[string]string{
+	config.OBMServiceIPMI:    models.OBMSettingIPMIServiceName,
+	config.OBMServiceAMT:     models.OBMSettingAMTServiceName,
+	config.OBMServiceRedfish: models.OBMSettingRedfishServiceName,
+	config.OBMServiceSNMPPDU: models.OBMSettingSNMPServiceName,
+}
+
+// GetOBMCredentials resolves the OBM credentials the CPI config holds for a node,
+// reading its manufacturer and tags only when the config depends on them
+func GetOBMCredentials(c config.Cpi, nodeID string) (config.OBMCredentials, error) {
+	var manufacturer string
+	if c.OBM.HasManufacturerCredentials() {
+		catalog, err := GetNodeCatalog(c, nodeID)
+		if err != nil {
+			return config.OBMCredentials{}, fmt.Errorf("error getting manufacturer of node %s: %s", nodeID, err)
+		}
+		manufacturer = catalog.Data.DMI.System.Manufacturer
+	}
+
+	var tags []string
+	if c.OBM.HasPoolCredentials() {
+		var err error
+		tags, err = GetTags(c, nodeID)
+		if err != nil {
+			return config.OBMCredentials{}, fmt.Errorf("error getting pool of node %s: %s", nodeID, err)
+		}
+	}
+
+	return c.OBM.CredentialsFor(nodeID, manufacturer, tags), nil
+}
+
+// setOBMService registers an OBM service on a node with the credentials the CPI config holds for it.
+// The service is detected from the node when neither its pool, manufacturer nor the defaults choose one.
+func setOBMService(c config.Cpi, node models.Node) (string, error) {
+	credentials, err := GetOBMCredentials(c, node.ID)
+	if err != nil {
+		return "", err
+	}
+
+	service := credentials.Service
+	if service == "" {
+		service = configuredOBMService(credentials.ServiceName)
+	}
+
+	if service == "" {
+		detected, host, err := detectOBMService(c, node, credentials)
+		if err != nil {
+			return "", err
+		}
+		log.Info(fmt.Sprintf("detected %s OBM service on node %s", detected, node.ID))
+		service = detected
+		if credentials.Host == "" {
+			credentials.Host = host
+		}
+	}
+
+	obmConfig, err := buildOBMConfig(c, node, service, credentials)
+	if err != nil {
+		return "", fmt.Errorf("error setting OBM service of node %s: %s", node.ID, err)
+	}
+
+	serviceName := credentials.ServiceName
+	if serviceName == "" {
+		serviceName = obmServiceNames[service]
+	}
+
+	obmReq := &models.OBMServiceRequest{
+		Config:      obmConfig,
+		NodeID:      node.ID,
+		ServiceName: serviceName,
+	}
+
+	obmURL := fmt.Sprintf("%s/api/2.0/obms", c.ApiServer)
+	log.Debug(fmt.Sprintf("Posting %s for node %s with user %s to %s", serviceName, node.ID, credentials.Username, obmURL))
+	obmBytes, err := json.Marshal(obmReq)
+	if err != nil {
+		return "", err
+	}
+	_, err = helpers.MakeRequest(httpClient(c), obmURL, "PUT", 201, obmBytes)
+	if err != nil {
+		return "", err
+	}
+	return serviceName, nil
+}
+
+// configuredOBMService maps a RackHD service name set in the config back to the OBM service it implements
+func configuredOBMService(serviceName string) string {
+	for service, name := range obmServiceNames {
+		if name == serviceName {
+			return service
+		}
+	}
+	return ""
+}
+
+// detectOBMService picks the OBM service of a node. A configured PDU community selects snmp-pdu,
+// otherwise a redfish identifier, the BMC catalog and then the AMT PCI device are probed in turn.
+// The host the probe found the controller at is returned along with the service.
+func detectOBMService(c config.Cpi, node models.Node, credentials config.OBMCredentials) (string, string, error) {
+	if credentials.Community != "" {
+		return config.OBMServiceSNMPPDU, "", nil
+	}
+
+	uri := redfishURI(node)
+	if uri != "" {
+		return config.OBMServiceRedfish, uri, nil
+	}
+
+	bmcMAC, err := bmcMACAddress(c, node.ID)
+	if err != nil {
+		return "", "", err
+	}
+	if bmcMAC != "" {
+		return config.OBMServiceIPMI, bmcMAC, nil
+	}
+
+	var lspci models.LSPCICatalog
+	found, err := getCatalog(c, node.ID, models.LSPCICatalogSource, &lspci)
+	if err != nil {
+		return "", "", err
+	}
+	if found {
+		for _, device := range lspci.Data {
+			if strings.Contains(device.DeviceName, AMTDeviceName) {
+				return config.OBMServiceAMT, nodeMACAddress(node), nil
+			}
+		}
+	}
+
+	return "", "", fmt.Errorf("error detecting OBM service of node %s: no BMC, redfish or AMT found, configure the obm service of the node", node.ID)
+}
+
+// buildOBMConfig builds the config the OBM service expects, taking the host from the node when the config sets none
+func buildOBMConfig(c config.Cpi, node models.Node, service string, credentials config.OBMCredentials) (interface{}, error) {
+	switch service {
+	case config.OBMServiceIPMI:
+		if credentials.Username == "" || credentials.Password == "" {
+			return nil, fmt.Errorf("no OBM credentials configured for node %s", node.ID)
+		}
+		host := credentials.Host
+		if host == "" {
+			bmcMAC, err := bmcMACAddress(c, node.ID)
+			if err != nil {
+				return nil, err
+			}
+			if bmcMAC == "" {
+				return nil, fmt.Errorf("MAC Address not found")
+			}
+			host = bmcMAC
+		}
+		return models.OBMConfig{Host: host, User: credentials.Username, Password: credentials.Password}, nil
+
+	case config.OBMServiceAMT:
+		if credentials.Password == "" {
+			return nil, fmt.Errorf("no OBM credentials configured for node %s", node.ID)
+		}
+		host := credentials.Host
+		if host == "" {
+			host = nodeMACAddress(node)
+		}
+		if host == "" {
+			return nil, fmt.Errorf("no host configured for AMT of node %s", node.ID)
+		}
+		return models.AMTOBMConfig{Host: host, Password: credentials.Password}, nil
+
+	case config.OBMServiceRedfish:
+		if credentials.Username == "" || credentials.Password == "" {
+			return nil, fmt.Errorf("no OBM credentials configured for node %s", node.ID)
+		}
+		uri := credentials.Host
+		if uri == "" {
+			uri = redfishURI(node)
+		}
+		if uri == "" {
+			return nil, fmt.Errorf("no redfish uri configured for node %s", node.ID)
+		}
+		return models.RedfishOBMConfig{URI: uri, Username: credentials.Username, Password: credentials.Password}, nil
+
+	case config.OBMServiceSNMPPDU:
+		if credentials.Host == "" || credentials.Community == "" || credentials.Outlet == 0 {
+			return nil, fmt.Errorf("no PDU host, community and outlet configured for node %s", node.ID)
+		}
+		return models.SNMPOBMConfig{Host: credentials.Host, Community: credentials.Community, Port: credentials.Outlet}, nil
+	}
+
+	return nil, fmt.Errorf("unknown OBM service %s", service)
+}
+
+// bmcMACAddress returns the MAC address of the BMC, or an empty string when RackHD found no BMC on the node
+func bmcMACAddress(c config.Cpi, nodeID string) (string, error) {
+	var catalog models.BMCCatalog
+	_, err := getCatalog(c, nodeID, models.BMCCatalogSource, &catalog)
+	if err != nil {
+		return "", err
+	}
+	return catalog.Data.MACAddress, nil
+}
+
+// redfishURI returns the service root of the node, which RackHD records among the identifiers of nodes it discovered over redfish
+func redfishURI(node models.Node) string {
+	for _, identifier := range node.Identifiers {
+		u, err := url.Parse(identifier)
+		if err != nil || u.Host == "" || !strings.HasPrefix(u.Path, "/redfish/") {
+			continue
+		}
+		return fmt.Sprintf("%s://%s/redfish/v1", u.Scheme, u.Host)
+	}
+	return ""
+}
+
+// nodeMACAddress returns the first MAC address among the identifiers of the node
+func nodeMACAddress(node models.Node) string {
+	for _, identifier := range node.Identifiers {
+		_, err := net.ParseMAC(identifier)
+		if err == nil {
+			return identifier
+		}
+	}
+	return ""
+}
@@ -13,7 +13,7 @@ import (
 func GetTags(c config.Cpi, nodeID string) ([]string, error) {
 	url := fmt.Sprintf("%s/api/2.0/nodes/%s/tags", c.ApiServer, nodeID)
 
-	body, err := helpers.MakeRequest(url, "GET", 200, nil)
+	body, err := helpers.MakeRequest(httpClient(c), url, "GET", 200, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -31,7 +31,7 @@ func GetTags(c config.Cpi, nodeID string) ([]string, error) {
 func DeleteTag(c config.Cpi, nodeID, tag string) error {
 	url := fmt.Sprintf("%s/api/2.0/nodes/%s/tags/%s", c.ApiServer, nodeID, tag)
 
-	_, err := helpers.MakeRequest(url, "DELETE", 204, nil)
+	_, err := helpers.MakeRequest(httpClient(c), url, "DELETE", 204, nil)
 	return err
 }
 
@@ -46,14 +46,14 @@ func CreateTag(c config.Cpi, nodeID, tag string) error {
 	}
 
 	url := fmt.Sprintf("%s/api/2.0/nodes/%s/tags", c.ApiServer, nodeID)
-	_, err = helpers.MakeRequest(url, "PATCH", 200, body)
+	_, err = helpers.MakeRequest(httpClient(c), url, "PATCH", 200, body)
 	return err
 }
 
 // GetNodesByTag returns all nodes that have the given tag
 func GetNodesByTag(c config.Cpi, tag string) ([]models.TagNode, error) {
 	url := fmt.Sprintf("%s/api/2.0/tags/%s/nodes", c.ApiServer, tag)
-	respBody, err := helpers.MakeRequest(url, "GET", 200, nil)
+	respBody, err := helpers.MakeRequest(httpClient(c), url, "GET", 200, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -116,3 +116,29 @@ func GetComputeNodesWithoutTags(c config.Cpi, tags []string) ([]models.Node, err
 func ReleaseNode(c config.Cpi, nodeID string) error {
 	return DeleteTag(c, nodeID, models.Unavailable)
 }
+
+// GetTagNode returns the node nodeID with its tags, persistent disks and metadata, whether or not the CPI reserved it
+func GetTagNode(c config.Cpi, nodeID string) (models.TagNode, error) {
+	url := fmt.Sprintf("%s/api/2.0/nodes/%s", c.ApiServer, nodeID)
+	respBody, err := helpers.MakeRequest(httpClient(c), url, "GET", 200, nil)
+	if err != nil {
+		return models.TagNode{}, fmt.Errorf("error getting node %s: %s", nodeID, err)
+	}
+
+	// the node resource links its tags instead of listing them
+	var node struct {
+		models.TagNode
+		Tags json.RawMessage `json:"tags"`
+	}
+	err = json.Unmarshal(respBody, &node)
+	if err != nil {
+		return models.TagNode{}, fmt.Errorf("error unmarshalling node %s: %s", nodeID, err)
+	}
+
+	node.TagNode.Tags, err = GetTags(c, nodeID)
+	if err != nil {
+		return models.TagNode{}, fmt.Errorf("error getting tags of node %s: %s", nodeID, err)
+	}
+
+	return node.TagNode, nil
+}
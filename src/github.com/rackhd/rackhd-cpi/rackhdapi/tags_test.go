@@ -157,4 +157,18 @@ var _ = Describe("Tags", func() {
 			})
 		})
 	})
+
+	Describe("GetTagNode", func() {
+		It("returns the node with the tags its resource links to", func() {
+			helpers.AddHandler(server, "GET", fmt.Sprintf("/api/2.0/nodes/%s", nodeID), http.StatusOK, []byte(fmt.Sprintf(`{"id": "%s", "tags": "/api/2.0/nodes/%s/tags", "metadata": {"rack": "r1"}, "persistent_disk": {"disks": [{"disk_cid": "disk_cid-1", "location": "/dev/sdb"}]}}`, nodeID, nodeID)))
+			helpers.AddHandler(server, "GET", fmt.Sprintf("/api/2.0/nodes/%s/tags", nodeID), http.StatusOK, []byte(`["unavailable", "disk_cid-1"]`))
+
+			node, err := rackhdapi.GetTagNode(c, nodeID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(node.ID).To(Equal(nodeID))
+			Expect(node.Tags).To(Equal([]string{"unavailable", "disk_cid-1"}))
+			Expect(node.Metadata).To(Equal(map[string]interface{}{"rack": "r1"}))
+			Expect(node.PersistentDisk.Disks).To(HaveLen(1))
+		})
+	})
 })
@@ -13,7 +13,7 @@ import (
 
 func PublishTask(c config.Cpi, taskBytes []byte) error {
 	url := fmt.Sprintf("%s/api/2.0/workflows/tasks", c.ApiServer)
-	respBody, err := helpers.MakeRequest(url, "PUT", 201, taskBytes)
+	respBody, err := helpers.MakeRequest(httpClient(c), url, "PUT", 201, taskBytes)
 	if err != nil {
 		return err
 	}
@@ -23,13 +23,13 @@ func PublishTask(c config.Cpi, taskBytes []byte) error {
 	if err != nil {
 		return fmt.Errorf("error unmarshalling task: %s", err)
 	}
-	log.Debug("task to publish: %+v", task)
+	log.Debug(fmt.Sprintf("task to publish: %+v", task))
 
 	publishedTask, err := RetrieveTask(c, task.Name)
 	if err != nil {
 		return err
 	}
-	log.Debug("published task: %+v", publishedTask)
+	log.Debug(fmt.Sprintf("published task: %+v", publishedTask))
 
 	if publishedTask.Name == task.Name {
 		return nil
@@ -39,7 +39,7 @@ func PublishTask(c config.Cpi, taskBytes []byte) error {
 
 func RetrieveTask(c config.Cpi, taskName string) (models.Task, error) {
 	url := fmt.Sprintf("%s/api/2.0/workflows/tasks/%s", c.ApiServer, taskName)
-	respBody, err := helpers.MakeRequest(url, "GET", 200, nil)
+	respBody, err := helpers.MakeRequest(httpClient(c), url, "GET", 200, nil)
 	if err != nil {
 		return models.Task{}, err
 	}
@@ -58,13 +58,13 @@ func RetrieveTask(c config.Cpi, taskName string) (models.Task, error) {
 
 func GetTaskBytes(c config.Cpi, taskName string) ([]byte, error) {
 	url := fmt.Sprintf("%s/api/2.0/workflows/tasks/%s", c.ApiServer, taskName)
-	return helpers.MakeRequest(url, "GET", 200, nil)
+	return helpers.MakeRequest(httpClient(c), url, "GET", 200, nil)
 }
 
 func DeleteTask(c config.Cpi, taskName string) error {
 	log.Info(fmt.Sprintf("deleting task %s", taskName))
 	url := fmt.Sprintf("%s/api/2.0/workflows/tasks/%s", c.ApiServer, taskName)
-	_, err := helpers.MakeRequest(url, "DELETE", 204, nil)
+	_, err := helpers.MakeRequest(httpClient(c), url, "DELETE", 204, nil)
 	if err != nil {
 		return fmt.Errorf("error deleting task %s", err)
 	}
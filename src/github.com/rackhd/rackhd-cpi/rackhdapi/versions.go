@@ -0,0 +1,27 @@
+package rackhdapi
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/helpers"
+	"github.com/rackhd/rackhd-cpi/models"
+)
+
+// GetVersions returns the versions of the packages RackHD runs
+func GetVersions(c config.Cpi) ([]models.PackageVersion, error) {
+	url := fmt.Sprintf("%s/api/2.0/versions", c.ApiServer)
+	respBody, err := helpers.MakeRequest(httpClient(c), url, "GET", 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting versions: %s", err)
+	}
+
+	var versions []models.PackageVersion
+	err = json.Unmarshal(respBody, &versions)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling versions: %s", err)
+	}
+
+	return versions, nil
+}
@@ -24,7 +24,7 @@ type workflowPosterFunc func(config.Cpi, string, models.RunWorkflowRequestBody)
 func PublishGraph(c config.Cpi, graphBytes []byte) error {
 	url := fmt.Sprintf("%s/api/2.0/workflows/graphs", c.ApiServer)
 
-	log.Debug("\nrequest body: %+v\n", string(graphBytes))
+	log.Debug(fmt.Sprintf("\nrequest body: %+v\n", string(graphBytes)))
 	log.Debug(fmt.Sprintf("workflow to publish: %s", string(graphBytes)))
 	request, err := http.NewRequest("PUT", url, bytes.NewReader(graphBytes))
 	request.Close = true
@@ -34,16 +34,16 @@ func PublishGraph(c config.Cpi, graphBytes []byte) error {
 	}
 	request.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(request)
+	resp, err := httpClient(c).Do(request)
 
-	log.Debug("\n\n\nreq: %+v\n body: %+v", request, string(graphBytes))
+	log.Debug(fmt.Sprintf("\n\n\nreq: %+v\n body: %+v", request, string(graphBytes)))
 	if err != nil {
 		return fmt.Errorf("error sending publishing workflow to %s", url)
 	}
 	defer resp.Body.Close()
 
 	b, err := ioutil.ReadAll(resp.Body)
-	log.Debug("\npublish body: %+v\n", string(b))
+	log.Debug(fmt.Sprintf("\npublish body: %+v\n", string(b)))
 
 	if resp.StatusCode != 201 {
 		return fmt.Errorf("error publishing workflow; response status code: %s,\nresponse body: %+v", resp.Status, resp)
@@ -58,7 +58,7 @@ func PublishGraph(c config.Cpi, graphBytes []byte) error {
 	if err != nil {
 		return fmt.Errorf("error unmarshalling graph: %s", err)
 	}
-	log.Debug("workflow received after publishing: %s", string(graphBytes))
+	log.Debug(fmt.Sprintf("workflow received after publishing: %s", string(graphBytes)))
 
 	_, err = RetrieveGraph(c, graph.Name)
 	return err
@@ -67,7 +67,7 @@ func PublishGraph(c config.Cpi, graphBytes []byte) error {
 // RetrieveGraph will get a graph identified by GraphName
 func RetrieveGraph(c config.Cpi, graphName string) (models.Graph, error) {
 	url := fmt.Sprintf("%s/api/2.0/workflows/graphs/%s", c.ApiServer, graphName)
-	respBody, err := helpers.MakeRequest(url, "GET", 200, nil)
+	respBody, err := helpers.MakeRequest(httpClient(c), url, "GET", 200, nil)
 	if err != nil {
 		return models.Graph{}, err
 	}
@@ -87,7 +87,7 @@ func RetrieveGraph(c config.Cpi, graphName string) (models.Graph, error) {
 func DeleteGraph(c config.Cpi, graphName string) error {
 	log.Info(fmt.Sprintf("deleting graph %s", graphName))
 	url := fmt.Sprintf("%s/api/2.0/workflows/graphs/%s", c.ApiServer, graphName)
-	_, err := helpers.MakeRequest(url, "DELETE", 204, nil)
+	_, err := helpers.MakeRequest(httpClient(c), url, "DELETE", 204, nil)
 	if err != nil {
 		return fmt.Errorf("error deleting graph %s", err)
 	}
@@ -113,7 +113,7 @@ func DeleteGraphAndTasks(c config.Cpi, graphName string, taskNames []string) err
 // WorkflowFetcher will fetch a workflow given by workflowIntanceID
 func WorkflowFetcher(c config.Cpi, workflowIntanceID string) (models.WorkflowResponse, error) {
 	url := fmt.Sprintf("%s/api/2.0/workflows/%s", c.ApiServer, workflowIntanceID)
-	respBody, err := helpers.MakeRequest(url, "GET", 200, nil)
+	respBody, err := helpers.MakeRequest(httpClient(c), url, "GET", 200, nil)
 	if err != nil {
 		return models.WorkflowResponse{}, err
 	}
@@ -134,7 +134,7 @@ func WorkflowPoster(c config.Cpi, nodeID string, req models.RunWorkflowRequestBo
 		return models.WorkflowResponse{}, fmt.Errorf("error marshalling workflow request body, %s", err)
 	}
 	url := fmt.Sprintf("%s/api/2.0/nodes/%s/workflows", c.ApiServer, nodeID)
-	respBody, err := helpers.MakeRequest(url, "POST", 201, reqBody)
+	respBody, err := helpers.MakeRequest(httpClient(c), url, "POST", 201, reqBody)
 	if err != nil {
 		return models.WorkflowResponse{}, err
 	}
@@ -149,14 +149,16 @@ func WorkflowPoster(c config.Cpi, nodeID string, req models.RunWorkflowRequestBo
 	return workflowResp, nil
 }
 
-// RunWorkflow will post a workflow using poster, fetch it using fetcher, and run it on nodeID
-func RunWorkflow(poster workflowPosterFunc, fetcher workflowFetcherFunc, c config.Cpi, nodeID string, req models.RunWorkflowRequestBody) error {
+// RunWorkflow will post a workflow using poster, fetch it using fetcher, and run it on nodeID,
+// killing it once timeout has passed
+func RunWorkflow(poster workflowPosterFunc, fetcher workflowFetcherFunc, c config.Cpi, nodeID string, req models.RunWorkflowRequestBody, timeout time.Duration) error {
+	log.Info(fmt.Sprintf("running workflow %s on node %s with a timeout of %s", req.Name, nodeID, timeout))
 	postedWorkflow, err := poster(c, nodeID, req)
 	if err != nil {
 		return fmt.Errorf("error starting workflow %s for node %s. error:%s", req.Name, nodeID, err)
 	}
 
-	timeoutChan := time.NewTimer(time.Second * c.RunWorkflowTimeoutSeconds).C
+	timeoutChan := time.NewTimer(timeout).C
 	retryChan := time.NewTicker(time.Second * 3).C
 
 	for {
@@ -166,7 +168,7 @@ func RunWorkflow(poster workflowPosterFunc, fetcher workflowFetcherFunc, c confi
 			if err != nil {
 				return fmt.Errorf("Could not kill timed out workflow on node: %s, error: %s", nodeID, err)
 			}
-			return fmt.Errorf("Timed out running workflow: %s on node: %s", req.Name, nodeID)
+			return fmt.Errorf("Timed out running workflow: %s on node: %s after %s", req.Name, nodeID, timeout)
 
 		case <-retryChan:
 			wr, err := fetcher(c, postedWorkflow.InstanceID)
@@ -199,7 +201,7 @@ func RunWorkflow(poster workflowPosterFunc, fetcher workflowFetcherFunc, c confi
 // KillActiveWorkflow will kill the workflow running on nodeID
 func KillActiveWorkflow(c config.Cpi, nodeID string) error {
 	url := fmt.Sprintf("%s/api/2.0/nodes/%s/workflows/action", c.ApiServer, nodeID)
-	_, err := helpers.MakeRequest(url, "PUT", 202, []byte("{\"command\": \"cancel\",\"options\": {}}"))
+	_, err := helpers.MakeRequest(httpClient(c), url, "PUT", 202, []byte("{\"command\": \"cancel\",\"options\": {}}"))
 	return err
 }
 
@@ -232,7 +234,7 @@ func getActiveWorkflows(c config.Cpi, nodeID string) ([]models.WorkflowResponse,
 	params.Add("active", "true")
 	req.URL.RawQuery = params.Encode()
 
-	respBody, err := helpers.MakeConfigRequest(req, []int{200})
+	respBody, err := helpers.MakeConfigRequest(httpClient(c), req, []int{200})
 	if err != nil {
 		return []models.WorkflowResponse{}, fmt.Errorf("error getting active workflows %s", err)
 	}
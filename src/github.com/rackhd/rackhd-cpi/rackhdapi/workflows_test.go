@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/rackhd/rackhd-cpi/config"
 	"github.com/rackhd/rackhd-cpi/helpers"
@@ -18,11 +18,10 @@ import (
 
 var _ = Describe("Workflows", func() {
 	var server *ghttp.Server
-	var jsonReader *strings.Reader
 	var cpiConfig config.Cpi
 
 	BeforeEach(func() {
-		server, jsonReader, cpiConfig, _ = helpers.SetUp("")
+		server, _, cpiConfig, _ = helpers.SetUp("")
 	})
 
 	AfterEach(func() {
@@ -122,6 +121,24 @@ var _ = Describe("Workflows", func() {
 		})
 	})
 
+	Describe("RunWorkflow", func() {
+		It("kills the workflow once its timeout has passed", func() {
+			nodeID := "55e79ea54e66816f6152fff9"
+			poster := func(config.Cpi, string, models.RunWorkflowRequestBody) (models.WorkflowResponse, error) {
+				return models.WorkflowResponse{InstanceID: "3c7760db-c57b-4212-afc5-93e4e204b72f"}, nil
+			}
+			fetcher := func(config.Cpi, string) (models.WorkflowResponse, error) {
+				return models.WorkflowResponse{Status: models.WorkflowRunningStatus}, nil
+			}
+			helpers.AddHandler(server, "PUT", fmt.Sprintf("/api/2.0/nodes/%s/workflows/action", nodeID), 202, []byte{})
+
+			body := models.RunWorkflowRequestBody{Name: "Graph.BOSH.Node.Reserve"}
+			err := rackhdapi.RunWorkflow(poster, fetcher, cpiConfig, nodeID, body, 10*time.Millisecond)
+			Expect(err).To(MatchError(fmt.Sprintf("Timed out running workflow: Graph.BOSH.Node.Reserve on node: %s after 10ms", nodeID)))
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
 	Describe("PublishGraph INTEGRATION", func() {
 		var cpiConfig config.Cpi
 		BeforeEach(func() {
@@ -202,7 +219,7 @@ var _ = Describe("Workflows", func() {
 		BeforeEach(func() {
 			apiServer, err := helpers.GetRackHDHost()
 			Expect(err).ToNot(HaveOccurred())
-			cpiConfig = config.Cpi{ApiServer: apiServer, RunWorkflowTimeoutSeconds: 5 * 60}
+			cpiConfig = config.Cpi{ApiServer: apiServer, RunWorkflowTimeout: config.Duration(5 * time.Minute)}
 
 			availableNodes, err = rackhdapi.GetComputeNodesWithoutTags(cpiConfig, []string{models.Unavailable, models.Blocked})
 			Expect(err).ToNot(HaveOccurred())
@@ -234,7 +251,7 @@ var _ = Describe("Workflows", func() {
 					Options: map[string]interface{}{"defaults": Options{OBMServiceName: &obm, NodeID: nodeID}},
 				}
 
-				err = rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, cpiConfig, nodeID, body)
+				err = rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, cpiConfig, nodeID, body, time.Duration(cpiConfig.RunWorkflowTimeout))
 				Expect(err).ToNot(HaveOccurred())
 
 				//*** clean up
@@ -269,7 +286,7 @@ var _ = Describe("Workflows", func() {
 					Options: map[string]interface{}{"defaults": Options{OBMServiceName: &obm}},
 				}
 
-				err = rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, cpiConfig, nodeID, body)
+				err = rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, cpiConfig, nodeID, body, time.Duration(cpiConfig.RunWorkflowTimeout))
 				Expect(err).To(HaveOccurred())
 				Expect(err.Error()).To(MatchRegexp(".+failed against node.+"))
 
@@ -284,7 +301,7 @@ var _ = Describe("Workflows", func() {
 
 		Context("when the workflow does not complete in the configurable timeout", func() {
 			It("returns an error", func() {
-				cpiConfig.RunWorkflowTimeoutSeconds = 10
+				cpiConfig.RunWorkflowTimeout = config.Duration(10 * time.Second)
 				dummyTask := helpers.LoadTask("../spec_assets/dummy_timeout_task.json")
 				dummyTask.Name += guid
 				dummyTaskBytes, err := json.Marshal(dummyTask)
@@ -306,7 +323,7 @@ var _ = Describe("Workflows", func() {
 					Name:    fakeWorkflow.Name,
 					Options: map[string]interface{}{"defaults": Options{OBMServiceName: &obm}},
 				}
-				err = rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, cpiConfig, nodeID, body)
+				err = rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, cpiConfig, nodeID, body, time.Duration(cpiConfig.RunWorkflowTimeout))
 				Expect(err).To(HaveOccurred())
 
 				//*** delete workflow and tasks
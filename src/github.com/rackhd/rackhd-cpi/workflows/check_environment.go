@@ -1,6 +1,40 @@
 package workflows
 
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
 
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/helpers"
+	"github.com/rackhd/rackhd-cpi/models"
+	"github.com/rackhd/rackhd-cpi/rackhdapi"
+)
+
+// EnvironmentCheck is one line of the preflight report. Err is nil when the check passed.
+type EnvironmentCheck struct {
+	Name   string
+	Detail string
+	Err    error
+}
+
+// Passed reports whether the check found no problem
+func (e EnvironmentCheck) Passed() bool {
+	return e.Err == nil
+}
+
+func (e EnvironmentCheck) String() string {
+	if e.Err != nil {
+		return fmt.Sprintf("FAIL  %s: %s", e.Name, e.Err)
+	}
+	return fmt.Sprintf("PASS  %s: %s", e.Name, e.Detail)
+}
 
 // GetRequiredTasks returns all required tasks name
 func GetRequiredTasks() map[string]string {
@@ -10,3 +44,192 @@ func GetRequiredTasks() map[string]string {
 		SetPxeRebootTaskName:    SetPxeRebootTaskTemplatePath,
 	}
 }
+
+// CheckEnvironment verifies that RackHD is ready for the CPI and returns one check per requirement, in a fixed order.
+// It stops after the API check when RackHD cannot be reached.
+func CheckEnvironment(c config.Cpi) []EnvironmentCheck {
+	checks := []EnvironmentCheck{checkAgentSettings(c)}
+
+	api := checkAPI(c)
+	checks = append(checks, api)
+	if !api.Passed() {
+		return checks
+	}
+
+	return append(checks,
+		checkRequiredTasks(c),
+		checkFileStore(c),
+		checkComputeNodes(c),
+	)
+}
+
+// EnvironmentError returns an error listing the failed checks, or nil when they all passed
+func EnvironmentError(checks []EnvironmentCheck) error {
+	var failed []string
+	for _, check := range checks {
+		if !check.Passed() {
+			failed = append(failed, fmt.Sprintf("%s: %s", check.Name, check.Err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("RackHD environment is not ready: %s", strings.Join(failed, "; "))
+}
+
+func checkAPI(c config.Cpi) EnvironmentCheck {
+	check := EnvironmentCheck{Name: "RackHD API"}
+
+	versions, err := rackhdapi.GetVersions(c)
+	if err != nil {
+		check.Err = fmt.Errorf("%s is not reachable: %s", c.ApiServer, err)
+		return check
+	}
+
+	for _, version := range versions {
+		if version.Package == models.OnHTTPPackage {
+			check.Detail = fmt.Sprintf("%s %s %s", c.ApiServer, version.Package, version.Version)
+			return check
+		}
+	}
+
+	check.Err = fmt.Errorf("%s reports no %s version", c.ApiServer, models.OnHTTPPackage)
+	return check
+}
+
+func checkRequiredTasks(c config.Cpi) EnvironmentCheck {
+	var names []string
+	for name := range GetRequiredTasks() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return checkDefinitions("required tasks", names, func(name string) error {
+		_, err := rackhdapi.RetrieveTask(c, name)
+		return err
+	})
+}
+
+func checkDefinitions(checkName string, names []string, retrieve func(string) error) EnvironmentCheck {
+	check := EnvironmentCheck{Name: checkName}
+
+	var missing []string
+	for _, name := range names {
+		err := retrieve(name)
+		if err != nil {
+			log.Debug(fmt.Sprintf("error retrieving %s: %s", name, err))
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		check.Err = fmt.Errorf("missing %s", strings.Join(missing, ", "))
+		return check
+	}
+
+	check.Detail = strings.Join(names, ", ")
+	return check
+}
+
+// checkFileStore uploads and deletes a small file, as create_stemcell and create_vm do
+func checkFileStore(c config.Cpi) EnvironmentCheck {
+	check := EnvironmentCheck{Name: "file store"}
+
+	uuid, err := helpers.GenerateUUID()
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	name := fmt.Sprintf("rackhd-cpi-check-%s", uuid)
+	content := []byte("rackhd-cpi check")
+
+	_, err = rackhdapi.UploadFile(c, name, bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		check.Err = fmt.Errorf("cannot write files: %s", err)
+		return check
+	}
+
+	err = rackhdapi.DeleteFile(c, name)
+	if err != nil {
+		check.Err = fmt.Errorf("cannot delete files: %s", err)
+		return check
+	}
+
+	check.Detail = "upload and delete succeeded"
+	return check
+}
+
+func checkComputeNodes(c config.Cpi) EnvironmentCheck {
+	check := EnvironmentCheck{Name: "compute nodes"}
+
+	nodes, err := rackhdapi.GetNodesWithType(c, "compute")
+	if err != nil {
+		check.Err = err
+		return check
+	}
+
+	if len(nodes) == 0 {
+		check.Err = errors.New("RackHD has not discovered any compute node")
+		return check
+	}
+
+	// the CPI registers an OBM service with the configured credentials on the nodes without one
+	var withOBM int
+	var unmanaged []string
+	for _, node := range nodes {
+		if len(node.OBMS) > 0 {
+			withOBM++
+			continue
+		}
+
+		credentials, err := rackhdapi.GetOBMCredentials(c, node.ID)
+		if err != nil {
+			check.Err = err
+			return check
+		}
+		if !credentials.Configured() {
+			unmanaged = append(unmanaged, node.ID)
+		}
+	}
+
+	if len(unmanaged) > 0 {
+		check.Err = fmt.Errorf("no OBM service and no OBM credentials configured for compute nodes %s", strings.Join(unmanaged, ", "))
+		return check
+	}
+
+	check.Detail = fmt.Sprintf("%d of %d compute nodes have an OBM service", withOBM, len(nodes))
+	if withOBM < len(nodes) {
+		check.Detail += ", the others get one with the configured credentials"
+	}
+	return check
+}
+
+// checkAgentSettings verifies that the agents get a nats or https mbus URL with a port and a blobstore provider
+func checkAgentSettings(c config.Cpi) EnvironmentCheck {
+	check := EnvironmentCheck{Name: "agent settings"}
+
+	mbus, err := url.Parse(c.Agent.Mbus)
+	if err != nil {
+		check.Err = fmt.Errorf("mbus is not a URL: %s", err)
+		return check
+	}
+
+	if mbus.Scheme != "nats" && mbus.Scheme != "https" {
+		check.Err = fmt.Errorf("mbus must be a nats or https URL, got scheme %q", mbus.Scheme)
+		return check
+	}
+
+	host, port, err := net.SplitHostPort(mbus.Host)
+	if err != nil || host == "" || port == "" {
+		check.Err = fmt.Errorf("mbus must name a host and a port, got %q", mbus.Host)
+		return check
+	}
+
+	if _, found := c.Agent.Blobstore["provider"]; !found {
+		check.Err = errors.New("the agent blobstore has no provider")
+		return check
+	}
+
+	check.Detail = fmt.Sprintf("%s://%s", mbus.Scheme, mbus.Host)
+	return check
+}
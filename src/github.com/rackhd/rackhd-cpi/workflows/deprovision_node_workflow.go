@@ -3,6 +3,7 @@ package workflows
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/rackhd/rackhd-cpi/config"
 	"github.com/rackhd/rackhd-cpi/models"
@@ -39,7 +40,7 @@ func RunDeprovisionNodeWorkflow(c config.Cpi, nodeID string, workflowName string
 		Options: map[string]interface{}{"defaults": options},
 	}
 
-	err = rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, c, nodeID, req)
+	err = rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, c, nodeID, req, time.Duration(c.Timeouts.Deprovision))
 	if err != nil {
 		return fmt.Errorf("Failed to complete delete VM workflow--its resource may not have been deprovisioned! Details: %s", err)
 	}
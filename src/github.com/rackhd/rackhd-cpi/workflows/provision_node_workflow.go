@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -13,15 +15,30 @@ import (
 )
 
 type ProvisionNodeWorkflowOptions struct {
-	AgentSettingsFile    *string `json:"agentSettingsFile"`
-	AgentSettingsPath    *string `json:"agentSettingsPath"`
-	CID                  *string `json:"cid"`
-	DownloadDir          string  `json:"downloadDir,omitempty"`
-	OBMServiceName       *string `json:"obmServiceName"`
-	RegistrySettingsFile *string `json:"registrySettingsFile"`
-	RegistrySettingsPath *string `json:"registrySettingsPath"`
-	StemcellFile         *string `json:"stemcellFile"`
-	WipeDisk             string  `json:"wipeDisk"`
+	AgentSettingsFile      *string `json:"agentSettingsFile"`
+	AgentSettingsPath      *string `json:"agentSettingsPath"`
+	CID                    *string `json:"cid"`
+	Device                 string  `json:"device,omitempty"`
+	DevicePartitionPrefix  string  `json:"devicePartitionPrefix,omitempty"`
+	DownloadDir            string  `json:"downloadDir,omitempty"`
+	EphemeralStripeDevices string  `json:"ephemeralStripeDevices"`
+	OBMServiceName         *string `json:"obmServiceName"`
+	Persistent             string  `json:"persistent"`
+	RegistrySettingsFile   *string `json:"registrySettingsFile"`
+	RegistrySettingsPath   *string `json:"registrySettingsPath"`
+	Reimage                string  `json:"reimage"`
+	StemcellFile           *string `json:"stemcellFile"`
+	WipeDisk               string  `json:"wipeDisk"`
+}
+
+// ProvisionDisks are the local drives the provision workflow writes to
+type ProvisionDisks struct {
+	System string
+	// Persistent are the drives of the pregenerated persistent disks, all zeroed when wipeDisk is set
+	Persistent             []string
+	EphemeralStripeDevices []string
+	// KeepSystem leaves the stemcell installed on the system disk in place, only the agent env is replaced
+	KeepSystem bool
 }
 
 type provisionNodeWorkflowOptionsContainer struct {
@@ -38,8 +55,8 @@ type provisionNodeWorkflow struct {
 	Tasks []models.WorkflowTask `json:"tasks"`
 }
 
-func RunProvisionNodeWorkflow(c config.Cpi, nodeID string, workflowName string, vmCID string, stemcellCID string, wipeDisk bool) error {
-	options, err := buildProvisionWorkflowOptions(c, nodeID, vmCID, stemcellCID, wipeDisk)
+func RunProvisionNodeWorkflow(c config.Cpi, nodeID string, workflowName string, vmCID string, stemcellCID string, wipeDisk bool, disks ProvisionDisks) error {
+	options, err := buildProvisionWorkflowOptions(c, nodeID, vmCID, stemcellCID, wipeDisk, disks)
 	if err != nil {
 		return err
 	}
@@ -49,7 +66,7 @@ func RunProvisionNodeWorkflow(c config.Cpi, nodeID string, workflowName string,
 		Options: map[string]interface{}{"defaults": options},
 	}
 
-	return rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, c, nodeID, req)
+	return rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, c, nodeID, req, time.Duration(c.Timeouts.Provision))
 }
 
 func PublishProvisionNodeWorkflow(c config.Cpi) (string, error) {
@@ -134,14 +151,19 @@ func generateProvisionNodeWorkflow(uuid string) ([][]byte, []byte, error) {
 	return [][]byte{pBytes, sBytes}, wBytes, nil
 }
 
-func buildProvisionWorkflowOptions(c config.Cpi, nodeID string, vmCID string, stemcellCID string, wipeDisk bool) (ProvisionNodeWorkflowOptions, error) {
+func buildProvisionWorkflowOptions(c config.Cpi, nodeID string, vmCID string, stemcellCID string, wipeDisk bool, disks ProvisionDisks) (ProvisionNodeWorkflowOptions, error) {
 	envPath := models.RackHDEnvPath
 	options := ProvisionNodeWorkflowOptions{
-		AgentSettingsFile: &nodeID,
-		AgentSettingsPath: &envPath,
-		CID:               &vmCID,
-		StemcellFile:      &stemcellCID,
-		WipeDisk:          strconv.FormatBool(wipeDisk),
+		AgentSettingsFile:      &nodeID,
+		AgentSettingsPath:      &envPath,
+		CID:                    &vmCID,
+		Device:                 disks.System,
+		DevicePartitionPrefix:  partitionPrefix(disks.System),
+		EphemeralStripeDevices: strings.Join(disks.EphemeralStripeDevices, " "),
+		Persistent:             strings.Join(disks.Persistent, " "),
+		Reimage:                strconv.FormatBool(!disks.KeepSystem),
+		StemcellFile:           &stemcellCID,
+		WipeDisk:               strconv.FormatBool(wipeDisk),
 	}
 
 	obmServiceName, err := rackhdapi.GetOBMServiceName(c, nodeID)
@@ -153,6 +175,18 @@ func buildProvisionWorkflowOptions(c config.Cpi, nodeID string, vmCID string, st
 	return options, nil
 }
 
+// partitionPrefix is the path the partition number is appended to, e.g. /dev/sda for /dev/sda1
+// and /dev/nvme0n1p for /dev/nvme0n1p1
+func partitionPrefix(device string) string {
+	if device == "" {
+		return ""
+	}
+	if last := device[len(device)-1]; last >= '0' && last <= '9' {
+		return device + "p"
+	}
+	return device
+}
+
 var provisionNodeTaskBytes = []byte(`
 {
   "injectableName": "Task.BOSH.Node.Provision",
@@ -165,49 +199,52 @@ var provisionNodeTaskBytes = []byte(`
     "agentSettingsUri": "{{ api.files }}/{{ options.agentSettingsFile }}",
     "commands": [
       {
-        "command": "if {{ options.wipeDisk }}; then sudo dd if=/dev/zero of={{ options.persistent }} bs=1M count=100; fi"
+        "command": "if {{ options.wipeDisk }}; then for d in {{ options.persistent }}; do sudo dd if=/dev/zero of=$d bs=1M count=100; done; fi"
       },
       {
-        "command": "curl --retry 3 {{ options.stemcellUri }} -o {{ options.downloadDir }}/{{ options.stemcellFile }}"
+        "command": "if {{ options.reimage }}; then curl --retry 3 {{ options.stemcellUri }} -o {{ options.downloadDir }}/{{ options.stemcellFile }}; fi"
       },
       {
         "command": "curl --retry 3 {{ options.agentSettingsUri }} -o {{ options.downloadDir }}/{{ options.agentSettingsFile }}"
       },
       {
-        "command": "curl {{ options.stemcellFileMd5Uri }} | tr -d '\"' > /opt/downloads/stemcellFileExpectedMd5"
+        "command": "if {{ options.reimage }}; then curl {{ options.stemcellFileMd5Uri }} | tr -d '\"' > /opt/downloads/stemcellFileExpectedMd5; fi"
       },
       {
         "command": "curl {{ options.agentSettingsMd5Uri }} | tr -d '\"' > /opt/downloads/agentSettingsExpectedMd5"
       },
       {
-        "command": "md5sum {{ options.downloadDir }}/{{ options.stemcellFile }} | cut -d' ' -f1 > /opt/downloads/stemcellFileCalculatedMd5"
+        "command": "if {{ options.reimage }}; then md5sum {{ options.downloadDir }}/{{ options.stemcellFile }} | cut -d' ' -f1 > /opt/downloads/stemcellFileCalculatedMd5; fi"
       },
       {
         "command": "md5sum {{ options.downloadDir }}/{{ options.agentSettingsFile }} | cut -d' ' -f1 > /opt/downloads/agentSettingsCalculatedMd5"
       },
       {
-        "command": "test $(cat /opt/downloads/stemcellFileCalculatedMd5) = $(cat /opt/downloads/stemcellFileExpectedMd5)"
+        "command": "if {{ options.reimage }}; then test $(cat /opt/downloads/stemcellFileCalculatedMd5) = $(cat /opt/downloads/stemcellFileExpectedMd5); fi"
       },
       {
         "command": "test $(cat /opt/downloads/agentSettingsCalculatedMd5) = $(cat /opt/downloads/agentSettingsExpectedMd5)"
       },
       {
-        "command": "sudo umount {{ options.device }} || true"
+        "command": "if {{ options.reimage }}; then sudo umount {{ options.device }} || true; fi"
+      },
+      {
+        "command": "if {{ options.reimage }}; then sudo tar --to-stdout -xvf {{ options.downloadDir }}/{{ options.stemcellFile }} | sudo dd of={{ options.device }}; fi"
       },
       {
-        "command": "sudo tar --to-stdout -xvf {{ options.downloadDir }}/{{ options.stemcellFile }} | sudo dd of={{ options.device }}"
+        "command": "if {{ options.reimage }}; then sudo sfdisk -R {{ options.device }}; fi"
       },
       {
-        "command": "sudo sfdisk -R {{ options.device }}"
+        "command": "sudo mount {{ options.devicePartitionPrefix }}1 /mnt"
       },
       {
-        "command": "sudo mount {{ options.device }}1 /mnt"
+        "command": "if {{ options.reimage }}; then sudo dd if=/dev/zero of={{ options.devicePartitionPrefix }}2 bs=1M count=100; fi"
       },
       {
-        "command": "sudo dd if=/dev/zero of={{ options.device }}2 bs=1M count=100"
+        "command": "if {{ options.reimage }}; then sudo dd if=/dev/zero of={{ options.devicePartitionPrefix }}3 bs=1M count=100; fi"
       },
       {
-        "command": "sudo dd if=/dev/zero of={{ options.device }}3 bs=1M count=100"
+        "command": "if {{ options.reimage }} && [ -n \"{{ options.ephemeralStripeDevices }}\" ]; then set -- {{ options.ephemeralStripeDevices }}; for d in \"$@\"; do sudo wipefs -a $d; done; yes | sudo mdadm --create {{ options.ephemeralStripe }} --run --level=0 --homehost=any --name=bosh-ephemeral --raid-devices=$# \"$@\"; fi"
       },
       {
         "command": "sudo cp {{ options.downloadDir }}/{{ options.agentSettingsFile }} /mnt/{{ options.agentSettingsPath }}"
@@ -217,8 +254,12 @@ var provisionNodeTaskBytes = []byte(`
       }
     ],
     "device": "/dev/sda",
+    "devicePartitionPrefix": "/dev/sda",
     "downloadDir": "/opt/downloads",
+    "ephemeralStripe": "/dev/md/bosh-ephemeral",
+    "ephemeralStripeDevices": "",
     "persistent": "/dev/sdb",
+    "reimage": "true",
     "stemcellFile": null,
     "stemcellFileMd5Uri": "{{ api.files }}/{{ options.stemcellFile }}/md5",
     "stemcellUri": "{{ api.files }}/{{ options.stemcellFile }}",
@@ -237,10 +278,15 @@ var provisionNodeWorkflowBytes = []byte(`
       "agentSettingsFile": null,
       "agentSettingsPath": null,
       "cid": null,
+      "device": "/dev/sda",
+      "devicePartitionPrefix": "/dev/sda",
       "downloadDir": "/opt/downloads",
+      "ephemeralStripeDevices": "",
       "obmServiceName": null,
+      "persistent": "/dev/sdb",
       "registrySettingsFile": null,
       "registrySettingsPath": null,
+      "reimage": "true",
       "stemcellFile": null,
       "wipeDisk": "true"
     }
@@ -107,15 +107,58 @@ var _ = Describe("ProvisionNodeWorkflow", func() {
 					CID:               &vmCID,
 					StemcellFile:      &stemcellCID,
 					WipeDisk:          wipeDisk,
+					Reimage:           "true",
 					OBMServiceName:    &ipmiServiceName,
 				}
 
-				options, err := buildProvisionWorkflowOptions(cpiConfig, nodeID, vmCID, stemcellCID, false)
+				options, err := buildProvisionWorkflowOptions(cpiConfig, nodeID, vmCID, stemcellCID, false, ProvisionDisks{})
 				Expect(err).ToNot(HaveOccurred())
 				Expect(options).To(Equal(expectedOptions))
 			})
 		})
 
+		Context("when the ephemeral disk is striped", func() {
+			It("passes the stripe devices to the provision task", func() {
+				expectedNode := helpers.LoadNode("../spec_assets/dummy_one_node_with_ipmi_response.json")
+				expectedNodeData, err := json.Marshal(expectedNode)
+				Expect(err).ToNot(HaveOccurred())
+
+				nodeID := "5665a65a0561790005b77b85"
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s", nodeID)),
+						ghttp.RespondWith(http.StatusOK, expectedNodeData),
+					),
+				)
+
+				options, err := buildProvisionWorkflowOptions(cpiConfig, nodeID, "vmCID", "stemcellCID", false, ProvisionDisks{EphemeralStripeDevices: []string{"/dev/sdc", "/dev/sdd"}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(options.EphemeralStripeDevices).To(Equal("/dev/sdc /dev/sdd"))
+			})
+		})
+
+		Context("when the system disk is an NVMe drive", func() {
+			It("passes the drives and the partition prefix to the provision task", func() {
+				expectedNode := helpers.LoadNode("../spec_assets/dummy_one_node_with_ipmi_response.json")
+				expectedNodeData, err := json.Marshal(expectedNode)
+				Expect(err).ToNot(HaveOccurred())
+
+				nodeID := "5665a65a0561790005b77b85"
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s", nodeID)),
+						ghttp.RespondWith(http.StatusOK, expectedNodeData),
+					),
+				)
+
+				options, err := buildProvisionWorkflowOptions(cpiConfig, nodeID, "vmCID", "stemcellCID", true, ProvisionDisks{System: "/dev/nvme0n1", Persistent: []string{"/dev/nvme1n1", "/dev/nvme2n1"}})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(options.Device).To(Equal("/dev/nvme0n1"))
+				Expect(options.DevicePartitionPrefix).To(Equal("/dev/nvme0n1p"))
+				Expect(options.Persistent).To(Equal("/dev/nvme1n1 /dev/nvme2n1"))
+			})
+		})
+
 		Context("when the node uses AMT", func() {
 			It("sets the OMB settings to AMT", func() {
 				expectedNode := helpers.LoadNode("../spec_assets/dummy_one_node_response.json")
@@ -141,10 +184,11 @@ var _ = Describe("ProvisionNodeWorkflow", func() {
 					CID:               &vmCID,
 					StemcellFile:      &stemcellCID,
 					WipeDisk:          wipeDisk,
+					Reimage:           "true",
 					OBMServiceName:    &ipmiServiceName,
 				}
 
-				options, err := buildProvisionWorkflowOptions(cpiConfig, nodeID, vmCID, stemcellCID, false)
+				options, err := buildProvisionWorkflowOptions(cpiConfig, nodeID, vmCID, stemcellCID, false, ProvisionDisks{})
 				Expect(err).ToNot(HaveOccurred())
 				Expect(options).To(Equal(expectedOptions))
 			})
@@ -0,0 +1,305 @@
+package workflows
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/models"
+	"github.com/rackhd/rackhd-cpi/rackhdapi"
+)
+
+// RelocationPort is the port the receiving microkernel listens on while a disk is copied between nodes
+const RelocationPort = 7070
+
+// RelocationAddressKey is the node attribute the receiving microkernel publishes its address under
+const RelocationAddressKey = "relocation_address"
+
+// RelocationSha256Key is the node attribute both microkernels publish the SHA-256 of the copied stream under
+const RelocationSha256Key = "relocation_sha256"
+
+type sendDiskWorkflowOptions struct {
+	OBMServiceName *string `json:"obmServiceName"`
+	Device         string  `json:"device"`
+	TargetNodeID   string  `json:"targetNodeId"`
+	Port           int     `json:"port"`
+}
+
+type receiveDiskWorkflowOptions struct {
+	OBMServiceName *string `json:"obmServiceName"`
+	Device         string  `json:"device"`
+	Port           int     `json:"port"`
+}
+
+// RunSendDiskWorkflow boots the source node into the microkernel and streams device to the target node
+func RunSendDiskWorkflow(c config.Cpi, nodeID string, workflowName string, device string, targetNodeID string) error {
+	obmServiceName, err := rackhdapi.GetOBMServiceName(c, nodeID)
+	if err != nil {
+		return err
+	}
+
+	options := sendDiskWorkflowOptions{
+		OBMServiceName: &obmServiceName,
+		Device:         device,
+		TargetNodeID:   targetNodeID,
+		Port:           RelocationPort,
+	}
+
+	req := models.RunWorkflowRequestBody{
+		Name:    workflowName,
+		Options: map[string]interface{}{"defaults": options},
+	}
+
+	err = rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, c, nodeID, req, time.Duration(c.Timeouts.RelocateDisk))
+	if err != nil {
+		return fmt.Errorf("failed to complete send disk workflow on node %s: %s", nodeID, err)
+	}
+	return nil
+}
+
+// RunReceiveDiskWorkflow boots the target node into the microkernel and writes the stream it receives to device
+func RunReceiveDiskWorkflow(c config.Cpi, nodeID string, workflowName string, device string) error {
+	obmServiceName, err := rackhdapi.GetOBMServiceName(c, nodeID)
+	if err != nil {
+		return err
+	}
+
+	options := receiveDiskWorkflowOptions{
+		OBMServiceName: &obmServiceName,
+		Device:         device,
+		Port:           RelocationPort,
+	}
+
+	req := models.RunWorkflowRequestBody{
+		Name:    workflowName,
+		Options: map[string]interface{}{"defaults": options},
+	}
+
+	err = rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, c, nodeID, req, time.Duration(c.Timeouts.RelocateDisk))
+	if err != nil {
+		return fmt.Errorf("failed to complete receive disk workflow on node %s: %s", nodeID, err)
+	}
+	return nil
+}
+
+// PublishRelocateDiskWorkflows publishes the send and receive disk workflows and returns their names
+func PublishRelocateDiskWorkflows(c config.Cpi) (string, string, error) {
+	tasks, workflows, err := generateRelocateDiskWorkflows(c.RequestID)
+	if err != nil {
+		return "", "", err
+	}
+
+	for i := range tasks {
+		err = rackhdapi.PublishTask(c, tasks[i])
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	names := make([]string, len(workflows))
+	for i := range workflows {
+		w := models.Graph{}
+		err = json.Unmarshal(workflows[i], &w)
+		if err != nil {
+			return "", "", fmt.Errorf("error umarshalling workflow: %s", err)
+		}
+
+		err = rackhdapi.PublishGraph(c, workflows[i])
+		if err != nil {
+			return "", "", err
+		}
+		names[i] = w.Name
+	}
+
+	return names[0], names[1], nil
+}
+
+// generateRelocateDiskWorkflows returns the send and receive tasks followed by the send and receive workflows
+func generateRelocateDiskWorkflows(uuid string) ([][]byte, [][]byte, error) {
+	var tasks [][]byte
+	var workflows [][]byte
+
+	for _, templates := range [][2][]byte{
+		{sendDiskTaskBytes, sendDiskWorkflowBytes},
+		{receiveDiskTaskBytes, receiveDiskWorkflowBytes},
+	} {
+		task := models.Task{}
+		err := json.Unmarshal(templates[0], &task)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error unmarshalling relocate disk task template: %s", err)
+		}
+
+		task.Name = fmt.Sprintf("%s.%s", task.Name, uuid)
+		task.UnusedName = fmt.Sprintf("%s.%s", task.UnusedName, "UPLOADED_BY_RACKHD_CPI")
+
+		taskBytes, err := json.Marshal(task)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error marshalling relocate disk task template: %s", err)
+		}
+
+		w := models.Graph{}
+		err = json.Unmarshal(templates[1], &w)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error unmarshalling relocate disk workflow template: %s", err)
+		}
+
+		w.Name = fmt.Sprintf("%s.%s", w.Name, uuid)
+		w.UnusedName = fmt.Sprintf("%s.%s", w.UnusedName, "UPLOADED_BY_RACKHD_CPI")
+		w.Tasks[3].TaskName = fmt.Sprintf("%s.%s", w.Tasks[3].TaskName, uuid)
+
+		wBytes, err := json.Marshal(w)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error marshalling relocate disk workflow template: %s", err)
+		}
+
+		tasks = append(tasks, taskBytes)
+		workflows = append(workflows, wBytes)
+	}
+
+	return tasks, workflows, nil
+}
+
+// the receiving microkernel publishes its address on its node, the sending one waits for it and streams the drive with netcat.
+// Both hash the stream as it passes and publish the SHA-256 on their node, so the CPI only trusts a complete copy.
+var sendDiskTaskBytes = []byte(`
+{
+  "friendlyName": "Send Disk",
+  "implementsTask": "Task.Base.Linux.Commands",
+  "injectableName": "Task.BOSH.Node.SendDisk",
+  "options": {
+    "device": "",
+    "targetNodeId": "",
+    "port": 7070,
+    "commands": [
+      {
+        "command": "test -b {{ options.device }}"
+      },
+      {
+        "command": "set -o pipefail; for i in $(seq 360); do addr=$(curl -s {{ api.base }}/nodes/{{ options.targetNodeId }} | grep -o '\"relocation_address\": *\"[^\"]*\"' | cut -d '\"' -f 4); if [ -n \"$addr\" ]; then break; fi; sleep 5; done; test -n \"$addr\" || exit 1; rm -f /tmp/relocation; mkfifo /tmp/relocation || exit 1; sha256sum < /tmp/relocation > /tmp/relocation.sha256 & sudo dd if={{ options.device }} bs=4M | tee /tmp/relocation | nc -q 0 $addr {{ options.port }} || exit 1; wait $! || exit 1; curl -sf -X PATCH -H 'Content-Type: application/json' -d '{\"relocation_sha256\": \"'$(cut -d ' ' -f 1 /tmp/relocation.sha256)'\"}' {{ api.base }}/nodes/{{ task.nodeId }}"
+      }
+    ]
+  },
+  "properties": {}
+}
+`)
+
+var receiveDiskTaskBytes = []byte(`
+{
+  "friendlyName": "Receive Disk",
+  "implementsTask": "Task.Base.Linux.Commands",
+  "injectableName": "Task.BOSH.Node.ReceiveDisk",
+  "options": {
+    "device": "",
+    "port": 7070,
+    "commands": [
+      {
+        "command": "test -b {{ options.device }}"
+      },
+      {
+        "command": "set -o pipefail; rm -f /tmp/relocation; mkfifo /tmp/relocation || exit 1; sha256sum < /tmp/relocation > /tmp/relocation.sha256 & set -- $(hostname -I); curl -sf -X PATCH -H 'Content-Type: application/json' -d '{\"relocation_address\": \"'$1'\"}' {{ api.base }}/nodes/{{ task.nodeId }} || exit 1; nc -l {{ options.port }} | tee /tmp/relocation | sudo dd of={{ options.device }} bs=4M || exit 1; sync; wait $! || exit 1; curl -sf -X PATCH -H 'Content-Type: application/json' -d '{\"relocation_sha256\": \"'$(cut -d ' ' -f 1 /tmp/relocation.sha256)'\"}' {{ api.base }}/nodes/{{ task.nodeId }}"
+      }
+    ]
+  },
+  "properties": {}
+}
+`)
+
+var sendDiskWorkflowBytes = []byte(`
+{
+  "friendlyName": "BOSH Send Disk",
+  "injectableName": "Graph.BOSH.Node.SendDisk",
+  "options": {
+    "defaults": {
+      "obmServiceName": null,
+      "device": "",
+      "targetNodeId": "",
+      "port": 7070
+    }
+  },
+  "tasks": [
+    {
+      "label": "set-boot-pxe",
+      "taskName": "Task.Obm.Node.PxeBoot",
+      "ignoreFailure": true
+    },
+    {
+      "label": "reboot",
+      "taskName": "Task.Obm.Node.Reboot",
+      "waitOn": {
+        "set-boot-pxe": "finished"
+      }
+    },
+    {
+      "label": "bootstrap-ubuntu",
+      "taskName": "Task.Linux.Bootstrap.Ubuntu",
+      "waitOn": {
+        "reboot": "succeeded"
+      }
+    },
+    {
+      "label": "send-disk",
+      "taskName": "Task.BOSH.Node.SendDisk",
+      "waitOn": {
+        "bootstrap-ubuntu": "succeeded"
+      }
+    },
+    {
+      "label": "shell-reboot",
+      "taskName": "Task.ProcShellReboot",
+      "waitOn": {
+        "send-disk": "finished"
+      }
+    }
+  ]
+}
+`)
+
+var receiveDiskWorkflowBytes = []byte(`
+{
+  "friendlyName": "BOSH Receive Disk",
+  "injectableName": "Graph.BOSH.Node.ReceiveDisk",
+  "options": {
+    "defaults": {
+      "obmServiceName": null,
+      "device": "",
+      "port": 7070
+    }
+  },
+  "tasks": [
+    {
+      "label": "set-boot-pxe",
+      "taskName": "Task.Obm.Node.PxeBoot",
+      "ignoreFailure": true
+    },
+    {
+      "label": "reboot",
+      "taskName": "Task.Obm.Node.Reboot",
+      "waitOn": {
+        "set-boot-pxe": "finished"
+      }
+    },
+    {
+      "label": "bootstrap-ubuntu",
+      "taskName": "Task.Linux.Bootstrap.Ubuntu",
+      "waitOn": {
+        "reboot": "succeeded"
+      }
+    },
+    {
+      "label": "receive-disk",
+      "taskName": "Task.BOSH.Node.ReceiveDisk",
+      "waitOn": {
+        "bootstrap-ubuntu": "succeeded"
+      }
+    },
+    {
+      "label": "shell-reboot",
+      "taskName": "Task.ProcShellReboot",
+      "waitOn": {
+        "receive-disk": "finished"
+      }
+    }
+  ]
+}
+`)
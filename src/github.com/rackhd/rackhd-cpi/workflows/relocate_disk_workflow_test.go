@@ -0,0 +1,33 @@
+package workflows
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rackhd/rackhd-cpi/models"
+)
+
+var _ = Describe("RelocateDiskWorkflow", func() {
+	Describe("generateRelocateDiskWorkflows", func() {
+		It("names the send and receive tasks and workflows after the request", func() {
+			tasks, workflows, err := generateRelocateDiskWorkflows("uuid")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tasks).To(HaveLen(2))
+			Expect(workflows).To(HaveLen(2))
+
+			for i, name := range []string{"SendDisk", "ReceiveDisk"} {
+				t := models.Task{}
+				err = json.Unmarshal(tasks[i], &t)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(t.Name).To(Equal("Task.BOSH.Node." + name + ".uuid"))
+
+				w := models.Graph{}
+				err = json.Unmarshal(workflows[i], &w)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(w.Name).To(Equal("Graph.BOSH.Node." + name + ".uuid"))
+				Expect(w.Tasks[3].TaskName).To(Equal(t.Name))
+			}
+		})
+	})
+})
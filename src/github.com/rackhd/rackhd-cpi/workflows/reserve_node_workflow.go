@@ -3,6 +3,7 @@ package workflows
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/rackhd/rackhd-cpi/config"
 	"github.com/rackhd/rackhd-cpi/models"
@@ -39,7 +40,7 @@ func RunReserveNodeWorkflow(c config.Cpi, nodeID string, workflowName string) er
 		Options: map[string]interface{}{"defaults": options},
 	}
 
-	return rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, c, nodeID, req)
+	return rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, c, nodeID, req, time.Duration(c.Timeouts.Reserve))
 }
 
 // PublishReserveNodeWorkflow does what the name implies
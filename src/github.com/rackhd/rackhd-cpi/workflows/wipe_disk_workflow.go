@@ -0,0 +1,205 @@
+package workflows
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/models"
+	"github.com/rackhd/rackhd-cpi/rackhdapi"
+)
+
+type wipeDiskWorkflowOptions struct {
+	OBMServiceName *string `json:"obmServiceName"`
+	Devices        string  `json:"devices"`
+	Policy         string  `json:"policy"`
+}
+
+type wipeDiskWorkflowDefaultOptionsContainer struct {
+	Defaults wipeDiskWorkflowOptions `json:"defaults"`
+}
+
+type wipeDiskWorkflowOptionsContainer struct {
+	Options wipeDiskWorkflowDefaultOptionsContainer `json:"options"`
+}
+
+type wipeDiskWorkflow struct {
+	*models.Graph
+	*wipeDiskWorkflowOptionsContainer
+	Tasks []models.WorkflowTask `json:"tasks"`
+}
+
+// RunWipeDiskWorkflow boots the node into the microkernel and wipes devices with the given policy
+func RunWipeDiskWorkflow(c config.Cpi, nodeID string, workflowName string, devices []string, policy string) error {
+	options, err := buildWipeDiskWorkflowOptions(c, nodeID, devices, policy)
+	if err != nil {
+		return err
+	}
+
+	req := models.RunWorkflowRequestBody{
+		Name:    workflowName,
+		Options: map[string]interface{}{"defaults": options},
+	}
+
+	err = rackhdapi.RunWorkflow(rackhdapi.WorkflowPoster, rackhdapi.WorkflowFetcher, c, nodeID, req, time.Duration(c.Timeouts.WipeDisk))
+	if err != nil {
+		return fmt.Errorf("failed to complete wipe disk workflow, %v may still hold data: %s", devices, err)
+	}
+	return nil
+}
+
+// PublishWipeDiskWorkflow does what the name implies
+func PublishWipeDiskWorkflow(c config.Cpi) (string, error) {
+	tasks, workflow, err := generateWipeDiskWorkflow(c.RequestID)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range tasks {
+		err = rackhdapi.PublishTask(c, tasks[i])
+		if err != nil {
+			return "", err
+		}
+	}
+
+	w := wipeDiskWorkflow{}
+	err = json.Unmarshal(workflow, &w)
+	if err != nil {
+		return "", fmt.Errorf("error umarshalling workflow: %s", err)
+	}
+
+	err = rackhdapi.PublishGraph(c, workflow)
+	if err != nil {
+		return "", err
+	}
+
+	return w.Name, nil
+}
+
+func generateWipeDiskWorkflow(uuid string) ([][]byte, []byte, error) {
+	wipeTask := models.Task{}
+	err := json.Unmarshal(wipeDiskTaskBytes, &wipeTask)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error unmarshalling wipe disk task template: %s", err)
+	}
+
+	wipeTask.Name = fmt.Sprintf("%s.%s", wipeTask.Name, uuid)
+	wipeTask.UnusedName = fmt.Sprintf("%s.%s", wipeTask.UnusedName, "UPLOADED_BY_RACKHD_CPI")
+
+	wipeTaskBytes, err := json.Marshal(wipeTask)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error marshalling wipe disk task template: %s", err)
+	}
+
+	w := wipeDiskWorkflow{}
+	err = json.Unmarshal(wipeDiskWorkflowBytes, &w)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error unmarshalling wipe disk workflow template: %s", err)
+	}
+
+	w.Name = fmt.Sprintf("%s.%s", w.Name, uuid)
+	w.UnusedName = fmt.Sprintf("%s.%s", w.UnusedName, "UPLOADED_BY_RACKHD_CPI")
+	w.Tasks[3].TaskName = fmt.Sprintf("%s.%s", w.Tasks[3].TaskName, uuid)
+
+	wBytes, err := json.Marshal(w)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error marshalling wipe disk workflow template: %s", err)
+	}
+
+	return [][]byte{wipeTaskBytes}, wBytes, nil
+}
+
+func buildWipeDiskWorkflowOptions(c config.Cpi, nodeID string, devices []string, policy string) (wipeDiskWorkflowOptions, error) {
+	options := wipeDiskWorkflowOptions{
+		Devices: strings.Join(devices, " "),
+		Policy:  policy,
+	}
+
+	obmServiceName, err := rackhdapi.GetOBMServiceName(c, nodeID)
+	if err != nil {
+		return wipeDiskWorkflowOptions{}, err
+	}
+	options.OBMServiceName = &obmServiceName
+
+	return options, nil
+}
+
+// quick zeroes the partition table and filesystem headers, full overwrites every block and
+// secure_erase asks the drive firmware to erase itself (nvme-cli for NVMe, hdparm for ATA)
+var wipeDiskTaskBytes = []byte(`
+{
+  "friendlyName": "Wipe Disk",
+  "implementsTask": "Task.Base.Linux.Commands",
+  "injectableName": "Task.BOSH.Node.Wipe",
+  "options": {
+    "devices": "",
+    "policy": "quick",
+    "commands": [
+      {
+        "command": "for d in {{ options.devices }}; do test -b $d || exit 1; done"
+      },
+      {
+        "command": "if [ \"{{ options.policy }}\" = \"quick\" ]; then for d in {{ options.devices }}; do sudo wipefs -a $d && sudo dd if=/dev/zero of=$d bs=1M count=100 || exit 1; done; fi"
+      },
+      {
+        "command": "if [ \"{{ options.policy }}\" = \"full\" ]; then for d in {{ options.devices }}; do sudo shred -n 0 -z $d || exit 1; done; fi"
+      },
+      {
+        "command": "if [ \"{{ options.policy }}\" = \"secure_erase\" ]; then for d in {{ options.devices }}; do case $(readlink -f $d) in /dev/nvme*) sudo nvme format $d --ses=1 ;; *) sudo hdparm --user-master u --security-set-pass bosh $d && sudo hdparm --user-master u --security-erase bosh $d ;; esac || exit 1; done; fi"
+      }
+    ]
+  },
+  "properties": {}
+}
+`)
+
+var wipeDiskWorkflowBytes = []byte(`
+{
+  "friendlyName": "BOSH Wipe Disk",
+  "injectableName": "Graph.BOSH.Node.Wipe",
+  "options": {
+    "defaults": {
+      "obmServiceName": null,
+      "devices": "",
+      "policy": "quick"
+    }
+  },
+  "tasks": [
+    {
+      "label": "set-boot-pxe",
+      "taskName": "Task.Obm.Node.PxeBoot",
+      "ignoreFailure": true
+    },
+    {
+      "label": "reboot",
+      "taskName": "Task.Obm.Node.Reboot",
+      "waitOn": {
+        "set-boot-pxe": "finished"
+      }
+    },
+    {
+      "label": "bootstrap-ubuntu",
+      "taskName": "Task.Linux.Bootstrap.Ubuntu",
+      "waitOn": {
+        "reboot": "succeeded"
+      }
+    },
+    {
+      "label": "wipe-disk",
+      "taskName": "Task.BOSH.Node.Wipe",
+      "waitOn": {
+        "bootstrap-ubuntu": "succeeded"
+      }
+    },
+    {
+      "label": "shell-reboot",
+      "taskName": "Task.ProcShellReboot",
+      "waitOn": {
+        "wipe-disk": "finished"
+      }
+    }
+  ]
+}
+`)
@@ -0,0 +1,90 @@
+package workflows
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/rackhd/rackhd-cpi/config"
+	"github.com/rackhd/rackhd-cpi/helpers"
+	"github.com/rackhd/rackhd-cpi/models"
+)
+
+var _ = Describe("WipeDiskWorkflow", func() {
+	Describe("generateWipeDiskWorkflow", func() {
+		It("names the tasks and the workflow after the request", func() {
+			tasks, workflow, err := generateWipeDiskWorkflow("uuid")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tasks).To(HaveLen(1))
+
+			t := models.Task{}
+			err = json.Unmarshal(tasks[0], &t)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(t.Name).To(Equal("Task.BOSH.Node.Wipe.uuid"))
+
+			w := wipeDiskWorkflow{}
+			err = json.Unmarshal(workflow, &w)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(w.Name).To(Equal("Graph.BOSH.Node.Wipe.uuid"))
+			Expect(w.Tasks[3].TaskName).To(Equal(t.Name))
+		})
+
+		It("fails the wipe task as soon as one of the devices fails", func() {
+			var task struct {
+				Options struct {
+					Commands []struct {
+						Command string `json:"command"`
+					} `json:"commands"`
+				} `json:"options"`
+			}
+			err := json.Unmarshal(wipeDiskTaskBytes, &task)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(task.Options.Commands).To(HaveLen(4))
+
+			for _, command := range task.Options.Commands {
+				Expect(command.Command).To(ContainSubstring("|| exit 1; done"))
+			}
+		})
+	})
+
+	Describe("buildWipeDiskWorkflowOptions", func() {
+		var server *ghttp.Server
+		var cpiConfig config.Cpi
+
+		BeforeEach(func() {
+			server, _, cpiConfig, _ = helpers.SetUp("")
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("passes the devices and the policy to the wipe task", func() {
+			expectedNode := helpers.LoadNode("../spec_assets/dummy_one_node_with_ipmi_response.json")
+			expectedNodeData, err := json.Marshal(expectedNode)
+			Expect(err).ToNot(HaveOccurred())
+
+			nodeID := "5665a65a0561790005b77b85"
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", fmt.Sprintf("/api/2.0/nodes/%s", nodeID)),
+					ghttp.RespondWith(http.StatusOK, expectedNodeData),
+				),
+			)
+
+			ipmiServiceName := models.OBMSettingIPMIServiceName
+			expectedOptions := wipeDiskWorkflowOptions{
+				OBMServiceName: &ipmiServiceName,
+				Devices:        "/dev/sdb /dev/nvme1n1",
+				Policy:         config.DiskWipeSecureErase,
+			}
+
+			options, err := buildWipeDiskWorkflowOptions(cpiConfig, nodeID, []string{"/dev/sdb", "/dev/nvme1n1"}, config.DiskWipeSecureErase)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(options).To(Equal(expectedOptions))
+		})
+	})
+})